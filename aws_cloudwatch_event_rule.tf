@@ -0,0 +1,6 @@
+resource "aws_cloudwatch_event_rule" "contact_sheet" {
+  description         = "Builds the contact sheets of the previous day."
+  name                = "${var.application}ContactSheet"
+  schedule_expression = var.contact_sheet_schedule_expression
+  state               = "ENABLED"
+}
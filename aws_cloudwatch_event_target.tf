@@ -0,0 +1,5 @@
+resource "aws_cloudwatch_event_target" "contact_sheet" {
+  arn       = aws_lambda_function.contact_sheet.arn
+  rule      = aws_cloudwatch_event_rule.contact_sheet.name
+  target_id = "ContactSheet"
+}
@@ -1,3 +1,9 @@
+resource "aws_iam_policy" "s3_object_delete_access" {
+  path   = "/${var.application}/"
+  policy = data.aws_iam_policy_document.s3_object_delete_access.json
+  name   = "${var.application}S3ObjectDeleteAccess"
+}
+
 resource "aws_iam_policy" "s3_object_read_only_access" {
   path   = "/${var.application}/"
   policy = data.aws_iam_policy_document.s3_object_read_only_access.json
@@ -9,3 +15,9 @@ resource "aws_iam_policy" "s3_object_write_only_access" {
   policy = data.aws_iam_policy_document.s3_object_write_only_access.json
   name   = "${var.application}S3ObjectWriteOnlyAccess"
 }
+
+resource "aws_iam_policy" "rekognition_index_faces" {
+  path   = "/${var.application}/"
+  policy = data.aws_iam_policy_document.rekognition_index_faces.json
+  name   = "${var.application}RekognitionIndexFaces"
+}
@@ -13,6 +13,18 @@ data "aws_iam_policy_document" "assume_role_lambda" {
   }
 }
 
+data "aws_iam_policy_document" "rekognition_index_faces" {
+  statement {
+    actions = [
+      "rekognition:IndexFaces"
+    ]
+    effect = "Allow"
+    resources = [
+      "arn:aws:rekognition:${var.region}:${data.aws_caller_identity.main.account_id}:collection/*"
+    ]
+  }
+}
+
 data "aws_iam_policy_document" "s3_bucket_notification_s3_object_created_images_uploaded" {
   statement {
     actions = [
@@ -31,22 +43,45 @@ data "aws_iam_policy_document" "s3_bucket_notification_s3_object_created_images_
   }
 }
 
+data "aws_iam_policy_document" "s3_object_delete_access" {
+  statement {
+    actions = [
+      "s3:DeleteObject"
+    ]
+    effect = "Allow"
+    resources = [
+      "${aws_s3_bucket.main.arn}/*"
+    ]
+  }
+}
+
 data "aws_iam_policy_document" "s3_object_read_only_access" {
   statement {
     actions = [
-      "s3:GetObject"
+      "s3:GetObject",
+      "s3:GetObjectTagging"
     ]
     effect = "Allow"
     resources = [
       "${aws_s3_bucket.main.arn}/*"
     ]
   }
+  statement {
+    actions = [
+      "s3:ListBucket"
+    ]
+    effect = "Allow"
+    resources = [
+      aws_s3_bucket.main.arn
+    ]
+  }
 }
 
 data "aws_iam_policy_document" "s3_object_write_only_access" {
   statement {
     actions = [
-      "s3:PutObject"
+      "s3:PutObject",
+      "s3:PutObjectTagging"
     ]
     effect = "Allow"
     resources = [
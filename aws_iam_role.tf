@@ -3,3 +3,9 @@ resource "aws_iam_role" "lambda_s3_bucket_notification" {
   name               = "${var.application}LambdaS3BucketNotification"
   path               = "/${var.application}/"
 }
+
+resource "aws_iam_role" "lambda_s3_object_quarantine" {
+  assume_role_policy = data.aws_iam_policy_document.assume_role_lambda.json
+  name               = "${var.application}LambdaS3ObjectQuarantine"
+  path               = "/${var.application}/"
+}
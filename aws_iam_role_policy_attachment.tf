@@ -17,3 +17,38 @@ resource "aws_iam_role_policy_attachment" "lambda_s3_bucket_notification_rekogni
   policy_arn = "arn:aws:iam::aws:policy/AmazonRekognitionReadOnlyAccess"
   role       = aws_iam_role.lambda_s3_bucket_notification.id
 }
+
+resource "aws_iam_role_policy_attachment" "lambda_s3_bucket_notification_rekognition_index_faces" {
+  policy_arn = aws_iam_policy.rekognition_index_faces.arn
+  role       = aws_iam_role.lambda_s3_bucket_notification.id
+}
+
+resource "aws_iam_role_policy_attachment" "lambda_s3_object_quarantine_lambda_basic_execution_role" {
+  policy_arn = "arn:aws:iam::aws:policy/service-role/AWSLambdaBasicExecutionRole"
+  role       = aws_iam_role.lambda_s3_object_quarantine.id
+}
+
+resource "aws_iam_role_policy_attachment" "lambda_s3_object_quarantine_s3_object_delete_access" {
+  policy_arn = aws_iam_policy.s3_object_delete_access.arn
+  role       = aws_iam_role.lambda_s3_object_quarantine.id
+}
+
+resource "aws_iam_role_policy_attachment" "lambda_s3_object_quarantine_s3_object_read_only_access" {
+  policy_arn = aws_iam_policy.s3_object_read_only_access.arn
+  role       = aws_iam_role.lambda_s3_object_quarantine.id
+}
+
+resource "aws_iam_role_policy_attachment" "lambda_s3_object_quarantine_s3_object_write_only_access" {
+  policy_arn = aws_iam_policy.s3_object_write_only_access.arn
+  role       = aws_iam_role.lambda_s3_object_quarantine.id
+}
+
+resource "aws_iam_role_policy_attachment" "lambda_s3_object_quarantine_rekognition" {
+  policy_arn = "arn:aws:iam::aws:policy/AmazonRekognitionReadOnlyAccess"
+  role       = aws_iam_role.lambda_s3_object_quarantine.id
+}
+
+resource "aws_iam_role_policy_attachment" "lambda_s3_object_quarantine_rekognition_index_faces" {
+  policy_arn = aws_iam_policy.rekognition_index_faces.arn
+  role       = aws_iam_role.lambda_s3_object_quarantine.id
+}
@@ -1,27 +1,142 @@
-resource "aws_lambda_function" "s3_object_notification_object_created_image" {
+resource "aws_lambda_function" "contact_sheet" {
   architectures           = ["x86_64"]
   code_signing_config_arn = null
   description             = null
   environment {
     variables = {
       APPLICATION                        = var.application
+      CONTACT_SHEET_CAPTIONS             = var.contact_sheet_captions
+      CONTACT_SHEET_CELL_SIZE            = var.contact_sheet_cell_size
+      CONTACT_SHEET_COLUMNS              = var.contact_sheet_columns
+      CONTACT_SHEET_FORMAT               = var.contact_sheet_format
+      CONTACT_SHEET_ROWS                 = var.contact_sheet_rows
       REGION                             = var.region
+      S3_BUCKET_FOLDER_CONTACT_SHEETS    = aws_s3_object.contact_sheets.key
       S3_BUCKET_FOLDER_IMAGES_COMPRESSED = aws_s3_object.images_compressed.key
       S3_BUCKET_FOLDER_IMAGES_EXIF       = aws_s3_object.images_exif.key
-      S3_BUCKET_FOLDER_IMAGES_UPLOADED   = aws_s3_object.images_uploaded.key
+      S3_BUCKET_NAME                     = aws_s3_bucket.main.id
+    }
+  }
+  handler          = "main"
+  filename         = "./src/lambda_function/contact_sheet/lambda.zip"
+  function_name    = "${var.application}ContactSheet"
+  layers           = null
+  memory_size      = 512
+  package_type     = "Zip"
+  publish          = false
+  runtime          = "provided.al2"
+  skip_destroy     = false
+  source_code_hash = sha256("./src/lambda_function/contact_sheet/lambda.zip")
+  role             = aws_iam_role.lambda_s3_bucket_notification.arn
+  timeout          = 900
+  tracing_config {
+    mode = "Active"
+  }
+}
+
+resource "aws_lambda_function" "moderation_review" {
+  architectures           = ["x86_64"]
+  code_signing_config_arn = null
+  description             = null
+  environment {
+    variables = {
+      APPLICATION                 = var.application
+      REGION                      = var.region
+      S3_BUCKET_FOLDER_QUARANTINE = aws_s3_object.quarantine.key
+      S3_BUCKET_NAME              = aws_s3_bucket.main.id
+    }
+  }
+  handler          = "main"
+  filename         = "./src/lambda_function/moderation_review/lambda.zip"
+  function_name    = "${var.application}ModerationReview"
+  layers           = null
+  memory_size      = 128
+  package_type     = "Zip"
+  publish          = false
+  runtime          = "provided.al2"
+  skip_destroy     = false
+  source_code_hash = sha256("./src/lambda_function/moderation_review/lambda.zip")
+  role             = aws_iam_role.lambda_s3_object_quarantine.arn
+  timeout          = 300
+  tracing_config {
+    mode = "Active"
+  }
+}
+
+resource "aws_lambda_function" "people" {
+  architectures           = ["x86_64"]
+  code_signing_config_arn = null
+  description             = null
+  environment {
+    variables = {
+      APPLICATION                      = var.application
+      REGION                           = var.region
+      S3_BUCKET_FOLDER_IMAGES_KEYWORDS = aws_s3_object.images_keywords.key
+      S3_BUCKET_FOLDER_IMAGES_PEOPLE   = aws_s3_object.images_people.key
+      S3_BUCKET_FOLDER_PEOPLE          = aws_s3_object.people.key
+      S3_BUCKET_NAME                   = aws_s3_bucket.main.id
+    }
+  }
+  handler          = "main"
+  filename         = "./src/lambda_function/people/lambda.zip"
+  function_name    = "${var.application}People"
+  layers           = null
+  memory_size      = 128
+  package_type     = "Zip"
+  publish          = false
+  runtime          = "provided.al2"
+  skip_destroy     = false
+  source_code_hash = sha256("./src/lambda_function/people/lambda.zip")
+  role             = aws_iam_role.lambda_s3_bucket_notification.arn
+  timeout          = 300
+  tracing_config {
+    mode = "Active"
+  }
+}
+
+resource "aws_lambda_function" "s3_object_notification_object_created_image" {
+  architectures           = ["x86_64"]
+  code_signing_config_arn = null
+  description             = null
+  environment {
+    variables = {
+      APPLICATION                               = var.application
+      IMAGE_CROP_PRESETS                        = jsonencode(var.image_crop_presets)
+      IMAGE_DUPLICATE_ACTION                    = var.image_duplicate_action
+      IMAGE_DUPLICATE_THRESHOLD                 = var.image_duplicate_threshold
+      IMAGE_GRADES                              = jsonencode(var.image_grades)
+      IMAGE_MAX_DECODE_PIXELS                   = var.image_max_decode_pixels
+      IMAGE_MAX_DIMENSION                       = var.image_max_dimension
+      IMAGE_MAX_PIXELS                          = var.image_max_pixels
+      IMAGE_MAX_UPLOAD_PIXELS                   = var.image_max_upload_pixels
+      IMAGE_PALETTE_SIZE                        = var.image_palette_size
+      IMAGE_REDACTION_COLLECTION_ID             = var.image_redaction_collection_id
+      IMAGE_REDACTION_FACE_MATCH_THRESHOLD      = var.image_redaction_face_match_threshold
+      IMAGE_RENDITIONS                          = jsonencode(var.image_renditions)
+      IMAGE_WATERMARK                           = var.image_watermark == null ? "" : jsonencode(var.image_watermark)
+      REGION                                    = var.region
+      S3_BUCKET_FOLDER_IMAGES_COMPRESSED        = aws_s3_object.images_compressed.key
+      S3_BUCKET_FOLDER_IMAGES_EXIF              = aws_s3_object.images_exif.key
+      S3_BUCKET_FOLDER_IMAGES_GRADED            = aws_s3_object.images_graded.key
+      S3_BUCKET_FOLDER_IMAGES_HASHES            = aws_s3_object.images_hashes.key
+      S3_BUCKET_FOLDER_IMAGES_RENDITIONS        = aws_s3_object.images_renditions.key
+      S3_BUCKET_FOLDER_IMAGES_UPLOADED          = aws_s3_object.images_uploaded.key
+      S3_BUCKET_FOLDER_QUARANTINE               = aws_s3_object.quarantine.key
+      S3_BUCKET_FOLDER_REKOGNITION_DETECT_FACES = aws_s3_object.rekognition_detect_faces.key
+      S3_BUCKET_FOLDER_REKOGNITION_STATUS       = aws_s3_object.rekognition_status.key
     }
   }
   handler          = "main"
   filename         = "./src/lambda_function/s3_object_notification/object_created/image/lambda.zip"
   function_name    = "${var.application}S3ObjectNotificationObjectCreatedImageUploaded"
   layers           = null
-  memory_size      = 256 // Might be running out of memory when not rescaling image/2
+  memory_size      = 2048
   package_type     = "Zip"
   publish          = false
   runtime          = "provided.al2"
   skip_destroy     = false
   source_code_hash = sha256("./src/lambda_function/s3_object_notification/object_created/image/lambda.zip")
-  role             = aws_iam_role.lambda_s3_bucket_notification.arn
+  role             = aws_iam_role.lambda_s3_object_quarantine.arn
   timeout          = 120
   tracing_config {
     mode = "Active"
@@ -35,25 +150,44 @@ resource "aws_lambda_function" "s3_object_notification_object_created_image_comp
   environment {
     variables = {
       APPLICATION                                           = var.application
+      MODERATION_QUARANTINE_THRESHOLDS                      = jsonencode(var.moderation_quarantine_thresholds)
       REGION                                                = var.region
+      REKOGNITION_DETECT_CUSTOM_LABELS_MIN_CONFIDENCE       = var.rekognition_detect_custom_labels_min_confidence
+      REKOGNITION_DETECT_CUSTOM_LABELS_PROJECT_VERSION_ARN  = var.rekognition_detect_custom_labels_project_version_arn
+      REKOGNITION_DETECT_MODERATION_LABELS_MIN_CONFIDENCE   = var.rekognition_detect_moderation_labels_min_confidence
+      REKOGNITION_DETECTOR_CONCURRENCY                      = var.rekognition_detector_concurrency
+      REKOGNITION_DETECTORS                                 = jsonencode(var.rekognition_detectors)
+      REKOGNITION_PEOPLE_COLLECTION_ID                      = var.rekognition_people_collection_id
+      REKOGNITION_PEOPLE_FACE_MATCH_THRESHOLD               = var.rekognition_people_face_match_threshold
+      S3_BUCKET_FOLDER_IMAGES_ANNOTATIONS                   = aws_s3_object.images_annotations.key
       S3_BUCKET_FOLDER_IMAGES_COMPRESSED                    = aws_s3_object.images_compressed.key
+      S3_BUCKET_FOLDER_IMAGES_EXIF                          = aws_s3_object.images_exif.key
+      S3_BUCKET_FOLDER_IMAGES_HASHES                        = aws_s3_object.images_hashes.key
+      S3_BUCKET_FOLDER_IMAGES_KEYWORDS                      = aws_s3_object.images_keywords.key
+      S3_BUCKET_FOLDER_IMAGES_PEOPLE                        = aws_s3_object.images_people.key
+      S3_BUCKET_FOLDER_IMAGES_RENDITIONS                    = aws_s3_object.images_renditions.key
+      S3_BUCKET_FOLDER_PEOPLE                               = aws_s3_object.people.key
+      S3_BUCKET_FOLDER_QUARANTINE                           = aws_s3_object.quarantine.key
+      S3_BUCKET_FOLDER_REKOGNITION_DETECT_CUSTOM_LABELS     = aws_s3_object.rekognition_detect_custom_labels.key
       S3_BUCKET_FOLDER_REKOGNITION_DETECT_FACES             = aws_s3_object.rekognition_detect_faces.key
       S3_BUCKET_FOLDER_REKOGNITION_DETECT_LABELS            = aws_s3_object.rekognition_detect_labels.key
       S3_BUCKET_FOLDER_REKOGNITION_DETECT_MODERATION_LABELS = aws_s3_object.rekognition_detect_moderation_labels.key
       S3_BUCKET_FOLDER_REKOGNITION_DETECT_TEXT              = aws_s3_object.rekognition_detect_text.key
+      S3_BUCKET_FOLDER_REKOGNITION_RECOGNIZE_CELEBRITIES    = aws_s3_object.rekognition_recognize_celebrities.key
+      S3_BUCKET_FOLDER_REKOGNITION_STATUS                   = aws_s3_object.rekognition_status.key
     }
   }
   handler          = "main"
   filename         = "./src/lambda_function/s3_object_notification/object_created/image_compressed/lambda.zip"
   function_name    = "${var.application}S3ObjectNotificationObjectCreatedImageCompressed"
   layers           = null
-  memory_size      = 128
+  memory_size      = 512
   package_type     = "Zip"
   publish          = false
   runtime          = "provided.al2"
   skip_destroy     = false
   source_code_hash = sha256("./src/lambda_function/s3_object_notification/object_created/image_compressed/lambda.zip")
-  role             = aws_iam_role.lambda_s3_bucket_notification.arn
+  role             = aws_iam_role.lambda_s3_object_quarantine.arn
   timeout          = 60
   tracing_config {
     mode = "Active"
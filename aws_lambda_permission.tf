@@ -1,3 +1,11 @@
+resource "aws_lambda_permission" "contact_sheet" {
+  action        = "lambda:InvokeFunction"
+  function_name = aws_lambda_function.contact_sheet.function_name
+  principal     = "events.amazonaws.com"
+  source_arn    = aws_cloudwatch_event_rule.contact_sheet.arn
+  statement_id  = "AllowEventBridgeInvoke"
+}
+
 resource "aws_lambda_permission" "s3_object_notification_object_created_image" {
   action        = "lambda:InvokeFunction"
   function_name = aws_lambda_function.s3_object_notification_object_created_image.function_name
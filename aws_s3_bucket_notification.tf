@@ -26,4 +26,11 @@ resource "aws_s3_bucket_notification" "main" {
     filter_suffix       = ".JPG"
     lambda_function_arn = aws_lambda_function.s3_object_notification_object_created_image_compressed.arn
   }
+
+  lambda_function {
+    events              = ["s3:ObjectCreated:*"]
+    filter_prefix       = aws_s3_object.images_exif.key
+    filter_suffix       = ".JSON"
+    lambda_function_arn = aws_lambda_function.s3_object_notification_object_created_image_compressed.arn
+  }
 }
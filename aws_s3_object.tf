@@ -4,6 +4,12 @@ resource "aws_s3_object" "analytics" {
   key          = "analytics/"
 }
 
+resource "aws_s3_object" "contact_sheets" {
+  bucket       = aws_s3_bucket.main.id
+  content_type = "application/x-directory"
+  key          = "contact_sheets/"
+}
+
 resource "aws_s3_object" "dynamodb" {
   bucket       = aws_s3_bucket.main.id
   content_type = "application/x-directory"
@@ -16,6 +22,13 @@ resource "aws_s3_object" "images" {
   key          = "images/"
 }
 
+resource "aws_s3_object" "images_annotations" {
+  bucket       = aws_s3_bucket.main.id
+  content_type = "application/x-directory"
+  depends_on   = [aws_s3_object.images]
+  key          = "${aws_s3_object.images.key}annotations/"
+}
+
 resource "aws_s3_object" "images_compressed" {
   bucket       = aws_s3_bucket.main.id
   content_type = "application/x-directory"
@@ -30,6 +43,41 @@ resource "aws_s3_object" "images_exif" {
   key          = "${aws_s3_object.images.key}exif/"
 }
 
+resource "aws_s3_object" "images_graded" {
+  bucket       = aws_s3_bucket.main.id
+  content_type = "application/x-directory"
+  depends_on   = [aws_s3_object.images]
+  key          = "${aws_s3_object.images.key}graded/"
+}
+
+resource "aws_s3_object" "images_hashes" {
+  bucket       = aws_s3_bucket.main.id
+  content_type = "application/x-directory"
+  depends_on   = [aws_s3_object.images]
+  key          = "${aws_s3_object.images.key}hashes/"
+}
+
+resource "aws_s3_object" "images_keywords" {
+  bucket       = aws_s3_bucket.main.id
+  content_type = "application/x-directory"
+  depends_on   = [aws_s3_object.images]
+  key          = "${aws_s3_object.images.key}keywords/"
+}
+
+resource "aws_s3_object" "images_people" {
+  bucket       = aws_s3_bucket.main.id
+  content_type = "application/x-directory"
+  depends_on   = [aws_s3_object.images]
+  key          = "${aws_s3_object.images.key}people/"
+}
+
+resource "aws_s3_object" "images_renditions" {
+  bucket       = aws_s3_bucket.main.id
+  content_type = "application/x-directory"
+  depends_on   = [aws_s3_object.images]
+  key          = "${aws_s3_object.images.key}renditions/"
+}
+
 resource "aws_s3_object" "images_uploaded" {
   bucket       = aws_s3_bucket.main.id
   content_type = "application/x-directory"
@@ -49,12 +97,31 @@ resource "aws_s3_object" "logs" {
   key          = "logs/"
 }
 
+resource "aws_s3_object" "people" {
+  bucket       = aws_s3_bucket.main.id
+  content_type = "application/x-directory"
+  key          = "people/"
+}
+
+resource "aws_s3_object" "quarantine" {
+  bucket       = aws_s3_bucket.main.id
+  content_type = "application/x-directory"
+  key          = "quarantine/"
+}
+
 resource "aws_s3_object" "rekognition" {
   bucket       = aws_s3_bucket.main.id
   content_type = "application/x-directory"
   key          = "rekognition/"
 }
 
+resource "aws_s3_object" "rekognition_detect_custom_labels" {
+  bucket       = aws_s3_bucket.main.id
+  content_type = "application/x-directory"
+  depends_on   = [aws_s3_object.rekognition]
+  key          = "${aws_s3_object.rekognition.key}detect_custom_labels/"
+}
+
 resource "aws_s3_object" "rekognition_detect_faces" {
   bucket       = aws_s3_bucket.main.id
   content_type = "application/x-directory"
@@ -82,3 +149,17 @@ resource "aws_s3_object" "rekognition_detect_text" {
   depends_on   = [aws_s3_object.rekognition]
   key          = "${aws_s3_object.rekognition.key}detect_text/"
 }
+
+resource "aws_s3_object" "rekognition_recognize_celebrities" {
+  bucket       = aws_s3_bucket.main.id
+  content_type = "application/x-directory"
+  depends_on   = [aws_s3_object.rekognition]
+  key          = "${aws_s3_object.rekognition.key}recognize_celebrities/"
+}
+
+resource "aws_s3_object" "rekognition_status" {
+  bucket       = aws_s3_bucket.main.id
+  content_type = "application/x-directory"
+  depends_on   = [aws_s3_object.rekognition]
+  key          = "${aws_s3_object.rekognition.key}status/"
+}
@@ -9,4 +9,7 @@ require (
 	golang.org/x/image v0.18.0
 )
 
-require github.com/jmespath/go-jmespath v0.4.0 // indirect
+require (
+	github.com/jmespath/go-jmespath v0.4.0 // indirect
+	golang.org/x/text v0.16.0 // indirect
+)
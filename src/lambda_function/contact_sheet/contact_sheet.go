@@ -0,0 +1,243 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+
+	"golang.org/x/image/draw"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+)
+
+// Contains functions for laying out and rendering contact sheets.
+//
+// A contact sheet is a grid of cells, each holding an image fitted within a square and an optional caption below it
+// with the file name and exposure settings. The images of a day are split across as many pages as needed.
+
+// Constants used to lay out contact sheets.
+const (
+	contactSheetFormatJPEG   = "JPEG"
+	contactSheetFormatPNG    = "PNG"
+	contactSheetJPEGQuality  = 90
+	contactSheetLineSpacing  = 1.4
+	contactSheetMaxCellSize  = 1024
+	contactSheetMaxColumns   = 20
+	contactSheetMaxRows      = 20
+	contactSheetMinFontSize  = 10
+	contactSheetMinPadding   = 4
+	contactSheetTruncatedEnd = "…"
+)
+
+// Content types and extensions of the contact sheet formats.
+var (
+	contactSheetContentTypes = map[string]string{
+		contactSheetFormatJPEG: "image/jpeg",
+		contactSheetFormatPNG:  "image/png"}
+	contactSheetExtensions = map[string]string{
+		contactSheetFormatJPEG: "JPG",
+		contactSheetFormatPNG:  "PNG"}
+)
+
+// Colours of contact sheets.
+var (
+	contactSheetBackground = color.RGBA{0xFF, 0xFF, 0xFF, 0xFF}
+	contactSheetForeground = color.RGBA{0x20, 0x20, 0x20, 0xFF}
+)
+
+// ExifMetadata contains the fields of the Exif metadata stored by the image function that captions show.
+type ExifMetadata struct {
+	ExposureTime    *string `json:"ExposureTime"`
+	FNumber         *string `json:"FNumber"`
+	ISOSpeedRatings *int    `json:"ISOSpeedRatings"`
+}
+
+// contactSheetLayout holds the dimensions of the cells of a contact sheet in pixels.
+type contactSheetLayout struct {
+	captionHeight int
+	captions      bool
+	cellSize      int
+	columns       int
+	fontSize      float64
+	padding       int
+	rows          int
+}
+
+// getContactSheetCaptions parses whether contact sheets are captioned.
+func getContactSheetCaptions(captions string) (bool, error) {
+	return strconv.ParseBool(captions)
+}
+
+// getContactSheetDimension parses and validates a contact sheet dimension, such as the number of columns.
+func getContactSheetDimension(dimension string, maximum int) (int, error) {
+	value, err := strconv.Atoi(dimension)
+	if err != nil {
+		return 0, err
+	}
+	if value < 1 || value > maximum {
+		return 0, fmt.Errorf("%d outside 1-%d", value, maximum)
+	}
+	return value, nil
+}
+
+// getContactSheetFormat validates the format contact sheets are encoded in.
+func getContactSheetFormat(format string) (string, error) {
+	if _, ok := contactSheetExtensions[format]; !ok {
+		return "", fmt.Errorf("unknown contact sheet format %q", format)
+	}
+	return format, nil
+}
+
+// newContactSheetLayout returns the layout of contact sheets with the cell size, columns and rows. The padding and
+// caption font scale with the cell size.
+func newContactSheetLayout(cellSize int, columns int, rows int, captions bool) *contactSheetLayout {
+	contactSheetLayout := contactSheetLayout{
+		captions: captions,
+		cellSize: cellSize,
+		columns:  columns,
+		padding:  max(contactSheetMinPadding, cellSize/16),
+		rows:     rows}
+	if captions {
+		contactSheetLayout.fontSize = math.Max(contactSheetMinFontSize, float64(cellSize)/16)
+		contactSheetLayout.captionHeight = contactSheetLayout.padding/2 + int(math.Ceil(2*contactSheetLayout.fontSize*contactSheetLineSpacing))
+	}
+	return &contactSheetLayout
+}
+
+// getPages splits the keys into the pages of contact sheets.
+func (l *contactSheetLayout) getPages(s3ObjectKeys []string) [][]string {
+	var pages [][]string
+	for size := l.columns * l.rows; len(s3ObjectKeys) > 0; s3ObjectKeys = s3ObjectKeys[min(size, len(s3ObjectKeys)):] {
+		pages = append(pages, s3ObjectKeys[:min(size, len(s3ObjectKeys))])
+	}
+	return pages
+}
+
+// getCellRectangle returns the square that the image of the cell at the index is fitted within.
+func (l *contactSheetLayout) getCellRectangle(index int) image.Rectangle {
+	x := l.padding + index%l.columns*(l.cellSize+l.padding)
+	y := l.padding + index/l.columns*(l.cellSize+l.captionHeight+l.padding)
+	return image.Rect(x, y, x+l.cellSize, y+l.cellSize)
+}
+
+// newImage returns a blank contact sheet with enough rows for the number of images.
+func (l *contactSheetLayout) newImage(count int) *image.RGBA {
+	rows := (count + l.columns - 1) / l.columns
+	contactSheet := image.NewRGBA(image.Rect(0, 0,
+		l.padding+min(count, l.columns)*(l.cellSize+l.padding),
+		l.padding+rows*(l.cellSize+l.captionHeight+l.padding)))
+	draw.Draw(contactSheet, contactSheet.Bounds(), image.NewUniform(contactSheetBackground), image.Point{}, draw.Src)
+	return contactSheet
+}
+
+// drawCell draws the image, fitted within its cell and centred, and the caption lines below it. A nil image leaves
+// the cell empty.
+func (l *contactSheetLayout) drawCell(contactSheet *image.RGBA, index int, imageSource image.Image, caption []string, captionFace font.Face) {
+	cellRectangle := l.getCellRectangle(index)
+	if imageSource != nil {
+		draw.CatmullRom.Scale(contactSheet, getFittedRectangle(imageSource.Bounds(), cellRectangle), imageSource, imageSource.Bounds(), draw.Src, nil)
+	}
+	if !l.captions {
+		return
+	}
+	fontDrawer := font.Drawer{
+		Dst:  contactSheet,
+		Src:  image.NewUniform(contactSheetForeground),
+		Face: captionFace}
+	lineHeight := l.fontSize * contactSheetLineSpacing
+	for i, line := range caption[:min(2, len(caption))] {
+		fontDrawer.Dot = fixed.Point26_6{
+			X: fixed.I(cellRectangle.Min.X),
+			Y: fixed.I(cellRectangle.Max.Y+l.padding/2) + captionFace.Metrics().Ascent + fixed.Int26_6(float64(i)*lineHeight*64)}
+		fontDrawer.DrawString(truncateCaption(captionFace, line, l.cellSize))
+	}
+}
+
+// getFittedRectangle returns the largest rectangle with the aspect ratio of the source that fits within the cell,
+// centred in it.
+func getFittedRectangle(imageSourceRectangle image.Rectangle, cellRectangle image.Rectangle) image.Rectangle {
+	scale := math.Min(float64(cellRectangle.Dx())/float64(imageSourceRectangle.Dx()), float64(cellRectangle.Dy())/float64(imageSourceRectangle.Dy()))
+	width := max(1, int(math.Round(float64(imageSourceRectangle.Dx())*scale)))
+	height := max(1, int(math.Round(float64(imageSourceRectangle.Dy())*scale)))
+	x := cellRectangle.Min.X + (cellRectangle.Dx()-width)/2
+	y := cellRectangle.Min.Y + (cellRectangle.Dy()-height)/2
+	return image.Rect(x, y, x+width, y+height)
+}
+
+// newContactSheetCaptionFace returns the Go Regular font face that captions are drawn with.
+func newContactSheetCaptionFace(size float64) (font.Face, error) {
+	captionFont, err := opentype.Parse(goregular.TTF)
+	if err != nil {
+		return nil, err
+	}
+	return opentype.NewFace(captionFont, &opentype.FaceOptions{Size: math.Max(size, contactSheetMinFontSize), DPI: 72, Hinting: font.HintingFull})
+}
+
+// truncateCaption shortens the caption with an ellipsis so that it fits within the width.
+func truncateCaption(captionFace font.Face, caption string, width int) string {
+	if font.MeasureString(captionFace, caption).Ceil() <= width {
+		return caption
+	}
+	runes := []rune(caption)
+	for len(runes) > 0 && font.MeasureString(captionFace, string(runes)+contactSheetTruncatedEnd).Ceil() > width {
+		runes = runes[:len(runes)-1]
+	}
+	return string(runes) + contactSheetTruncatedEnd
+}
+
+// getExposureCaption formats the shutter speed, aperture and ISO of the Exif metadata, such as "1/250s f/2.8 ISO 400".
+// Settings that are missing are left out.
+func getExposureCaption(exifMetadata *ExifMetadata) string {
+	var settings []string
+	if exifMetadata.ExposureTime != nil {
+		if exposureTime, ok := parseRational(*exifMetadata.ExposureTime); ok && exposureTime > 0 {
+			if exposureTime < 1 {
+				settings = append(settings, fmt.Sprintf("1/%.0fs", 1/exposureTime))
+			} else {
+				settings = append(settings, fmt.Sprintf("%ss", strconv.FormatFloat(math.Round(exposureTime*10)/10, 'f', -1, 64)))
+			}
+		}
+	}
+	if exifMetadata.FNumber != nil {
+		if fNumber, ok := parseRational(*exifMetadata.FNumber); ok && fNumber > 0 {
+			settings = append(settings, fmt.Sprintf("f/%s", strconv.FormatFloat(math.Round(fNumber*10)/10, 'f', -1, 64)))
+		}
+	}
+	if exifMetadata.ISOSpeedRatings != nil {
+		settings = append(settings, fmt.Sprintf("ISO %d", *exifMetadata.ISOSpeedRatings))
+	}
+	return strings.Join(settings, " ")
+}
+
+// parseRational parses an Exif rational, such as "28/10", or a decimal number.
+func parseRational(value string) (float64, bool) {
+	numerator, denominator, isRational := strings.Cut(value, "/")
+	n, err := strconv.ParseFloat(numerator, 64)
+	if err != nil {
+		return 0, false
+	}
+	if !isRational {
+		return n, true
+	}
+	d, err := strconv.ParseFloat(denominator, 64)
+	if err != nil || d == 0 {
+		return 0, false
+	}
+	return n / d, true
+}
+
+// encodeContactSheet encodes the contact sheet in the format.
+func encodeContactSheet(w io.Writer, contactSheet image.Image, format string) error {
+	if format == contactSheetFormatPNG {
+		return png.Encode(w, contactSheet)
+	}
+	return jpeg.Encode(w, contactSheet, &jpeg.Options{Quality: contactSheetJPEGQuality})
+}
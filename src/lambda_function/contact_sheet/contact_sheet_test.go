@@ -0,0 +1,114 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+func TestGetExposureCaption(t *testing.T) {
+	for _, test := range []struct {
+		exifMetadata ExifMetadata
+		caption      string
+	}{
+		{ExifMetadata{ExposureTime: aws.String("1/250"), FNumber: aws.String("28/10"), ISOSpeedRatings: aws.Int(400)}, "1/250s f/2.8 ISO 400"},
+		{ExifMetadata{ExposureTime: aws.String("10/1"), FNumber: aws.String("8/1")}, "10s f/8"},
+		{ExifMetadata{ExposureTime: aws.String("5/10")}, "1/2s"},
+		{ExifMetadata{ExposureTime: aws.String("1/0"), FNumber: aws.String("invalid")}, ""},
+		{ExifMetadata{}, ""},
+	} {
+		if caption := getExposureCaption(&test.exifMetadata); caption != test.caption {
+			t.Errorf("GetExposureCaption: got %q want %q", caption, test.caption)
+		}
+	}
+}
+
+func TestGetPages(t *testing.T) {
+	contactSheetLayout := newContactSheetLayout(64, 3, 2, false)
+	s3ObjectKeys := []string{"a", "b", "c", "d", "e", "f", "g", "h"}
+	pages := contactSheetLayout.getPages(s3ObjectKeys)
+	if len(pages) != 2 || len(pages[0]) != 6 || len(pages[1]) != 2 || pages[1][0] != "g" {
+		t.Fatalf("GetPages: %v", pages)
+	}
+	if pages := contactSheetLayout.getPages(nil); len(pages) != 0 {
+		t.Fatalf("GetPages: %v", pages)
+	}
+}
+
+func TestGetContactSheetDate(t *testing.T) {
+	now := time.Date(2024, 5, 2, 13, 30, 0, 0, time.UTC)
+	if date, err := getContactSheetDate("", now); err != nil || !date.Equal(time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("GetContactSheetDate: %v %v", date, err)
+	}
+	if date, err := getContactSheetDate("2023-12-31", now); err != nil || createS3ObjectKey("images/compressed", "", date) != "images/compressed/2023/12/31/" {
+		t.Errorf("GetContactSheetDate: %v %v", date, err)
+	}
+	if _, err := getContactSheetDate("31/12/2023", now); err == nil {
+		t.Errorf("GetContactSheetDate: invalid date accepted")
+	}
+}
+
+func TestContactSheetLayout(t *testing.T) {
+	contactSheetLayout := newContactSheetLayout(64, 3, 2, true)
+	contactSheet := contactSheetLayout.newImage(4)
+	rowHeight := contactSheetLayout.cellSize + contactSheetLayout.captionHeight + contactSheetLayout.padding
+	if contactSheet.Bounds() != image.Rect(0, 0, contactSheetLayout.padding+3*(64+contactSheetLayout.padding), contactSheetLayout.padding+2*rowHeight) {
+		t.Fatalf("NewImage: %v", contactSheet.Bounds())
+	}
+
+	// A landscape image is centred vertically within its cell, with the caption below.
+	captionFace, err := newContactSheetCaptionFace(contactSheetLayout.fontSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer captionFace.Close()
+	imageSource := image.NewUniform(color.RGBA{0xFF, 0, 0, 0xFF})
+	contactSheetLayout.drawCell(contactSheet, 4, &imageBounds{imageSource, image.Rect(0, 0, 200, 100)}, []string{"IMG_0001.JPG", "1/250s f/2.8 ISO 400"}, captionFace)
+	cellRectangle := contactSheetLayout.getCellRectangle(4)
+	if cellRectangle.Min.X != contactSheetLayout.padding*2+64 || cellRectangle.Min.Y != contactSheetLayout.padding+rowHeight {
+		t.Fatalf("GetCellRectangle: %v", cellRectangle)
+	}
+	if contactSheet.RGBAAt(cellRectangle.Min.X+32, cellRectangle.Min.Y+32) != (color.RGBA{0xFF, 0, 0, 0xFF}) {
+		t.Errorf("DrawCell: image not drawn")
+	}
+	if contactSheet.RGBAAt(cellRectangle.Min.X+32, cellRectangle.Min.Y+4) != contactSheetBackground {
+		t.Errorf("DrawCell: image not centred")
+	}
+	drawn := false
+	for y := cellRectangle.Max.Y; y < cellRectangle.Max.Y+contactSheetLayout.captionHeight; y++ {
+		for x := cellRectangle.Min.X; x < cellRectangle.Max.X; x++ {
+			drawn = drawn || contactSheet.RGBAAt(x, y) != contactSheetBackground
+		}
+	}
+	if !drawn {
+		t.Errorf("DrawCell: caption not drawn")
+	}
+}
+
+func TestTruncateCaption(t *testing.T) {
+	captionFace, err := newContactSheetCaptionFace(12)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer captionFace.Close()
+	if caption := truncateCaption(captionFace, "IMG_0001.JPG", 1000); caption != "IMG_0001.JPG" {
+		t.Errorf("TruncateCaption: %q", caption)
+	}
+	if caption := truncateCaption(captionFace, "A_VERY_LONG_FILE_NAME_0001.JPG", 60); len([]rune(caption)) >= 30 || caption[len(caption)-len(contactSheetTruncatedEnd):] != contactSheetTruncatedEnd {
+		t.Errorf("TruncateCaption: %q", caption)
+	}
+}
+
+// imageBounds gives an unbounded image, such as a uniform colour, finite bounds.
+type imageBounds struct {
+	image.Image
+	bounds image.Rectangle
+}
+
+// Bounds implements the image.Image interface.
+func (i *imageBounds) Bounds() image.Rectangle {
+	return i.bounds
+}
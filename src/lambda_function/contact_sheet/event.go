@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+)
+
+// ContactSheetEvent requests the contact sheets of the images compressed for a date. Date is formatted as YYYY-MM-DD
+// and defaults to the previous day, so that a daily schedule builds the sheets of the day before.
+type ContactSheetEvent struct {
+	Date string `json:"Date"`
+}
+
+// createS3ObjectKey creates an S3 object key in the date partition of the folder, matching the keys written by the
+// image function.
+func createS3ObjectKey(s3BucketFolder string, fileName string, fileTime time.Time) string {
+	return fmt.Sprintf("%s/%s/%s", s3BucketFolder, fileTime.Format("2006/01/02"), fileName)
+}
+
+// getContactSheetDate parses the date of the event, defaulting to the day before now.
+func getContactSheetDate(date string, now time.Time) (time.Time, error) {
+	if date == "" {
+		return now.AddDate(0, 0, -1).Truncate(24 * time.Hour), nil
+	}
+	return time.Parse(time.DateOnly, date)
+}
+
+// processContactSheetEvent builds and uploads the contact sheets of the date requested by the event.
+func processContactSheetEvent(session *session.Session, contactSheetEvent *ContactSheetEvent) {
+	date, err := getContactSheetDate(contactSheetEvent.Date, time.Now().UTC())
+	if err != nil {
+		log.Fatalf("ContactSheetEvent: Date=%s Error=%s", contactSheetEvent.Date, err)
+	}
+
+	// List the compressed images of the date partition.
+	s3Client := s3.New(session)
+	prefix := createS3ObjectKey(s3BucketFolderImagesCompressed, "", date)
+	s3ObjectKeys, err := listS3ObjectImageKeys(s3Client, s3BucketName, prefix)
+	if err != nil {
+		log.Fatalf("ContactSheet: Bucket=%s Prefix=%s Error=%s", s3BucketName, prefix, err)
+	}
+	contactSheetLayout := newContactSheetLayout(contactSheetCellSize, contactSheetColumns, contactSheetRows, contactSheetCaptions)
+	pages := contactSheetLayout.getPages(s3ObjectKeys)
+	log.Printf("ContactSheet: Bucket=%s Prefix=%s Images=%d Pages=%d", s3BucketName, prefix, len(s3ObjectKeys), len(pages))
+
+	s3UploadManager := s3manager.NewUploader(session)
+	for page, pageKeys := range pages {
+		processContactSheetPage(s3Client, s3UploadManager, contactSheetLayout, date, page+1, pageKeys)
+	}
+}
+
+// processContactSheetPage builds and uploads one page of contact sheets from the images of the keys.
+func processContactSheetPage(s3Client *s3.S3, s3UploadManager *s3manager.Uploader, contactSheetLayout *contactSheetLayout, date time.Time, page int, s3ObjectKeys []string) {
+	captionFace, err := newContactSheetCaptionFace(contactSheetLayout.fontSize)
+	if err != nil {
+		log.Fatalf("ContactSheet: Error=%s", err)
+	}
+	defer captionFace.Close()
+
+	contactSheet := contactSheetLayout.newImage(len(s3ObjectKeys))
+	for index, s3ObjectKey := range s3ObjectKeys {
+		imageSource, err := getS3ObjectImage(s3Client, s3BucketName, s3ObjectKey)
+		if err != nil {
+			// An unreadable image leaves an empty cell rather than failing the whole sheet.
+			log.Printf("ContactSheet: Key=%s Error=%s", s3ObjectKey, err)
+		}
+		var caption []string
+		if contactSheetLayout.captions {
+			caption = processContactSheetCaption(s3Client, s3ObjectKey, date)
+		}
+		contactSheetLayout.drawCell(contactSheet, index, imageSource, caption, captionFace)
+	}
+
+	// Upload the page to S3.
+	s3ObjectKey := createS3ObjectKey(s3BucketFolderContactSheets, fmt.Sprintf("%03d.%s", page, contactSheetExtensions[contactSheetFormat]), date)
+	log.Printf("ContactSheet: Page=%d Images=%d Bucket=%s Key=%s", page, len(s3ObjectKeys), s3BucketName, s3ObjectKey)
+	s3ManagerUploadOutput, err := putS3ObjectContactSheet(s3UploadManager, s3BucketName, s3ObjectKey, contactSheet, contactSheetFormat)
+	if err != nil {
+		log.Fatalf("ContactSheet: Page=%d Error=%s", page, err)
+	}
+	log.Printf("S3ManagerUploadOutput: Location=%s UploadID=%s", s3ManagerUploadOutput.Location, s3ManagerUploadOutput.UploadID)
+}
+
+// processContactSheetCaption returns the caption of the image with the key, made of its file name and, when its Exif
+// metadata has been stored, its exposure settings.
+func processContactSheetCaption(s3Client *s3.S3, s3ObjectKey string, date time.Time) []string {
+	caption := []string{path.Base(s3ObjectKey)}
+	s3ObjectKeyExif := createS3ObjectKey(s3BucketFolderImagesExif, fmt.Sprintf("%s.JSON", strings.Split(path.Base(s3ObjectKey), ".")[0]), date)
+	exifMetadata, err := getS3ObjectExifMetadata(s3Client, s3BucketName, s3ObjectKeyExif)
+	if err != nil {
+		log.Printf("ExifMetadata: Key=%s Error=%s", s3ObjectKeyExif, err)
+		return caption
+	}
+	if exposure := getExposureCaption(exifMetadata); exposure != "" {
+		caption = append(caption, exposure)
+	}
+	return caption
+}
@@ -0,0 +1,129 @@
+// Package main serves as the entry point for an AWS Lambda function that builds contact sheets of a day's images.
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/aws/session"
+)
+
+// Global variables to store the S3 bucket name and folder names.
+var (
+	s3BucketName                   string
+	s3BucketFolderContactSheets    string
+	s3BucketFolderImagesCompressed string
+	s3BucketFolderImagesExif       string
+)
+
+// Global variables to store the contact sheet configuration.
+var (
+	contactSheetCaptions bool
+	contactSheetCellSize int
+	contactSheetColumns  int
+	contactSheetFormat   string
+	contactSheetRows     int
+)
+
+// createAWSSession creates and returns a new AWS session.
+func createAWSSession() *session.Session {
+	awsSession, err := session.NewSession(nil)
+	if err != nil {
+		log.Fatalf("Session: Error=%s", err)
+	}
+	return awsSession
+}
+
+// getEnvironmentVariable retrieves an environment variable by its key and returns its value.
+// It exits the program with an error if the variable is not set or empty.
+func getEnvironmentVariable(key string) string {
+	environmentValue := os.Getenv(key)
+	if len(environmentValue) == 0 {
+		log.Fatalf("%s is not set", key)
+	}
+	return environmentValue
+}
+
+// getEnvironmentVariableOrDefault retrieves an environment variable by its key and returns its value.
+// It returns the defaultValue if the variable is not set or empty.
+func getEnvironmentVariableOrDefault(key string, defaultValue string) string {
+	environmentValue := os.Getenv(key)
+	if len(environmentValue) == 0 {
+		return defaultValue
+	}
+	return environmentValue
+}
+
+// validateS3Folders checks that S3 bucket folder names are unique and not empty.
+func validateS3Folders() {
+	folders := []string{
+		s3BucketFolderContactSheets,
+		s3BucketFolderImagesCompressed,
+		s3BucketFolderImagesExif,
+	}
+
+	// Create a map to store folder names and check for duplicates.
+	folderMap := make(map[string]bool)
+
+	for _, folder := range folders {
+		if folder == "" {
+			log.Fatal("S3 bucket folder names cannot be empty.")
+		}
+		if folderMap[folder] {
+			log.Fatalf("Duplicate S3 bucket folder name found: %s", folder)
+		}
+		folderMap[folder] = true
+	}
+}
+
+// handler is the AWS Lambda function that builds the contact sheets requested by the event.
+func handler(context context.Context, contactSheetEvent *ContactSheetEvent) {
+	log.Printf("S3_BUCKET_FOLDER_CONTACT_SHEETS=%s S3_BUCKET_FOLDER_IMAGES_COMPRESSED=%s S3_BUCKET_FOLDER_IMAGES_EXIF=%s",
+		s3BucketFolderContactSheets,
+		s3BucketFolderImagesCompressed,
+		s3BucketFolderImagesExif)
+
+	// Create an AWS session and process the contact sheet event.
+	awsSession := createAWSSession()
+	processContactSheetEvent(awsSession, contactSheetEvent)
+}
+
+// main function is the entry point of the AWS Lambda application.
+func main() {
+	// Initialize S3 bucket variables from environment variables.
+	s3BucketName = getEnvironmentVariable("S3_BUCKET_NAME")
+	s3BucketFolderContactSheets = getEnvironmentVariable("S3_BUCKET_FOLDER_CONTACT_SHEETS")
+	s3BucketFolderImagesCompressed = getEnvironmentVariable("S3_BUCKET_FOLDER_IMAGES_COMPRESSED")
+	s3BucketFolderImagesExif = getEnvironmentVariable("S3_BUCKET_FOLDER_IMAGES_EXIF")
+
+	// Validate S3 folder names.
+	validateS3Folders()
+
+	// Initialize the contact sheet configuration from environment variables.
+	var err error
+	contactSheetCaptions, err = getContactSheetCaptions(getEnvironmentVariableOrDefault("CONTACT_SHEET_CAPTIONS", "true"))
+	if err != nil {
+		log.Fatalf("CONTACT_SHEET_CAPTIONS: Error=%s", err)
+	}
+	contactSheetCellSize, err = getContactSheetDimension(getEnvironmentVariableOrDefault("CONTACT_SHEET_CELL_SIZE", "256"), contactSheetMaxCellSize)
+	if err != nil {
+		log.Fatalf("CONTACT_SHEET_CELL_SIZE: Error=%s", err)
+	}
+	contactSheetColumns, err = getContactSheetDimension(getEnvironmentVariableOrDefault("CONTACT_SHEET_COLUMNS", "5"), contactSheetMaxColumns)
+	if err != nil {
+		log.Fatalf("CONTACT_SHEET_COLUMNS: Error=%s", err)
+	}
+	contactSheetFormat, err = getContactSheetFormat(getEnvironmentVariableOrDefault("CONTACT_SHEET_FORMAT", contactSheetFormatJPEG))
+	if err != nil {
+		log.Fatalf("CONTACT_SHEET_FORMAT: Error=%s", err)
+	}
+	contactSheetRows, err = getContactSheetDimension(getEnvironmentVariableOrDefault("CONTACT_SHEET_ROWS", "6"), contactSheetMaxRows)
+	if err != nil {
+		log.Fatalf("CONTACT_SHEET_ROWS: Error=%s", err)
+	}
+
+	// Start the AWS Lambda handler function.
+	lambda.Start(handler)
+}
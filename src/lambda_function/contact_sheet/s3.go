@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"image"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+)
+
+// contactSheetImageExtensions lists the extensions of the images included in contact sheets.
+var contactSheetImageExtensions = map[string]bool{
+	".jpeg": true,
+	".jpg":  true,
+	".png":  true}
+
+// listS3ObjectImageKeys lists the keys of the images under the prefix, sorted so that pages are stable.
+func listS3ObjectImageKeys(s3Client *s3.S3, s3BucketName string, prefix string) ([]string, error) {
+	var s3ObjectKeys []string
+	s3ListObjectsV2Input := s3.ListObjectsV2Input{
+		Bucket: &s3BucketName,
+		Prefix: &prefix}
+	err := s3Client.ListObjectsV2Pages(&s3ListObjectsV2Input, func(s3ListObjectsV2Output *s3.ListObjectsV2Output, lastPage bool) bool {
+		for _, s3Object := range s3ListObjectsV2Output.Contents {
+			if contactSheetImageExtensions[strings.ToLower(path.Ext(aws.StringValue(s3Object.Key)))] {
+				s3ObjectKeys = append(s3ObjectKeys, aws.StringValue(s3Object.Key))
+			}
+		}
+		return true
+	})
+	sort.Strings(s3ObjectKeys)
+	return s3ObjectKeys, err
+}
+
+// getS3ObjectBytes downloads an object from the provided S3 bucket and returns its contents.
+func getS3ObjectBytes(s3Client *s3.S3, s3BucketName string, s3ObjectKey string) ([]byte, error) {
+	getObjectInput := s3.GetObjectInput{
+		Bucket: &s3BucketName,
+		Key:    &s3ObjectKey}
+	getObjectOutput, err := s3Client.GetObject(&getObjectInput)
+	if err != nil {
+		return nil, err
+	}
+	defer getObjectOutput.Body.Close()
+	return io.ReadAll(getObjectOutput.Body)
+}
+
+// getS3ObjectImage downloads and decodes an image from the provided S3 bucket.
+func getS3ObjectImage(s3Client *s3.S3, s3BucketName string, s3ObjectKey string) (image.Image, error) {
+	b, err := getS3ObjectBytes(s3Client, s3BucketName, s3ObjectKey)
+	if err != nil {
+		return nil, err
+	}
+	imageSource, _, err := image.Decode(bytes.NewReader(b))
+	return imageSource, err
+}
+
+// getS3ObjectExifMetadata downloads and parses the Exif metadata stored as JSON by the image function.
+func getS3ObjectExifMetadata(s3Client *s3.S3, s3BucketName string, s3ObjectKey string) (*ExifMetadata, error) {
+	b, err := getS3ObjectBytes(s3Client, s3BucketName, s3ObjectKey)
+	if err != nil {
+		return nil, err
+	}
+	var exifMetadata ExifMetadata
+	if err := json.Unmarshal(b, &exifMetadata); err != nil {
+		return nil, err
+	}
+	return &exifMetadata, nil
+}
+
+// putS3ObjectContactSheet encodes a contact sheet in the format and uploads it to the specified S3 bucket.
+func putS3ObjectContactSheet(s3UploadManager *s3manager.Uploader, s3BucketName string, s3ObjectKey string, contactSheet image.Image, format string) (*s3manager.UploadOutput, error) {
+	var buffer bytes.Buffer
+	if err := encodeContactSheet(&buffer, contactSheet, format); err != nil {
+		return nil, err
+	}
+	s3ManagerUploadInput := s3manager.UploadInput{
+		Body:        bytes.NewReader(buffer.Bytes()),
+		Bucket:      &s3BucketName,
+		ContentType: aws.String(contactSheetContentTypes[format]),
+		Key:         &s3ObjectKey}
+	return s3UploadManager.Upload(&s3ManagerUploadInput)
+}
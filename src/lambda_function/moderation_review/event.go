@@ -0,0 +1,162 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// Review actions and the statuses they give a quarantined image.
+const (
+	moderationReviewActionApprove  = "Approve"
+	moderationReviewActionReject   = "Reject"
+	moderationReviewStatusApproved = "Approved"
+	moderationReviewStatusPending  = "Pending"
+	moderationReviewStatusRejected = "Rejected"
+)
+
+// moderationReviewStatuses maps each review action to the status it gives a quarantined image.
+var moderationReviewStatuses = map[string]string{
+	moderationReviewActionApprove: moderationReviewStatusApproved,
+	moderationReviewActionReject:  moderationReviewStatusRejected}
+
+// ModerationReviewEvent approves or rejects an image quarantined by moderation, identified by the ID in its review
+// record: the date folders and name of the image, such as 2024/05/01/IMG_0001. Approve restores the image, the
+// objects derived from it and its Rekognition outputs to their original keys, and Reject permanently deletes them.
+type ModerationReviewEvent struct {
+	Action string `json:"Action"`
+	ID     string `json:"ID"`
+}
+
+// ModerationCategory is a top-level moderation category that caused an image to be quarantined.
+type ModerationCategory struct {
+	Confidence float64  `json:"Confidence"`
+	Labels     []string `json:"Labels"`
+	Name       string   `json:"Name"`
+}
+
+// ModerationReviewRecord is stored in the quarantine folder by the image_compressed Lambda for an image quarantined
+// by moderation, and is updated when the image is approved or rejected.
+type ModerationReviewRecord struct {
+	Categories    []*ModerationCategory `json:"Categories"`
+	ID            string                `json:"ID"`
+	Key           string                `json:"Key"`
+	Name          string                `json:"Name"`
+	QuarantineKey string                `json:"QuarantineKey"`
+	QuarantinedAt time.Time             `json:"QuarantinedAt"`
+	ReviewedAt    *time.Time            `json:"ReviewedAt"`
+	Status        string                `json:"Status"`
+}
+
+// getModerationReviewRecordKey returns the S3 object key of the review record of the image with the ID.
+func getModerationReviewRecordKey(imageID string) string {
+	return fmt.Sprintf("%s/moderation/%s.JSON", s3BucketFolderQuarantine, imageID)
+}
+
+// validateModerationImageID checks that the ID is a relative path of date folders and a name that stays within the
+// moderation quarantine.
+func validateModerationImageID(imageID string) error {
+	if imageID == "" || path.IsAbs(imageID) || path.Clean(imageID) != imageID || strings.HasPrefix(imageID, "..") {
+		return fmt.Errorf("invalid image ID %q", imageID)
+	}
+	return nil
+}
+
+// getModerationReviewStatus validates the event and returns the status that its action gives the image. Repeating
+// the action of an earlier review is allowed, so that a review interrupted part way through can be completed.
+func getModerationReviewStatus(moderationReviewEvent *ModerationReviewEvent, moderationReviewRecord *ModerationReviewRecord) (string, error) {
+	status, ok := moderationReviewStatuses[moderationReviewEvent.Action]
+	if !ok {
+		return "", fmt.Errorf("unknown review action %q", moderationReviewEvent.Action)
+	}
+	if moderationReviewRecord.Status != moderationReviewStatusPending && moderationReviewRecord.Status != status {
+		return "", fmt.Errorf("image %q is already %s", moderationReviewRecord.ID, moderationReviewRecord.Status)
+	}
+	return status, nil
+}
+
+// getRestoredKey returns the original key of an object quarantined under the quarantine key of its image.
+func getRestoredKey(quarantineKey string, s3ObjectKey string) (string, error) {
+	restoredKey, ok := strings.CutPrefix(s3ObjectKey, quarantineKey)
+	if !ok || restoredKey == "" {
+		return "", fmt.Errorf("key %q is not quarantined under %q", s3ObjectKey, quarantineKey)
+	}
+	return restoredKey, nil
+}
+
+// processModerationReviewEvent applies the review decision of the event to the quarantined image.
+func processModerationReviewEvent(session *session.Session, moderationReviewEvent *ModerationReviewEvent) {
+	log.Printf("ModerationReviewEvent: Action=%s ID=%s", moderationReviewEvent.Action, moderationReviewEvent.ID)
+	if err := validateModerationImageID(moderationReviewEvent.ID); err != nil {
+		log.Fatalf("ModerationReviewEvent: Error=%s", err)
+	}
+
+	// Read the review record of the image.
+	s3Client := s3.New(session)
+	s3ObjectKeyRecord := getModerationReviewRecordKey(moderationReviewEvent.ID)
+	b, err := getS3ObjectBytes(s3Client, s3BucketName, s3ObjectKeyRecord)
+	if err != nil {
+		log.Fatalf("ModerationReviewRecord: Bucket=%s Key=%s Error=%s", s3BucketName, s3ObjectKeyRecord, err)
+	}
+	var moderationReviewRecord ModerationReviewRecord
+	if err := json.Unmarshal(b, &moderationReviewRecord); err != nil {
+		log.Fatalf("ModerationReviewRecord: Key=%s Error=%s", s3ObjectKeyRecord, err)
+	}
+	status, err := getModerationReviewStatus(moderationReviewEvent, &moderationReviewRecord)
+	if err != nil {
+		log.Fatalf("ModerationReviewEvent: Error=%s", err)
+	}
+	s3ObjectKeys, err := listS3ObjectKeys(s3Client, s3BucketName, moderationReviewRecord.QuarantineKey)
+	if err != nil {
+		log.Fatalf("ModerationReview: Prefix=%s Error=%s", moderationReviewRecord.QuarantineKey, err)
+	}
+	log.Printf("ModerationReview: ID=%s Status=%s Objects=%d", moderationReviewRecord.ID, status, len(s3ObjectKeys))
+
+	reviewedAt := time.Now().UTC()
+	moderationReviewRecord.ReviewedAt = &reviewedAt
+	moderationReviewRecord.Status = status
+	if status == moderationReviewStatusApproved {
+		// Record the approval before restoring anything, so that the Lambdas triggered by the restored objects do not
+		// quarantine the image again.
+		processModerationReviewRecord(s3Client, s3ObjectKeyRecord, &moderationReviewRecord)
+		for _, s3ObjectKey := range s3ObjectKeys {
+			restoredKey, err := getRestoredKey(moderationReviewRecord.QuarantineKey, s3ObjectKey)
+			if err != nil {
+				log.Fatalf("ModerationReview: Error=%s", err)
+			}
+			log.Printf("ModerationReview: Key=%s RestoredKey=%s", s3ObjectKey, restoredKey)
+			if _, err := copyS3Object(s3Client, s3BucketName, s3ObjectKey, restoredKey); err != nil {
+				log.Fatalf("ModerationReview: Key=%s Error=%s", s3ObjectKey, err)
+			}
+			if _, err := deleteS3Object(s3Client, s3BucketName, s3ObjectKey); err != nil {
+				log.Fatalf("ModerationReview: Key=%s Error=%s", s3ObjectKey, err)
+			}
+		}
+		return
+	}
+
+	// Delete everything before recording the rejection, so that a failed rejection can be repeated.
+	for _, s3ObjectKey := range s3ObjectKeys {
+		log.Printf("ModerationReview: Key=%s Deleted=true", s3ObjectKey)
+		if _, err := deleteS3Object(s3Client, s3BucketName, s3ObjectKey); err != nil {
+			log.Fatalf("ModerationReview: Key=%s Error=%s", s3ObjectKey, err)
+		}
+	}
+	processModerationReviewRecord(s3Client, s3ObjectKeyRecord, &moderationReviewRecord)
+}
+
+// processModerationReviewRecord stores the updated review record.
+func processModerationReviewRecord(s3Client *s3.S3, s3ObjectKey string, moderationReviewRecord *ModerationReviewRecord) {
+	s3PutObjectOutput, err := putS3ObjectJSON(s3Client, s3BucketName, s3ObjectKey, moderationReviewRecord)
+	if err != nil {
+		log.Fatalf("ModerationReviewRecord: Key=%s Error=%s", s3ObjectKey, err)
+	}
+	log.Printf("S3PutObjectOutput: ETag=%s", aws.StringValue(s3PutObjectOutput.ETag))
+}
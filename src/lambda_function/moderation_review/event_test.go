@@ -0,0 +1,51 @@
+package main
+
+import "testing"
+
+func TestGetModerationReviewStatus(t *testing.T) {
+	for _, test := range []struct {
+		action string
+		status string
+		want   string
+	}{
+		{moderationReviewActionApprove, moderationReviewStatusPending, moderationReviewStatusApproved},
+		{moderationReviewActionReject, moderationReviewStatusPending, moderationReviewStatusRejected},
+		{moderationReviewActionApprove, moderationReviewStatusApproved, moderationReviewStatusApproved},
+		{moderationReviewActionReject, moderationReviewStatusApproved, ""},
+		{moderationReviewActionApprove, moderationReviewStatusRejected, ""},
+		{"Delete", moderationReviewStatusPending, ""},
+	} {
+		status, err := getModerationReviewStatus(&ModerationReviewEvent{Action: test.action, ID: "2024/05/01/IMG_0001"}, &ModerationReviewRecord{ID: "2024/05/01/IMG_0001", Name: "IMG_0001", Status: test.status})
+		if status != test.want || (err == nil) != (test.want != "") {
+			t.Errorf("GetModerationReviewStatus: %s %s got %q %v want %q", test.action, test.status, status, err, test.want)
+		}
+	}
+}
+
+func TestGetRestoredKey(t *testing.T) {
+	s3BucketFolderQuarantine = "quarantine/"
+	quarantineKey := "quarantine//moderation/2024/05/01/IMG_0001/"
+	restoredKey, err := getRestoredKey(quarantineKey, quarantineKey+"images/compressed//2024/05/01/IMG_0001.JPG")
+	if err != nil || restoredKey != "images/compressed//2024/05/01/IMG_0001.JPG" {
+		t.Fatalf("GetRestoredKey: %q %v", restoredKey, err)
+	}
+	for _, s3ObjectKey := range []string{quarantineKey, "quarantine//moderation/2024/05/01/IMG_0002/images/IMG_0002.JPG"} {
+		if _, err := getRestoredKey(quarantineKey, s3ObjectKey); err == nil {
+			t.Errorf("GetRestoredKey: %q accepted", s3ObjectKey)
+		}
+	}
+	if s3ObjectKey := getModerationReviewRecordKey("2024/05/01/IMG_0001"); s3ObjectKey != "quarantine//moderation/2024/05/01/IMG_0001.JSON" {
+		t.Errorf("GetModerationReviewRecordKey: %q", s3ObjectKey)
+	}
+}
+
+func TestValidateModerationImageID(t *testing.T) {
+	if err := validateModerationImageID("2024/05/01/IMG_0001"); err != nil {
+		t.Errorf("ValidateModerationImageID: %s", err)
+	}
+	for _, imageID := range []string{"", "/2024/05/01/IMG_0001", "../IMG_0001", "2024/05/../../IMG_0001", "2024//05/01/IMG_0001", "2024/05/01/IMG_0001/"} {
+		if err := validateModerationImageID(imageID); err == nil {
+			t.Errorf("ValidateModerationImageID: %q accepted", imageID)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+// Package main serves as the entry point for an AWS Lambda function that approves or rejects images quarantined by
+// moderation.
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/aws/session"
+)
+
+// Global variables to store the S3 bucket name and folder names.
+var (
+	s3BucketName             string
+	s3BucketFolderQuarantine string
+)
+
+// createAWSSession creates and returns a new AWS session.
+func createAWSSession() *session.Session {
+	awsSession, err := session.NewSession(nil)
+	if err != nil {
+		log.Fatalf("Session: Error=%s", err)
+	}
+	return awsSession
+}
+
+// getEnvironmentVariable retrieves an environment variable by its key and returns its value.
+// It exits the program with an error if the variable is not set or empty.
+func getEnvironmentVariable(key string) string {
+	environmentValue := os.Getenv(key)
+	if len(environmentValue) == 0 {
+		log.Fatalf("%s is not set", key)
+	}
+	return environmentValue
+}
+
+// handler is the AWS Lambda function that applies the review decision of the event.
+func handler(context context.Context, moderationReviewEvent *ModerationReviewEvent) {
+	log.Printf("S3_BUCKET_FOLDER_QUARANTINE=%s", s3BucketFolderQuarantine)
+
+	// Create an AWS session and process the moderation review event.
+	awsSession := createAWSSession()
+	processModerationReviewEvent(awsSession, moderationReviewEvent)
+}
+
+// main function is the entry point of the AWS Lambda application.
+func main() {
+	// Initialize S3 bucket variables from environment variables.
+	s3BucketName = getEnvironmentVariable("S3_BUCKET_NAME")
+	s3BucketFolderQuarantine = getEnvironmentVariable("S3_BUCKET_FOLDER_QUARANTINE")
+
+	// Start the AWS Lambda handler function.
+	lambda.Start(handler)
+}
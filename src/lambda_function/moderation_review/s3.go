@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"sort"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// Contains utility functions for interacting with Amazon S3.
+
+// listS3ObjectKeys lists the keys of the objects under the prefix, sorted so that reviews are logged in a stable order.
+func listS3ObjectKeys(s3Client *s3.S3, s3BucketName string, prefix string) ([]string, error) {
+	var s3ObjectKeys []string
+	s3ListObjectsV2Input := s3.ListObjectsV2Input{
+		Bucket: &s3BucketName,
+		Prefix: &prefix}
+	err := s3Client.ListObjectsV2Pages(&s3ListObjectsV2Input, func(s3ListObjectsV2Output *s3.ListObjectsV2Output, lastPage bool) bool {
+		for _, s3Object := range s3ListObjectsV2Output.Contents {
+			s3ObjectKeys = append(s3ObjectKeys, aws.StringValue(s3Object.Key))
+		}
+		return true
+	})
+	sort.Strings(s3ObjectKeys)
+	return s3ObjectKeys, err
+}
+
+// getS3ObjectBytes downloads an object from the provided S3 bucket and returns its contents.
+func getS3ObjectBytes(s3Client *s3.S3, s3BucketName string, s3ObjectKey string) ([]byte, error) {
+	getObjectInput := s3.GetObjectInput{
+		Bucket: &s3BucketName,
+		Key:    &s3ObjectKey}
+	getObjectOutput, err := s3Client.GetObject(&getObjectInput)
+	if err != nil {
+		return nil, err
+	}
+	defer getObjectOutput.Body.Close()
+	return io.ReadAll(getObjectOutput.Body)
+}
+
+// putS3ObjectJSON serializes the s3ObjectBody to JSON and uploads it to the specified S3 bucket.
+func putS3ObjectJSON(s3Client *s3.S3, s3BucketName string, s3ObjectKey string, s3ObjectBody interface{}) (*s3.PutObjectOutput, error) {
+	b, err := json.Marshal(s3ObjectBody)
+	if err != nil {
+		return nil, err
+	}
+	s3PutObjectInput := s3.PutObjectInput{
+		Bucket:        &s3BucketName,
+		Body:          aws.ReadSeekCloser(bytes.NewReader(b)),
+		ContentLength: aws.Int64(int64(len(b))),
+		ContentType:   aws.String("application/json"),
+		Key:           &s3ObjectKey}
+	return s3Client.PutObject(&s3PutObjectInput)
+}
+
+// copyS3Object copies an object within the specified S3 bucket.
+func copyS3Object(s3Client *s3.S3, s3BucketName string, s3ObjectKeySource string, s3ObjectKeyDestination string) (*s3.CopyObjectOutput, error) {
+	s3CopyObjectInput := s3.CopyObjectInput{
+		Bucket:     &s3BucketName,
+		CopySource: aws.String(url.PathEscape(fmt.Sprintf("%s/%s", s3BucketName, s3ObjectKeySource))),
+		Key:        &s3ObjectKeyDestination}
+	return s3Client.CopyObject(&s3CopyObjectInput)
+}
+
+// deleteS3Object deletes an object from the specified S3 bucket.
+func deleteS3Object(s3Client *s3.S3, s3BucketName string, s3ObjectKey string) (*s3.DeleteObjectOutput, error) {
+	s3DeleteObjectInput := s3.DeleteObjectInput{
+		Bucket: &s3BucketName,
+		Key:    &s3ObjectKey}
+	return s3Client.DeleteObject(&s3DeleteObjectInput)
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+const (
+	// keywordSetMaxAttempts limits the attempts to update a keyword set while other writers change it.
+	keywordSetMaxAttempts = 5
+
+	// peopleRegistryMaxAttempts limits the attempts to update the people registry while other writers change it.
+	peopleRegistryMaxAttempts = 5
+)
+
+// People commands.
+const (
+	peopleActionMerge = "Merge"
+	peopleActionName  = "Name"
+	peopleActionSplit = "Split"
+)
+
+// PeopleEvent is a command that edits the people registry. Name names PersonID, Merge merges PersonIDs into the
+// first of them and Split moves FaceIDs out of PersonID into a new person, named Name when it is set.
+type PeopleEvent struct {
+	Action    string   `json:"Action"`
+	FaceIDs   []string `json:"FaceIds"`
+	Name      string   `json:"Name"`
+	PersonID  string   `json:"PersonID"`
+	PersonIDs []string `json:"PersonIDs"`
+}
+
+// getPeopleRegistryKey returns the S3 object key of the people registry.
+func getPeopleRegistryKey() string {
+	return fmt.Sprintf("%s/registry.JSON", s3BucketFolderPeople)
+}
+
+// getPhotoPeopleKey returns the S3 object key of the people of the photo with the ID.
+func getPhotoPeopleKey(imageID string) string {
+	return fmt.Sprintf("%s/%s.JSON", s3BucketFolderImagesPeople, imageID)
+}
+
+// getKeywordSetKey returns the S3 object key of the keyword set of the named photo.
+func getKeywordSetKey(name string) string {
+	return fmt.Sprintf("%s/%s.JSON", s3BucketFolderImagesKeywords, name)
+}
+
+// applyPeopleEvent applies the command of the event to the registry. It returns the external image IDs of the photos
+// affected.
+func applyPeopleEvent(peopleRegistry *PeopleRegistry, peopleEvent *PeopleEvent) ([]string, error) {
+	switch peopleEvent.Action {
+	case peopleActionMerge:
+		return mergePeople(peopleRegistry, peopleEvent.PersonIDs)
+	case peopleActionName:
+		if peopleEvent.Name == "" {
+			return nil, fmt.Errorf("naming requires a name")
+		}
+		return namePerson(peopleRegistry, peopleEvent.PersonID, peopleEvent.Name)
+	case peopleActionSplit:
+		return splitPerson(peopleRegistry, peopleEvent.PersonID, peopleEvent.FaceIDs, peopleEvent.Name)
+	}
+	return nil, fmt.Errorf("unknown people action %q", peopleEvent.Action)
+}
+
+// processPeopleEvent applies the command of the event to the people registry and rewrites the people and keyword
+// sets of the photos affected. The registry is stored only if no other writer, such as the image_compressed Lambda
+// adding the faces of a photo, changed it in the meantime, and the command is applied to the latest registry otherwise.
+func processPeopleEvent(session *session.Session, peopleEvent *PeopleEvent) {
+	log.Printf("PeopleEvent: Action=%s PersonID=%s PersonIDs=%v FaceIds=%v Name=%s",
+		peopleEvent.Action,
+		peopleEvent.PersonID,
+		peopleEvent.PersonIDs,
+		peopleEvent.FaceIDs,
+		peopleEvent.Name)
+
+	s3Client := s3.New(session)
+	var peopleRegistry PeopleRegistry
+	var externalImageIDs []string
+	for attempt := 1; ; attempt++ {
+		peopleRegistry = PeopleRegistry{}
+		eTag, err := getS3ObjectJSONETag(s3Client, s3BucketName, getPeopleRegistryKey(), &peopleRegistry)
+		if err != nil {
+			log.Fatalf("PeopleRegistry: Error=%s", err)
+		}
+		externalImageIDs, err = applyPeopleEvent(&peopleRegistry, peopleEvent)
+		if err != nil {
+			log.Fatalf("PeopleEvent: Error=%s", err)
+		}
+		_, err = putS3ObjectJSONConditional(s3Client, s3BucketName, getPeopleRegistryKey(), &peopleRegistry, eTag)
+		if err == nil {
+			break
+		}
+		if !isS3PreconditionFailed(err) || attempt == peopleRegistryMaxAttempts {
+			log.Fatalf("S3PutObject: Key=%s Error=%s", getPeopleRegistryKey(), err)
+		}
+		log.Printf("PeopleRegistry: Attempt=%d Error=%s", attempt, err)
+	}
+	log.Printf("S3PutObject: Key=%s", getPeopleRegistryKey())
+	log.Printf("PeopleRegistry: People=%d Photos=%d", len(peopleRegistry.People), len(externalImageIDs))
+
+	for _, externalImageID := range externalImageIDs {
+		photoPeople := getPhotoPeople(&peopleRegistry, externalImageID)
+		processS3ObjectJSON(s3Client, getPhotoPeopleKey(photoPeople.ID), photoPeople)
+
+		processKeywordSet(s3Client, photoPeople)
+	}
+}
+
+// processKeywordSet replaces the people keywords of the keyword set of the photo. The set is stored only if no other
+// writer, such as the image_compressed Lambda adding the keywords of a detector, changed it in the meantime, and the
+// keywords are applied to the latest set otherwise.
+func processKeywordSet(s3Client *s3.S3, photoPeople *PhotoPeople) {
+	s3ObjectKey := getKeywordSetKey(photoPeople.Name)
+	for attempt := 1; ; attempt++ {
+		keywordSet := KeywordSet{Name: photoPeople.Name}
+		eTag, err := getS3ObjectJSONETag(s3Client, s3BucketName, s3ObjectKey, &keywordSet)
+		if err != nil {
+			log.Fatalf("KeywordSet: Name=%s Error=%s", photoPeople.Name, err)
+		}
+		setPeopleKeywords(&keywordSet, photoPeople)
+		_, err = putS3ObjectJSONConditional(s3Client, s3BucketName, s3ObjectKey, &keywordSet, eTag)
+		if err == nil {
+			break
+		}
+		if !isS3PreconditionFailed(err) || attempt == keywordSetMaxAttempts {
+			log.Fatalf("S3PutObject: Key=%s Error=%s", s3ObjectKey, err)
+		}
+		log.Printf("KeywordSet: Name=%s Attempt=%d Error=%s", photoPeople.Name, attempt, err)
+	}
+	log.Printf("S3PutObject: Key=%s", s3ObjectKey)
+}
+
+// processS3ObjectJSON stores the JSON document in S3.
+func processS3ObjectJSON(s3Client *s3.S3, s3ObjectKey string, s3ObjectBody interface{}) {
+	if _, err := putS3ObjectJSON(s3Client, s3BucketName, s3ObjectKey, s3ObjectBody); err != nil {
+		log.Fatalf("S3PutObject: Key=%s Error=%s", s3ObjectKey, err)
+	}
+	log.Printf("S3PutObject: Key=%s", s3ObjectKey)
+}
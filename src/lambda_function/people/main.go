@@ -0,0 +1,88 @@
+// Package main serves as the entry point for an AWS Lambda function that names, merges and splits the people
+// recognised in photos.
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/aws/session"
+)
+
+// Global variables to store the S3 bucket name and folder names.
+var (
+	s3BucketName                 string
+	s3BucketFolderImagesKeywords string
+	s3BucketFolderImagesPeople   string
+	s3BucketFolderPeople         string
+)
+
+// createAWSSession creates and returns a new AWS session.
+func createAWSSession() *session.Session {
+	awsSession, err := session.NewSession(nil)
+	if err != nil {
+		log.Fatalf("Session: Error=%s", err)
+	}
+	return awsSession
+}
+
+// getEnvironmentVariable retrieves an environment variable by its key and returns its value.
+// It exits the program with an error if the variable is not set or empty.
+func getEnvironmentVariable(key string) string {
+	environmentValue := os.Getenv(key)
+	if len(environmentValue) == 0 {
+		log.Fatalf("%s is not set", key)
+	}
+	return environmentValue
+}
+
+// validateS3Folders checks that S3 bucket folder names are unique and not empty.
+func validateS3Folders() {
+	folders := []string{
+		s3BucketFolderImagesKeywords,
+		s3BucketFolderImagesPeople,
+		s3BucketFolderPeople,
+	}
+
+	// Create a map to store folder names and check for duplicates.
+	folderMap := make(map[string]bool)
+
+	for _, folder := range folders {
+		if folder == "" {
+			log.Fatal("S3 bucket folder names cannot be empty.")
+		}
+		if folderMap[folder] {
+			log.Fatalf("Duplicate S3 bucket folder name found: %s", folder)
+		}
+		folderMap[folder] = true
+	}
+}
+
+// handler is the AWS Lambda function that applies the command of the event to the people registry.
+func handler(context context.Context, peopleEvent *PeopleEvent) {
+	log.Printf("S3_BUCKET_FOLDER_IMAGES_KEYWORDS=%s S3_BUCKET_FOLDER_IMAGES_PEOPLE=%s S3_BUCKET_FOLDER_PEOPLE=%s",
+		s3BucketFolderImagesKeywords,
+		s3BucketFolderImagesPeople,
+		s3BucketFolderPeople)
+
+	// Create an AWS session and process the people event.
+	awsSession := createAWSSession()
+	processPeopleEvent(awsSession, peopleEvent)
+}
+
+// main function is the entry point of the AWS Lambda application.
+func main() {
+	// Initialize S3 bucket variables from environment variables.
+	s3BucketName = getEnvironmentVariable("S3_BUCKET_NAME")
+	s3BucketFolderImagesKeywords = getEnvironmentVariable("S3_BUCKET_FOLDER_IMAGES_KEYWORDS")
+	s3BucketFolderImagesPeople = getEnvironmentVariable("S3_BUCKET_FOLDER_IMAGES_PEOPLE")
+	s3BucketFolderPeople = getEnvironmentVariable("S3_BUCKET_FOLDER_PEOPLE")
+
+	// Validate S3 folder names.
+	validateS3Folders()
+
+	// Start the AWS Lambda handler function.
+	lambda.Start(handler)
+}
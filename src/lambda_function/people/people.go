@@ -0,0 +1,249 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/rekognition"
+)
+
+// Contains the people registry written by the image_compressed Lambda and the commands that edit it.
+
+// keywordSourcePerson is the keyword source of the people named in a photo.
+const keywordSourcePerson = "Person"
+
+// PersonFace is a face of a person, indexed into the face collection from the photo identified by its external image
+// ID.
+type PersonFace struct {
+	BoundingBox     *rekognition.BoundingBox `json:"BoundingBox"`
+	Confidence      float64                  `json:"Confidence"`
+	ExternalImageID string                   `json:"ExternalImageId"`
+	FaceID          string                   `json:"FaceId"`
+}
+
+// Person groups the faces of one person. ID is the face ID of the person's first face, and Name is empty until the
+// person is named.
+type Person struct {
+	Faces []*PersonFace `json:"Faces"`
+	ID    string        `json:"ID"`
+	Name  string        `json:"Name"`
+}
+
+// PeopleRegistry is the registry of the people known to the face collection.
+type PeopleRegistry struct {
+	People []*Person `json:"People"`
+}
+
+// PhotoPerson is a person found in a photo.
+type PhotoPerson struct {
+	BoundingBox *rekognition.BoundingBox `json:"BoundingBox"`
+	Confidence  float64                  `json:"Confidence"`
+	FaceID      string                   `json:"FaceId"`
+	Name        string                   `json:"Name"`
+	PersonID    string                   `json:"PersonID"`
+}
+
+// PhotoPeople lists the people found in a photo.
+type PhotoPeople struct {
+	ID     string         `json:"ID"`
+	Name   string         `json:"Name"`
+	People []*PhotoPerson `json:"People"`
+}
+
+// Keyword is a keyword found in a photo.
+type Keyword struct {
+	Confidence float64  `json:"Confidence"`
+	Name       string   `json:"Name"`
+	Source     string   `json:"Source"`
+	URLs       []string `json:"URLs"`
+}
+
+// KeywordSet is the set of keywords found in a photo.
+type KeywordSet struct {
+	Keywords []*Keyword `json:"Keywords"`
+	Name     string     `json:"Name"`
+}
+
+// getPerson returns the person with the ID.
+func (r *PeopleRegistry) getPerson(personID string) (*Person, error) {
+	for _, person := range r.People {
+		if person.ID == personID {
+			return person, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown person %q", personID)
+}
+
+// removePerson removes the person from the registry.
+func (r *PeopleRegistry) removePerson(person *Person) {
+	for i := range r.People {
+		if r.People[i] == person {
+			r.People = append(r.People[:i], r.People[i+1:]...)
+			return
+		}
+	}
+}
+
+// getImageID returns the ID of the photo, its date folders and name such as 2024/05/01/IMG_0001, from the external image
+// ID of its faces, in which the image_compressed Lambda replaced the slashes with colons.
+func getImageID(externalImageID string) string {
+	return strings.Replace(externalImageID, ":", "/", 3)
+}
+
+// getExternalImageIDs returns the external image IDs of the photos that the faces of the people were indexed from.
+func getExternalImageIDs(people ...*Person) []string {
+	var externalImageIDs []string
+	seen := make(map[string]bool)
+	for _, person := range people {
+		for _, face := range person.Faces {
+			if !seen[face.ExternalImageID] {
+				seen[face.ExternalImageID] = true
+				externalImageIDs = append(externalImageIDs, face.ExternalImageID)
+			}
+		}
+	}
+	sort.Strings(externalImageIDs)
+	return externalImageIDs
+}
+
+// namePerson names the person. It returns the external image IDs of the photos affected.
+func namePerson(peopleRegistry *PeopleRegistry, personID string, name string) ([]string, error) {
+	person, err := peopleRegistry.getPerson(personID)
+	if err != nil {
+		return nil, err
+	}
+	person.Name = name
+	return getExternalImageIDs(person), nil
+}
+
+// mergePeople merges the people into the first, which keeps its name or takes the first name among the others.
+// It returns the external image IDs of the photos affected.
+func mergePeople(peopleRegistry *PeopleRegistry, personIDs []string) ([]string, error) {
+	if len(personIDs) < 2 {
+		return nil, fmt.Errorf("merging requires at least two people, found %d", len(personIDs))
+	}
+	var people []*Person
+	for _, personID := range personIDs {
+		person, err := peopleRegistry.getPerson(personID)
+		if err != nil {
+			return nil, err
+		}
+		for _, other := range people {
+			if other == person {
+				return nil, fmt.Errorf("person %q listed twice", personID)
+			}
+		}
+		people = append(people, person)
+	}
+	merged := people[0]
+	for _, person := range people[1:] {
+		merged.Faces = append(merged.Faces, person.Faces...)
+		if merged.Name == "" {
+			merged.Name = person.Name
+		}
+		peopleRegistry.removePerson(person)
+	}
+	return getExternalImageIDs(merged), nil
+}
+
+// splitPerson moves the faces out of the person into a new person with the name, whose ID is the first face's ID.
+// It returns the external image IDs of the photos affected.
+func splitPerson(peopleRegistry *PeopleRegistry, personID string, faceIDs []string, name string) ([]string, error) {
+	person, err := peopleRegistry.getPerson(personID)
+	if err != nil {
+		return nil, err
+	}
+	if len(faceIDs) == 0 {
+		return nil, fmt.Errorf("splitting requires at least one face")
+	}
+	split := make(map[string]bool)
+	for _, faceID := range faceIDs {
+		split[faceID] = true
+	}
+	var kept, moved []*PersonFace
+	for _, face := range person.Faces {
+		if split[face.FaceID] {
+			moved = append(moved, face)
+		} else {
+			kept = append(kept, face)
+		}
+	}
+	if len(moved) != len(split) {
+		return nil, fmt.Errorf("person %q does not have all of the faces %v", personID, faceIDs)
+	}
+	if len(kept) == 0 {
+		return nil, fmt.Errorf("splitting every face from person %q leaves it empty", personID)
+	}
+
+	// The person keeps its ID unless the face it was named after moves, and the new person takes the ID of its
+	// first face.
+	person.Faces = kept
+	if split[person.ID] {
+		person.ID = kept[0].FaceID
+	}
+	newPerson := Person{Faces: moved, ID: moved[0].FaceID, Name: name}
+	peopleRegistry.People = append(peopleRegistry.People, &newPerson)
+	return getExternalImageIDs(person, &newPerson), nil
+}
+
+// getPhotoPeople returns the people of the registry found in the photo with the external image ID, ordered from left
+// to right.
+func getPhotoPeople(peopleRegistry *PeopleRegistry, externalImageID string) *PhotoPeople {
+	imageID := getImageID(externalImageID)
+	photoPeople := PhotoPeople{ID: imageID, Name: path.Base(imageID)}
+	for _, person := range peopleRegistry.People {
+		for _, face := range person.Faces {
+			if face.ExternalImageID == externalImageID {
+				photoPeople.People = append(photoPeople.People, &PhotoPerson{
+					BoundingBox: face.BoundingBox,
+					Confidence:  face.Confidence,
+					FaceID:      face.FaceID,
+					Name:        person.Name,
+					PersonID:    person.ID})
+			}
+		}
+	}
+	left := func(photoPerson *PhotoPerson) float64 {
+		if photoPerson.BoundingBox == nil {
+			return 0
+		}
+		return aws.Float64Value(photoPerson.BoundingBox.Left)
+	}
+	sort.SliceStable(photoPeople.People, func(i, j int) bool {
+		return left(photoPeople.People[i]) < left(photoPeople.People[j])
+	})
+	return &photoPeople
+}
+
+// setPeopleKeywords replaces the people keywords of the keyword set with the named people of the photo.
+func setPeopleKeywords(keywordSet *KeywordSet, photoPeople *PhotoPeople) {
+	var keywords []*Keyword
+	keywordMap := make(map[string]*Keyword)
+	for _, keyword := range keywordSet.Keywords {
+		if keyword.Source != keywordSourcePerson {
+			keywords = append(keywords, keyword)
+		}
+	}
+	for _, photoPerson := range photoPeople.People {
+		if photoPerson.Name == "" {
+			continue
+		}
+		if keyword, ok := keywordMap[photoPerson.Name]; ok {
+			keyword.Confidence = max(keyword.Confidence, photoPerson.Confidence)
+			continue
+		}
+		keyword := Keyword{Confidence: photoPerson.Confidence, Name: photoPerson.Name, Source: keywordSourcePerson}
+		keywordMap[photoPerson.Name] = &keyword
+		keywords = append(keywords, &keyword)
+	}
+	sort.SliceStable(keywords, func(i, j int) bool {
+		if keywords[i].Name != keywords[j].Name {
+			return keywords[i].Name < keywords[j].Name
+		}
+		return keywords[i].Source < keywords[j].Source
+	})
+	keywordSet.Keywords = keywords
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/rekognition"
+)
+
+// newTestPeopleRegistry returns a registry of two people, Alice with faces in photos A and B, and an unnamed person
+// with a face in photo B.
+func newTestPeopleRegistry() *PeopleRegistry {
+	return &PeopleRegistry{People: []*Person{
+		{ID: "f1", Name: "Alice", Faces: []*PersonFace{
+			{BoundingBox: &rekognition.BoundingBox{Left: aws.Float64(0.6)}, Confidence: 99, ExternalImageID: "A", FaceID: "f1"},
+			{BoundingBox: &rekognition.BoundingBox{Left: aws.Float64(0.6)}, Confidence: 98, ExternalImageID: "B", FaceID: "f2"}}},
+		{ID: "f3", Faces: []*PersonFace{
+			{BoundingBox: &rekognition.BoundingBox{Left: aws.Float64(0.1)}, Confidence: 97, ExternalImageID: "B", FaceID: "f3"}}},
+	}}
+}
+
+func TestApplyPeopleEvent(t *testing.T) {
+	peopleRegistry := newTestPeopleRegistry()
+	names, err := applyPeopleEvent(peopleRegistry, &PeopleEvent{Action: peopleActionName, PersonID: "f3", Name: "Bob"})
+	if err != nil || !reflect.DeepEqual(names, []string{"B"}) || peopleRegistry.People[1].Name != "Bob" {
+		t.Fatalf("Name: %v %v", names, err)
+	}
+
+	names, err = applyPeopleEvent(peopleRegistry, &PeopleEvent{Action: peopleActionMerge, PersonIDs: []string{"f1", "f3"}})
+	if err != nil || !reflect.DeepEqual(names, []string{"A", "B"}) || len(peopleRegistry.People) != 1 || len(peopleRegistry.People[0].Faces) != 3 || peopleRegistry.People[0].Name != "Alice" {
+		t.Fatalf("Merge: %v %v %+v", names, err, peopleRegistry.People)
+	}
+
+	// Splitting the face the person was named after gives the person a new ID.
+	names, err = applyPeopleEvent(peopleRegistry, &PeopleEvent{Action: peopleActionSplit, PersonID: "f1", FaceIDs: []string{"f1", "f3"}, Name: "Carol"})
+	if err != nil || !reflect.DeepEqual(names, []string{"A", "B"}) || len(peopleRegistry.People) != 2 {
+		t.Fatalf("Split: %v %v", names, err)
+	}
+	if person := peopleRegistry.People[0]; person.ID != "f2" || len(person.Faces) != 1 {
+		t.Errorf("Split: kept %+v", person)
+	}
+	if person := peopleRegistry.People[1]; person.ID != "f1" || person.Name != "Carol" || len(person.Faces) != 2 {
+		t.Errorf("Split: new %+v", person)
+	}
+
+	for _, peopleEvent := range []PeopleEvent{
+		{Action: "Delete", PersonID: "f2"},
+		{Action: peopleActionName, PersonID: "f2"},
+		{Action: peopleActionName, PersonID: "unknown", Name: "Dan"},
+		{Action: peopleActionMerge, PersonIDs: []string{"f2"}},
+		{Action: peopleActionMerge, PersonIDs: []string{"f2", "f2"}},
+		{Action: peopleActionSplit, PersonID: "f2", FaceIDs: []string{"f2"}},
+		{Action: peopleActionSplit, PersonID: "f1", FaceIDs: []string{"f2"}},
+	} {
+		if _, err := applyPeopleEvent(peopleRegistry, &peopleEvent); err == nil {
+			t.Errorf("ApplyPeopleEvent: %+v accepted", peopleEvent)
+		}
+	}
+}
+
+func TestSetPeopleKeywords(t *testing.T) {
+	peopleRegistry := newTestPeopleRegistry()
+	photoPeople := getPhotoPeople(peopleRegistry, "B")
+	if len(photoPeople.People) != 2 || photoPeople.People[0].PersonID != "f3" || photoPeople.People[1].Name != "Alice" {
+		t.Fatalf("GetPhotoPeople: %+v %+v", photoPeople.People[0], photoPeople.People[1])
+	}
+	keywordSet := KeywordSet{Keywords: []*Keyword{{Name: "Old", Source: keywordSourcePerson}, {Name: "Jane Doe", Source: "Celebrity"}}}
+	setPeopleKeywords(&keywordSet, photoPeople)
+	var names []string
+	for _, keyword := range keywordSet.Keywords {
+		names = append(names, keyword.Name+"/"+keyword.Source)
+	}
+	if want := []string{"Alice/Person", "Jane Doe/Celebrity"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("SetPeopleKeywords: %v", names)
+	}
+}
+
+func TestIsS3PreconditionFailed(t *testing.T) {
+	for _, test := range []struct {
+		err  error
+		want bool
+	}{
+		{awserr.NewRequestFailure(awserr.New("PreconditionFailed", "changed", nil), http.StatusPreconditionFailed, ""), true},
+		{awserr.NewRequestFailure(awserr.New("ConditionalRequestConflict", "in progress", nil), http.StatusConflict, ""), true},
+		{awserr.NewRequestFailure(awserr.New("AccessDenied", "denied", nil), http.StatusForbidden, ""), false},
+		{errors.New("network"), false},
+	} {
+		if got := isS3PreconditionFailed(test.err); got != test.want {
+			t.Errorf("IsS3PreconditionFailed(%v): %v", test.err, got)
+		}
+	}
+}
+
+func TestGetPhotoPeopleImageID(t *testing.T) {
+	peopleRegistry := PeopleRegistry{People: []*Person{{ID: "f1", Faces: []*PersonFace{
+		{ExternalImageID: "2024:05:01:IMG_0001", FaceID: "f1"},
+		{ExternalImageID: "2024:06:01:IMG_0001", FaceID: "f2"}}}}}
+	photoPeople := getPhotoPeople(&peopleRegistry, "2024:05:01:IMG_0001")
+	if photoPeople.ID != "2024/05/01/IMG_0001" || photoPeople.Name != "IMG_0001" || len(photoPeople.People) != 1 || photoPeople.People[0].FaceID != "f1" {
+		t.Errorf("GetPhotoPeople: %+v", photoPeople)
+	}
+	if imageID := getImageID("2024:05:01:IMG:0001"); imageID != "2024/05/01/IMG:0001" {
+		t.Errorf("GetImageID: %q", imageID)
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// Contains utility functions for interacting with Amazon S3.
+
+// getS3ObjectBytes downloads an object from the provided S3 bucket and returns its contents.
+func getS3ObjectBytes(s3Client *s3.S3, s3BucketName string, s3ObjectKey string) ([]byte, error) {
+	getObjectInput := s3.GetObjectInput{
+		Bucket: &s3BucketName,
+		Key:    &s3ObjectKey}
+	getObjectOutput, err := s3Client.GetObject(&getObjectInput)
+	if err != nil {
+		return nil, err
+	}
+	defer getObjectOutput.Body.Close()
+	return io.ReadAll(getObjectOutput.Body)
+}
+
+// putS3ObjectJSON serializes the s3ObjectBody to JSON and uploads it to the specified S3 bucket.
+func putS3ObjectJSON(s3Client *s3.S3, s3BucketName string, s3ObjectKey string, s3ObjectBody interface{}) (*s3.PutObjectOutput, error) {
+	b, err := json.Marshal(s3ObjectBody)
+	if err != nil {
+		return nil, err
+	}
+	s3PutObjectInput := s3.PutObjectInput{
+		Bucket:        &s3BucketName,
+		Body:          aws.ReadSeekCloser(bytes.NewReader(b)),
+		ContentLength: aws.Int64(int64(len(b))),
+		ContentType:   aws.String("application/json"),
+		Key:           &s3ObjectKey}
+	return s3Client.PutObject(&s3PutObjectInput)
+}
+
+// putS3ObjectJSONConditional serializes the s3ObjectBody to JSON and uploads it to the specified S3 bucket only if the
+// object still has the ETag, or does not exist yet when the ETag is empty.
+func putS3ObjectJSONConditional(s3Client *s3.S3, s3BucketName string, s3ObjectKey string, s3ObjectBody interface{}, eTag string) (*s3.PutObjectOutput, error) {
+	b, err := json.Marshal(s3ObjectBody)
+	if err != nil {
+		return nil, err
+	}
+	s3PutObjectInput := s3.PutObjectInput{
+		Bucket:        &s3BucketName,
+		Body:          aws.ReadSeekCloser(bytes.NewReader(b)),
+		ContentLength: aws.Int64(int64(len(b))),
+		ContentType:   aws.String("application/json"),
+		Key:           &s3ObjectKey}
+
+	// The SDK has no field for the conditions of a PutObject, so set their headers on the request.
+	return s3Client.PutObjectWithContext(aws.BackgroundContext(), &s3PutObjectInput, func(r *request.Request) {
+		if eTag == "" {
+			r.HTTPRequest.Header.Set("If-None-Match", "*")
+		} else {
+			r.HTTPRequest.Header.Set("If-Match", eTag)
+		}
+	})
+}
+
+// getS3ObjectJSONETag downloads a JSON document like getS3ObjectJSON and also returns its ETag, which is empty if the
+// object does not exist.
+func getS3ObjectJSONETag(s3Client *s3.S3, s3BucketName string, s3ObjectKey string, value interface{}) (string, error) {
+	getObjectInput := s3.GetObjectInput{
+		Bucket: &s3BucketName,
+		Key:    &s3ObjectKey}
+	getObjectOutput, err := s3Client.GetObject(&getObjectInput)
+	var awsErr awserr.Error
+	if errors.As(err, &awsErr) && awsErr.Code() == s3.ErrCodeNoSuchKey {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	defer getObjectOutput.Body.Close()
+	b, err := io.ReadAll(getObjectOutput.Body)
+	if err != nil {
+		return "", err
+	}
+	return aws.StringValue(getObjectOutput.ETag), json.Unmarshal(b, value)
+}
+
+// isS3PreconditionFailed reports whether a conditional write was rejected because the object changed, or because
+// another conditional write to it was in progress.
+func isS3PreconditionFailed(err error) bool {
+	var requestFailure awserr.RequestFailure
+	return errors.As(err, &requestFailure) &&
+		(requestFailure.StatusCode() == http.StatusPreconditionFailed || requestFailure.StatusCode() == http.StatusConflict)
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"image"
+	"image/draw"
+	"math"
+	"strings"
+)
+
+// Contains functions for identifying the colour space of an image and converting it to sRGB.
+
+// Exif ColorSpace and InteroperabilityIndex values used to identify the colour space of images without an ICC profile.
+const (
+	exifColorSpaceSRGB                 = 1
+	exifColorSpaceUncalibrated         = 0xFFFF
+	exifInteroperabilityIndexAdobeRGB  = "R03"
+	exifInteroperabilityIndexSRGB      = "R98"
+	colorSpaceAdobeRGB                 = "Adobe RGB (1998)"
+	colorSpaceDisplayP3                = "Display P3"
+	colorSpaceProPhotoRGB              = "ProPhoto RGB"
+	colorSpaceSRGB                     = "sRGB"
+	colorSpaceUncalibrated             = "Uncalibrated"
+	colorProfileSourceDefault          = "Default"
+	colorProfileSourceExif             = "Exif"
+	colorProfileSourceICC              = "ICC"
+	colorTransformOutputTableSize      = 4096
+	colorTransformSRGBMatrixTolerance  = 0.002
+	colorTransformSRGBCurveTolerance   = 0.01
+	colorTransformSRGBCurveSampleCount = 16
+)
+
+// srgbMatrix converts linear sRGB to D50 adapted XYZ, matching the colorant tags of an sRGB ICC profile.
+var srgbMatrix = [3][3]float64{
+	{0.4360747, 0.3850649, 0.1430804},
+	{0.2225045, 0.7168786, 0.0606169},
+	{0.0139322, 0.0971045, 0.7141733}}
+
+// srgbInverseMatrix converts D50 adapted XYZ to linear sRGB.
+var srgbInverseMatrix = [3][3]float64{
+	{3.1338561, -1.6168667, -0.4906146},
+	{-0.9787684, 1.9161415, 0.0334540},
+	{0.0719453, -0.2289914, 1.4052427}}
+
+// adobeRGBProfile is used for images tagged as Adobe RGB through Exif rather than an embedded ICC profile.
+var adobeRGBProfile = ICCProfile{
+	ColorSpace:  "RGB",
+	Description: colorSpaceAdobeRGB,
+	DeviceClass: "mntr",
+	PCS:         "XYZ",
+	Version:     "2.1.0",
+	BlueTRC:     &ICCToneCurve{Table: []float64{563.0 / 256}},
+	GreenTRC:    &ICCToneCurve{Table: []float64{563.0 / 256}},
+	RedTRC:      &ICCToneCurve{Table: []float64{563.0 / 256}},
+	Matrix: &[3][3]float64{
+		{0.6097559, 0.2052401, 0.1492240},
+		{0.3111242, 0.6256560, 0.0632197},
+		{0.0194811, 0.0608902, 0.7448387}}}
+
+// ColorProfile describes the colour space of a source image and whether it was converted to sRGB.
+type ColorProfile struct {
+	ColorSpace      string      `json:"ColorSpace"`
+	ConvertedToSRGB bool        `json:"ConvertedToSRGB"`
+	Description     *string     `json:"Description"`
+	Source          string      `json:"Source"`
+	Version         *string     `json:"Version"`
+	iccProfile      *ICCProfile `json:"-"`
+}
+
+// colorTransform converts 8-bit RGB values from a matrix/TRC profile to 8-bit sRGB values.
+type colorTransform struct {
+	input  [3][256]float64
+	matrix [3][3]float64
+	output [colorTransformOutputTableSize]uint8
+}
+
+// getColorProfile identifies the colour space of an image from its ICC profile, falling back to the
+// Exif ColorSpace and InteroperabilityIndex tags. Images that carry neither are assumed to be sRGB.
+func getColorProfile(iccProfile *ICCProfile, exifMetadata *ExifMetadata) *ColorProfile {
+	if iccProfile != nil {
+		return &ColorProfile{
+			ColorSpace:  getColorSpaceName(iccProfile.Description),
+			Description: &iccProfile.Description,
+			Source:      colorProfileSourceICC,
+			Version:     &iccProfile.Version,
+			iccProfile:  iccProfile}
+	}
+	if exifMetadata != nil && exifMetadata.ColorSpace != nil {
+		switch *exifMetadata.ColorSpace {
+		case exifColorSpaceSRGB:
+			return &ColorProfile{ColorSpace: colorSpaceSRGB, Source: colorProfileSourceExif}
+		case exifColorSpaceUncalibrated:
+			// Cameras set the ColorSpace to Uncalibrated for Adobe RGB and identify it with the R03 interoperability index.
+			if exifMetadata.InteroperabilityIndex != nil && *exifMetadata.InteroperabilityIndex == exifInteroperabilityIndexAdobeRGB {
+				return &ColorProfile{ColorSpace: colorSpaceAdobeRGB, Source: colorProfileSourceExif, iccProfile: &adobeRGBProfile}
+			}
+			return &ColorProfile{ColorSpace: colorSpaceUncalibrated, Source: colorProfileSourceExif}
+		}
+	}
+	if exifMetadata != nil && exifMetadata.InteroperabilityIndex != nil && *exifMetadata.InteroperabilityIndex == exifInteroperabilityIndexSRGB {
+		return &ColorProfile{ColorSpace: colorSpaceSRGB, Source: colorProfileSourceExif}
+	}
+	return &ColorProfile{ColorSpace: colorSpaceSRGB, Source: colorProfileSourceDefault}
+}
+
+// getColorSpaceName returns a well known colour space name for an ICC profile description.
+func getColorSpaceName(description string) string {
+	switch {
+	case strings.Contains(description, "Adobe RGB"):
+		return colorSpaceAdobeRGB
+	case strings.Contains(description, "P3"):
+		return colorSpaceDisplayP3
+	case strings.Contains(description, "ProPhoto"):
+		return colorSpaceProPhotoRGB
+	case strings.Contains(description, "sRGB"):
+		return colorSpaceSRGB
+	}
+	return description
+}
+
+// isSRGBProfile reports whether the ICC profile is equivalent to sRGB, in which case no conversion is needed.
+func isSRGBProfile(iccProfile *ICCProfile) bool {
+	for row := range srgbMatrix {
+		for column := range srgbMatrix[row] {
+			if math.Abs(iccProfile.Matrix[row][column]-srgbMatrix[row][column]) > colorTransformSRGBMatrixTolerance {
+				return false
+			}
+		}
+	}
+	for _, curve := range []*ICCToneCurve{iccProfile.RedTRC, iccProfile.GreenTRC, iccProfile.BlueTRC} {
+		for i := 0; i <= colorTransformSRGBCurveSampleCount; i++ {
+			x := float64(i) / colorTransformSRGBCurveSampleCount
+			if math.Abs(curve.Linear(x)-srgbLinear(x)) > colorTransformSRGBCurveTolerance {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// newColorTransform creates a colorTransform from a matrix/TRC ICC profile to sRGB.
+func newColorTransform(iccProfile *ICCProfile) *colorTransform {
+	transform := colorTransform{}
+	for channel, curve := range []*ICCToneCurve{iccProfile.RedTRC, iccProfile.GreenTRC, iccProfile.BlueTRC} {
+		for value := range transform.input[channel] {
+			transform.input[channel][value] = curve.Linear(float64(value) / 255)
+		}
+	}
+	// Combine the profile's RGB to XYZ matrix with the XYZ to sRGB matrix.
+	for row := 0; row < 3; row++ {
+		for column := 0; column < 3; column++ {
+			for k := 0; k < 3; k++ {
+				transform.matrix[row][column] += srgbInverseMatrix[row][k] * iccProfile.Matrix[k][column]
+			}
+		}
+	}
+	for i := range transform.output {
+		transform.output[i] = uint8(math.Round(srgbEncode(float64(i)/(colorTransformOutputTableSize-1)) * 255))
+	}
+	return &transform
+}
+
+// convert converts a single 8-bit RGB value to sRGB.
+func (t *colorTransform) convert(r, g, b uint8) (uint8, uint8, uint8) {
+	linear := [3]float64{t.input[0][r], t.input[1][g], t.input[2][b]}
+	var result [3]uint8
+	for row := range t.matrix {
+		value := t.matrix[row][0]*linear[0] + t.matrix[row][1]*linear[1] + t.matrix[row][2]*linear[2]
+		if math.IsNaN(value) {
+			// Clamping keeps NaN, which would index outside the table.
+			value = 0
+		}
+		value = math.Max(0, math.Min(1, value))
+		result[row] = t.output[int(value*(colorTransformOutputTableSize-1)+0.5)]
+	}
+	return result[0], result[1], result[2]
+}
+
+// convertImageToSRGB converts the image from the colour space described by the ColorProfile to sRGB.
+// The image is returned unchanged if it is already sRGB or its profile cannot be converted with a matrix and TRCs.
+func convertImageToSRGB(imageSource image.Image, colorProfile *ColorProfile) image.Image {
+	if colorProfile.iccProfile == nil || !colorProfile.iccProfile.isMatrixTRC() || isSRGBProfile(colorProfile.iccProfile) {
+		return imageSource
+	}
+	imageDestination, ok := imageSource.(*image.RGBA)
+	if !ok {
+		imageDestination = image.NewRGBA(imageSource.Bounds())
+		draw.Draw(imageDestination, imageDestination.Bounds(), imageSource, imageSource.Bounds().Min, draw.Src)
+	}
+	transform := newColorTransform(colorProfile.iccProfile)
+	for i := 0; i+3 < len(imageDestination.Pix); i += 4 {
+		pixel := imageDestination.Pix[i : i+4 : i+4]
+		switch pixel[3] {
+		case 0:
+			continue
+		case 0xFF:
+			pixel[0], pixel[1], pixel[2] = transform.convert(pixel[0], pixel[1], pixel[2])
+		default:
+			// Convert the unpremultiplied colour and premultiply the result again.
+			a := uint32(pixel[3])
+			r, g, b := transform.convert(uint8(uint32(pixel[0])*0xFF/a), uint8(uint32(pixel[1])*0xFF/a), uint8(uint32(pixel[2])*0xFF/a))
+			pixel[0], pixel[1], pixel[2] = uint8(uint32(r)*a/0xFF), uint8(uint32(g)*a/0xFF), uint8(uint32(b)*a/0xFF)
+		}
+	}
+	colorProfile.ConvertedToSRGB = true
+	return imageDestination
+}
+
+// srgbEncode applies the sRGB transfer function to a linear light value.
+func srgbEncode(x float64) float64 {
+	if x <= 0.0031308 {
+		return 12.92 * x
+	}
+	return 1.055*math.Pow(x, 1/2.4) - 0.055
+}
+
+// srgbLinear converts an sRGB encoded value to linear light.
+func srgbLinear(x float64) float64 {
+	if x <= 0.04045 {
+		return x / 12.92
+	}
+	return math.Pow((x+0.055)/1.055, 2.4)
+}
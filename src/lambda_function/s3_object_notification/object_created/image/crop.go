@@ -0,0 +1,240 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"image"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/service/rekognition"
+	"golang.org/x/image/draw"
+)
+
+// Contains functions for choosing crop windows for named aspect ratio presets.
+
+// Constants used by the smart crop heuristic.
+const (
+	cropAnalysisSize       = 256
+	cropCellSize           = 8
+	cropCenterBias         = 0.1
+	cropEntropyBins        = 16
+	cropFaceWeight         = 8
+	cropSearchStepFraction = 0.02
+)
+
+// CropPreset is a named aspect ratio, such as 4:5, that renditions are cropped to.
+type CropPreset struct {
+	AspectRatio string `json:"AspectRatio"`
+	Name        string `json:"Name"`
+	height      int
+	width       int
+}
+
+// CropRectangle records the crop window chosen for a rendition in the pixel coordinates of the decoded source image,
+// whose dimensions the rendition manifest records.
+type CropRectangle struct {
+	AspectRatio string `json:"AspectRatio"`
+	FaceCount   int    `json:"FaceCount"`
+	Height      int    `json:"Height"`
+	Preset      string `json:"Preset"`
+	Width       int    `json:"Width"`
+	X           int    `json:"X"`
+	Y           int    `json:"Y"`
+}
+
+// subImager is implemented by the standard library image types that can share pixels with a cropped region.
+type subImager interface {
+	SubImage(image.Rectangle) image.Image
+}
+
+// getCropPresets parses the JSON crop preset configuration, keyed by preset name.
+func getCropPresets(configuration string) (map[string]*CropPreset, error) {
+	var cropPresets []*CropPreset
+	if err := json.Unmarshal([]byte(configuration), &cropPresets); err != nil {
+		return nil, err
+	}
+	cropPresetMap := make(map[string]*CropPreset)
+	for _, cropPreset := range cropPresets {
+		if cropPreset.Name == "" {
+			return nil, fmt.Errorf("crop preset %q has no name", cropPreset.AspectRatio)
+		}
+		if _, ok := cropPresetMap[cropPreset.Name]; ok {
+			return nil, fmt.Errorf("duplicate crop preset name %q", cropPreset.Name)
+		}
+		width, height, ok := strings.Cut(cropPreset.AspectRatio, ":")
+		if !ok {
+			return nil, fmt.Errorf("crop preset %q has invalid aspect ratio %q", cropPreset.Name, cropPreset.AspectRatio)
+		}
+		var err error
+		if cropPreset.width, err = strconv.Atoi(width); err != nil || cropPreset.width <= 0 {
+			return nil, fmt.Errorf("crop preset %q has invalid aspect ratio %q", cropPreset.Name, cropPreset.AspectRatio)
+		}
+		if cropPreset.height, err = strconv.Atoi(height); err != nil || cropPreset.height <= 0 {
+			return nil, fmt.Errorf("crop preset %q has invalid aspect ratio %q", cropPreset.Name, cropPreset.AspectRatio)
+		}
+		cropPresetMap[cropPreset.Name] = cropPreset
+	}
+	return cropPresetMap, nil
+}
+
+// getFaceBoundingBoxes returns the face bounding boxes of a DetectFaces output, which may be nil.
+func getFaceBoundingBoxes(rekognitionDetectFacesOutput *rekognition.DetectFacesOutput) []*rekognition.BoundingBox {
+	if rekognitionDetectFacesOutput == nil {
+		return nil
+	}
+	var boundingBoxes []*rekognition.BoundingBox
+	for _, faceDetail := range rekognitionDetectFacesOutput.FaceDetails {
+		if faceDetail.BoundingBox != nil {
+			boundingBoxes = append(boundingBoxes, faceDetail.BoundingBox)
+		}
+	}
+	return boundingBoxes
+}
+
+// getBoundingBoxRectangle converts a Rekognition bounding box, relative to the image size, to pixel coordinates.
+func getBoundingBoxRectangle(boundingBox *rekognition.BoundingBox, imageSourceRectangle image.Rectangle) image.Rectangle {
+	var left, top, width, height float64
+	if boundingBox.Left != nil {
+		left = *boundingBox.Left
+	}
+	if boundingBox.Top != nil {
+		top = *boundingBox.Top
+	}
+	if boundingBox.Width != nil {
+		width = *boundingBox.Width
+	}
+	if boundingBox.Height != nil {
+		height = *boundingBox.Height
+	}
+	dx, dy := float64(imageSourceRectangle.Dx()), float64(imageSourceRectangle.Dy())
+	rectangle := image.Rect(
+		int(math.Floor(left*dx)),
+		int(math.Floor(top*dy)),
+		int(math.Ceil((left+width)*dx)),
+		int(math.Ceil((top+height)*dy)))
+	return rectangle.Add(imageSourceRectangle.Min).Intersect(imageSourceRectangle)
+}
+
+// getSaliencyMap scores every pixel of a small copy of the image by how interesting it is. The score combines the
+// luminance entropy of the pixel's cell, which favours detailed areas over flat sky or walls, with its gradient
+// magnitude, which favours edges. Faces are weighted strongly so that crops keep people in frame.
+func getSaliencyMap(imageSource image.Image, faceBoundingBoxes []*rekognition.BoundingBox) ([][]float64, float64) {
+	imageSourceRectangle := imageSource.Bounds()
+	scale := math.Min(1, float64(cropAnalysisSize)/float64(max(imageSourceRectangle.Dx(), imageSourceRectangle.Dy())))
+	analysisRectangle := image.Rect(0, 0, max(1, int(float64(imageSourceRectangle.Dx())*scale)), max(1, int(float64(imageSourceRectangle.Dy())*scale)))
+	analysisImage := image.NewGray(analysisRectangle)
+	draw.ApproxBiLinear.Scale(analysisImage, analysisRectangle, imageSource, imageSourceRectangle, draw.Src, nil)
+	width, height := analysisRectangle.Dx(), analysisRectangle.Dy()
+
+	// Measure the entropy of each cell's luminance histogram.
+	cellsX, cellsY := (width+cropCellSize-1)/cropCellSize, (height+cropCellSize-1)/cropCellSize
+	entropy := make([]float64, cellsX*cellsY)
+	for cellY := 0; cellY < cellsY; cellY++ {
+		for cellX := 0; cellX < cellsX; cellX++ {
+			var histogram [cropEntropyBins]float64
+			count := 0.0
+			for y := cellY * cropCellSize; y < min(height, (cellY+1)*cropCellSize); y++ {
+				for x := cellX * cropCellSize; x < min(width, (cellX+1)*cropCellSize); x++ {
+					histogram[int(analysisImage.GrayAt(x, y).Y)*cropEntropyBins/256]++
+					count++
+				}
+			}
+			for _, frequency := range histogram {
+				if frequency > 0 {
+					p := frequency / count
+					entropy[cellY*cellsX+cellX] -= p * math.Log2(p)
+				}
+			}
+		}
+	}
+
+	saliency := make([][]float64, height)
+	total := 0.0
+	for y := 0; y < height; y++ {
+		saliency[y] = make([]float64, width)
+		for x := 0; x < width; x++ {
+			gx := float64(analysisImage.GrayAt(min(x+1, width-1), y).Y) - float64(analysisImage.GrayAt(max(x-1, 0), y).Y)
+			gy := float64(analysisImage.GrayAt(x, min(y+1, height-1)).Y) - float64(analysisImage.GrayAt(x, max(y-1, 0)).Y)
+			saliency[y][x] = entropy[(y/cropCellSize)*cellsX+x/cropCellSize] + math.Hypot(gx, gy)/255
+			total += saliency[y][x]
+		}
+	}
+	mean := total / float64(width*height)
+
+	// Weight faces relative to the average saliency so that they dominate the crop regardless of image content.
+	for _, boundingBox := range faceBoundingBoxes {
+		faceRectangle := getBoundingBoxRectangle(boundingBox, analysisRectangle)
+		for y := faceRectangle.Min.Y; y < faceRectangle.Max.Y; y++ {
+			for x := faceRectangle.Min.X; x < faceRectangle.Max.X; x++ {
+				saliency[y][x] += cropFaceWeight * (mean + 1)
+			}
+		}
+	}
+	return saliency, scale
+}
+
+// getSmartCrop chooses the largest crop window with the preset's aspect ratio that captures the most salient part
+// of the image, with a slight preference for centred windows. The window is returned in source pixel coordinates.
+func getSmartCrop(imageSource image.Image, cropPreset *CropPreset, faceBoundingBoxes []*rekognition.BoundingBox) *CropRectangle {
+	imageSourceRectangle := imageSource.Bounds()
+	cropRectangle := CropRectangle{
+		AspectRatio: cropPreset.AspectRatio,
+		FaceCount:   len(faceBoundingBoxes),
+		Preset:      cropPreset.Name}
+
+	// The largest window with the preset's aspect ratio spans the full width or the full height of the image.
+	cropRectangle.Width = imageSourceRectangle.Dx()
+	cropRectangle.Height = cropRectangle.Width * cropPreset.height / cropPreset.width
+	if cropRectangle.Height > imageSourceRectangle.Dy() {
+		cropRectangle.Height = imageSourceRectangle.Dy()
+		cropRectangle.Width = cropRectangle.Height * cropPreset.width / cropPreset.height
+	}
+	cropRectangle.Width, cropRectangle.Height = max(1, cropRectangle.Width), max(1, cropRectangle.Height)
+
+	saliency, scale := getSaliencyMap(imageSource, faceBoundingBoxes)
+	height, width := len(saliency), len(saliency[0])
+
+	// Build an integral image so that every candidate window is scored in constant time.
+	integral := make([][]float64, height+1)
+	integral[0] = make([]float64, width+1)
+	for y := 0; y < height; y++ {
+		integral[y+1] = make([]float64, width+1)
+		for x := 0; x < width; x++ {
+			integral[y+1][x+1] = saliency[y][x] + integral[y][x+1] + integral[y+1][x] - integral[y][x]
+		}
+	}
+	windowWidth := min(width, max(1, int(math.Round(float64(cropRectangle.Width)*scale))))
+	windowHeight := min(height, max(1, int(math.Round(float64(cropRectangle.Height)*scale))))
+	step := max(1, int(float64(max(width, height))*cropSearchStepFraction))
+	bestScore, bestX, bestY := math.Inf(-1), 0, 0
+	for y := 0; y <= height-windowHeight; y += step {
+		for x := 0; x <= width-windowWidth; x += step {
+			score := integral[y+windowHeight][x+windowWidth] - integral[y][x+windowWidth] - integral[y+windowHeight][x] + integral[y][x]
+			// Penalise windows by their distance from the centre of the image.
+			offsetX := float64(x+windowWidth/2)/float64(width) - 0.5
+			offsetY := float64(y+windowHeight/2)/float64(height) - 0.5
+			score *= 1 - cropCenterBias*math.Hypot(offsetX, offsetY)
+			if score > bestScore {
+				bestScore, bestX, bestY = score, x, y
+			}
+		}
+	}
+
+	// Map the window back to the source image, keeping it within bounds.
+	cropRectangle.X = min(imageSourceRectangle.Min.X+int(math.Round(float64(bestX)/scale)), imageSourceRectangle.Max.X-cropRectangle.Width)
+	cropRectangle.Y = min(imageSourceRectangle.Min.Y+int(math.Round(float64(bestY)/scale)), imageSourceRectangle.Max.Y-cropRectangle.Height)
+	return &cropRectangle
+}
+
+// cropImage returns the region of the image described by the CropRectangle, sharing pixels where possible.
+func cropImage(imageSource image.Image, cropRectangle *CropRectangle) image.Image {
+	rectangle := image.Rect(cropRectangle.X, cropRectangle.Y, cropRectangle.X+cropRectangle.Width, cropRectangle.Y+cropRectangle.Height)
+	if imageSubImager, ok := imageSource.(subImager); ok {
+		return imageSubImager.SubImage(rectangle)
+	}
+	imageDestination := image.NewRGBA(image.Rect(0, 0, rectangle.Dx(), rectangle.Dy()))
+	draw.Draw(imageDestination, imageDestination.Bounds(), imageSource, rectangle.Min, draw.Src)
+	return imageDestination
+}
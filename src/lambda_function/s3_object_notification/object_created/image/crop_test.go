@@ -0,0 +1,62 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/rekognition"
+)
+
+func TestGetCropPresets(t *testing.T) {
+	for _, configuration := range []string{
+		`[{"Name":"Square","AspectRatio":"1"}]`,
+		`[{"Name":"Square","AspectRatio":"0:1"}]`,
+		`[{"AspectRatio":"1:1"}]`,
+		`[{"Name":"Square","AspectRatio":"1:1"},{"Name":"Square","AspectRatio":"4:5"}]`,
+	} {
+		if _, err := getCropPresets(configuration); err == nil {
+			t.Errorf("GetCropPresets: expected an error for %s", configuration)
+		}
+	}
+	cropPresets, err := getCropPresets(`[{"Name":"Story","AspectRatio":"9:16"}]`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cropPresets["Story"].width != 9 || cropPresets["Story"].height != 16 {
+		t.Fatalf("GetCropPresets: %+v", cropPresets["Story"])
+	}
+}
+
+func TestGetSmartCrop(t *testing.T) {
+	cropPresets, err := getCropPresets(`[{"Name":"Square","AspectRatio":"1:1"}]`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A flat image with a detailed region on the right.
+	imageSource := image.NewRGBA(image.Rect(0, 0, 400, 200))
+	for y := 0; y < 200; y++ {
+		for x := 0; x < 400; x++ {
+			imageSource.Set(x, y, color.RGBA{128, 128, 128, 255})
+			if x > 300 && (x+y)%4 < 2 {
+				imageSource.Set(x, y, color.RGBA{255, 255, 255, 255})
+			}
+		}
+	}
+	cropRectangle := getSmartCrop(imageSource, cropPresets["Square"], nil)
+	if cropRectangle.Width != 200 || cropRectangle.Height != 200 || cropRectangle.X < 150 {
+		t.Fatalf("GetSmartCrop: detail crop %+v", cropRectangle)
+	}
+
+	// A face on the left outweighs the detail on the right.
+	faceBoundingBoxes := []*rekognition.BoundingBox{{Left: aws.Float64(0.05), Top: aws.Float64(0.25), Width: aws.Float64(0.2), Height: aws.Float64(0.5)}}
+	cropRectangle = getSmartCrop(imageSource, cropPresets["Square"], faceBoundingBoxes)
+	if cropRectangle.X > 20 || cropRectangle.FaceCount != 1 {
+		t.Fatalf("GetSmartCrop: face crop %+v", cropRectangle)
+	}
+	if bounds := cropImage(imageSource, cropRectangle).Bounds(); bounds.Dx() != 200 || bounds.Dy() != 200 {
+		t.Fatalf("CropImage: %v", bounds)
+	}
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"log"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// Contains functions for detecting uploads that duplicate previously uploaded images.
+//
+// Every image is recorded in a hash index stored as JSON shards in S3. The 64-bit perceptual hash is split into
+// eight bytes and the image is recorded in one shard per byte, keyed by the byte's position and value. Two hashes
+// within a Hamming distance of seven or less must share at least one byte, so reading the eight shards of a new
+// image finds every near-duplicate within the maximum threshold. A candidate is only a near-duplicate when its
+// difference hash is within the threshold as well, which rules out images whose DCT happens to be similar. Shards
+// are updated with a read-modify-write, so concurrent uploads of images sharing a shard may occasionally drop an
+// entry from the index. An image quarantined by moderation is removed from the index, so that later uploads are not
+// flagged as duplicates of it; approving it restores the upload, which indexes it again.
+
+// Actions taken when an upload duplicates an existing image.
+const (
+	duplicateActionProcess = "process"
+	duplicateActionSkip    = "skip"
+	duplicateActionTag     = "tag"
+	duplicateMaxThreshold  = 7
+	duplicateTagKey        = "DuplicateOf"
+)
+
+// ImageDuplicate identifies the existing image that an upload duplicates. Exact duplicates are byte for byte copies,
+// while near-duplicates have perceptual and difference hashes within the configured Hamming distance. Distance is the
+// distance between the perceptual hashes.
+type ImageDuplicate struct {
+	Action   string `json:"Action"`
+	Distance int    `json:"Distance"`
+	Exact    bool   `json:"Exact"`
+	Key      string `json:"Key"`
+}
+
+// ImageHashIndexEntry records the hashes of an uploaded image in the hash index.
+type ImageHashIndexEntry struct {
+	DHash  string `json:"DHash"`
+	Key    string `json:"Key"`
+	PHash  string `json:"PHash"`
+	SHA256 string `json:"SHA256"`
+}
+
+// getDuplicateAction validates the action taken for duplicate uploads.
+func getDuplicateAction(action string) (string, error) {
+	switch action {
+	case duplicateActionProcess, duplicateActionSkip, duplicateActionTag:
+		return action, nil
+	}
+	return "", fmt.Errorf("unknown duplicate action %q", action)
+}
+
+// getDuplicateThreshold parses and validates the maximum Hamming distance between near-duplicates.
+func getDuplicateThreshold(threshold string) (int, error) {
+	value, err := strconv.Atoi(threshold)
+	if err != nil {
+		return 0, err
+	}
+	if value < 0 || value > duplicateMaxThreshold {
+		return 0, fmt.Errorf("duplicate threshold %d outside 0-%d", value, duplicateMaxThreshold)
+	}
+	return value, nil
+}
+
+// getImageHashIndexShardKeys returns the S3 object keys of the shards that an image with the perceptual hash is
+// recorded in.
+func getImageHashIndexShardKeys(perceptualHash uint64) []string {
+	s3ObjectKeys := make([]string, 8)
+	for i := range s3ObjectKeys {
+		s3ObjectKeys[i] = fmt.Sprintf("%s/%d/%02x.JSON", s3BucketFolderImagesHashes, i, byte(perceptualHash>>(56-8*i)))
+	}
+	return s3ObjectKeys
+}
+
+// findImageDuplicate returns the closest image in the index entries that the upload duplicates, preferring exact
+// duplicates. Entries for the upload's own key are ignored so that reprocessing an image does not flag it.
+// It returns nil if no entry is within the threshold.
+func findImageDuplicate(imageHashes *ImageHashes, s3ObjectKey string, imageHashIndexEntries []ImageHashIndexEntry, threshold int) (*ImageDuplicate, error) {
+	perceptualHash, err := parseHash(imageHashes.PHash)
+	if err != nil {
+		return nil, err
+	}
+	differenceHash, err := parseHash(imageHashes.DHash)
+	if err != nil {
+		return nil, err
+	}
+	var imageDuplicate *ImageDuplicate
+	for _, imageHashIndexEntry := range imageHashIndexEntries {
+		if imageHashIndexEntry.Key == s3ObjectKey {
+			continue
+		}
+		entryHash, err := parseHash(imageHashIndexEntry.PHash)
+		if err != nil {
+			return nil, err
+		}
+		entryDifferenceHash, err := parseHash(imageHashIndexEntry.DHash)
+		if err != nil {
+			return nil, err
+		}
+		candidate := ImageDuplicate{
+			Distance: getHashDistance(perceptualHash, entryHash),
+			Exact:    imageHashIndexEntry.SHA256 == imageHashes.SHA256,
+			Key:      imageHashIndexEntry.Key}
+		if !candidate.Exact && (candidate.Distance > threshold || getHashDistance(differenceHash, entryDifferenceHash) > threshold) {
+			continue
+		}
+		if imageDuplicate == nil || (candidate.Exact && !imageDuplicate.Exact) || (candidate.Exact == imageDuplicate.Exact && candidate.Distance < imageDuplicate.Distance) {
+			imageDuplicate = &candidate
+		}
+	}
+	return imageDuplicate, nil
+}
+
+// getS3ObjectImageHashIndexShard downloads a shard of the hash index. A shard that does not exist yet is empty.
+func getS3ObjectImageHashIndexShard(s3Client *s3.S3, s3BucketName string, s3ObjectKey string) ([]ImageHashIndexEntry, error) {
+	b, err := getS3ObjectBytes(s3Client, s3BucketName, s3ObjectKey)
+	var awsErr awserr.Error
+	if errors.As(err, &awsErr) && awsErr.Code() == s3.ErrCodeNoSuchKey {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var imageHashIndexEntries []ImageHashIndexEntry
+	if err := json.Unmarshal(b, &imageHashIndexEntries); err != nil {
+		return nil, err
+	}
+	return imageHashIndexEntries, nil
+}
+
+// putS3ObjectImageHashIndexEntry records the image in every shard of the hash index that its hash belongs to,
+// replacing any previous entry for the same key.
+func putS3ObjectImageHashIndexEntry(s3Client *s3.S3, s3BucketName string, imageHashIndexEntry ImageHashIndexEntry, shards map[string][]ImageHashIndexEntry) error {
+	for s3ObjectKey, imageHashIndexEntries := range shards {
+		updatedEntries := []ImageHashIndexEntry{imageHashIndexEntry}
+		for _, existingEntry := range imageHashIndexEntries {
+			if existingEntry.Key != imageHashIndexEntry.Key {
+				updatedEntries = append(updatedEntries, existingEntry)
+			}
+		}
+		s3PutObjectOutput, err := putS3ObjectJSON(s3Client, s3BucketName, s3ObjectKey, updatedEntries)
+		if err != nil {
+			return err
+		}
+		processS3PutObjectOutput(s3PutObjectOutput)
+	}
+	return nil
+}
+
+// deleteS3ObjectImageHashIndexEntry removes the entry for the key from every shard of the hash index that an image
+// with the perceptual hash is recorded in. Shards without the entry are left unchanged.
+func deleteS3ObjectImageHashIndexEntry(s3Client *s3.S3, s3BucketName string, s3ObjectKey string, perceptualHash uint64) error {
+	for _, shardKey := range getImageHashIndexShardKeys(perceptualHash) {
+		imageHashIndexEntries, err := getS3ObjectImageHashIndexShard(s3Client, s3BucketName, shardKey)
+		if err != nil {
+			return err
+		}
+		updatedEntries := []ImageHashIndexEntry{}
+		for _, existingEntry := range imageHashIndexEntries {
+			if existingEntry.Key != s3ObjectKey {
+				updatedEntries = append(updatedEntries, existingEntry)
+			}
+		}
+		if len(updatedEntries) == len(imageHashIndexEntries) {
+			continue
+		}
+		log.Printf("ImageHashIndex: Key=%s Removed=%s", shardKey, s3ObjectKey)
+		s3PutObjectOutput, err := putS3ObjectJSON(s3Client, s3BucketName, shardKey, updatedEntries)
+		if err != nil {
+			return err
+		}
+		processS3PutObjectOutput(s3PutObjectOutput)
+	}
+	return nil
+}
+
+// putS3ObjectTaggingDuplicate tags the uploaded object with the key of the image it duplicates.
+func putS3ObjectTaggingDuplicate(s3Client *s3.S3, s3BucketName string, s3ObjectKey string, imageDuplicate *ImageDuplicate) error {
+	s3PutObjectTaggingInput := s3.PutObjectTaggingInput{
+		Bucket: &s3BucketName,
+		Key:    &s3ObjectKey,
+		Tagging: &s3.Tagging{
+			TagSet: []*s3.Tag{{Key: aws.String(duplicateTagKey), Value: aws.String(imageDuplicate.Key)}}}}
+	_, err := s3Client.PutObjectTagging(&s3PutObjectTaggingInput)
+	return err
+}
+
+// processS3ObjectImageHashes hashes the image, checks the hash index for an existing copy and records the image
+// in the index. It returns false if the image is a duplicate that should not be processed any further.
+func processS3ObjectImageHashes(s3Client *s3.S3, s3BucketName string, s3ObjectKey string, imageMetadata *ImageMetadata, fileName string, imageSource image.Image) bool {
+	var err error
+	imageMetadata.Hashes, err = getImageHashes(fileName, imageSource)
+	if err != nil {
+		log.Fatalf("ImageHashes: Error=%s", err)
+	}
+	log.Printf("ImageHashes: AHash=%s DHash=%s PHash=%s SHA256=%s",
+		imageMetadata.Hashes.AHash,
+		imageMetadata.Hashes.DHash,
+		imageMetadata.Hashes.PHash,
+		imageMetadata.Hashes.SHA256)
+
+	// Read every shard that a near-duplicate could be recorded in.
+	perceptualHash, err := parseHash(imageMetadata.Hashes.PHash)
+	if err != nil {
+		log.Fatalf("ImageHashes: Error=%s", err)
+	}
+	shards := make(map[string][]ImageHashIndexEntry)
+	var imageHashIndexEntries []ImageHashIndexEntry
+	for _, shardKey := range getImageHashIndexShardKeys(perceptualHash) {
+		shards[shardKey], err = getS3ObjectImageHashIndexShard(s3Client, s3BucketName, shardKey)
+		if err != nil {
+			log.Fatalf("ImageHashIndex: Bucket=%s Key=%s Error=%s", s3BucketName, shardKey, err)
+		}
+		imageHashIndexEntries = append(imageHashIndexEntries, shards[shardKey]...)
+	}
+
+	imageMetadata.Duplicate, err = findImageDuplicate(imageMetadata.Hashes, s3ObjectKey, imageHashIndexEntries, imageDuplicateThreshold)
+	if err != nil {
+		log.Fatalf("ImageDuplicate: Error=%s", err)
+	}
+	if imageMetadata.Duplicate != nil {
+		imageMetadata.Duplicate.Action = imageDuplicateAction
+		log.Printf("ImageDuplicate: Key=%s DuplicateOf=%s Distance=%d Exact=%v Action=%s",
+			s3ObjectKey,
+			imageMetadata.Duplicate.Key,
+			imageMetadata.Duplicate.Distance,
+			imageMetadata.Duplicate.Exact,
+			imageMetadata.Duplicate.Action)
+		switch imageDuplicateAction {
+		case duplicateActionSkip:
+			// Skipped images are not indexed, so the original remains the only entry.
+			return false
+		case duplicateActionTag:
+			if err := putS3ObjectTaggingDuplicate(s3Client, s3BucketName, s3ObjectKey, imageMetadata.Duplicate); err != nil {
+				log.Fatalf("ImageDuplicate: Error=%s", err)
+			}
+		}
+	}
+
+	imageHashIndexEntry := ImageHashIndexEntry{
+		DHash:  imageMetadata.Hashes.DHash,
+		Key:    s3ObjectKey,
+		PHash:  imageMetadata.Hashes.PHash,
+		SHA256: imageMetadata.Hashes.SHA256}
+	if err := putS3ObjectImageHashIndexEntry(s3Client, s3BucketName, imageHashIndexEntry, shards); err != nil {
+		log.Fatalf("ImageHashIndex: Error=%s", err)
+	}
+	return true
+}
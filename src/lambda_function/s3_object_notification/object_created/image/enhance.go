@@ -0,0 +1,280 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"math"
+)
+
+// Contains functions for automatically enhancing flat or colour cast renditions.
+//
+// Enhancement runs on the resized rendition, in three optional steps: white balance scales each channel so that the
+// average colour (grey world) or the brightest colours (white patch) become neutral, auto levels stretches the
+// luminance histogram so that a small percentage of pixels clips at each end, and local contrast applies contrast
+// limited adaptive histogram equalisation (CLAHE) to the luminance. The parameters each step derives from the image
+// are recorded in the rendition manifest.
+
+// Constants used by the enhancement steps.
+const (
+	enhancementDefaultClip          = 0.5
+	enhancementDefaultClipLimit     = 2
+	enhancementDefaultTiles         = 8
+	enhancementMaxGain              = 2
+	enhancementMinGain              = 0.5
+	enhancementMinLevelsRange       = 32
+	enhancementWhiteBalanceGrey     = "GreyWorld"
+	enhancementWhiteBalanceWhite    = "WhitePatch"
+	enhancementWhitePatchPercentile = 99
+)
+
+// Enhancement describes the automatic enhancement of a rendition. WhiteBalance is GreyWorld, WhitePatch or empty to
+// leave the colour balance alone. AutoLevels stretches the levels so that Clip percent of the pixels clip at each end,
+// 0.5 percent unless Clip is set, and 0 to stretch only to the darkest and brightest pixels. LocalContrast applies
+// CLAHE over a grid of Tiles by Tiles regions, with ClipLimit limiting the contrast gain.
+type Enhancement struct {
+	AutoLevels    bool     `json:"AutoLevels"`
+	Clip          *float64 `json:"Clip"`
+	ClipLimit     float64  `json:"ClipLimit"`
+	LocalContrast bool     `json:"LocalContrast"`
+	Tiles         int      `json:"Tiles"`
+	WhiteBalance  string   `json:"WhiteBalance"`
+}
+
+// EnhancementParameters records the parameters that enhancement derived from a rendition. Gains are the red, green
+// and blue white balance multipliers, and BlackPoint and WhitePoint the luminance levels stretched to 0 and 255.
+type EnhancementParameters struct {
+	BlackPoint   int        `json:"BlackPoint"`
+	ClipLimit    float64    `json:"ClipLimit"`
+	Gains        [3]float64 `json:"Gains"`
+	Tiles        int        `json:"Tiles"`
+	WhiteBalance string     `json:"WhiteBalance"`
+	WhitePoint   int        `json:"WhitePoint"`
+}
+
+// validateEnhancement checks that the enhancement is usable, filling in the defaults.
+func validateEnhancement(enhancement *Enhancement) error {
+	if !enhancement.AutoLevels && !enhancement.LocalContrast && enhancement.WhiteBalance == "" {
+		return errors.New("enhancement enables none of AutoLevels, LocalContrast or WhiteBalance")
+	}
+	if enhancement.WhiteBalance != "" && enhancement.WhiteBalance != enhancementWhiteBalanceGrey && enhancement.WhiteBalance != enhancementWhiteBalanceWhite {
+		return fmt.Errorf("unknown white balance %q", enhancement.WhiteBalance)
+	}
+	if enhancement.Clip == nil {
+		clip := float64(enhancementDefaultClip)
+		enhancement.Clip = &clip
+	}
+	if *enhancement.Clip < 0 || *enhancement.Clip > 10 {
+		return fmt.Errorf("enhancement clip %v outside 0-10", *enhancement.Clip)
+	}
+	if enhancement.ClipLimit == 0 {
+		enhancement.ClipLimit = enhancementDefaultClipLimit
+	}
+	if enhancement.ClipLimit < 1 || enhancement.ClipLimit > 10 {
+		return fmt.Errorf("enhancement clip limit %v outside 1-10", enhancement.ClipLimit)
+	}
+	if enhancement.Tiles == 0 {
+		enhancement.Tiles = enhancementDefaultTiles
+	}
+	if enhancement.Tiles < 1 || enhancement.Tiles > 32 {
+		return fmt.Errorf("enhancement tiles %d outside 1-32", enhancement.Tiles)
+	}
+	return nil
+}
+
+// applyEnhancement enhances the image in place and returns the parameters it derived.
+func applyEnhancement(imageDestination *image.RGBA, enhancement *Enhancement) *EnhancementParameters {
+	enhancementParameters := EnhancementParameters{Gains: [3]float64{1, 1, 1}, WhitePoint: 0xFF}
+	if enhancement.WhiteBalance != "" {
+		enhancementParameters.WhiteBalance = enhancement.WhiteBalance
+		enhancementParameters.Gains = getWhiteBalanceGains(imageDestination, enhancement.WhiteBalance)
+		applyLevels(imageDestination, enhancementParameters.Gains, 0, 0xFF)
+	}
+	if enhancement.AutoLevels {
+		enhancementParameters.BlackPoint, enhancementParameters.WhitePoint = getLevels(imageDestination, *enhancement.Clip)
+		applyLevels(imageDestination, [3]float64{1, 1, 1}, enhancementParameters.BlackPoint, enhancementParameters.WhitePoint)
+	}
+	if enhancement.LocalContrast {
+		enhancementParameters.ClipLimit = enhancement.ClipLimit
+		enhancementParameters.Tiles = enhancement.Tiles
+		applyCLAHE(imageDestination, enhancement.Tiles, enhancement.ClipLimit)
+	}
+	return &enhancementParameters
+}
+
+// applyLevels multiplies each channel of the image in place by its gain and stretches the range from the black point
+// to the white point to the full range.
+func applyLevels(imageDestination *image.RGBA, gains [3]float64, blackPoint int, whitePoint int) {
+	var tables [3][256]uint8
+	scale := 0xFF / float64(whitePoint-blackPoint)
+	for channel := range tables {
+		for level := range tables[channel] {
+			value := (float64(level)*gains[channel] - float64(blackPoint)) * scale
+			tables[channel][level] = uint8(math.Round(math.Max(0, math.Min(0xFF, value))))
+		}
+	}
+	for i := 0; i < len(imageDestination.Pix); i += 4 {
+		for channel := range tables {
+			imageDestination.Pix[i+channel] = tables[channel][imageDestination.Pix[i+channel]]
+		}
+	}
+}
+
+// getWhiteBalanceGains returns the channel multipliers that neutralise the colour cast of the image. Grey world
+// makes the mean of each channel equal, white patch makes the brightest colours white. Gains are limited so that
+// images dominated by one colour, such as a sunset, are not neutralised entirely.
+func getWhiteBalanceGains(imageSource *image.RGBA, whiteBalance string) [3]float64 {
+	var reference [3]float64
+	if whiteBalance == enhancementWhiteBalanceGrey {
+		var sums [3]float64
+		for i := 0; i < len(imageSource.Pix); i += 4 {
+			for channel := range sums {
+				sums[channel] += float64(imageSource.Pix[i+channel])
+			}
+		}
+		reference = sums
+	} else {
+		var histograms [3][256]int
+		for i := 0; i < len(imageSource.Pix); i += 4 {
+			for channel := range histograms {
+				histograms[channel][imageSource.Pix[i+channel]]++
+			}
+		}
+		for channel := range histograms {
+			reference[channel] = float64(getHistogramPercentile(histograms[channel][:], enhancementWhitePatchPercentile))
+		}
+	}
+	mean := (reference[0] + reference[1] + reference[2]) / 3
+	gains := [3]float64{1, 1, 1}
+	for channel := range gains {
+		if reference[channel] > 0 {
+			gains[channel] = math.Round(math.Max(enhancementMinGain, math.Min(enhancementMaxGain, mean/reference[channel]))*1000) / 1000
+		}
+	}
+	if whiteBalance == enhancementWhiteBalanceWhite {
+		// Scale the gains so that the brightest channel reaches white without clipping the others further, within the
+		// same limits.
+		maximum := 0.0
+		for channel := range gains {
+			maximum = math.Max(maximum, reference[channel]*gains[channel])
+		}
+		for channel := range gains {
+			if maximum > 0 {
+				gains[channel] = math.Round(math.Max(enhancementMinGain, math.Min(enhancementMaxGain, gains[channel]*0xFF/maximum))*1000) / 1000
+			}
+		}
+	}
+	return gains
+}
+
+// getLevels returns the luminance levels below and above which the clip percentage of the pixels lie. The range is
+// widened around its centre when it is too narrow, so that nearly uniform images are not stretched into noise.
+func getLevels(imageSource *image.RGBA, clip float64) (int, int) {
+	var histogram [256]int
+	for i := 0; i < len(imageSource.Pix); i += 4 {
+		histogram[getLuminanceLevel(imageSource.Pix[i:i+3])]++
+	}
+	blackPoint := getHistogramPercentile(histogram[:], clip)
+	whitePoint := getHistogramPercentile(histogram[:], 100-clip)
+	if whitePoint-blackPoint < enhancementMinLevelsRange {
+		center := (blackPoint + whitePoint) / 2
+		blackPoint = max(0, center-enhancementMinLevelsRange/2)
+		whitePoint = min(0xFF, blackPoint+enhancementMinLevelsRange)
+		blackPoint = whitePoint - enhancementMinLevelsRange
+	}
+	return blackPoint, whitePoint
+}
+
+// getHistogramPercentile returns the lowest level at or below which the percentage of the histogram lies.
+func getHistogramPercentile(histogram []int, percentile float64) int {
+	total := 0
+	for _, count := range histogram {
+		total += count
+	}
+	target := int(math.Ceil(float64(total) * percentile / 100))
+	cumulative := 0
+	for level, count := range histogram {
+		cumulative += count
+		if cumulative >= max(1, target) {
+			return level
+		}
+	}
+	return len(histogram) - 1
+}
+
+// getLuminanceLevel returns the Rec. 601 luma of an 8-bit RGB pixel.
+func getLuminanceLevel(pixel []uint8) uint8 {
+	return uint8((299*int(pixel[0]) + 587*int(pixel[1]) + 114*int(pixel[2]) + 500) / 1000)
+}
+
+// applyCLAHE applies contrast limited adaptive histogram equalisation to the luminance of the image in place. Each
+// tile of the grid gets its own equalisation curve, with histogram counts above the clip limit times the average
+// count redistributed so that noise in flat areas is not amplified, and each pixel interpolates bilinearly between
+// the curves of the four nearest tile centres. The colour channels are scaled by the change in luminance, which
+// preserves hue.
+func applyCLAHE(imageDestination *image.RGBA, tiles int, clipLimit float64) {
+	width, height := imageDestination.Rect.Dx(), imageDestination.Rect.Dy()
+	tilesX, tilesY := min(tiles, width), min(tiles, height)
+	luminance := make([]uint8, width*height)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			offset := y*imageDestination.Stride + x*4
+			luminance[y*width+x] = getLuminanceLevel(imageDestination.Pix[offset : offset+3])
+		}
+	}
+
+	// Compute the clipped equalisation curve of each tile.
+	curves := make([][256]float64, tilesX*tilesY)
+	for tileY := 0; tileY < tilesY; tileY++ {
+		for tileX := 0; tileX < tilesX; tileX++ {
+			x0, x1 := tileX*width/tilesX, (tileX+1)*width/tilesX
+			y0, y1 := tileY*height/tilesY, (tileY+1)*height/tilesY
+			var histogram [256]float64
+			for y := y0; y < y1; y++ {
+				for x := x0; x < x1; x++ {
+					histogram[luminance[y*width+x]]++
+				}
+			}
+			count := float64((x1 - x0) * (y1 - y0))
+			limit := math.Max(1, clipLimit*count/256)
+			excess := 0.0
+			for level := range histogram {
+				if histogram[level] > limit {
+					excess += histogram[level] - limit
+					histogram[level] = limit
+				}
+			}
+			cumulative := 0.0
+			for level := range histogram {
+				cumulative += histogram[level] + excess/256
+				curves[tileY*tilesX+tileX][level] = cumulative / count * 0xFF
+			}
+		}
+	}
+
+	// Interpolate between the curves of the tile centres surrounding each pixel.
+	tileCoordinate := func(position int, size int, tileCount int) (int, int, float64) {
+		t := (float64(position)+0.5)*float64(tileCount)/float64(size) - 0.5
+		t0 := int(math.Floor(t))
+		fraction := t - float64(t0)
+		return max(0, min(tileCount-1, t0)), max(0, min(tileCount-1, t0+1)), fraction
+	}
+	for y := 0; y < height; y++ {
+		ty0, ty1, fy := tileCoordinate(y, height, tilesY)
+		for x := 0; x < width; x++ {
+			tx0, tx1, fx := tileCoordinate(x, width, tilesX)
+			level := luminance[y*width+x]
+			if level == 0 {
+				continue
+			}
+			top := curves[ty0*tilesX+tx0][level]*(1-fx) + curves[ty0*tilesX+tx1][level]*fx
+			bottom := curves[ty1*tilesX+tx0][level]*(1-fx) + curves[ty1*tilesX+tx1][level]*fx
+			equalised := top*(1-fy) + bottom*fy
+			ratio := equalised / float64(level)
+			offset := y*imageDestination.Stride + x*4
+			for channel := 0; channel < 3; channel++ {
+				imageDestination.Pix[offset+channel] = uint8(math.Round(math.Min(0xFF, float64(imageDestination.Pix[offset+channel])*ratio)))
+			}
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"testing"
+)
+
+// newTestGradientImage returns an image whose columns step through the levels from low to high, tinted by the gains.
+func newTestGradientImage(width int, height int, low int, high int, gains [3]float64) *image.RGBA {
+	imageSource := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			level := float64(low + (high-low)*x/(width-1))
+			imageSource.SetRGBA(x, y, color.RGBA{
+				uint8(math.Min(0xFF, level*gains[0])),
+				uint8(math.Min(0xFF, level*gains[1])),
+				uint8(math.Min(0xFF, level*gains[2])),
+				0xFF})
+		}
+	}
+	return imageSource
+}
+
+func TestValidateEnhancement(t *testing.T) {
+	enhancement := Enhancement{AutoLevels: true}
+	if err := validateEnhancement(&enhancement); err != nil || *enhancement.Clip != enhancementDefaultClip || enhancement.ClipLimit != enhancementDefaultClipLimit || enhancement.Tiles != enhancementDefaultTiles {
+		t.Fatalf("ValidateEnhancement: %+v %v", enhancement, err)
+	}
+	clip := 20.0
+	for _, enhancement := range []Enhancement{
+		{},
+		{WhiteBalance: "Daylight"},
+		{AutoLevels: true, Clip: &clip},
+		{LocalContrast: true, ClipLimit: 0.5},
+		{LocalContrast: true, Tiles: 64},
+	} {
+		if err := validateEnhancement(&enhancement); err == nil {
+			t.Errorf("ValidateEnhancement: %+v accepted", enhancement)
+		}
+	}
+	if _, err := getRenditions(`[{"Name": "Web", "Enhance": {"AutoLevels": true}}]`, nil); err != nil {
+		t.Errorf("GetRenditions: %v", err)
+	}
+	if _, err := getRenditions(`[{"Name": "Web", "Enhance": {}}]`, nil); err == nil {
+		t.Errorf("GetRenditions: empty enhancement accepted")
+	}
+
+	// A Clip of 0 is kept rather than replaced by the default.
+	renditions, err := getRenditions(`[{"Name": "Web", "Enhance": {"AutoLevels": true, "Clip": 0}}]`, nil)
+	if err != nil || *renditions[0].Enhance.Clip != 0 {
+		t.Errorf("GetRenditions: Clip 0 %v", err)
+	}
+}
+
+func TestApplyEnhancementWhiteBalance(t *testing.T) {
+	for _, whiteBalance := range []string{enhancementWhiteBalanceGrey, enhancementWhiteBalanceWhite} {
+		// A warm cast boosts red and cuts blue.
+		imageDestination := newTestGradientImage(64, 8, 40, 200, [3]float64{1.2, 1, 0.8})
+		enhancementParameters := applyEnhancement(imageDestination, &Enhancement{WhiteBalance: whiteBalance})
+		if enhancementParameters.Gains[0] >= enhancementParameters.Gains[1] || enhancementParameters.Gains[2] <= enhancementParameters.Gains[1] {
+			t.Errorf("ApplyEnhancement: %s gains %v", whiteBalance, enhancementParameters.Gains)
+		}
+		pixel := imageDestination.RGBAAt(32, 4)
+		if math.Abs(float64(pixel.R)-float64(pixel.B)) > 6 {
+			t.Errorf("ApplyEnhancement: %s left cast %v", whiteBalance, pixel)
+		}
+	}
+}
+
+func TestGetWhiteBalanceGainsLimit(t *testing.T) {
+	// Scaling a dark image's brightest colours to white stays within the gain limits.
+	gains := getWhiteBalanceGains(newTestGradientImage(64, 8, 10, 60, [3]float64{1.2, 1, 0.8}), enhancementWhiteBalanceWhite)
+	for channel, gain := range gains {
+		if gain < enhancementMinGain || gain > enhancementMaxGain {
+			t.Errorf("GetWhiteBalanceGains: channel %d gain %v", channel, gain)
+		}
+	}
+}
+
+func TestApplyEnhancementAutoLevels(t *testing.T) {
+	clip := 1.0
+	imageDestination := newTestGradientImage(256, 4, 80, 160, [3]float64{1, 1, 1})
+	enhancementParameters := applyEnhancement(imageDestination, &Enhancement{AutoLevels: true, Clip: &clip})
+	if enhancementParameters.BlackPoint < 80 || enhancementParameters.BlackPoint > 82 || enhancementParameters.WhitePoint < 158 || enhancementParameters.WhitePoint > 160 {
+		t.Fatalf("ApplyEnhancement: levels %d-%d", enhancementParameters.BlackPoint, enhancementParameters.WhitePoint)
+	}
+	if low, high := imageDestination.RGBAAt(0, 0).G, imageDestination.RGBAAt(255, 0).G; low != 0 || high != 0xFF {
+		t.Errorf("ApplyEnhancement: stretched to %d-%d", low, high)
+	}
+
+	// A nearly uniform image is stretched no further than the minimum range.
+	if blackPoint, whitePoint := getLevels(newTestGradientImage(16, 16, 120, 124, [3]float64{1, 1, 1}), 0.5); whitePoint-blackPoint != enhancementMinLevelsRange {
+		t.Errorf("GetLevels: %d-%d", blackPoint, whitePoint)
+	}
+}
+
+func TestApplyEnhancementLocalContrast(t *testing.T) {
+	// A faint gradient gains contrast.
+	imageDestination := newTestGradientImage(128, 128, 100, 140, [3]float64{1, 1, 1})
+	applyEnhancement(imageDestination, &Enhancement{LocalContrast: true, ClipLimit: 4, Tiles: 4})
+	if low, high := imageDestination.RGBAAt(0, 64).G, imageDestination.RGBAAt(127, 64).G; int(high)-int(low) <= 40 {
+		t.Errorf("ApplyEnhancement: contrast %d-%d", low, high)
+	}
+
+	// A uniform image keeps a uniform tone.
+	imageDestination = newTestGradientImage(64, 64, 120, 120, [3]float64{1, 1, 1})
+	applyEnhancement(imageDestination, &Enhancement{LocalContrast: true, ClipLimit: 2, Tiles: 4})
+	if a, b := imageDestination.RGBAAt(0, 0), imageDestination.RGBAAt(40, 50); a != b {
+		t.Errorf("ApplyEnhancement: uniform image became %v and %v", a, b)
+	}
+}
@@ -1,13 +1,17 @@
 package main
 
 import (
+	"errors"
 	"fmt"
+	"image"
+	"io/fs"
 	"log"
 	"path"
 	"strings"
 	"time"
 
 	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/aws/aws-sdk-go/service/s3/s3manager"
@@ -108,27 +112,50 @@ func processS3Object(session *session.Session, s3BucketName string, s3Object *ev
 	// Create an S3 client.
 	s3Client := s3.New(session)
 
+	// Validate the file before decoding it, quarantining files that are rejected.
+	if !processS3ObjectValidation(s3Client, s3BucketName, s3Object.Key, fileName) {
+		return
+	}
+
 	// Process Exif metadata for the S3 object.
-	processS3ObjectExifMetadata(session, s3Client, s3BucketName, fileName)
+	processS3ObjectExifMetadata(session, s3Client, s3BucketName, s3Object.Key, fileName)
 }
 
 // processS3ObjectExifMetadata processes Exif metadata for an AWS S3 object event.
-func processS3ObjectExifMetadata(session *session.Session, s3Client *s3.S3, s3BucketName string, fileName string) {
+func processS3ObjectExifMetadata(session *session.Session, s3Client *s3.S3, s3BucketName string, s3ObjectKey string, fileName string) {
 	log.Printf("ExifMetadata: BucketName=%s FileName=%s", s3BucketName, fileName)
 
 	// Open and extract Exif metadata from the image file.
 	exifMetadata, err := openExif(fileName)
-	if err != nil {
+	var pathError *fs.PathError
+	if errors.As(err, &pathError) {
 		log.Fatalf("ExifMetadata: Error=%s", err)
 	}
+	if err == nil && exifMetadata.DateTime == nil {
+		err = errors.New("no DateTime tag")
+	}
+	if err != nil {
+		// Photos are filed by the date they were taken, so an upload without Exif metadata, such as most PNG files,
+		// is quarantined rather than retried.
+		processS3ObjectQuarantine(s3Client, s3BucketName, s3ObjectKey, newImageValidationError(validationReasonExifMissing, "%s", err))
+		return
+	}
 	log.Println("ExifMetata: Successfully created ExifMetadata")
 
+	// Process the image itself, collecting the metadata derived from its pixel data.
+	imageMetadata := processS3ObjectImage(session, s3Client, s3BucketName, s3ObjectKey, fileName, exifMetadata)
+	if imageMetadata == nil {
+		// The image could not be decoded and was quarantined.
+		return
+	}
+
 	// Create the S3 object key for the Exif metadata.
-	s3ObjectKey := createS3ObjectKey(s3BucketFolderImagesExif, fmt.Sprintf("%s.JSON", strings.Split(path.Base(fileName), ".")[0]), *exifMetadata.DateTime)
+	s3ObjectKeyUploaded := s3ObjectKey
+	s3ObjectKey = createS3ObjectKey(s3BucketFolderImagesExif, fmt.Sprintf("%s.JSON", strings.Split(path.Base(fileName), ".")[0]), *exifMetadata.DateTime)
 	log.Printf("ExifMetadata: Bucket=%s Key=%s", s3BucketName, s3ObjectKey)
 
 	// Upload the Exif metadata to S3.
-	s3PutObjectOutput, err := putS3ObjectExifMetadata(s3Client, s3BucketName, s3ObjectKey, exifMetadata)
+	s3PutObjectOutput, err := putS3ObjectImageMetadata(s3Client, s3BucketName, s3ObjectKey, imageMetadata)
 	if err != nil {
 		log.Fatalf("ExifMetadata: Error=%s", err)
 	}
@@ -137,20 +164,58 @@ func processS3ObjectExifMetadata(session *session.Session, s3Client *s3.S3, s3Bu
 	// Process the S3 PutObject output.
 	processS3PutObjectOutput(s3PutObjectOutput)
 
-	// Process the image itself.
-	processS3ObjectImage(session, s3Client, s3BucketName, fileName, *exifMetadata.DateTime)
+	// Quarantine whatever was produced if moderation quarantined the image while it was being processed.
+	processS3ObjectModerationQuarantine(s3Client, s3BucketName, s3ObjectKeyUploaded, fileName, *exifMetadata.DateTime, imageMetadata)
 }
 
 // processS3ObjectImage processes an image for an AWS S3 object event.
-func processS3ObjectImage(session *session.Session, s3Client *s3.S3, s3BucketName string, fileName string, fileTime time.Time) {
+// It returns the ImageMetadata describing the processed image.
+func processS3ObjectImage(session *session.Session, s3Client *s3.S3, s3BucketName string, s3ObjectKey string, fileName string, exifMetadata *ExifMetadata) *ImageMetadata {
 	log.Printf("CompressImage: BucketName=%s FileName=%s", s3BucketName, fileName)
+	imageMetadata := ImageMetadata{ExifMetadata: exifMetadata, UploadedKey: s3ObjectKey}
+	s3ObjectKeyUploaded := s3ObjectKey
 
-	// Open and prepare the image for compression.
-	image, err := openImage(fileName)
+	// Open and prepare the image for compression, measuring the peak memory used to process it.
+	resetPeakMemory()
+	image, imageDecoding, err := openImage(fileName)
+	var imageValidationError *ImageValidationError
+	if errors.As(getImageValidationError(err), &imageValidationError) {
+		processS3ObjectQuarantine(s3Client, s3BucketName, s3ObjectKey, imageValidationError)
+		return nil
+	}
 	if err != nil {
 		log.Fatalf("CompressImage: Error=%s", err)
 	}
-	log.Println("CompressImage: Successfully created image")
+	imageMetadata.Decoding = imageDecoding
+	defer func() {
+		imageMetadata.Decoding.PeakMemory = getPeakMemory()
+		log.Printf("ImageDecoding: PeakMemory=%d", imageMetadata.Decoding.PeakMemory)
+	}()
+	log.Printf("CompressImage: Successfully created image Decoder=%s Format=%s Width=%d Height=%d DecodedWidth=%d DecodedHeight=%d",
+		imageDecoding.Decoder,
+		imageDecoding.Format,
+		imageDecoding.Width,
+		imageDecoding.Height,
+		imageDecoding.DecodedWidth,
+		imageDecoding.DecodedHeight)
+
+	// Convert the image to sRGB so that it displays consistently once re-encoded without its profile.
+	image = processS3ObjectImageColorProfile(&imageMetadata, fileName, image)
+
+	// Compute the placeholders displayed while the renditions load.
+	processS3ObjectImagePlaceholder(&imageMetadata, image)
+
+	// Extract the dominant colours so that images can be searched and sorted by colour.
+	processS3ObjectImagePalette(&imageMetadata, image)
+
+	// Score the technical quality of the image so that bad frames can be culled.
+	processS3ObjectImageQuality(&imageMetadata, image)
+
+	// Check whether the image duplicates an earlier upload before publishing it.
+	if !processS3ObjectImageHashes(s3Client, s3BucketName, s3ObjectKey, &imageMetadata, fileName, image) {
+		log.Printf("CompressImage: Skipping duplicate Key=%s", s3ObjectKey)
+		return &imageMetadata
+	}
 
 	// Ensure that the compressed folder configuration is correct.
 	if strings.Contains(s3BucketFolderImagesCompressed, "upload") {
@@ -158,7 +223,7 @@ func processS3ObjectImage(session *session.Session, s3Client *s3.S3, s3BucketNam
 	}
 
 	// Create the S3 object key for the compressed image.
-	s3ObjectKey := createS3ObjectKey(s3BucketFolderImagesCompressed, path.Base(fileName), fileTime)
+	s3ObjectKey = createS3ObjectKey(s3BucketFolderImagesCompressed, path.Base(fileName), *exifMetadata.DateTime)
 	log.Printf("CompressImage: Bucket=%s Key=%s", s3BucketName, s3ObjectKey)
 
 	// Upload the compressed image to S3.
@@ -170,4 +235,29 @@ func processS3ObjectImage(session *session.Session, s3Client *s3.S3, s3BucketNam
 
 	// Process the S3 Upload output.
 	processS3ManagerUploadOutput(s3ManagerUploadOutput)
+
+	// Produce the graded copies of the image selected by the upload's prefix and tags.
+	processS3ObjectImageGrades(session, s3Client, s3BucketName, s3ObjectKeyUploaded, &imageMetadata, fileName, *exifMetadata.DateTime, image)
+
+	// Produce the configured renditions of the image.
+	eTag := strings.Trim(aws.StringValue(s3ManagerUploadOutput.ETag), `"`)
+	processS3ObjectImageRenditions(session, s3Client, s3BucketName, fileName, *exifMetadata.DateTime, eTag, image)
+	return &imageMetadata
+}
+
+// processS3ObjectImageColorProfile identifies the colour space of the image and converts it to sRGB.
+// The embedded ICC profile is preferred, falling back to the Exif colour space tags.
+func processS3ObjectImageColorProfile(imageMetadata *ImageMetadata, fileName string, imageSource image.Image) image.Image {
+	iccProfile, err := openICCProfile(fileName)
+	if err != nil {
+		// A damaged profile should not prevent the image from being published.
+		log.Printf("ColorProfile: Error=%s", err)
+	}
+	imageMetadata.ColorProfile = getColorProfile(iccProfile, imageMetadata.ExifMetadata)
+	imageDestination := convertImageToSRGB(imageSource, imageMetadata.ColorProfile)
+	log.Printf("ColorProfile: ColorSpace=%s ConvertedToSRGB=%v Source=%s",
+		imageMetadata.ColorProfile.ColorSpace,
+		imageMetadata.ColorProfile.ConvertedToSRGB,
+		imageMetadata.ColorProfile.Source)
+	return imageDestination
 }
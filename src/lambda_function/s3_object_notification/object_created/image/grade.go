@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bytes"
+	"embed"
+	"encoding/json"
+	"fmt"
+	"image"
+	"log"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+)
+
+// Contains functions for producing colour graded copies of uploads with the editors' house looks.
+
+// gradeTagKey is the S3 object tag of an upload that lists, separated by spaces, the grades applied to it.
+const gradeTagKey = "Grade"
+
+// Grade describes a house look applied to uploads. The look is a .cube LUT stored in the bucket under LUT or bundled
+// with the function under BundledLUT. A grade applies to uploads whose keys start with one of its Prefixes and to
+// uploads tagged with its name. Interpolation is Tetrahedral or Trilinear, defaulting to Tetrahedral, and Quality is
+// the JPEG quality of the graded image.
+type Grade struct {
+	BundledLUT    string   `json:"BundledLUT"`
+	Interpolation string   `json:"Interpolation"`
+	LUT           string   `json:"LUT"`
+	Name          string   `json:"Name"`
+	Prefixes      []string `json:"Prefixes"`
+	Quality       int      `json:"Quality"`
+}
+
+// ImageGrade records a graded copy of an image stored in S3.
+type ImageGrade struct {
+	Key   string `json:"Key"`
+	Name  string `json:"Name"`
+	Title string `json:"Title"`
+}
+
+// bundledLUTs holds the LUTs bundled with the function.
+//
+//go:embed luts/*.cube
+var bundledLUTs embed.FS
+
+// gradeLUTs caches the parsed LUT of each grade between invocations of a warm Lambda.
+var gradeLUTs = make(map[string]*colorLUT)
+
+// getGrades parses the JSON grade configuration and checks that every grade is usable. Bundled LUTs are parsed
+// immediately; LUTs stored in S3 are loaded when first used.
+func getGrades(configuration string) ([]Grade, error) {
+	var grades []Grade
+	if err := json.Unmarshal([]byte(configuration), &grades); err != nil {
+		return nil, err
+	}
+	names := make(map[string]bool)
+	for i := range grades {
+		grade := &grades[i]
+		if grade.Name == "" || strings.ContainsAny(grade.Name, "/. ") {
+			return nil, fmt.Errorf("invalid grade name %q", grade.Name)
+		}
+		if names[grade.Name] {
+			return nil, fmt.Errorf("duplicate grade name %q", grade.Name)
+		}
+		if (grade.LUT == "") == (grade.BundledLUT == "") {
+			return nil, fmt.Errorf("grade %q requires exactly one of LUT or BundledLUT", grade.Name)
+		}
+		if grade.Interpolation == "" {
+			grade.Interpolation = lutInterpolationTetrahedral
+		}
+		if grade.Interpolation != lutInterpolationTetrahedral && grade.Interpolation != lutInterpolationTrilinear {
+			return nil, fmt.Errorf("grade %q has unknown interpolation %q", grade.Name, grade.Interpolation)
+		}
+		if grade.Quality < 0 || grade.Quality > 100 {
+			return nil, fmt.Errorf("grade %q has quality %d outside 0-100 (0 for the default)", grade.Name, grade.Quality)
+		}
+		if grade.BundledLUT != "" {
+			b, err := bundledLUTs.ReadFile(path.Join("luts", grade.BundledLUT))
+			if err != nil {
+				return nil, fmt.Errorf("grade %q: %w", grade.Name, err)
+			}
+			if gradeLUTs[grade.Name], err = parseCubeLUT(bytes.NewReader(b)); err != nil {
+				return nil, fmt.Errorf("grade %q: %w", grade.Name, err)
+			}
+		}
+		names[grade.Name] = true
+	}
+	return grades, nil
+}
+
+// getGradeLUT returns the LUT of the grade, loading it from S3 on first use.
+func getGradeLUT(s3Client *s3.S3, s3BucketName string, grade *Grade) (*colorLUT, error) {
+	if lut, ok := gradeLUTs[grade.Name]; ok {
+		return lut, nil
+	}
+	b, err := getS3ObjectBytes(s3Client, s3BucketName, grade.LUT)
+	if err != nil {
+		return nil, err
+	}
+	lut, err := parseCubeLUT(bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	gradeLUTs[grade.Name] = lut
+	return lut, nil
+}
+
+// getSelectedGrades returns the grades that apply to the upload with the key and tags.
+func getSelectedGrades(grades []Grade, s3ObjectKey string, s3Tags []*s3.Tag) []*Grade {
+	tagged := make(map[string]bool)
+	for _, s3Tag := range s3Tags {
+		if aws.StringValue(s3Tag.Key) == gradeTagKey {
+			for _, name := range strings.Fields(aws.StringValue(s3Tag.Value)) {
+				tagged[name] = true
+			}
+		}
+	}
+	var selectedGrades []*Grade
+	for i := range grades {
+		grade := &grades[i]
+		selected := tagged[grade.Name]
+		for _, prefix := range grade.Prefixes {
+			selected = selected || strings.HasPrefix(s3ObjectKey, prefix)
+		}
+		if selected {
+			selectedGrades = append(selectedGrades, grade)
+		}
+	}
+	return selectedGrades
+}
+
+// processS3ObjectImageGrades produces and uploads the graded copies of the image selected by the upload's key and
+// tags, recording them in the metadata.
+func processS3ObjectImageGrades(session *session.Session, s3Client *s3.S3, s3BucketName string, s3ObjectKey string, imageMetadata *ImageMetadata, fileName string, fileTime time.Time, imageSource image.Image) {
+	if len(imageGrades) == 0 {
+		return
+	}
+	s3Tags, err := getS3ObjectTagging(s3Client, s3BucketName, s3ObjectKey)
+	if err != nil {
+		// Grades can still be selected by prefix when the tags cannot be read.
+		log.Printf("Grades: Bucket=%s Key=%s Error=%s", s3BucketName, s3ObjectKey, err)
+	}
+	selectedGrades := getSelectedGrades(imageGrades, s3ObjectKey, s3Tags)
+	log.Printf("Grades: Bucket=%s Key=%s Grades=%d", s3BucketName, s3ObjectKey, len(selectedGrades))
+
+	s3UploadManager := s3manager.NewUploader(session)
+	for _, grade := range selectedGrades {
+		lut, err := getGradeLUT(s3Client, s3BucketName, grade)
+		if err != nil {
+			log.Fatalf("Grade: Name=%s LUT=%s Error=%s", grade.Name, grade.LUT, err)
+		}
+		imageGraded := applyLUT(imageSource, lut, grade.Interpolation)
+
+		// Upload the graded image to S3.
+		s3ObjectKeyGraded := createS3ObjectKey(fmt.Sprintf("%s/%s", s3BucketFolderImagesGraded, grade.Name), path.Base(fileName), fileTime)
+		log.Printf("Grade: Name=%s Title=%s Interpolation=%s Bucket=%s Key=%s", grade.Name, lut.title, grade.Interpolation, s3BucketName, s3ObjectKeyGraded)
+		s3ManagerUploadOutput, err := putS3ObjectImageJpgQuality(s3UploadManager, s3BucketName, s3ObjectKeyGraded, imageGraded, grade.Quality)
+		if err != nil {
+			log.Fatalf("Grade: Name=%s Error=%s", grade.Name, err)
+		}
+		processS3ManagerUploadOutput(s3ManagerUploadOutput)
+		imageMetadata.Grades = append(imageMetadata.Grades, ImageGrade{Key: s3ObjectKeyGraded, Name: grade.Name, Title: lut.title})
+	}
+}
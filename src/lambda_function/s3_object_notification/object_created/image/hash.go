@@ -0,0 +1,164 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"image"
+	"io"
+	"math"
+	"math/bits"
+	"os"
+	"sort"
+	"strconv"
+)
+
+// Contains functions for computing cryptographic and perceptual hashes of images.
+
+// Dimensions of the thumbnails that the perceptual hashes are computed from.
+const (
+	hashAverageSize    = 8
+	hashDifferenceSize = 8
+	hashPerceptualSize = 32
+	hashPerceptualLow  = 8
+)
+
+// ImageHashes contains the hashes of an image. SHA256 identifies byte for byte copies of the uploaded file, while
+// the 64-bit average (AHash), difference (DHash) and DCT based perceptual (PHash) hashes, encoded as hexadecimal,
+// stay close in Hamming distance when an image is re-encoded, resized or lightly edited.
+type ImageHashes struct {
+	AHash  string `json:"AHash"`
+	DHash  string `json:"DHash"`
+	PHash  string `json:"PHash"`
+	SHA256 string `json:"SHA256"`
+}
+
+// getImageHashes computes the perceptual hashes of the image and the SHA-256 hash of the file it was decoded from.
+func getImageHashes(fileName string, imageSource image.Image) (*ImageHashes, error) {
+	fileHash, err := openSHA256(fileName)
+	if err != nil {
+		return nil, err
+	}
+	return &ImageHashes{
+		AHash:  formatHash(getAverageHash(imageSource)),
+		DHash:  formatHash(getDifferenceHash(imageSource)),
+		PHash:  formatHash(getPerceptualHash(imageSource)),
+		SHA256: fileHash}, nil
+}
+
+// openSHA256 returns the hexadecimal SHA-256 hash of the file specified by the filename.
+func openSHA256(fileName string) (string, error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// formatHash encodes a 64-bit hash as 16 hexadecimal digits.
+func formatHash(hash uint64) string {
+	return fmt.Sprintf("%016x", hash)
+}
+
+// parseHash decodes a 64-bit hash encoded by formatHash.
+func parseHash(hash string) (uint64, error) {
+	return strconv.ParseUint(hash, 16, 64)
+}
+
+// getHashDistance returns the Hamming distance between two 64-bit hashes.
+func getHashDistance(a uint64, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
+
+// getLuminance reduces the image to a thumbnail of the given dimensions and returns its luminance values.
+func getLuminance(imageSource image.Image, width int, height int) []float64 {
+	thumbnail := thumbnailImage(imageSource, width, height)
+	luminance := make([]float64, width*height)
+	for i := range luminance {
+		pixel := thumbnail.Pix[i*4 : i*4+3]
+		luminance[i] = 0.299*float64(pixel[0]) + 0.587*float64(pixel[1]) + 0.114*float64(pixel[2])
+	}
+	return luminance
+}
+
+// getAverageHash sets a bit for every pixel of an 8x8 thumbnail that is brighter than the thumbnail's mean.
+func getAverageHash(imageSource image.Image) uint64 {
+	luminance := getLuminance(imageSource, hashAverageSize, hashAverageSize)
+	mean := 0.0
+	for _, value := range luminance {
+		mean += value
+	}
+	mean /= float64(len(luminance))
+	var hash uint64
+	for i, value := range luminance {
+		if value > mean {
+			hash |= 1 << uint(len(luminance)-1-i)
+		}
+	}
+	return hash
+}
+
+// getDifferenceHash sets a bit for every pixel of a 9x8 thumbnail that is brighter than its right neighbour.
+func getDifferenceHash(imageSource image.Image) uint64 {
+	luminance := getLuminance(imageSource, hashDifferenceSize+1, hashDifferenceSize)
+	var hash uint64
+	bit := 63
+	for y := 0; y < hashDifferenceSize; y++ {
+		for x := 0; x < hashDifferenceSize; x++ {
+			if luminance[y*(hashDifferenceSize+1)+x] > luminance[y*(hashDifferenceSize+1)+x+1] {
+				hash |= 1 << uint(bit)
+			}
+			bit--
+		}
+	}
+	return hash
+}
+
+// getPerceptualHash computes the DCT of a 32x32 thumbnail and sets a bit for every one of the 8x8 lowest frequency
+// coefficients that is above their median. The DC coefficient is excluded from the median as it only reflects the
+// overall brightness.
+func getPerceptualHash(imageSource image.Image) uint64 {
+	luminance := getLuminance(imageSource, hashPerceptualSize, hashPerceptualSize)
+
+	// Compute the 2D DCT-II, keeping only the low frequency coefficients.
+	var cosines [hashPerceptualLow][hashPerceptualSize]float64
+	for u := range cosines {
+		for x := range cosines[u] {
+			cosines[u][x] = math.Cos(float64(2*x+1) * float64(u) * math.Pi / (2 * hashPerceptualSize))
+		}
+	}
+	var rows [hashPerceptualSize][hashPerceptualLow]float64
+	for y := 0; y < hashPerceptualSize; y++ {
+		for u := 0; u < hashPerceptualLow; u++ {
+			for x := 0; x < hashPerceptualSize; x++ {
+				rows[y][u] += luminance[y*hashPerceptualSize+x] * cosines[u][x]
+			}
+		}
+	}
+	coefficients := make([]float64, 0, hashPerceptualLow*hashPerceptualLow)
+	for v := 0; v < hashPerceptualLow; v++ {
+		for u := 0; u < hashPerceptualLow; u++ {
+			coefficient := 0.0
+			for y := 0; y < hashPerceptualSize; y++ {
+				coefficient += rows[y][u] * cosines[v][y]
+			}
+			coefficients = append(coefficients, coefficient)
+		}
+	}
+
+	sorted := append([]float64(nil), coefficients[1:]...)
+	sort.Float64s(sorted)
+	median := sorted[len(sorted)/2]
+	var hash uint64
+	for i, coefficient := range coefficients {
+		if coefficient > median {
+			hash |= 1 << uint(len(coefficients)-1-i)
+		}
+	}
+	return hash
+}
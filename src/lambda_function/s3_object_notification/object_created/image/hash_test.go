@@ -0,0 +1,84 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"math/rand"
+	"testing"
+)
+
+// newTestHashImage creates an image of overlapping circles whose layout is determined by the seed.
+func newTestHashImage(seed int64, width int, height int) *image.RGBA {
+	random := rand.New(rand.NewSource(seed))
+	type circle struct{ x, y, r, value float64 }
+	circles := make([]circle, 12)
+	for i := range circles {
+		circles[i] = circle{random.Float64(), random.Float64(), 0.05 + random.Float64()*0.2, random.Float64() * 255}
+	}
+	imageSource := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			value := 128.0
+			for _, c := range circles {
+				if math.Hypot(float64(x)/float64(width)-c.x, float64(y)/float64(height)-c.y) < c.r {
+					value = c.value
+				}
+			}
+			imageSource.Set(x, y, color.Gray{Y: uint8(value)})
+		}
+	}
+	return imageSource
+}
+
+func TestGetPerceptualHashDistance(t *testing.T) {
+	original := newTestHashImage(1, 640, 480)
+	resized := newTestHashImage(1, 320, 240)
+	different := newTestHashImage(2, 640, 480)
+	for name, hash := range map[string]func(image.Image) uint64{"AHash": getAverageHash, "DHash": getDifferenceHash, "PHash": getPerceptualHash} {
+		if distance := getHashDistance(hash(original), hash(resized)); distance > 4 {
+			t.Errorf("%s: resized image has distance %d", name, distance)
+		}
+		if distance := getHashDistance(hash(original), hash(different)); distance <= 4 {
+			t.Errorf("%s: different image has distance %d", name, distance)
+		}
+	}
+}
+
+func TestFindImageDuplicate(t *testing.T) {
+	imageHashes := &ImageHashes{DHash: "00000000000000ff", PHash: "00000000000000ff", SHA256: "a"}
+	imageHashIndexEntries := []ImageHashIndexEntry{
+		{DHash: "00000000000000ff", Key: "images/uploaded/self.JPG", PHash: "00000000000000ff", SHA256: "a"},
+		{DHash: "00000000000000fc", Key: "images/uploaded/near.JPG", PHash: "00000000000000fe", SHA256: "b"},
+		{DHash: "ffffffffffffffff", Key: "images/uploaded/far.JPG", PHash: "ffffffffffffffff", SHA256: "c"},
+		// The perceptual hash matches but the difference hash does not.
+		{DHash: "ffffffffffffffff", Key: "images/uploaded/similar.JPG", PHash: "00000000000000ff", SHA256: "d"},
+	}
+	imageDuplicate, err := findImageDuplicate(imageHashes, "images/uploaded/self.JPG", imageHashIndexEntries, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if imageDuplicate == nil || imageDuplicate.Key != "images/uploaded/near.JPG" || imageDuplicate.Distance != 1 || imageDuplicate.Exact {
+		t.Fatalf("FindImageDuplicate: %+v", imageDuplicate)
+	}
+
+	// An exact copy is preferred over a closer near-duplicate.
+	imageHashIndexEntries = append(imageHashIndexEntries, ImageHashIndexEntry{DHash: "ffffffffffffffff", Key: "images/uploaded/copy.JPG", PHash: "00000000000000fc", SHA256: "a"})
+	imageDuplicate, err = findImageDuplicate(imageHashes, "images/uploaded/self.JPG", imageHashIndexEntries, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if imageDuplicate == nil || imageDuplicate.Key != "images/uploaded/copy.JPG" || !imageDuplicate.Exact {
+		t.Fatalf("FindImageDuplicate: %+v", imageDuplicate)
+	}
+}
+
+func TestGetImageHashIndexShardKeys(t *testing.T) {
+	folder := s3BucketFolderImagesHashes
+	t.Cleanup(func() { s3BucketFolderImagesHashes = folder })
+	s3BucketFolderImagesHashes = "images/hashes/"
+	s3ObjectKeys := getImageHashIndexShardKeys(0x0102030405060708)
+	if s3ObjectKeys[0] != "images/hashes//0/01.JSON" || s3ObjectKeys[7] != "images/hashes//7/08.JSON" {
+		t.Fatalf("GetImageHashIndexShardKeys: %v", s3ObjectKeys)
+	}
+}
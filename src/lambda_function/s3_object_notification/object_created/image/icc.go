@@ -0,0 +1,369 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"unicode/utf16"
+)
+
+// Contains functions for extracting and parsing ICC colour profiles embedded in JPEG files.
+
+// iccProfileSignature is the identifier that prefixes every ICC profile chunk stored in a JPEG APP2 segment.
+const iccProfileSignature = "ICC_PROFILE\x00"
+
+// ICCProfile contains the parts of an ICC profile needed to convert matrix/TRC based RGB profiles to sRGB.
+type ICCProfile struct {
+	ColorSpace  string
+	Description string
+	DeviceClass string
+	PCS         string
+	Version     string
+	BlueTRC     *ICCToneCurve
+	GreenTRC    *ICCToneCurve
+	RedTRC      *ICCToneCurve
+	Matrix      *[3][3]float64
+}
+
+// ICCToneCurve is a tone reproduction curve (TRC) taken from a curv or para ICC tag.
+type ICCToneCurve struct {
+	Function   int
+	Parameters []float64
+	Table      []float64
+}
+
+// isMatrixTRC reports whether the profile is an RGB profile that can be converted using its colorant matrix and TRCs.
+func (p *ICCProfile) isMatrixTRC() bool {
+	return p.ColorSpace == "RGB" && p.Matrix != nil && p.RedTRC != nil && p.GreenTRC != nil && p.BlueTRC != nil
+}
+
+// Linear converts an encoded value in the range [0, 1] to a linear light value using the tone curve.
+func (c *ICCToneCurve) Linear(x float64) float64 {
+	if c.Table != nil {
+		switch len(c.Table) {
+		case 0:
+			return x
+		case 1:
+			return math.Pow(x, c.Table[0])
+		}
+		// Interpolate linearly between the two nearest table entries.
+		position := x * float64(len(c.Table)-1)
+		index := int(position)
+		if index >= len(c.Table)-1 {
+			return c.Table[len(c.Table)-1]
+		}
+		fraction := position - float64(index)
+		return c.Table[index] + (c.Table[index+1]-c.Table[index])*fraction
+	}
+	p := c.Parameters
+	switch c.Function {
+	case 0:
+		return math.Pow(x, p[0])
+	case 1:
+		if x >= -p[2]/p[1] {
+			return math.Pow(p[1]*x+p[2], p[0])
+		}
+		return 0
+	case 2:
+		if x >= -p[2]/p[1] {
+			return math.Pow(p[1]*x+p[2], p[0]) + p[3]
+		}
+		return p[3]
+	case 3:
+		if x >= p[4] {
+			return math.Pow(p[1]*x+p[2], p[0])
+		}
+		return p[3] * x
+	case 4:
+		if x >= p[4] {
+			return math.Pow(p[1]*x+p[2], p[0]) + p[5]
+		}
+		return p[3]*x + p[6]
+	}
+	return x
+}
+
+// openICCProfile opens the JPEG file specified by the filename and returns its embedded ICC profile.
+// It returns nil without an error if the file does not embed a profile.
+func openICCProfile(fileName string) (*ICCProfile, error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	b, err := getJPEGICCProfile(file)
+	if err != nil || b == nil {
+		return nil, err
+	}
+	return parseICCProfile(b)
+}
+
+// getJPEGICCProfile reads the JPEG markers preceding the image data and reassembles the ICC profile
+// from its APP2 chunks. Profiles larger than a single segment are split across several numbered chunks.
+// It returns nil without an error if the JPEG does not embed a profile.
+func getJPEGICCProfile(file io.Reader) ([]byte, error) {
+	reader := bufio.NewReader(file)
+	var soi [2]byte
+	if _, err := io.ReadFull(reader, soi[:]); err != nil {
+		return nil, err
+	}
+	if soi[0] != 0xFF || soi[1] != 0xD8 {
+		return nil, errors.New("icc: missing JPEG SOI marker")
+	}
+	chunks := make(map[int][]byte)
+	chunkCount := 0
+	for {
+		marker, err := readJPEGMarker(reader)
+		if err != nil {
+			return nil, err
+		}
+		// Standalone markers carry no length or payload.
+		if marker == 0x01 || (marker >= 0xD0 && marker <= 0xD7) {
+			continue
+		}
+		// The ICC profile must precede the image data.
+		if marker == 0xDA || marker == 0xD9 {
+			break
+		}
+		var length [2]byte
+		if _, err := io.ReadFull(reader, length[:]); err != nil {
+			return nil, err
+		}
+		segmentLength := int(binary.BigEndian.Uint16(length[:])) - 2
+		if segmentLength < 0 {
+			return nil, fmt.Errorf("icc: invalid segment length for marker 0x%X", marker)
+		}
+		if marker != 0xE2 {
+			if _, err := reader.Discard(segmentLength); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		segment := make([]byte, segmentLength)
+		if _, err := io.ReadFull(reader, segment); err != nil {
+			return nil, err
+		}
+		if len(segment) < len(iccProfileSignature)+2 || string(segment[:len(iccProfileSignature)]) != iccProfileSignature {
+			continue
+		}
+		sequence := int(segment[len(iccProfileSignature)])
+		count := int(segment[len(iccProfileSignature)+1])
+		if sequence == 0 || sequence > count {
+			return nil, fmt.Errorf("icc: invalid chunk %d of %d", sequence, count)
+		}
+		if chunkCount != 0 && chunkCount != count {
+			return nil, fmt.Errorf("icc: inconsistent chunk count %d and %d", chunkCount, count)
+		}
+		chunkCount = count
+		chunks[sequence] = segment[len(iccProfileSignature)+2:]
+	}
+	if chunkCount == 0 {
+		return nil, nil
+	}
+	if len(chunks) != chunkCount {
+		return nil, fmt.Errorf("icc: found %d of %d chunks", len(chunks), chunkCount)
+	}
+	sequences := make([]int, 0, len(chunks))
+	for sequence := range chunks {
+		sequences = append(sequences, sequence)
+	}
+	sort.Ints(sequences)
+	var profile bytes.Buffer
+	for _, sequence := range sequences {
+		profile.Write(chunks[sequence])
+	}
+	return profile.Bytes(), nil
+}
+
+// readJPEGMarker reads the next JPEG marker, skipping any fill bytes that precede it.
+func readJPEGMarker(reader *bufio.Reader) (byte, error) {
+	b, err := reader.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	if b != 0xFF {
+		return 0, fmt.Errorf("icc: expected marker, found 0x%X", b)
+	}
+	for b == 0xFF {
+		b, err = reader.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+	}
+	return b, nil
+}
+
+// parseICCProfile parses the header and the tags needed for matrix/TRC colour conversion from an ICC profile.
+func parseICCProfile(b []byte) (*ICCProfile, error) {
+	if len(b) < 132 {
+		return nil, errors.New("icc: profile too short")
+	}
+	if string(b[36:40]) != "acsp" {
+		return nil, errors.New("icc: missing acsp signature")
+	}
+	profile := ICCProfile{
+		ColorSpace:  strings.TrimSpace(string(b[16:20])),
+		DeviceClass: strings.TrimSpace(string(b[12:16])),
+		PCS:         strings.TrimSpace(string(b[20:24])),
+		Version:     fmt.Sprintf("%d.%d.%d", b[8], b[9]>>4, b[9]&0x0F)}
+
+	tags := make(map[string][]byte)
+	tagCount := int(binary.BigEndian.Uint32(b[128:132]))
+	if tagCount > (len(b)-132)/12 {
+		return nil, fmt.Errorf("icc: tag count %d exceeds profile size", tagCount)
+	}
+	for i := 0; i < tagCount; i++ {
+		entry := b[132+i*12 : 144+i*12]
+		offset := int64(binary.BigEndian.Uint32(entry[4:8]))
+		size := int64(binary.BigEndian.Uint32(entry[8:12]))
+		if offset+size > int64(len(b)) {
+			return nil, fmt.Errorf("icc: tag %q exceeds profile size", entry[0:4])
+		}
+		tags[string(entry[0:4])] = b[offset : offset+size]
+	}
+
+	if tag, ok := tags["desc"]; ok {
+		profile.Description = parseICCText(tag)
+	}
+
+	var matrix [3][3]float64
+	for column, signature := range []string{"rXYZ", "gXYZ", "bXYZ"} {
+		tag, ok := tags[signature]
+		if !ok {
+			return &profile, nil
+		}
+		xyz, err := parseICCXYZ(tag)
+		if err != nil {
+			return nil, fmt.Errorf("icc: %s: %w", signature, err)
+		}
+		for row := range xyz {
+			matrix[row][column] = xyz[row]
+		}
+	}
+	profile.Matrix = &matrix
+
+	var err error
+	for _, trc := range []struct {
+		signature string
+		curve     **ICCToneCurve
+	}{{"rTRC", &profile.RedTRC}, {"gTRC", &profile.GreenTRC}, {"bTRC", &profile.BlueTRC}} {
+		tag, ok := tags[trc.signature]
+		if !ok {
+			continue
+		}
+		*trc.curve, err = parseICCToneCurve(tag)
+		if err != nil {
+			return nil, fmt.Errorf("icc: %s: %w", trc.signature, err)
+		}
+	}
+	return &profile, nil
+}
+
+// parseICCS15Fixed16 decodes a signed 15.16 fixed point number.
+func parseICCS15Fixed16(b []byte) float64 {
+	return float64(int32(binary.BigEndian.Uint32(b))) / 65536
+}
+
+// parseICCText decodes a textDescriptionType (ICC v2) or multiLocalizedUnicodeType (ICC v4) tag.
+func parseICCText(tag []byte) string {
+	if len(tag) < 12 {
+		return ""
+	}
+	switch string(tag[0:4]) {
+	case "desc":
+		length := int(binary.BigEndian.Uint32(tag[8:12]))
+		if length > len(tag)-12 {
+			length = len(tag) - 12
+		}
+		return strings.TrimRight(string(tag[12:12+length]), "\x00")
+	case "mluc":
+		if len(tag) < 28 || binary.BigEndian.Uint32(tag[8:12]) == 0 {
+			return ""
+		}
+		// Use the first record, which is the profile's default language.
+		length := int(binary.BigEndian.Uint32(tag[20:24]))
+		offset := int(binary.BigEndian.Uint32(tag[24:28]))
+		if offset+length > len(tag) {
+			return ""
+		}
+		text := make([]uint16, length/2)
+		for i := range text {
+			text[i] = binary.BigEndian.Uint16(tag[offset+i*2:])
+		}
+		return strings.TrimRight(string(utf16.Decode(text)), "\x00")
+	}
+	return ""
+}
+
+// parseICCXYZ decodes the first value of an XYZType tag.
+func parseICCXYZ(tag []byte) ([3]float64, error) {
+	if len(tag) < 20 || string(tag[0:4]) != "XYZ " {
+		return [3]float64{}, errors.New("invalid XYZ tag")
+	}
+	return [3]float64{
+		parseICCS15Fixed16(tag[8:12]),
+		parseICCS15Fixed16(tag[12:16]),
+		parseICCS15Fixed16(tag[16:20])}, nil
+}
+
+// parseICCToneCurve decodes a curveType or parametricCurveType tag, rejecting curves that do not map every 8-bit
+// value to a finite linear value.
+func parseICCToneCurve(tag []byte) (*ICCToneCurve, error) {
+	curve, err := parseICCToneCurveTag(tag)
+	if err != nil {
+		return nil, err
+	}
+	for value := 0; value <= 0xFF; value++ {
+		if linear := curve.Linear(float64(value) / 0xFF); math.IsNaN(linear) || math.IsInf(linear, 0) {
+			return nil, fmt.Errorf("curve is not finite at %d", value)
+		}
+	}
+	return curve, nil
+}
+
+// parseICCToneCurveTag decodes the table or parameters of a curveType or parametricCurveType tag.
+func parseICCToneCurveTag(tag []byte) (*ICCToneCurve, error) {
+	if len(tag) < 12 {
+		return nil, errors.New("invalid curve tag")
+	}
+	switch string(tag[0:4]) {
+	case "curv":
+		count := int(binary.BigEndian.Uint32(tag[8:12]))
+		if len(tag) < 12+count*2 {
+			return nil, errors.New("curve tag too short")
+		}
+		table := make([]float64, count)
+		for i := range table {
+			value := binary.BigEndian.Uint16(tag[12+i*2:])
+			if count == 1 {
+				// A single entry is a gamma value encoded as u8Fixed8.
+				table[i] = float64(value) / 256
+			} else {
+				table[i] = float64(value) / 65535
+			}
+		}
+		return &ICCToneCurve{Table: table}, nil
+	case "para":
+		function := int(binary.BigEndian.Uint16(tag[8:10]))
+		parameterCounts := []int{1, 3, 4, 5, 7}
+		if function >= len(parameterCounts) {
+			return nil, fmt.Errorf("unsupported parametric function %d", function)
+		}
+		if len(tag) < 12+parameterCounts[function]*4 {
+			return nil, errors.New("parametric curve tag too short")
+		}
+		parameters := make([]float64, parameterCounts[function])
+		for i := range parameters {
+			parameters[i] = parseICCS15Fixed16(tag[12+i*4:])
+		}
+		return &ICCToneCurve{Function: function, Parameters: parameters}, nil
+	}
+	return nil, fmt.Errorf("unsupported curve type %q", tag[0:4])
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"math"
+	"testing"
+)
+
+// newTestICCProfile builds a minimal ICC v2 matrix/TRC profile with the given description, colorants and gamma.
+func newTestICCProfile(description string, matrix [3][3]float64, gamma float64) []byte {
+	type tag struct {
+		signature string
+		data      []byte
+	}
+	xyz := func(column int) []byte {
+		b := make([]byte, 20)
+		copy(b, "XYZ ")
+		for row := 0; row < 3; row++ {
+			binary.BigEndian.PutUint32(b[8+row*4:], uint32(int32(math.Round(matrix[row][column]*65536))))
+		}
+		return b
+	}
+	curve := make([]byte, 14)
+	copy(curve, "curv")
+	binary.BigEndian.PutUint32(curve[8:], 1)
+	binary.BigEndian.PutUint16(curve[12:], uint16(gamma*256))
+	desc := make([]byte, 12+len(description)+1)
+	copy(desc, "desc")
+	binary.BigEndian.PutUint32(desc[8:], uint32(len(description)+1))
+	copy(desc[12:], description)
+	tags := []tag{{"desc", desc}, {"rXYZ", xyz(0)}, {"gXYZ", xyz(1)}, {"bXYZ", xyz(2)}, {"rTRC", curve}, {"gTRC", curve}, {"bTRC", curve}}
+
+	header := make([]byte, 132+len(tags)*12)
+	header[8] = 2
+	header[9] = 0x10
+	copy(header[12:], "mntr")
+	copy(header[16:], "RGB ")
+	copy(header[20:], "XYZ ")
+	copy(header[36:], "acsp")
+	binary.BigEndian.PutUint32(header[128:], uint32(len(tags)))
+	var data bytes.Buffer
+	for i, t := range tags {
+		entry := header[132+i*12:]
+		copy(entry, t.signature)
+		binary.BigEndian.PutUint32(entry[4:], uint32(len(header)+data.Len()))
+		binary.BigEndian.PutUint32(entry[8:], uint32(len(t.data)))
+		data.Write(t.data)
+		// Tags are aligned to four bytes.
+		for data.Len()%4 != 0 {
+			data.WriteByte(0)
+		}
+	}
+	profile := append(header, data.Bytes()...)
+	binary.BigEndian.PutUint32(profile[0:], uint32(len(profile)))
+	return profile
+}
+
+// newTestJPEGHeader builds the markers of a JPEG file storing the ICC profile across the given number of APP2 chunks.
+// The chunks are written in reverse order to check that they are reassembled by sequence number.
+func newTestJPEGHeader(profile []byte, count int) []byte {
+	var b bytes.Buffer
+	b.Write([]byte{0xFF, 0xD8})
+	// An APP0 segment that must be skipped.
+	b.Write([]byte{0xFF, 0xE0, 0x00, 0x04, 0x00, 0x00})
+	size := (len(profile) + count - 1) / count
+	for sequence := count; sequence >= 1; sequence-- {
+		chunk := profile[(sequence-1)*size : min(sequence*size, len(profile))]
+		segment := append([]byte(iccProfileSignature), byte(sequence), byte(count))
+		segment = append(segment, chunk...)
+		b.Write([]byte{0xFF, 0xE2})
+		binary.Write(&b, binary.BigEndian, uint16(len(segment)+2))
+		b.Write(segment)
+	}
+	b.Write([]byte{0xFF, 0xDA})
+	return b.Bytes()
+}
+
+func TestGetJPEGICCProfile(t *testing.T) {
+	profile := newTestICCProfile(colorSpaceAdobeRGB, *adobeRGBProfile.Matrix, 2.2)
+	b, err := getJPEGICCProfile(bytes.NewReader(newTestJPEGHeader(profile, 3)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b, profile) {
+		t.Fatalf("GetJPEGICCProfile: reassembled %d bytes, expected %d", len(b), len(profile))
+	}
+}
+
+func TestGetJPEGICCProfileMissingChunk(t *testing.T) {
+	header := newTestJPEGHeader(newTestICCProfile(colorSpaceAdobeRGB, *adobeRGBProfile.Matrix, 2.2), 2)
+	// Drop the first chunk written, leaving chunk 1 of 2.
+	length := int(binary.BigEndian.Uint16(header[10:12]))
+	header = append(header[:8:8], header[10+length:]...)
+	if _, err := getJPEGICCProfile(bytes.NewReader(header)); err == nil {
+		t.Fatal("GetJPEGICCProfile: expected an error for a missing chunk")
+	}
+}
+
+func TestParseICCProfile(t *testing.T) {
+	iccProfile, err := parseICCProfile(newTestICCProfile("Display P3", srgbMatrix, 2.2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if iccProfile.Description != "Display P3" || iccProfile.ColorSpace != "RGB" || iccProfile.Version != "2.1.0" {
+		t.Fatalf("ParseICCProfile: Description=%s ColorSpace=%s Version=%s", iccProfile.Description, iccProfile.ColorSpace, iccProfile.Version)
+	}
+	if !iccProfile.isMatrixTRC() {
+		t.Fatal("ParseICCProfile: expected a matrix/TRC profile")
+	}
+	if math.Abs(iccProfile.RedTRC.Linear(0.5)-math.Pow(0.5, 2.2)) > 0.01 {
+		t.Fatalf("ParseICCProfile: RedTRC.Linear(0.5)=%f", iccProfile.RedTRC.Linear(0.5))
+	}
+}
+
+func TestParseICCToneCurveNotFinite(t *testing.T) {
+	// A parametric curve whose offset is negative raises a negative base to a fractional power for dark values.
+	tag := make([]byte, 32)
+	copy(tag, "para")
+	binary.BigEndian.PutUint16(tag[8:], 3)
+	binary.BigEndian.PutUint32(tag[12:], 0x00023333) // 2.2
+	binary.BigEndian.PutUint32(tag[16:], 0x00010000) // 1
+	binary.BigEndian.PutUint32(tag[20:], 0xFFFF8000) // -0.5
+	if _, err := parseICCToneCurve(tag); err == nil {
+		t.Fatal("ParseICCToneCurve: accepted a curve that is not finite")
+	}
+
+	// A transform that produces NaN converts to black rather than indexing outside the table.
+	transform := colorTransform{}
+	transform.input[0][0xFF] = math.NaN()
+	transform.matrix[0][0] = 1
+	if r, _, _ := transform.convert(0xFF, 0, 0); r != transform.output[0] {
+		t.Fatalf("Convert: NaN converted to %d", r)
+	}
+}
+
+func TestConvertImageToSRGB(t *testing.T) {
+	imageSource := image.NewRGBA(image.Rect(0, 0, 2, 1))
+	imageSource.Set(0, 0, color.RGBA{128, 128, 128, 255})
+	imageSource.Set(1, 0, color.RGBA{0, 255, 0, 255})
+	colorProfile := getColorProfile(nil, &ExifMetadata{
+		ColorSpace:            func(i int) *int { return &i }(exifColorSpaceUncalibrated),
+		InteroperabilityIndex: func(s string) *string { return &s }(exifInteroperabilityIndexAdobeRGB)})
+	if colorProfile.ColorSpace != colorSpaceAdobeRGB {
+		t.Fatalf("GetColorProfile: ColorSpace=%s", colorProfile.ColorSpace)
+	}
+	imageDestination := convertImageToSRGB(imageSource, colorProfile).(*image.RGBA)
+	if !colorProfile.ConvertedToSRGB {
+		t.Fatal("ConvertImageToSRGB: expected the image to be converted")
+	}
+	grey := imageDestination.RGBAAt(0, 0)
+	if grey.R != grey.G || grey.G != grey.B {
+		t.Fatalf("ConvertImageToSRGB: grey converted to %v", grey)
+	}
+	// Adobe RGB green lies outside the sRGB gamut and is clipped.
+	green := imageDestination.RGBAAt(1, 0)
+	if green.R != 0 || green.G != 255 {
+		t.Fatalf("ConvertImageToSRGB: green converted to %v", green)
+	}
+}
+
+func TestConvertImageToSRGBUnchanged(t *testing.T) {
+	iccProfile, err := parseICCProfile(newTestICCProfile("sRGB IEC61966-2.1", srgbMatrix, 2.2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	imageSource := image.NewRGBA(image.Rect(0, 0, 1, 1))
+	colorProfile := getColorProfile(iccProfile, nil)
+	convertImageToSRGB(imageSource, colorProfile)
+	if colorProfile.ColorSpace != colorSpaceSRGB || colorProfile.ConvertedToSRGB {
+		t.Fatalf("ConvertImageToSRGB: ColorSpace=%s ConvertedToSRGB=%v", colorProfile.ColorSpace, colorProfile.ConvertedToSRGB)
+	}
+}
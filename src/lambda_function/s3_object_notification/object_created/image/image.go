@@ -1,40 +1,190 @@
 package main
 
 import (
+	"errors"
+	"fmt"
 	"image"
+	"image/jpeg"
 	"io"
+	"math"
 	"os"
+	"strconv"
 
 	"golang.org/x/image/draw"
 )
 
-// openImage opens an image file specified by the filename and returns the decoded image and any error encountered.
-// It first opens the file, then uses getImage to decode and return the image. The file is closed automatically when done.
-func openImage(fileName string) (image.Image, error) {
+// Decoders recorded in the ImageDecoding.
+const (
+	imageDecoderJPEGScaled = "JPEGScaled"
+	imageDecoderStandard   = "Standard"
+)
+
+// ImageDecoding describes how an image was decoded. Width and Height are the dimensions of the uploaded image and
+// DecodedWidth and DecodedHeight those of the decoded image, which is reduced when the upload exceeds the maximum
+// number of pixels. PeakMemory is the peak resident memory in bytes while the image was processed.
+type ImageDecoding struct {
+	DecodedHeight int     `json:"DecodedHeight"`
+	DecodedWidth  int     `json:"DecodedWidth"`
+	Decoder       string  `json:"Decoder"`
+	Format        string  `json:"Format"`
+	Height        int     `json:"Height"`
+	PeakMemory    uint64  `json:"PeakMemory"`
+	Scale         float64 `json:"Scale"`
+	Width         int     `json:"Width"`
+}
+
+// openImage opens an image file specified by the filename and returns the decoded image, a description of how it
+// was decoded and any error encountered. The dimensions are read before decoding so that large JPEG images can be
+// reduced while they are decoded, keeping peak memory proportional to the decoded size. Other formats, and JPEG
+// encodings that cannot be decoded at a reduced size, are decoded in full by getImage if they are within the
+// maximum number of pixels that may be decoded.
+func openImage(fileName string) (image.Image, *ImageDecoding, error) {
 	file, err := os.Open(fileName)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	defer file.Close()
-	return getImage(file)
+	imageConfig, format, err := image.DecodeConfig(file)
+	if err != nil {
+		return nil, nil, err
+	}
+	imageDecoding := ImageDecoding{
+		Format: format,
+		Height: imageConfig.Height,
+		Width:  imageConfig.Width}
+
+	if format == "jpeg" {
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			return nil, nil, err
+		}
+
+		// An image within the maximum number of pixels is decoded at full size by the standard decoder.
+		scale := getJPEGScale(imageConfig.Width, imageConfig.Height, imageMaxPixels)
+		if scale == 8 {
+			imageSource, err := jpeg.Decode(file)
+			if err != nil {
+				return nil, nil, err
+			}
+			return getImageDecoding(&imageDecoding, imageDecoderStandard, fitImage(imageSource, imageMaxPixels))
+		}
+		imageDestination, err := decodeJPEGScaled(file, scale)
+		if err == nil {
+			// An image that is still too large at 1/8 of its size is reduced to the maximum after decoding.
+			return getImageDecoding(&imageDecoding, imageDecoderJPEGScaled, fitImage(imageDestination, imageMaxPixels))
+		}
+		if !errors.Is(err, errUnsupportedJPEG) {
+			return nil, nil, err
+		}
+	}
+
+	if imageConfig.Width*imageConfig.Height > imageMaxDecodePixels {
+		return nil, nil, newImageValidationError(validationReasonTooManyPixels, "%s image of %dx%d exceeds the maximum of %d pixels for full decoding", format, imageConfig.Width, imageConfig.Height, imageMaxDecodePixels)
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return nil, nil, err
+	}
+	imageDestination, err := getImage(file)
+	if err != nil {
+		return nil, nil, err
+	}
+	return getImageDecoding(&imageDecoding, imageDecoderStandard, imageDestination)
+}
+
+// getImageDecoding records the decoder and the dimensions of the decoded image in the ImageDecoding, and returns them
+// with the image.
+func getImageDecoding(imageDecoding *ImageDecoding, decoder string, imageDestination image.Image) (image.Image, *ImageDecoding, error) {
+	imageDecoding.Decoder = decoder
+	imageDecoding.Scale = float64(imageDestination.Bounds().Dx()) / float64(imageDecoding.Width)
+	imageDecoding.DecodedHeight = imageDestination.Bounds().Dy()
+	imageDecoding.DecodedWidth = imageDestination.Bounds().Dx()
+	return imageDestination, imageDecoding, nil
 }
 
 // getImage decodes an image from the provided io.Reader and returns the decoded image and any error encountered.
-// It uses the image.Decode function to decode the image. If successful, it returns the decoded image.
+// It uses the image.Decode function to decode the image. If successful, it returns the image converted to RGBA and
+// reduced to the maximum number of pixels.
 func getImage(file io.Reader) (image.Image, error) {
 	imageSource, _, err := image.Decode(file)
 	if err != nil {
 		return nil, err
 	}
-	return scaleImage(imageSource, draw.ApproxBiLinear), nil
+	return fitImage(imageSource, imageMaxPixels), nil
+}
+
+// getImageMaxPixels parses and validates a maximum number of pixels.
+func getImageMaxPixels(maxPixels string) (int, error) {
+	value, err := strconv.Atoi(maxPixels)
+	if err != nil {
+		return 0, err
+	}
+	if value <= 0 {
+		return 0, fmt.Errorf("maximum pixels %d must be positive", value)
+	}
+	return value, nil
+}
+
+// getJPEGScale returns the largest scale, in eighths, at which a JPEG image of the given dimensions is decoded to
+// no more than the maximum number of pixels, or 1 if even 1/8 of the image exceeds it.
+func getJPEGScale(width int, height int, maxPixels int) int {
+	for scale := 8; scale > 1; scale-- {
+		if ((width*scale+7)/8)*((height*scale+7)/8) <= maxPixels {
+			return scale
+		}
+	}
+	return 1
+}
+
+// fitImage converts the image to RGBA, reducing it if it has more than the maximum number of pixels.
+func fitImage(imageSource image.Image, maxPixels int) *image.RGBA {
+	bounds := imageSource.Bounds()
+	if bounds.Dx()*bounds.Dy() > maxPixels {
+		scale := math.Sqrt(float64(maxPixels) / float64(bounds.Dx()*bounds.Dy()))
+		return thumbnailImage(imageSource, max(1, int(float64(bounds.Dx())*scale)), max(1, int(float64(bounds.Dy())*scale)))
+	}
+	if imageRGBA, ok := imageSource.(*image.RGBA); ok && bounds.Min == (image.Point{}) {
+		return imageRGBA
+	}
+	imageDestination := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(imageDestination, imageDestination.Bounds(), imageSource, bounds.Min, draw.Src)
+	return imageDestination
 }
 
-// scaleImage scales the provided image using the given scaler and returns the scaled image.
-// It scales the image to half of its original dimensions by default.
-func scaleImage(imageSource image.Image, scale draw.Scaler) image.Image {
-	imageSourceRectangle := imageSource.Bounds()
-	imageDestinationRectangle := image.Rect(0, 0, imageSourceRectangle.Max.X, imageSourceRectangle.Max.Y)
-	imageDestination := image.NewRGBA(imageDestinationRectangle)
-	scale.Scale(imageDestination, imageDestinationRectangle, imageSource, imageSourceRectangle, draw.Over, nil)
+// thumbnailImage reduces the image to the given dimensions by averaging every source pixel into its destination
+// pixel. Unlike the draw package's scalers, which sample a few source pixels, this does not alias when images are
+// reduced by large factors, which makes it suitable for image analysis.
+func thumbnailImage(imageSource image.Image, width int, height int) *image.RGBA {
+	bounds := imageSource.Bounds()
+	sums := make([][4]uint64, width*height)
+	counts := make([]uint64, width*height)
+	imageRGBA, isRGBA := imageSource.(*image.RGBA)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		row := (y - bounds.Min.Y) * height / bounds.Dy() * width
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			var r, g, b, a uint32
+			if isRGBA {
+				pixel := imageRGBA.Pix[imageRGBA.PixOffset(x, y):]
+				r, g, b, a = uint32(pixel[0]), uint32(pixel[1]), uint32(pixel[2]), uint32(pixel[3])
+			} else {
+				r, g, b, a = imageSource.At(x, y).RGBA()
+				r, g, b, a = r>>8, g>>8, b>>8, a>>8
+			}
+			index := row + (x-bounds.Min.X)*width/bounds.Dx()
+			sums[index][0] += uint64(r)
+			sums[index][1] += uint64(g)
+			sums[index][2] += uint64(b)
+			sums[index][3] += uint64(a)
+			counts[index]++
+		}
+	}
+	imageDestination := image.NewRGBA(image.Rect(0, 0, width, height))
+	for index, sum := range sums {
+		if counts[index] == 0 {
+			continue
+		}
+		pixel := imageDestination.Pix[index*4 : index*4+4]
+		for channel := range pixel {
+			pixel[channel] = uint8(sum[channel] / counts[index])
+		}
+	}
 	return imageDestination
 }
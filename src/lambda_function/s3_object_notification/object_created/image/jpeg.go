@@ -0,0 +1,498 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"math"
+)
+
+// Contains a baseline JPEG decoder that decodes directly to a reduced size.
+//
+// The standard library decodes the full image before it can be resized, which for very large photographs needs more
+// memory than the Lambda has. This decoder reduces every 8x8 block of DCT coefficients to NxN pixels with an N-point
+// inverse DCT of the lowest frequency coefficients, as libjpeg does for scaled decoding, and writes each MCU straight
+// into the output image. Peak memory is therefore proportional to the output size rather than the source size.
+// Progressive, arithmetic coded, 12-bit and CMYK images return errUnsupportedJPEG so that the caller can fall back to
+// the standard decoder.
+
+// JPEG markers handled by the decoder.
+const (
+	jpegMarkerAPP14 = 0xEE
+	jpegMarkerDHT   = 0xC4
+	jpegMarkerDQT   = 0xDB
+	jpegMarkerDRI   = 0xDD
+	jpegMarkerEOI   = 0xD9
+	jpegMarkerRST0  = 0xD0
+	jpegMarkerRST7  = 0xD7
+	jpegMarkerSOF0  = 0xC0
+	jpegMarkerSOF1  = 0xC1
+	jpegMarkerSOF15 = 0xCF
+	jpegMarkerSOI   = 0xD8
+	jpegMarkerSOS   = 0xDA
+)
+
+// errUnsupportedJPEG is returned for valid JPEG images that the scaled decoder cannot decode.
+var errUnsupportedJPEG = errors.New("jpeg: unsupported encoding for scaled decoding")
+
+// jpegZigzag maps the zigzag order that coefficients are stored in to their natural order.
+var jpegZigzag = [64]int{
+	0, 1, 8, 16, 9, 2, 3, 10, 17, 24, 32, 25, 18, 11, 4, 5,
+	12, 19, 26, 33, 40, 48, 41, 34, 27, 20, 13, 6, 7, 14, 21, 28,
+	35, 42, 49, 56, 57, 50, 43, 36, 29, 22, 15, 23, 30, 37, 44, 51,
+	58, 59, 52, 45, 38, 31, 39, 46, 53, 60, 61, 54, 47, 55, 62, 63}
+
+// jpegComponent describes a colour component of the frame.
+type jpegComponent struct {
+	acTable      int
+	dcTable      int
+	h            int
+	id           byte
+	predictor    int32
+	quantization int
+	v            int
+}
+
+// jpegHuffman is a Huffman table decoded with the canonical code ranges described in the JPEG specification.
+type jpegHuffman struct {
+	maxCode [17]int32
+	minCode [17]int32
+	valPtr  [17]int32
+	values  []byte
+}
+
+// jpegDecoder holds the state of a scaled decode.
+type jpegDecoder struct {
+	adobeTransform  int
+	bitCount        int
+	bits            byte
+	components      []jpegComponent
+	height          int
+	huffman         [2][4]*jpegHuffman
+	marker          byte
+	quantization    [4][64]int32
+	reader          *bufio.Reader
+	restartInterval int
+	width           int
+}
+
+// decodeJPEGScaled decodes a baseline JPEG image at scale/8 of its size, where scale is between 1 and 8.
+func decodeJPEGScaled(reader io.Reader, scale int) (*image.RGBA, error) {
+	if scale < 1 || scale > 8 {
+		return nil, fmt.Errorf("jpeg: invalid scale %d/8", scale)
+	}
+	d := jpegDecoder{reader: bufio.NewReader(reader), adobeTransform: -1}
+	marker, err := d.readMarker()
+	if err != nil {
+		return nil, err
+	}
+	if marker != jpegMarkerSOI {
+		return nil, errors.New("jpeg: missing SOI marker")
+	}
+	for {
+		marker, err := d.readMarker()
+		if err != nil {
+			return nil, err
+		}
+		if marker == jpegMarkerEOI {
+			return nil, errors.New("jpeg: missing image data")
+		}
+		if marker >= jpegMarkerRST0 && marker <= jpegMarkerRST7 {
+			continue
+		}
+		segment, err := d.readSegment()
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case marker == jpegMarkerSOF0 || marker == jpegMarkerSOF1:
+			err = d.processFrame(segment)
+		case marker == jpegMarkerDHT:
+			err = d.processHuffmanTables(segment)
+		case marker == jpegMarkerDQT:
+			err = d.processQuantizationTables(segment)
+		case marker == jpegMarkerDRI:
+			if len(segment) != 2 {
+				return nil, errors.New("jpeg: invalid DRI segment")
+			}
+			d.restartInterval = int(segment[0])<<8 | int(segment[1])
+		case marker == jpegMarkerAPP14:
+			if len(segment) >= 12 && string(segment[:5]) == "Adobe" {
+				d.adobeTransform = int(segment[11])
+			}
+		case marker == jpegMarkerSOS:
+			return d.processScan(segment, scale)
+		case marker > jpegMarkerSOF1 && marker <= jpegMarkerSOF15:
+			// The remaining SOF markers are progressive, lossless or arithmetic coded.
+			return nil, errUnsupportedJPEG
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// readMarker reads the next marker, skipping any fill bytes.
+func (d *jpegDecoder) readMarker() (byte, error) {
+	b, err := d.reader.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	if b != 0xFF {
+		return 0, fmt.Errorf("jpeg: expected marker, found %#02x", b)
+	}
+	for b == 0xFF {
+		if b, err = d.reader.ReadByte(); err != nil {
+			return 0, err
+		}
+	}
+	return b, nil
+}
+
+// readSegment reads the body of a marker segment.
+func (d *jpegDecoder) readSegment() ([]byte, error) {
+	var length [2]byte
+	if _, err := io.ReadFull(d.reader, length[:]); err != nil {
+		return nil, err
+	}
+	size := int(length[0])<<8 | int(length[1])
+	if size < 2 {
+		return nil, errors.New("jpeg: invalid segment length")
+	}
+	segment := make([]byte, size-2)
+	_, err := io.ReadFull(d.reader, segment)
+	return segment, err
+}
+
+// processFrame reads the image dimensions and components from a baseline SOF segment.
+func (d *jpegDecoder) processFrame(segment []byte) error {
+	if len(segment) < 6 {
+		return errors.New("jpeg: invalid SOF segment")
+	}
+	if segment[0] != 8 {
+		return errUnsupportedJPEG
+	}
+	d.height = int(segment[1])<<8 | int(segment[2])
+	d.width = int(segment[3])<<8 | int(segment[4])
+	count := int(segment[5])
+	if d.height == 0 || count != 1 && count != 3 {
+		// Heights defined by a DNL marker and CMYK images are left to the standard decoder.
+		return errUnsupportedJPEG
+	}
+	if d.width == 0 || len(segment) != 6+3*count {
+		return errors.New("jpeg: invalid SOF segment")
+	}
+	d.components = make([]jpegComponent, count)
+	for i := range d.components {
+		component := &d.components[i]
+		component.id = segment[6+3*i]
+		component.h, component.v = int(segment[7+3*i]>>4), int(segment[7+3*i]&15)
+		component.quantization = int(segment[8+3*i])
+		if component.h < 1 || component.h > 4 || component.v < 1 || component.v > 4 || component.quantization > 3 {
+			return errors.New("jpeg: invalid component")
+		}
+		if count == 1 {
+			// A single component is never interleaved, so every MCU is one block.
+			component.h, component.v = 1, 1
+		}
+	}
+	return nil
+}
+
+// processHuffmanTables reads the Huffman tables from a DHT segment.
+func (d *jpegDecoder) processHuffmanTables(segment []byte) error {
+	for len(segment) > 0 {
+		if len(segment) < 17 {
+			return errors.New("jpeg: invalid DHT segment")
+		}
+		class, id := int(segment[0]>>4), int(segment[0]&15)
+		if class > 1 || id > 3 {
+			return errors.New("jpeg: invalid Huffman table")
+		}
+		table := jpegHuffman{}
+		code, count := int32(0), int32(0)
+		for length := 1; length <= 16; length++ {
+			codes := int32(segment[length])
+			table.valPtr[length] = count
+			table.minCode[length] = code
+			table.maxCode[length] = code + codes - 1
+			code = (code + codes) << 1
+			count += codes
+		}
+		if len(segment) < 17+int(count) {
+			return errors.New("jpeg: invalid DHT segment")
+		}
+		table.values = segment[17 : 17+count]
+		d.huffman[class][id] = &table
+		segment = segment[17+count:]
+	}
+	return nil
+}
+
+// processQuantizationTables reads the quantization tables from a DQT segment, keeping them in zigzag order.
+func (d *jpegDecoder) processQuantizationTables(segment []byte) error {
+	for len(segment) > 0 {
+		precision, id := int(segment[0]>>4), int(segment[0]&15)
+		if precision > 1 || id > 3 || len(segment) < 1+64*(precision+1) {
+			return errors.New("jpeg: invalid DQT segment")
+		}
+		for i := range d.quantization[id] {
+			if precision == 0 {
+				d.quantization[id][i] = int32(segment[1+i])
+			} else {
+				d.quantization[id][i] = int32(segment[1+2*i])<<8 | int32(segment[2+2*i])
+			}
+		}
+		segment = segment[1+64*(precision+1):]
+	}
+	return nil
+}
+
+// readDataByte reads a byte of entropy coded data, removing stuffed zero bytes. Once a marker is reached it is
+// recorded and the data is padded with zeros.
+func (d *jpegDecoder) readDataByte() (byte, error) {
+	if d.marker != 0 {
+		return 0, nil
+	}
+	b, err := d.reader.ReadByte()
+	if err != nil || b != 0xFF {
+		return b, err
+	}
+	next, err := d.reader.ReadByte()
+	for err == nil && next == 0xFF {
+		next, err = d.reader.ReadByte()
+	}
+	if err != nil || next == 0 {
+		return 0xFF, err
+	}
+	d.marker = next
+	return 0, nil
+}
+
+// readBit reads a single bit of entropy coded data.
+func (d *jpegDecoder) readBit() (int32, error) {
+	if d.bitCount == 0 {
+		b, err := d.readDataByte()
+		if err != nil {
+			return 0, err
+		}
+		d.bits, d.bitCount = b, 8
+	}
+	d.bitCount--
+	return int32(d.bits>>d.bitCount) & 1, nil
+}
+
+// readBits reads the number of bits and sign extends them as described by the JPEG specification.
+func (d *jpegDecoder) readBits(count byte) (int32, error) {
+	var value int32
+	for i := byte(0); i < count; i++ {
+		bit, err := d.readBit()
+		if err != nil {
+			return 0, err
+		}
+		value = value<<1 | bit
+	}
+	if count > 0 && value < 1<<(count-1) {
+		value += -1<<count + 1
+	}
+	return value, nil
+}
+
+// decodeHuffman decodes a single Huffman coded value.
+func (d *jpegDecoder) decodeHuffman(table *jpegHuffman) (byte, error) {
+	var code int32
+	for length := 1; length <= 16; length++ {
+		bit, err := d.readBit()
+		if err != nil {
+			return 0, err
+		}
+		code = code<<1 | bit
+		if code <= table.maxCode[length] {
+			return table.values[table.valPtr[length]+code-table.minCode[length]], nil
+		}
+	}
+	return 0, errors.New("jpeg: invalid Huffman code")
+}
+
+// decodeBlock decodes and dequantizes the coefficients of a block into natural order.
+func (d *jpegDecoder) decodeBlock(component *jpegComponent, coefficients *[64]int32) error {
+	*coefficients = [64]int32{}
+	quantization := &d.quantization[component.quantization]
+	size, err := d.decodeHuffman(d.huffman[0][component.dcTable])
+	if err != nil {
+		return err
+	}
+	difference, err := d.readBits(size)
+	if err != nil {
+		return err
+	}
+	component.predictor += difference
+	coefficients[0] = component.predictor * quantization[0]
+	for k := 1; k < 64; k++ {
+		value, err := d.decodeHuffman(d.huffman[1][component.acTable])
+		if err != nil {
+			return err
+		}
+		run, size := int(value>>4), value&15
+		if size == 0 {
+			if run != 15 {
+				// End of block.
+				return nil
+			}
+			k += 15
+			continue
+		}
+		k += run
+		if k > 63 {
+			return errors.New("jpeg: invalid AC coefficient")
+		}
+		coefficient, err := d.readBits(size)
+		if err != nil {
+			return err
+		}
+		coefficients[jpegZigzag[k]] = coefficient * quantization[k]
+	}
+	return nil
+}
+
+// processRestart consumes a restart marker and resets the decoder state.
+func (d *jpegDecoder) processRestart() error {
+	d.bitCount = 0
+	for d.marker == 0 {
+		if _, err := d.readDataByte(); err != nil {
+			return err
+		}
+	}
+	if d.marker < jpegMarkerRST0 || d.marker > jpegMarkerRST7 {
+		return fmt.Errorf("jpeg: expected restart marker, found %#02x", d.marker)
+	}
+	d.marker = 0
+	for i := range d.components {
+		d.components[i].predictor = 0
+	}
+	return nil
+}
+
+// getJPEGCosines returns the basis of the N-point inverse DCT, including the normalisation of each coefficient.
+func getJPEGCosines(scale int) [][8]float64 {
+	cosines := make([][8]float64, scale)
+	for x := range cosines {
+		for u := 0; u < scale; u++ {
+			normalisation := 0.5
+			if u == 0 {
+				normalisation = 0.5 / math.Sqrt2
+			}
+			cosines[x][u] = normalisation * math.Cos(float64(2*x+1)*float64(u)*math.Pi/float64(2*scale))
+		}
+	}
+	return cosines
+}
+
+// inverseDCT reduces the block's coefficients to scale x scale samples, written to the destination with the stride.
+func inverseDCT(coefficients *[64]int32, cosines [][8]float64, destination []uint8, stride int) {
+	scale := len(cosines)
+	var rows [8][8]float64
+	for v := 0; v < scale; v++ {
+		for x := 0; x < scale; x++ {
+			sum := 0.0
+			for u := 0; u < scale; u++ {
+				sum += cosines[x][u] * float64(coefficients[v*8+u])
+			}
+			rows[v][x] = sum
+		}
+	}
+	for y := 0; y < scale; y++ {
+		for x := 0; x < scale; x++ {
+			sum := 128.0
+			for v := 0; v < scale; v++ {
+				sum += cosines[y][v] * rows[v][x]
+			}
+			destination[y*stride+x] = uint8(math.Max(0, math.Min(255, math.Round(sum))))
+		}
+	}
+}
+
+// processScan decodes the interleaved scan of a baseline image into an image of scale/8 of its size.
+func (d *jpegDecoder) processScan(segment []byte, scale int) (*image.RGBA, error) {
+	if d.components == nil {
+		return nil, errors.New("jpeg: missing SOF segment")
+	}
+	if len(segment) < 1 || len(segment) != 4+2*int(segment[0]) {
+		return nil, errors.New("jpeg: invalid SOS segment")
+	}
+	if int(segment[0]) != len(d.components) {
+		// Images whose components are stored in separate scans are left to the standard decoder.
+		return nil, errUnsupportedJPEG
+	}
+	for i := range d.components {
+		id, tables := segment[1+2*i], segment[2+2*i]
+		if id != d.components[i].id {
+			return nil, errUnsupportedJPEG
+		}
+		d.components[i].dcTable, d.components[i].acTable = int(tables>>4), int(tables&15)
+		if d.components[i].dcTable > 3 || d.components[i].acTable > 3 || d.huffman[0][d.components[i].dcTable] == nil || d.huffman[1][d.components[i].acTable] == nil {
+			return nil, errors.New("jpeg: missing Huffman table")
+		}
+	}
+
+	maxH, maxV := 1, 1
+	for _, component := range d.components {
+		maxH, maxV = max(maxH, component.h), max(maxV, component.v)
+	}
+	mcuWidth, mcuHeight := 8*maxH, 8*maxV
+	mcusX, mcusY := (d.width+mcuWidth-1)/mcuWidth, (d.height+mcuHeight-1)/mcuHeight
+	imageDestination := image.NewRGBA(image.Rect(0, 0, (d.width*scale+7)/8, (d.height*scale+7)/8))
+	isRGB := len(d.components) == 3 && (d.adobeTransform == 0 || d.components[0].id == 'R' && d.components[1].id == 'G' && d.components[2].id == 'B')
+
+	// Every MCU is decoded into a small buffer per component before being converted to RGBA.
+	cosines := getJPEGCosines(scale)
+	samples := make([][]uint8, len(d.components))
+	for i, component := range d.components {
+		samples[i] = make([]uint8, scale*component.h*scale*component.v)
+	}
+	var coefficients [64]int32
+	for mcu := 0; mcu < mcusX*mcusY; mcu++ {
+		if d.restartInterval > 0 && mcu > 0 && mcu%d.restartInterval == 0 {
+			if err := d.processRestart(); err != nil {
+				return nil, err
+			}
+		}
+		for i := range d.components {
+			component := &d.components[i]
+			stride := scale * component.h
+			for blockY := 0; blockY < component.v; blockY++ {
+				for blockX := 0; blockX < component.h; blockX++ {
+					if err := d.decodeBlock(component, &coefficients); err != nil {
+						return nil, err
+					}
+					inverseDCT(&coefficients, cosines, samples[i][blockY*scale*stride+blockX*scale:], stride)
+				}
+			}
+		}
+
+		// Convert the MCU's samples, upsampling subsampled components by repetition.
+		mcuX, mcuY := mcu%mcusX*maxH*scale, mcu/mcusX*maxV*scale
+		for y := 0; y < maxV*scale && mcuY+y < imageDestination.Rect.Max.Y; y++ {
+			for x := 0; x < maxH*scale && mcuX+x < imageDestination.Rect.Max.X; x++ {
+				pixel := imageDestination.Pix[imageDestination.PixOffset(mcuX+x, mcuY+y):]
+				var values [3]uint8
+				for i, component := range d.components {
+					values[i] = samples[i][y*component.v/maxV*scale*component.h+x*component.h/maxH]
+				}
+				switch {
+				case len(d.components) == 1:
+					pixel[0], pixel[1], pixel[2] = values[0], values[0], values[0]
+				case isRGB:
+					pixel[0], pixel[1], pixel[2] = values[0], values[1], values[2]
+				default:
+					pixel[0], pixel[1], pixel[2] = color.YCbCrToRGB(values[0], values[1], values[2])
+				}
+				pixel[3] = 0xFF
+			}
+		}
+	}
+	return imageDestination, nil
+}
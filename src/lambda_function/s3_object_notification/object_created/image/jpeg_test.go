@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// newTestJPEG encodes a test image with smooth gradients and hard edges as a baseline JPEG.
+func newTestJPEG(t *testing.T, imageSource image.Image) []byte {
+	var b bytes.Buffer
+	if err := jpeg.Encode(&b, imageSource, &jpeg.Options{Quality: 95}); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+// newTestColorImage creates a colour image of the given size with gradients and a sharp edged square. The square is
+// aligned to the 16x16 MCUs so that subsampled chroma does not blur its edges.
+func newTestColorImage(width int, height int) *image.RGBA {
+	imageSource := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			pixel := color.RGBA{uint8(x * 255 / width), uint8(y * 255 / height), 128, 0xFF}
+			if x >= 64 && x < 128 && y >= 32 && y < 96 {
+				pixel = color.RGBA{0xF0, 0x20, 0x20, 0xFF}
+			}
+			imageSource.SetRGBA(x, y, pixel)
+		}
+	}
+	return imageSource
+}
+
+// getMeanAbsoluteDifference returns the mean absolute difference between the colour channels of two images.
+func getMeanAbsoluteDifference(t *testing.T, a image.Image, b *image.RGBA) float64 {
+	if a.Bounds() != b.Bounds() {
+		t.Fatalf("Bounds: %v != %v", a.Bounds(), b.Bounds())
+	}
+	imageA := fitImage(a, math.MaxInt)
+	sum := 0.0
+	for i := range imageA.Pix {
+		if i%4 != 3 {
+			sum += math.Abs(float64(imageA.Pix[i]) - float64(b.Pix[i]))
+		}
+	}
+	return sum / float64(len(imageA.Pix)*3/4)
+}
+
+func TestDecodeJPEGScaled(t *testing.T) {
+	// Grayscale images are encoded with a single component.
+	imageGray := image.NewGray(image.Rect(0, 0, 208, 160))
+	draw.Draw(imageGray, imageGray.Bounds(), newTestHashImage(1, 208, 160), image.Point{}, draw.Src)
+	for _, imageSource := range []image.Image{newTestColorImage(208, 160), newTestHashImage(1, 208, 160), imageGray} {
+		b := newTestJPEG(t, imageSource)
+		reference, err := jpeg.Decode(bytes.NewReader(b))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, scale := range []int{8, 4, 2, 1, 3} {
+			imageDestination, err := decodeJPEGScaled(bytes.NewReader(b), scale)
+			if err != nil {
+				t.Fatalf("DecodeJPEGScaled: Scale=%d Error=%s", scale, err)
+			}
+			expected := thumbnailImage(reference, (208*scale+7)/8, (160*scale+7)/8)
+			if difference := getMeanAbsoluteDifference(t, imageDestination, expected); difference > 4 {
+				t.Errorf("DecodeJPEGScaled: Scale=%d Difference=%v", scale, difference)
+
+			}
+		}
+	}
+}
+
+func TestDecodeJPEGScaledUnsupported(t *testing.T) {
+	b := newTestJPEG(t, newTestColorImage(64, 64))
+	// Relabel the baseline frame as progressive.
+	i := bytes.Index(b, []byte{0xFF, jpegMarkerSOF0})
+	b[i+1] = 0xC2
+	if _, err := decodeJPEGScaled(bytes.NewReader(b), 4); !errors.Is(err, errUnsupportedJPEG) {
+		t.Fatalf("DecodeJPEGScaled: Error=%v", err)
+	}
+}
+
+func TestGetJPEGScale(t *testing.T) {
+	for _, test := range []struct {
+		width, height, maxPixels, scale int
+	}{
+		{6000, 4000, 24000000, 8},
+		{9504, 6336, 24000000, 5},
+		{9504, 6336, 1000000, 1},
+	} {
+		if scale := getJPEGScale(test.width, test.height, test.maxPixels); scale != test.scale {
+			t.Errorf("GetJPEGScale: %dx%d got %d want %d", test.width, test.height, scale, test.scale)
+		}
+	}
+}
+
+func TestOpenImageJPEG(t *testing.T) {
+	maxDecodePixels, maxPixels := imageMaxDecodePixels, imageMaxPixels
+	t.Cleanup(func() { imageMaxDecodePixels, imageMaxPixels = maxDecodePixels, maxPixels })
+	imageMaxDecodePixels = 40000000
+	fileName := filepath.Join(t.TempDir(), "IMG_0001.JPG")
+	if err := os.WriteFile(fileName, newTestJPEG(t, newTestColorImage(208, 160)), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		maxPixels     int
+		decoder       string
+		width, height int
+	}{
+		// Within the maximum, the image is decoded at full size by the standard decoder.
+		{208 * 160, imageDecoderStandard, 208, 160},
+		{208 * 160 / 4, imageDecoderJPEGScaled, 104, 80},
+		// Even at 1/8 the image exceeds the maximum, so it is reduced further.
+		{100, imageDecoderJPEGScaled, 11, 8},
+	} {
+		imageMaxPixels = test.maxPixels
+		imageDestination, imageDecoding, err := openImage(fileName)
+		if err != nil {
+			t.Fatalf("OpenImage: MaxPixels=%d Error=%s", test.maxPixels, err)
+		}
+		bounds := imageDestination.Bounds()
+		if imageDecoding.Decoder != test.decoder || bounds.Dx() != test.width || bounds.Dy() != test.height || bounds.Dx()*bounds.Dy() > test.maxPixels {
+			t.Errorf("OpenImage: MaxPixels=%d Decoder=%s Bounds=%v", test.maxPixels, imageDecoding.Decoder, bounds)
+		}
+		if imageDecoding.DecodedWidth != bounds.Dx() || imageDecoding.Scale != float64(bounds.Dx())/208 {
+			t.Errorf("OpenImage: MaxPixels=%d Decoding=%+v", test.maxPixels, imageDecoding)
+		}
+	}
+}
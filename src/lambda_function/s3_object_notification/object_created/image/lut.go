@@ -0,0 +1,272 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"image"
+	"image/draw"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Contains functions for parsing colour lookup tables in the .cube format and applying them to images.
+//
+// A .cube file holds either a 1D LUT, which maps each channel through its own curve, or a 3D LUT, which maps every
+// colour through a lattice of output colours. Values between the lattice points are interpolated trilinearly, from
+// the eight surrounding points, or tetrahedrally, from the four points of the tetrahedron containing the colour,
+// which is cheaper and keeps neutral greys neutral.
+
+// Interpolation methods for 3D LUTs.
+const (
+	lutInterpolationTetrahedral = "Tetrahedral"
+	lutInterpolationTrilinear   = "Trilinear"
+)
+
+// Limits on the size of LUTs.
+const (
+	lutMax1DSize = 65536
+	lutMax3DSize = 256
+)
+
+// colorLUT is a parsed 1D or 3D colour lookup table. The table of a 3D LUT is ordered with red changing fastest.
+type colorLUT struct {
+	domainMax        [3]float64
+	domainMin        [3]float64
+	size             int
+	table            [][3]float32
+	threeDimensional bool
+	title            string
+}
+
+// parseCubeLUT parses a LUT in the .cube format.
+func parseCubeLUT(reader io.Reader) (*colorLUT, error) {
+	lut := colorLUT{domainMax: [3]float64{1, 1, 1}}
+	scanner := bufio.NewScanner(reader)
+	line := 0
+	for scanner.Scan() {
+		line++
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		var err error
+		switch fields[0] {
+		case "TITLE":
+			lut.title = strings.Trim(strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(scanner.Text()), "TITLE")), "\"")
+		case "LUT_1D_SIZE", "LUT_3D_SIZE":
+			if lut.size != 0 {
+				return nil, fmt.Errorf("line %d: LUT size already set", line)
+			}
+			lut.threeDimensional = fields[0] == "LUT_3D_SIZE"
+			maxSize := lutMax1DSize
+			if lut.threeDimensional {
+				maxSize = lutMax3DSize
+			}
+			if len(fields) != 2 {
+				return nil, fmt.Errorf("line %d: %s requires one value", line, fields[0])
+			}
+			if lut.size, err = strconv.Atoi(fields[1]); err != nil || lut.size < 2 || lut.size > maxSize {
+				return nil, fmt.Errorf("line %d: %s %s outside 2-%d", line, fields[0], fields[1], maxSize)
+			}
+		case "DOMAIN_MIN":
+			lut.domainMin, err = parseCubeTriple(fields[1:])
+		case "DOMAIN_MAX":
+			lut.domainMax, err = parseCubeTriple(fields[1:])
+		case "LUT_1D_INPUT_RANGE", "LUT_3D_INPUT_RANGE":
+			// The input range variant sets the same domain for all three channels.
+			if len(fields) != 3 {
+				return nil, fmt.Errorf("line %d: %s requires two values", line, fields[0])
+			}
+			var minimum, maximum float64
+			if minimum, err = parseCubeValue(fields[1]); err == nil {
+				maximum, err = parseCubeValue(fields[2])
+			}
+			lut.domainMin = [3]float64{minimum, minimum, minimum}
+			lut.domainMax = [3]float64{maximum, maximum, maximum}
+		default:
+			var value [3]float64
+			if value, err = parseCubeTriple(fields); err == nil {
+				lut.table = append(lut.table, [3]float32{float32(value[0]), float32(value[1]), float32(value[2])})
+			}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if lut.size == 0 {
+		return nil, fmt.Errorf("missing LUT_1D_SIZE or LUT_3D_SIZE")
+	}
+	entries := lut.size
+	if lut.threeDimensional {
+		entries = lut.size * lut.size * lut.size
+	}
+	if len(lut.table) != entries {
+		return nil, fmt.Errorf("LUT has %d entries, expected %d", len(lut.table), entries)
+	}
+	for channel := range lut.domainMin {
+		if lut.domainMax[channel] <= lut.domainMin[channel] {
+			return nil, fmt.Errorf("LUT domain maximum %v not above minimum %v", lut.domainMax[channel], lut.domainMin[channel])
+		}
+	}
+	return &lut, nil
+}
+
+// parseCubeTriple parses three floating point values of a .cube file.
+func parseCubeTriple(fields []string) ([3]float64, error) {
+	var value [3]float64
+	if len(fields) != 3 {
+		return value, fmt.Errorf("expected 3 values, found %d", len(fields))
+	}
+	for i, field := range fields {
+		var err error
+		if value[i], err = parseCubeValue(field); err != nil {
+			return value, err
+		}
+	}
+	return value, nil
+}
+
+// parseCubeValue parses a floating point value of a .cube file. Values that are not finite once stored in the table
+// are rejected, as they would turn the LUT's positions and outputs into NaN.
+func parseCubeValue(field string) (float64, error) {
+	value, err := strconv.ParseFloat(field, 64)
+	if err != nil {
+		return 0, err
+	}
+	if math.IsNaN(value) || math.IsInf(float64(float32(value)), 0) {
+		return 0, fmt.Errorf("value %s is not finite", field)
+	}
+	return value, nil
+}
+
+// getPosition returns the position of the channel value within the LUT, between 0 and size-1.
+func (l *colorLUT) getPosition(value float64, channel int) float64 {
+	position := (value - l.domainMin[channel]) / (l.domainMax[channel] - l.domainMin[channel]) * float64(l.size-1)
+	return math.Max(0, math.Min(float64(l.size-1), position))
+}
+
+// lookup1D maps the colour through the curves of a 1D LUT.
+func (l *colorLUT) lookup1D(rgb [3]float64) [3]float64 {
+	var result [3]float64
+	for channel := range rgb {
+		position := l.getPosition(rgb[channel], channel)
+		i := min(int(position), l.size-2)
+		fraction := position - float64(i)
+		result[channel] = float64(l.table[i][channel])*(1-fraction) + float64(l.table[i+1][channel])*fraction
+	}
+	return result
+}
+
+// lookup3D maps the colour through a 3D LUT with the interpolation.
+func (l *colorLUT) lookup3D(rgb [3]float64, interpolation string) [3]float64 {
+	var base [3]int
+	var fraction [3]float64
+	for channel := range rgb {
+		position := l.getPosition(rgb[channel], channel)
+		base[channel] = min(int(position), l.size-2)
+		fraction[channel] = position - float64(base[channel])
+	}
+	at := func(r, g, b int) [3]float32 {
+		return l.table[(base[0]+r)+(base[1]+g)*l.size+(base[2]+b)*l.size*l.size]
+	}
+	fr, fg, fb := fraction[0], fraction[1], fraction[2]
+
+	var result [3]float64
+	add := func(weight float64, value [3]float32) {
+		for channel := range result {
+			result[channel] += weight * float64(value[channel])
+		}
+	}
+	if interpolation == lutInterpolationTrilinear {
+		for corner := 0; corner < 8; corner++ {
+			r, g, b := corner&1, corner>>1&1, corner>>2&1
+			weight := (float64(r)*fr + float64(1-r)*(1-fr)) * (float64(g)*fg + float64(1-g)*(1-fg)) * (float64(b)*fb + float64(1-b)*(1-fb))
+			add(weight, at(r, g, b))
+		}
+		return result
+	}
+
+	// Select the tetrahedron of the cube that contains the colour from the order of the fractions.
+	switch {
+	case fr > fg && fg > fb:
+		add(1-fr, at(0, 0, 0))
+		add(fr-fg, at(1, 0, 0))
+		add(fg-fb, at(1, 1, 0))
+		add(fb, at(1, 1, 1))
+	case fr > fg && fr > fb:
+		add(1-fr, at(0, 0, 0))
+		add(fr-fb, at(1, 0, 0))
+		add(fb-fg, at(1, 0, 1))
+		add(fg, at(1, 1, 1))
+	case fr > fg:
+		add(1-fb, at(0, 0, 0))
+		add(fb-fr, at(0, 0, 1))
+		add(fr-fg, at(1, 0, 1))
+		add(fg, at(1, 1, 1))
+	case fb > fg:
+		add(1-fb, at(0, 0, 0))
+		add(fb-fg, at(0, 0, 1))
+		add(fg-fr, at(0, 1, 1))
+		add(fr, at(1, 1, 1))
+	case fb > fr:
+		add(1-fg, at(0, 0, 0))
+		add(fg-fb, at(0, 1, 0))
+		add(fb-fr, at(0, 1, 1))
+		add(fr, at(1, 1, 1))
+	default:
+		add(1-fg, at(0, 0, 0))
+		add(fg-fr, at(0, 1, 0))
+		add(fr-fb, at(1, 1, 0))
+		add(fb, at(1, 1, 1))
+	}
+	return result
+}
+
+// applyLUT returns a copy of the image with its colours mapped through the LUT. Colours are looked up on their sRGB
+// encoded values, which is what .cube files produced by grading tools expect.
+func applyLUT(imageSource image.Image, lut *colorLUT, interpolation string) *image.RGBA {
+	imageDestination := image.NewRGBA(imageSource.Bounds())
+	draw.Draw(imageDestination, imageDestination.Rect, imageSource, imageSource.Bounds().Min, draw.Src)
+
+	// A 1D LUT maps each 8-bit level independently, so it is tabulated once.
+	var levels [3][256]uint8
+	if !lut.threeDimensional {
+		for level := 0; level < 256; level++ {
+			value := float64(level) / 0xFF
+			result := lut.lookup1D([3]float64{value, value, value})
+			for channel := range levels {
+				levels[channel][level] = uint8(math.Round(clampUnit(result[channel]) * 0xFF))
+			}
+		}
+	}
+
+	for i := 0; i < len(imageDestination.Pix); i += 4 {
+		pixel := imageDestination.Pix[i : i+4]
+		alpha := pixel[3]
+		if alpha == 0 {
+			continue
+		}
+		// Look up the unpremultiplied colour and premultiply the result.
+		var rgb [3]float64
+		for channel := range rgb {
+			rgb[channel] = math.Min(1, float64(pixel[channel])/float64(alpha))
+		}
+		var result [3]float64
+		if lut.threeDimensional {
+			result = lut.lookup3D(rgb, interpolation)
+		} else {
+			for channel := range result {
+				result[channel] = float64(levels[channel][uint8(math.Round(rgb[channel]*0xFF))]) / 0xFF
+			}
+		}
+		for channel := range result {
+			pixel[channel] = uint8(math.Round(clampUnit(result[channel]) * float64(alpha)))
+		}
+	}
+	return imageDestination
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// newTestCubeLUT returns a 3D .cube LUT of the size computed by the function.
+func newTestCubeLUT(size int, f func(r, g, b float64) [3]float64) string {
+	var builder strings.Builder
+	fmt.Fprintf(&builder, "TITLE \"Test\"\n# Generated\nLUT_3D_SIZE %d\n", size)
+	for b := 0; b < size; b++ {
+		for g := 0; g < size; g++ {
+			for r := 0; r < size; r++ {
+				value := f(float64(r)/float64(size-1), float64(g)/float64(size-1), float64(b)/float64(size-1))
+				fmt.Fprintf(&builder, "%.6f %.6f %.6f\n", value[0], value[1], value[2])
+			}
+		}
+	}
+	return builder.String()
+}
+
+func TestParseCubeLUT(t *testing.T) {
+	lut, err := parseCubeLUT(strings.NewReader(newTestCubeLUT(3, func(r, g, b float64) [3]float64 { return [3]float64{r, g, b} })))
+	if err != nil || lut.title != "Test" || lut.size != 3 || !lut.threeDimensional || len(lut.table) != 27 {
+		t.Fatalf("ParseCubeLUT: %+v %v", lut, err)
+	}
+	for _, cube := range []string{
+		"0 0 0\n1 1 1\n",
+		"LUT_1D_SIZE 2\n0 0 0\n",
+		"LUT_1D_SIZE 1\n0 0 0\n",
+		"LUT_3D_SIZE 2\nLUT_1D_SIZE 2\n",
+		"LUT_1D_SIZE 2\nDOMAIN_MIN 1 1 1\nDOMAIN_MAX 0 0 0\n0 0 0\n1 1 1\n",
+		"LUT_1D_SIZE 2\n0 0\n1 1 1\n",
+		"LUT_1D_SIZE 2\nDOMAIN_MIN NaN 0 0\n0 0 0\n1 1 1\n",
+		"LUT_1D_SIZE 2\nLUT_1D_INPUT_RANGE 0 +Inf\n0 0 0\n1 1 1\n",
+		"LUT_1D_SIZE 2\n0 0 0\n1 1e39 1\n",
+	} {
+		if _, err := parseCubeLUT(strings.NewReader(cube)); err == nil {
+			t.Errorf("ParseCubeLUT: %q accepted", cube)
+		}
+	}
+}
+
+func TestApplyLUT(t *testing.T) {
+	imageSource := image.NewRGBA(image.Rect(0, 0, 4, 1))
+	colors := []color.RGBA{{0, 0, 0, 0xFF}, {200, 100, 50, 0xFF}, {17, 230, 128, 0xFF}, {0xFF, 0xFF, 0xFF, 0xFF}}
+	for x, c := range colors {
+		imageSource.SetRGBA(x, 0, c)
+	}
+
+	// Both interpolations reproduce a LUT of a linear transform exactly, here rotating the channels.
+	lut, err := parseCubeLUT(strings.NewReader(newTestCubeLUT(5, func(r, g, b float64) [3]float64 { return [3]float64{g, b, r} })))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, interpolation := range []string{lutInterpolationTetrahedral, lutInterpolationTrilinear} {
+		imageDestination := applyLUT(imageSource, lut, interpolation)
+		for x, c := range colors {
+			if got := imageDestination.RGBAAt(x, 0); got != (color.RGBA{c.G, c.B, c.R, 0xFF}) {
+				t.Errorf("ApplyLUT: %s %v got %v", interpolation, c, got)
+			}
+		}
+	}
+
+	// Tetrahedral interpolation keeps greys unchanged through a LUT that only changes the colours off the grey axis,
+	// which trilinear interpolation blends into them.
+	lut, err = parseCubeLUT(strings.NewReader(newTestCubeLUT(2, func(r, g, b float64) [3]float64 {
+		if r == g && g == b {
+			return [3]float64{r, g, b}
+		}
+		return [3]float64{1 - r, 1 - g, 1 - b}
+	})))
+	if err != nil {
+		t.Fatal(err)
+	}
+	imageGrey := image.NewRGBA(image.Rect(0, 0, 1, 1))
+	imageGrey.SetRGBA(0, 0, color.RGBA{64, 64, 64, 0xFF})
+	if got := applyLUT(imageGrey, lut, lutInterpolationTetrahedral).RGBAAt(0, 0); got != (color.RGBA{64, 64, 64, 0xFF}) {
+		t.Errorf("ApplyLUT: tetrahedral grey %v", got)
+	}
+	if got := applyLUT(imageGrey, lut, lutInterpolationTrilinear).RGBAAt(0, 0); got.R == 64 {
+		t.Errorf("ApplyLUT: trilinear grey unchanged %v", got)
+	}
+
+	// A 1D LUT with an input range of 0-2 maps the input through the first half of its curve.
+	lut, err = parseCubeLUT(strings.NewReader("LUT_1D_SIZE 3\nLUT_1D_INPUT_RANGE 0 2\n1 1 1\n0.5 0.5 0.5\n0 0 0\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := applyLUT(imageSource, lut, lutInterpolationTetrahedral).RGBAAt(3, 0); math.Abs(float64(got.R)-128) > 1 {
+		t.Errorf("ApplyLUT: 1D %v", got)
+	}
+}
+
+func TestGetGrades(t *testing.T) {
+	grades, err := getGrades(`[{"Name": "Neutral", "BundledLUT": "Identity.cube", "Prefixes": ["images/uploaded/weddings/"]}, {"Name": "Warm", "LUT": "luts/Warm.cube"}]`)
+	if err != nil || grades[0].Interpolation != lutInterpolationTetrahedral || gradeLUTs["Neutral"] == nil || gradeLUTs["Neutral"].title != "Identity" {
+		t.Fatalf("GetGrades: %+v %v", grades, err)
+	}
+	for _, configuration := range []string{
+		`[{"Name": "Warm"}]`,
+		`[{"Name": "Warm", "LUT": "a.cube", "BundledLUT": "Identity.cube"}]`,
+		`[{"Name": "Warm", "BundledLUT": "Missing.cube"}]`,
+		`[{"Name": "Warm", "LUT": "a.cube", "Interpolation": "Cubic"}]`,
+		`[{"Name": "Warm Look", "LUT": "a.cube"}]`,
+	} {
+		if _, err := getGrades(configuration); err == nil {
+			t.Errorf("GetGrades: %s accepted", configuration)
+		}
+	}
+
+	// Grades are selected by prefix and by tag.
+	s3Tags := []*s3.Tag{{Key: aws.String(gradeTagKey), Value: aws.String("Warm Cool")}}
+	for _, test := range []struct {
+		key   string
+		tags  []*s3.Tag
+		count int
+	}{
+		{"images/uploaded/weddings/IMG_0001.JPG", nil, 1},
+		{"images/uploaded/IMG_0001.JPG", nil, 0},
+		{"images/uploaded/IMG_0001.JPG", s3Tags, 1},
+		{"images/uploaded/weddings/IMG_0001.JPG", s3Tags, 2},
+	} {
+		if selectedGrades := getSelectedGrades(grades, test.key, test.tags); len(selectedGrades) != test.count {
+			t.Errorf("GetSelectedGrades: %s selected %d want %d", test.key, len(selectedGrades), test.count)
+		}
+	}
+}
@@ -20,7 +20,34 @@ const (
 var (
 	s3BucketFolderImagesCompressed string
 	s3BucketFolderImagesExif       string
+	s3BucketFolderImagesGraded     string
+	s3BucketFolderImagesHashes     string
+	s3BucketFolderImagesRenditions string
 	s3BucketFolderImagesUploaded   string
+	s3BucketFolderQuarantine       string
+)
+
+// Global variables to store the S3 bucket folder names written by other functions.
+var (
+	s3BucketFolderRekognitionDetectFaces string
+	s3BucketFolderRekognitionStatus      string
+)
+
+// Global variables to store the image processing configuration.
+var (
+	imageCropPresets                 map[string]*CropPreset
+	imageDuplicateAction             string
+	imageDuplicateThreshold          int
+	imageGrades                      []Grade
+	imageMaxDecodePixels             int
+	imageMaxDimension                int
+	imageMaxPixels                   int
+	imageMaxUploadPixels             int
+	imagePaletteSize                 int
+	imageRedactionCollectionID       string
+	imageRedactionFaceMatchThreshold float64
+	imageRenditions                  []Rendition
+	imageWatermark                   *WatermarkConfiguration
 )
 
 // createAWSSession creates and returns a new AWS session.
@@ -42,12 +69,26 @@ func getEnvironmentVariable(key string) string {
 	return environmentValue
 }
 
+// getEnvironmentVariableOrDefault retrieves an environment variable by its key and returns its value.
+// It returns the defaultValue if the variable is not set or empty.
+func getEnvironmentVariableOrDefault(key string, defaultValue string) string {
+	environmentValue := os.Getenv(key)
+	if len(environmentValue) == 0 {
+		return defaultValue
+	}
+	return environmentValue
+}
+
 // validateS3Folders checks that S3 bucket folder names are unique and not empty.
 func validateS3Folders() {
 	folders := []string{
 		s3BucketFolderImagesCompressed,
 		s3BucketFolderImagesExif,
+		s3BucketFolderImagesGraded,
+		s3BucketFolderImagesHashes,
+		s3BucketFolderImagesRenditions,
 		s3BucketFolderImagesUploaded,
+		s3BucketFolderQuarantine,
 	}
 
 	// Create a map to store folder names and check for duplicates.
@@ -66,9 +107,12 @@ func validateS3Folders() {
 
 // handler is the AWS Lambda function that processes S3 events.
 func handler(context context.Context, s3Event *events.S3Event) {
-	log.Printf("S3_BUCKET_FOLDER_IMAGES_COMPRESSED=%s S3_BUCKET_FOLDER_IMAGES_EXIF=%s S3_BUCKET_FOLDER_IMAGES_UPLOADED=%s",
+	log.Printf("S3_BUCKET_FOLDER_IMAGES_COMPRESSED=%s S3_BUCKET_FOLDER_IMAGES_EXIF=%s S3_BUCKET_FOLDER_IMAGES_GRADED=%s S3_BUCKET_FOLDER_IMAGES_HASHES=%s S3_BUCKET_FOLDER_IMAGES_RENDITIONS=%s S3_BUCKET_FOLDER_IMAGES_UPLOADED=%s",
 		s3BucketFolderImagesCompressed,
 		s3BucketFolderImagesExif,
+		s3BucketFolderImagesGraded,
+		s3BucketFolderImagesHashes,
+		s3BucketFolderImagesRenditions,
 		s3BucketFolderImagesUploaded)
 
 	// Check if the S3 bucket folders are configured correctly.
@@ -86,11 +130,69 @@ func main() {
 	// Initialize S3 bucket folder variables from environment variables.
 	s3BucketFolderImagesCompressed = getEnvironmentVariable("S3_BUCKET_FOLDER_IMAGES_COMPRESSED")
 	s3BucketFolderImagesExif = getEnvironmentVariable("S3_BUCKET_FOLDER_IMAGES_EXIF")
+	s3BucketFolderImagesGraded = getEnvironmentVariable("S3_BUCKET_FOLDER_IMAGES_GRADED")
+	s3BucketFolderImagesHashes = getEnvironmentVariable("S3_BUCKET_FOLDER_IMAGES_HASHES")
+	s3BucketFolderImagesRenditions = getEnvironmentVariable("S3_BUCKET_FOLDER_IMAGES_RENDITIONS")
 	s3BucketFolderImagesUploaded = getEnvironmentVariable("S3_BUCKET_FOLDER_IMAGES_UPLOADED")
+	s3BucketFolderQuarantine = getEnvironmentVariable("S3_BUCKET_FOLDER_QUARANTINE")
+	s3BucketFolderRekognitionDetectFaces = getEnvironmentVariable("S3_BUCKET_FOLDER_REKOGNITION_DETECT_FACES")
+	s3BucketFolderRekognitionStatus = getEnvironmentVariable("S3_BUCKET_FOLDER_REKOGNITION_STATUS")
 
 	// Validate S3 folder names.
 	validateS3Folders()
 
+	// Initialize the image processing configuration from environment variables.
+	var err error
+	imageCropPresets, err = getCropPresets(getEnvironmentVariableOrDefault("IMAGE_CROP_PRESETS", "[]"))
+	if err != nil {
+		log.Fatalf("IMAGE_CROP_PRESETS: Error=%s", err)
+	}
+	imageDuplicateAction, err = getDuplicateAction(getEnvironmentVariableOrDefault("IMAGE_DUPLICATE_ACTION", duplicateActionProcess))
+	if err != nil {
+		log.Fatalf("IMAGE_DUPLICATE_ACTION: Error=%s", err)
+	}
+	imageDuplicateThreshold, err = getDuplicateThreshold(getEnvironmentVariableOrDefault("IMAGE_DUPLICATE_THRESHOLD", "4"))
+	if err != nil {
+		log.Fatalf("IMAGE_DUPLICATE_THRESHOLD: Error=%s", err)
+	}
+	imageGrades, err = getGrades(getEnvironmentVariableOrDefault("IMAGE_GRADES", "[]"))
+	if err != nil {
+		log.Fatalf("IMAGE_GRADES: Error=%s", err)
+	}
+	imageMaxDecodePixels, err = getImageMaxPixels(getEnvironmentVariableOrDefault("IMAGE_MAX_DECODE_PIXELS", "40000000"))
+	if err != nil {
+		log.Fatalf("IMAGE_MAX_DECODE_PIXELS: Error=%s", err)
+	}
+	imageMaxDimension, err = getImageMaxDimension(getEnvironmentVariableOrDefault("IMAGE_MAX_DIMENSION", "30000"))
+	if err != nil {
+		log.Fatalf("IMAGE_MAX_DIMENSION: Error=%s", err)
+	}
+	imageMaxPixels, err = getImageMaxPixels(getEnvironmentVariableOrDefault("IMAGE_MAX_PIXELS", "24000000"))
+	if err != nil {
+		log.Fatalf("IMAGE_MAX_PIXELS: Error=%s", err)
+	}
+	imageMaxUploadPixels, err = getImageMaxPixels(getEnvironmentVariableOrDefault("IMAGE_MAX_UPLOAD_PIXELS", "200000000"))
+	if err != nil {
+		log.Fatalf("IMAGE_MAX_UPLOAD_PIXELS: Error=%s", err)
+	}
+	imagePaletteSize, err = getPaletteSize(getEnvironmentVariableOrDefault("IMAGE_PALETTE_SIZE", "5"))
+	if err != nil {
+		log.Fatalf("IMAGE_PALETTE_SIZE: Error=%s", err)
+	}
+	imageRedactionCollectionID = getEnvironmentVariableOrDefault("IMAGE_REDACTION_COLLECTION_ID", "")
+	imageRedactionFaceMatchThreshold, err = getFaceMatchThreshold(getEnvironmentVariableOrDefault("IMAGE_REDACTION_FACE_MATCH_THRESHOLD", "90"))
+	if err != nil {
+		log.Fatalf("IMAGE_REDACTION_FACE_MATCH_THRESHOLD: Error=%s", err)
+	}
+	imageRenditions, err = getRenditions(getEnvironmentVariableOrDefault("IMAGE_RENDITIONS", "[]"), imageCropPresets)
+	if err != nil {
+		log.Fatalf("IMAGE_RENDITIONS: Error=%s", err)
+	}
+	imageWatermark, err = getWatermarkConfiguration(getEnvironmentVariableOrDefault("IMAGE_WATERMARK", ""))
+	if err != nil {
+		log.Fatalf("IMAGE_WATERMARK: Error=%s", err)
+	}
+
 	// Start the AWS Lambda handler function.
 	lambda.Start(handler)
 }
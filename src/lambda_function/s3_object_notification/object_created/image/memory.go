@@ -0,0 +1,47 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"runtime/debug"
+	"strconv"
+	"strings"
+)
+
+// Contains functions for measuring the peak memory used while processing an image.
+
+// Files of the proc filesystem used to measure memory.
+const (
+	procSelfClearRefs = "/proc/self/clear_refs"
+	procSelfStatus    = "/proc/self/status"
+)
+
+// resetPeakMemory returns memory freed by previous invocations to the operating system and resets the peak resident
+// memory of the process, so that the peak measured afterwards belongs to the current image. Resetting the peak is
+// best effort, as it requires a Linux kernel that allows writing to clear_refs.
+func resetPeakMemory() {
+	debug.FreeOSMemory()
+	_ = os.WriteFile(procSelfClearRefs, []byte("5"), 0)
+}
+
+// getPeakMemory returns the peak resident memory of the process in bytes, or 0 if it is unavailable.
+func getPeakMemory() uint64 {
+	file, err := os.Open(procSelfStatus)
+	if err != nil {
+		return 0
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		// The line has the format "VmHWM:     12345 kB".
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 3 && fields[0] == "VmHWM:" {
+			value, err := strconv.ParseUint(fields[1], 10, 64)
+			if err != nil {
+				return 0
+			}
+			return value * 1024
+		}
+	}
+	return 0
+}
@@ -0,0 +1,16 @@
+package main
+
+// ImageMetadata contains the Exif metadata of an image alongside the properties derived from its pixel data.
+// The Exif fields are embedded so that they remain at the top level of the uploaded JSON document.
+type ImageMetadata struct {
+	*ExifMetadata
+	ColorProfile *ColorProfile     `json:"ColorProfile"`
+	Decoding     *ImageDecoding    `json:"Decoding"`
+	Duplicate    *ImageDuplicate   `json:"Duplicate"`
+	Grades       []ImageGrade      `json:"Grades"`
+	Hashes       *ImageHashes      `json:"Hashes"`
+	Palette      []PaletteColor    `json:"Palette"`
+	Placeholder  *ImagePlaceholder `json:"Placeholder"`
+	Quality      *ImageQuality     `json:"Quality"`
+	UploadedKey  string            `json:"UploadedKey"`
+}
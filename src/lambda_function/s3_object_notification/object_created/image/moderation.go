@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// Contains functions for honouring the moderation quarantine of the image_compressed Lambda.
+//
+// The image_compressed Lambda quarantines unsafe images while this Lambda may still be producing renditions of them.
+// Renditions are not produced for an image that is already quarantined, and once the image has been processed the
+// quarantine is checked again, moving whatever was produced after the image_compressed Lambda moved the rest.
+
+// moderationReviewStatusPending is the status of a quarantined image awaiting review.
+const moderationReviewStatusPending = "Pending"
+
+// ModerationReviewRecord holds the fields of the image_compressed Lambda's review record that this Lambda reads.
+type ModerationReviewRecord struct {
+	ID     string `json:"ID"`
+	Status string `json:"Status"`
+}
+
+// getModerationImageID returns the date folders and name that identify the image in the quarantine, such as
+// 2024/05/01/IMG_0001, matching the ID the image_compressed Lambda derives from the compressed image key.
+func getModerationImageID(fileName string, fileTime time.Time) string {
+	return fmt.Sprintf("%s/%s", fileTime.Format("2006/01/02"), strings.Split(path.Base(fileName), ".")[0])
+}
+
+// getModerationReviewRecordKey returns the S3 object key of the review record of the image with the ID.
+func getModerationReviewRecordKey(imageID string) string {
+	return fmt.Sprintf("%s/moderation/%s.JSON", s3BucketFolderQuarantine, imageID)
+}
+
+// getModerationQuarantineKey returns the S3 object key that an object of the image with the ID is quarantined at.
+func getModerationQuarantineKey(imageID string, s3ObjectKey string) string {
+	return fmt.Sprintf("%s/moderation/%s/%s", s3BucketFolderQuarantine, imageID, s3ObjectKey)
+}
+
+// getModerationQuarantineObjectKeys returns the keys of the objects this Lambda produces for an uploaded image.
+func getModerationQuarantineObjectKeys(s3ObjectKeyUploaded string, fileName string, fileTime time.Time, imageMetadata *ImageMetadata) []string {
+	name := strings.Split(path.Base(fileName), ".")[0]
+	s3ObjectKeys := []string{
+		s3ObjectKeyUploaded,
+		createS3ObjectKey(s3BucketFolderImagesCompressed, path.Base(fileName), fileTime),
+		createS3ObjectKey(s3BucketFolderImagesExif, fmt.Sprintf("%s.JSON", name), fileTime),
+		createS3ObjectKey(s3BucketFolderImagesRenditions, fmt.Sprintf("%s.JSON", name), fileTime),
+	}
+	for _, imageGrade := range imageMetadata.Grades {
+		s3ObjectKeys = append(s3ObjectKeys, imageGrade.Key)
+	}
+	for _, rendition := range imageRenditions {
+		s3ObjectKeys = append(s3ObjectKeys, createS3ObjectKey(fmt.Sprintf("%s/%s", s3BucketFolderImagesRenditions, rendition.Name), path.Base(fileName), fileTime))
+	}
+	return s3ObjectKeys
+}
+
+// isS3ObjectModerationQuarantined reports whether the image is quarantined and awaiting review. Errors reading the
+// review record are fatal, so that an unsafe image is never published because its record could not be read.
+func isS3ObjectModerationQuarantined(s3Client *s3.S3, s3BucketName string, fileName string, fileTime time.Time) bool {
+	imageID := getModerationImageID(fileName, fileTime)
+	b, err := getS3ObjectBytes(s3Client, s3BucketName, getModerationReviewRecordKey(imageID))
+	var awsErr awserr.Error
+	if errors.As(err, &awsErr) && awsErr.Code() == s3.ErrCodeNoSuchKey {
+		return false
+	}
+	if err != nil {
+		log.Fatalf("ModerationQuarantine: Error=%s", err)
+	}
+	var moderationReviewRecord ModerationReviewRecord
+	if err := json.Unmarshal(b, &moderationReviewRecord); err != nil {
+		log.Fatalf("ModerationQuarantine: Error=%s", err)
+	}
+	log.Printf("ModerationQuarantine: ID=%s Status=%s", imageID, moderationReviewRecord.Status)
+	return moderationReviewRecord.Status == moderationReviewStatusPending
+}
+
+// processS3ObjectModerationQuarantine moves the objects produced for an image to the quarantine folder, and removes
+// the image from the hash index, if the image was quarantined while it was being processed. Objects that were never
+// produced are skipped.
+func processS3ObjectModerationQuarantine(s3Client *s3.S3, s3BucketName string, s3ObjectKeyUploaded string, fileName string, fileTime time.Time, imageMetadata *ImageMetadata) {
+	if !isS3ObjectModerationQuarantined(s3Client, s3BucketName, fileName, fileTime) {
+		return
+	}
+	// Remove the image from the hash index, so that later uploads are not flagged as duplicates of it.
+	if imageMetadata.Hashes != nil {
+		perceptualHash, err := parseHash(imageMetadata.Hashes.PHash)
+		if err != nil {
+			log.Fatalf("ImageHashIndex: Error=%s", err)
+		}
+		if err := deleteS3ObjectImageHashIndexEntry(s3Client, s3BucketName, s3ObjectKeyUploaded, perceptualHash); err != nil {
+			log.Fatalf("ImageHashIndex: Error=%s", err)
+		}
+	}
+
+	imageID := getModerationImageID(fileName, fileTime)
+	for _, s3ObjectKey := range getModerationQuarantineObjectKeys(s3ObjectKeyUploaded, fileName, fileTime, imageMetadata) {
+		s3ObjectKeyQuarantine := getModerationQuarantineKey(imageID, s3ObjectKey)
+		// Copy the object before deleting it, so that a failure never loses it.
+		_, err := copyS3Object(s3Client, s3BucketName, s3ObjectKey, s3ObjectKeyQuarantine)
+		var awsErr awserr.Error
+		if errors.As(err, &awsErr) && awsErr.Code() == s3.ErrCodeNoSuchKey {
+			continue
+		}
+		if err != nil {
+			log.Fatalf("ModerationQuarantine: Key=%s Error=%s", s3ObjectKey, err)
+		}
+		log.Printf("ModerationQuarantine: Key=%s QuarantineKey=%s", s3ObjectKey, s3ObjectKeyQuarantine)
+		if _, err := deleteS3Object(s3Client, s3BucketName, s3ObjectKey); err != nil {
+			log.Fatalf("ModerationQuarantine: Key=%s Error=%s", s3ObjectKey, err)
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestGetModerationQuarantineObjectKeys(t *testing.T) {
+	s3BucketFolderImagesCompressed = "images/compressed/"
+	s3BucketFolderImagesExif = "images/exif/"
+	s3BucketFolderImagesRenditions = "images/renditions/"
+	s3BucketFolderQuarantine = "quarantine/"
+	imageRenditions = []Rendition{{Name: "Web"}}
+	defer func() { imageRenditions = nil }()
+
+	fileTime := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	imageMetadata := ImageMetadata{Grades: []ImageGrade{{Key: "images/graded//Film/2024/05/01/IMG_0001.JPG", Name: "Film"}}}
+	s3ObjectKeys := getModerationQuarantineObjectKeys("images/uploaded/IMG_0001.JPG", "/tmp/IMG_0001.JPG", fileTime, &imageMetadata)
+	want := []string{
+		"images/uploaded/IMG_0001.JPG",
+		"images/compressed//2024/05/01/IMG_0001.JPG",
+		"images/exif//2024/05/01/IMG_0001.JSON",
+		"images/renditions//2024/05/01/IMG_0001.JSON",
+		"images/graded//Film/2024/05/01/IMG_0001.JPG",
+		"images/renditions//Web/2024/05/01/IMG_0001.JPG",
+	}
+	if !reflect.DeepEqual(s3ObjectKeys, want) {
+		t.Errorf("GetModerationQuarantineObjectKeys: %q", s3ObjectKeys)
+	}
+	imageID := getModerationImageID("/tmp/IMG_0001.JPG", fileTime)
+	if imageID != "2024/05/01/IMG_0001" {
+		t.Errorf("GetModerationImageID: %q", imageID)
+	}
+	if s3ObjectKey := getModerationQuarantineKey(imageID, want[0]); s3ObjectKey != "quarantine//moderation/2024/05/01/IMG_0001/images/uploaded/IMG_0001.JPG" {
+		t.Errorf("GetModerationQuarantineKey: %q", s3ObjectKey)
+	}
+	if s3ObjectKey := getModerationReviewRecordKey(imageID); s3ObjectKey != "quarantine//moderation/2024/05/01/IMG_0001.JSON" {
+		t.Errorf("GetModerationReviewRecordKey: %q", s3ObjectKey)
+	}
+
+	// A photo with the same name taken on another day has its own review record.
+	if s3ObjectKey := getModerationReviewRecordKey(getModerationImageID("/tmp/IMG_0001.JPG", fileTime.AddDate(0, 1, 0))); s3ObjectKey != "quarantine//moderation/2024/06/01/IMG_0001.JSON" {
+		t.Errorf("GetModerationReviewRecordKey: %q for another day", s3ObjectKey)
+	}
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"log"
+	"math"
+	"math/rand"
+	"sort"
+	"strconv"
+)
+
+// Contains functions for extracting the dominant colour palette of an image.
+//
+// Pixels of a small thumbnail are clustered with k-means in CIELAB space, where Euclidean distance approximates
+// perceived colour difference. Each cluster becomes a palette colour weighted by the fraction of pixels it contains.
+
+// Constants used by the palette extractor.
+const (
+	paletteIterations    = 20
+	paletteMaxSize       = 16
+	paletteSeed          = 1
+	paletteThumbnailSize = 64
+)
+
+// PaletteColor is one of the dominant colours of an image. Hex is the mean sRGB colour of the pixels in the cluster,
+// Lab its CIELAB coordinates, Name the nearest named colour and Weight the fraction of the image it covers.
+type PaletteColor struct {
+	Hex    string     `json:"Hex"`
+	Lab    [3]float64 `json:"Lab"`
+	Name   string     `json:"Name"`
+	Weight float64    `json:"Weight"`
+}
+
+// namedColor is a colour that palette colours are named after.
+type namedColor struct {
+	lab  [3]float64
+	name string
+}
+
+// namedColorHex lists the colour names used for search, chosen to be distinct and familiar rather than exhaustive.
+var namedColorHex = map[string]string{
+	"Beige":     "#F5F5DC",
+	"Black":     "#000000",
+	"Blue":      "#0000FF",
+	"Brown":     "#8B4513",
+	"Coral":     "#FF7F50",
+	"Crimson":   "#DC143C",
+	"Cyan":      "#00FFFF",
+	"DarkBlue":  "#00008B",
+	"DarkGreen": "#006400",
+	"Gold":      "#FFD700",
+	"Gray":      "#808080",
+	"Green":     "#008000",
+	"Khaki":     "#C3B091",
+	"Lavender":  "#E6E6FA",
+	"LightBlue": "#ADD8E6",
+	"LightGray": "#D3D3D3",
+	"Lime":      "#00FF00",
+	"Magenta":   "#FF00FF",
+	"Maroon":    "#800000",
+	"Navy":      "#000080",
+	"Olive":     "#808000",
+	"Orange":    "#FFA500",
+	"Pink":      "#FFC0CB",
+	"Purple":    "#800080",
+	"Red":       "#FF0000",
+	"SkyBlue":   "#87CEEB",
+	"Tan":       "#D2B48C",
+	"Teal":      "#008080",
+	"Turquoise": "#40E0D0",
+	"Violet":    "#EE82EE",
+	"White":     "#FFFFFF",
+	"Yellow":    "#FFFF00"}
+
+// namedColors holds the named colours converted to CIELAB.
+var namedColors = getNamedColors()
+
+// getNamedColors converts the named colours to CIELAB, sorted by name so that ties resolve consistently.
+func getNamedColors() []namedColor {
+	var colors []namedColor
+	for name, hexColor := range namedColorHex {
+		rgb, err := parseHexColor(hexColor)
+		if err != nil {
+			panic(err)
+		}
+		colors = append(colors, namedColor{lab: rgbToLab(rgb.R, rgb.G, rgb.B), name: name})
+	}
+	sort.Slice(colors, func(i, j int) bool { return colors[i].name < colors[j].name })
+	return colors
+}
+
+// getPaletteSize parses and validates the number of colours extracted for each image.
+func getPaletteSize(size string) (int, error) {
+	value, err := strconv.Atoi(size)
+	if err != nil {
+		return 0, err
+	}
+	if value < 0 || value > paletteMaxSize {
+		return 0, fmt.Errorf("palette size %d outside 0-%d", value, paletteMaxSize)
+	}
+	return value, nil
+}
+
+// rgbToLab converts an sRGB colour to CIELAB under the D65 white point.
+func rgbToLab(r, g, b uint8) [3]float64 {
+	linearR, linearG, linearB := srgbLinear(float64(r)/0xFF), srgbLinear(float64(g)/0xFF), srgbLinear(float64(b)/0xFF)
+	x := (0.4124564*linearR + 0.3575761*linearG + 0.1804375*linearB) / 0.95047
+	y := 0.2126729*linearR + 0.7151522*linearG + 0.0721750*linearB
+	z := (0.0193339*linearR + 0.1191920*linearG + 0.9503041*linearB) / 1.08883
+	f := func(t float64) float64 {
+		if t > 216.0/24389 {
+			return math.Cbrt(t)
+		}
+		return (24389.0/27*t + 16) / 116
+	}
+	fx, fy, fz := f(x), f(y), f(z)
+	return [3]float64{116*fy - 16, 500 * (fx - fy), 200 * (fy - fz)}
+}
+
+// getLabDistance returns the squared CIE76 colour difference.
+func getLabDistance(a [3]float64, b [3]float64) float64 {
+	return (a[0]-b[0])*(a[0]-b[0]) + (a[1]-b[1])*(a[1]-b[1]) + (a[2]-b[2])*(a[2]-b[2])
+}
+
+// getNearestColorName returns the name of the named colour closest to the CIELAB colour.
+func getNearestColorName(lab [3]float64) string {
+	nearest, nearestDistance := "", math.Inf(1)
+	for _, color := range namedColors {
+		if distance := getLabDistance(lab, color.lab); distance < nearestDistance {
+			nearest, nearestDistance = color.name, distance
+		}
+	}
+	return nearest
+}
+
+// getPalette returns up to size dominant colours of the image, ordered by decreasing weight.
+func getPalette(imageSource image.Image, size int) []PaletteColor {
+	if size == 0 {
+		return nil
+	}
+	thumbnail := getPlaceholderThumbnail(imageSource, paletteThumbnailSize)
+	count := thumbnail.Rect.Dx() * thumbnail.Rect.Dy()
+	pixels := make([][3]float64, count)
+	for i := range pixels {
+		pixel := thumbnail.Pix[i*4 : i*4+3]
+		pixels[i] = rgbToLab(pixel[0], pixel[1], pixel[2])
+	}
+
+	// Seed the clusters with k-means++, using a fixed seed so that an image always produces the same palette.
+	random := rand.New(rand.NewSource(paletteSeed))
+	centroids := [][3]float64{pixels[random.Intn(count)]}
+	distances := make([]float64, count)
+	for i := range distances {
+		distances[i] = math.Inf(1)
+	}
+	for len(centroids) < size {
+		// Each pixel is chosen with probability proportional to its squared distance from the nearest centroid.
+		total := 0.0
+		for i, pixel := range pixels {
+			distances[i] = math.Min(distances[i], getLabDistance(pixel, centroids[len(centroids)-1]))
+			total += distances[i]
+		}
+		if total == 0 {
+			// The image has fewer distinct colours than the palette size.
+			break
+		}
+		target := random.Float64() * total
+		next := count - 1
+		for i, distance := range distances {
+			if target -= distance; target <= 0 {
+				next = i
+				break
+			}
+		}
+		centroids = append(centroids, pixels[next])
+	}
+
+	// Refine the clusters with Lloyd's algorithm until the assignments stop changing.
+	assignments := make([]int, count)
+	for iteration := 0; iteration < paletteIterations; iteration++ {
+		changed := false
+		for i, pixel := range pixels {
+			nearest, nearestDistance := 0, math.Inf(1)
+			for j, centroid := range centroids {
+				if distance := getLabDistance(pixel, centroid); distance < nearestDistance {
+					nearest, nearestDistance = j, distance
+				}
+			}
+			if assignments[i] != nearest || iteration == 0 {
+				assignments[i] = nearest
+				changed = true
+			}
+		}
+		if !changed {
+			break
+		}
+		sums := make([][3]float64, len(centroids))
+		counts := make([]int, len(centroids))
+		for i, pixel := range pixels {
+			for channel := range pixel {
+				sums[assignments[i]][channel] += pixel[channel]
+			}
+			counts[assignments[i]]++
+		}
+		for j := range centroids {
+			if counts[j] > 0 {
+				centroids[j] = [3]float64{sums[j][0] / float64(counts[j]), sums[j][1] / float64(counts[j]), sums[j][2] / float64(counts[j])}
+			}
+		}
+	}
+
+	// Describe each cluster by the mean sRGB colour of its pixels.
+	rgbSums := make([][3]int, len(centroids))
+	counts := make([]int, len(centroids))
+	for i := range pixels {
+		pixel := thumbnail.Pix[i*4 : i*4+3]
+		for channel := range rgbSums[assignments[i]] {
+			rgbSums[assignments[i]][channel] += int(pixel[channel])
+		}
+		counts[assignments[i]]++
+	}
+	var palette []PaletteColor
+	for j, centroid := range centroids {
+		if counts[j] == 0 {
+			continue
+		}
+		palette = append(palette, PaletteColor{
+			Hex:    fmt.Sprintf("#%02X%02X%02X", rgbSums[j][0]/counts[j], rgbSums[j][1]/counts[j], rgbSums[j][2]/counts[j]),
+			Lab:    [3]float64{math.Round(centroid[0]*100) / 100, math.Round(centroid[1]*100) / 100, math.Round(centroid[2]*100) / 100},
+			Name:   getNearestColorName(centroid),
+			Weight: math.Round(float64(counts[j])/float64(count)*10000) / 10000})
+	}
+	sort.SliceStable(palette, func(i, j int) bool { return palette[i].Weight > palette[j].Weight })
+	return palette
+}
+
+// processS3ObjectImagePalette extracts the dominant colours of the image and records them in the metadata.
+func processS3ObjectImagePalette(imageMetadata *ImageMetadata, imageSource image.Image) {
+	imageMetadata.Palette = getPalette(imageSource, imagePaletteSize)
+	for _, paletteColor := range imageMetadata.Palette {
+		log.Printf("Palette: Hex=%s Name=%s Weight=%v", paletteColor.Hex, paletteColor.Name, paletteColor.Weight)
+	}
+}
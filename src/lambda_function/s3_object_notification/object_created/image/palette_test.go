@@ -0,0 +1,54 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"testing"
+)
+
+func TestRGBToLab(t *testing.T) {
+	for _, test := range []struct {
+		rgb color.RGBA
+		lab [3]float64
+	}{
+		{color.RGBA{0xFF, 0xFF, 0xFF, 0xFF}, [3]float64{100, 0, 0}},
+		{color.RGBA{0, 0, 0, 0xFF}, [3]float64{0, 0, 0}},
+		{color.RGBA{0xFF, 0, 0, 0xFF}, [3]float64{53.24, 80.09, 67.20}},
+	} {
+		lab := rgbToLab(test.rgb.R, test.rgb.G, test.rgb.B)
+		for channel := range lab {
+			if math.Abs(lab[channel]-test.lab[channel]) > 0.05 {
+				t.Errorf("RGBToLab: %v got %v want %v", test.rgb, lab, test.lab)
+			}
+		}
+	}
+}
+
+func TestGetPalette(t *testing.T) {
+	// Three quarters of the image are blue and the rest is red.
+	imageSource := image.NewRGBA(image.Rect(0, 0, 400, 400))
+	draw.Draw(imageSource, imageSource.Bounds(), image.NewUniform(color.RGBA{0, 0, 0xFF, 0xFF}), image.Point{}, draw.Src)
+	draw.Draw(imageSource, image.Rect(0, 0, 100, 400), image.NewUniform(color.RGBA{0xFF, 0, 0, 0xFF}), image.Point{}, draw.Src)
+
+	palette := getPalette(imageSource, 5)
+	if len(palette) != 2 {
+		t.Fatalf("GetPalette: %+v", palette)
+	}
+	if palette[0].Hex != "#0000FF" || palette[0].Name != "Blue" || palette[0].Weight != 0.75 {
+		t.Errorf("GetPalette: %+v", palette[0])
+	}
+	if palette[1].Hex != "#FF0000" || palette[1].Name != "Red" || palette[1].Weight != 0.25 {
+		t.Errorf("GetPalette: %+v", palette[1])
+	}
+	if palette := getPalette(imageSource, 0); palette != nil {
+		t.Errorf("GetPalette: %+v", palette)
+	}
+}
+
+func TestGetNearestColorName(t *testing.T) {
+	if name := getNearestColorName(rgbToLab(0xF0, 0xA0, 0x10)); name != "Orange" {
+		t.Errorf("GetNearestColorName: %s", name)
+	}
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"image"
+	"log"
+	"math"
+	"strings"
+)
+
+// Contains functions for encoding compact placeholders that front ends display while renditions load.
+
+// Constants used to encode the placeholders.
+const (
+	blurHashCharacters      = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz#$%*+,-.:;=?@[]^_{|}~"
+	blurHashComponentsLong  = 4
+	blurHashComponentsShort = 3
+	blurHashSize            = 32
+	thumbHashSize           = 100
+)
+
+// ImagePlaceholder contains low resolution representations of an image. AspectRatio is the width divided by the
+// height and AverageColor is the mean sRGB colour in the #RRGGBB format. BlurHash and ThumbHash are the image encoded
+// in the formats described at https://blurha.sh and https://evanw.github.io/thumbhash, with ThumbHash encoded as
+// standard base64.
+type ImagePlaceholder struct {
+	AspectRatio  float64 `json:"AspectRatio"`
+	AverageColor string  `json:"AverageColor"`
+	BlurHash     string  `json:"BlurHash"`
+	ThumbHash    string  `json:"ThumbHash"`
+}
+
+// getImagePlaceholder computes the placeholders of the image.
+func getImagePlaceholder(imageSource image.Image) *ImagePlaceholder {
+	bounds := imageSource.Bounds()
+	return &ImagePlaceholder{
+		AspectRatio:  math.Round(float64(bounds.Dx())/float64(bounds.Dy())*10000) / 10000,
+		AverageColor: getAverageColor(thumbnailImage(imageSource, 1, 1)),
+		BlurHash:     getBlurHash(imageSource),
+		ThumbHash:    base64.StdEncoding.EncodeToString(getThumbHash(imageSource))}
+}
+
+// getPlaceholderThumbnail reduces the image to fit within the size, preserving its aspect ratio.
+func getPlaceholderThumbnail(imageSource image.Image, size int) *image.RGBA {
+	bounds := imageSource.Bounds()
+	scale := math.Min(1, float64(size)/float64(max(bounds.Dx(), bounds.Dy())))
+	return thumbnailImage(imageSource, max(1, int(math.Round(float64(bounds.Dx())*scale))), max(1, int(math.Round(float64(bounds.Dy())*scale))))
+}
+
+// getAverageColor formats the first pixel of the thumbnail in the #RRGGBB format.
+func getAverageColor(thumbnail *image.RGBA) string {
+	return fmt.Sprintf("#%02X%02X%02X", thumbnail.Pix[0], thumbnail.Pix[1], thumbnail.Pix[2])
+}
+
+// getBlurHash encodes the image as a BlurHash, using more components along the image's longer side.
+func getBlurHash(imageSource image.Image) string {
+	thumbnail := getPlaceholderThumbnail(imageSource, blurHashSize)
+	width, height := thumbnail.Rect.Dx(), thumbnail.Rect.Dy()
+	componentsX, componentsY := blurHashComponentsLong, blurHashComponentsShort
+	if height > width {
+		componentsX, componentsY = componentsY, componentsX
+	}
+
+	// Compute the DCT factors of each channel in linear light.
+	factors := make([][3]float64, 0, componentsX*componentsY)
+	for j := 0; j < componentsY; j++ {
+		for i := 0; i < componentsX; i++ {
+			normalisation := 2.0
+			if i == 0 && j == 0 {
+				normalisation = 1
+			}
+			var factor [3]float64
+			for y := 0; y < height; y++ {
+				for x := 0; x < width; x++ {
+					basis := normalisation * math.Cos(math.Pi*float64(i*x)/float64(width)) * math.Cos(math.Pi*float64(j*y)/float64(height))
+					pixel := thumbnail.Pix[thumbnail.PixOffset(x, y):]
+					for channel := range factor {
+						factor[channel] += basis * srgbLinear(float64(pixel[channel])/0xFF)
+					}
+				}
+			}
+			for channel := range factor {
+				factor[channel] /= float64(width * height)
+			}
+			factors = append(factors, factor)
+		}
+	}
+
+	var blurHash strings.Builder
+	blurHash.WriteString(encodeBase83((componentsX-1)+(componentsY-1)*9, 1))
+	maximumValue := 0.0
+	for _, factor := range factors[1:] {
+		for _, value := range factor {
+			maximumValue = math.Max(maximumValue, math.Abs(value))
+		}
+	}
+	quantisedMaximumValue := max(0, min(82, int(math.Floor(maximumValue*166-0.5))))
+	maximumValue = float64(quantisedMaximumValue+1) / 166
+	blurHash.WriteString(encodeBase83(quantisedMaximumValue, 1))
+
+	// The DC component is the average colour, quantised to 8 bits per channel.
+	dc := 0
+	for _, value := range factors[0] {
+		dc = dc<<8 | int(math.Round(srgbEncode(math.Max(0, math.Min(1, value)))*0xFF))
+	}
+	blurHash.WriteString(encodeBase83(dc, 4))
+
+	// The AC components are quantised to 19 levels per channel relative to the maximum value.
+	for _, factor := range factors[1:] {
+		ac := 0
+		for _, value := range factor {
+			quantised := math.Floor(math.Copysign(math.Sqrt(math.Abs(value/maximumValue)), value)*9 + 9.5)
+			ac = ac*19 + max(0, min(18, int(quantised)))
+		}
+		blurHash.WriteString(encodeBase83(ac, 2))
+	}
+	return blurHash.String()
+}
+
+// encodeBase83 encodes the value as the given number of BlurHash base 83 digits.
+func encodeBase83(value int, length int) string {
+	digits := make([]byte, length)
+	for i := length - 1; i >= 0; i-- {
+		digits[i] = blurHashCharacters[value%83]
+		value /= 83
+	}
+	return string(digits)
+}
+
+// getThumbHash encodes the image as a ThumbHash, following the reference implementation. Transparent pixels are
+// composited over the average colour and the alpha channel is only encoded when the image is not fully opaque.
+func getThumbHash(imageSource image.Image) []byte {
+	thumbnail := getPlaceholderThumbnail(imageSource, thumbHashSize)
+	width, height := thumbnail.Rect.Dx(), thumbnail.Rect.Dy()
+	count := width * height
+
+	// Determine the average colour. The thumbnail's pixels are premultiplied by alpha.
+	var averageR, averageG, averageB, averageA float64
+	for i := 0; i < count; i++ {
+		pixel := thumbnail.Pix[i*4 : i*4+4]
+		averageR += float64(pixel[0]) / 0xFF
+		averageG += float64(pixel[1]) / 0xFF
+		averageB += float64(pixel[2]) / 0xFF
+		averageA += float64(pixel[3]) / 0xFF
+	}
+	if averageA > 0 {
+		averageR, averageG, averageB = averageR/averageA, averageG/averageA, averageB/averageA
+	}
+	hasAlpha := averageA < float64(count)
+	luminanceLimit := 7.0
+	if hasAlpha {
+		luminanceLimit = 5
+	}
+	luminanceX := max(1, int(math.Round(luminanceLimit*float64(width)/float64(max(width, height)))))
+	luminanceY := max(1, int(math.Round(luminanceLimit*float64(height)/float64(max(width, height)))))
+
+	// Convert the image to luminance, yellow-blue, red-green and alpha channels.
+	l, p, q, a := make([]float64, count), make([]float64, count), make([]float64, count), make([]float64, count)
+	for i := 0; i < count; i++ {
+		pixel := thumbnail.Pix[i*4 : i*4+4]
+		alpha := float64(pixel[3]) / 0xFF
+		r := averageR*(1-alpha) + float64(pixel[0])/0xFF
+		g := averageG*(1-alpha) + float64(pixel[1])/0xFF
+		b := averageB*(1-alpha) + float64(pixel[2])/0xFF
+		l[i] = (r + g + b) / 3
+		p[i] = (r+g)/2 - b
+		q[i] = r - g
+		a[i] = alpha
+	}
+
+	lDC, lAC, lScale := encodeThumbHashChannel(l, width, height, max(3, luminanceX), max(3, luminanceY))
+	pDC, pAC, pScale := encodeThumbHashChannel(p, width, height, 3, 3)
+	qDC, qAC, qScale := encodeThumbHashChannel(q, width, height, 3, 3)
+
+	// Write the header containing the constant terms and scales.
+	isLandscape := width > height
+	header24 := int(math.Round(63*lDC)) | int(math.Round(31.5+31.5*pDC))<<6 | int(math.Round(31.5+31.5*qDC))<<12 | int(math.Round(31*lScale))<<18
+	header16 := luminanceX | int(math.Round(63*pScale))<<3 | int(math.Round(63*qScale))<<9
+	if isLandscape {
+		header16 = header16&^7 | luminanceY | 1<<15
+	}
+	if hasAlpha {
+		header24 |= 1 << 23
+	}
+	acs := [][]float64{lAC, pAC, qAC}
+	thumbHash := []byte{byte(header24), byte(header24 >> 8), byte(header24 >> 16), byte(header16), byte(header16 >> 8)}
+	if hasAlpha {
+		aDC, aAC, aScale := encodeThumbHashChannel(a, width, height, 5, 5)
+		thumbHash = append(thumbHash, byte(int(math.Round(15*aDC))|int(math.Round(15*aScale))<<4))
+		acs = append(acs, aAC)
+	}
+
+	// Write the varying terms as 4-bit values, two per byte.
+	index := 0
+	for _, ac := range acs {
+		for _, value := range ac {
+			nibble := byte(math.Round(15 * value))
+			if index%2 == 0 {
+				thumbHash = append(thumbHash, nibble)
+			} else {
+				thumbHash[len(thumbHash)-1] |= nibble << 4
+			}
+			index++
+		}
+	}
+	return thumbHash
+}
+
+// encodeThumbHashChannel computes the DCT of a channel, returning the constant term, the varying terms normalised to
+// [0, 1] and their scale.
+func encodeThumbHashChannel(channel []float64, width int, height int, componentsX int, componentsY int) (float64, []float64, float64) {
+	var dc, scale float64
+	var ac []float64
+	fx := make([]float64, width)
+	for cy := 0; cy < componentsY; cy++ {
+		for cx := 0; cx*componentsY < componentsX*(componentsY-cy); cx++ {
+			for x := 0; x < width; x++ {
+				fx[x] = math.Cos(math.Pi / float64(width) * float64(cx) * (float64(x) + 0.5))
+			}
+			f := 0.0
+			for y := 0; y < height; y++ {
+				fy := math.Cos(math.Pi / float64(height) * float64(cy) * (float64(y) + 0.5))
+				for x := 0; x < width; x++ {
+					f += channel[x+y*width] * fx[x] * fy
+				}
+			}
+			f /= float64(width * height)
+			if cx > 0 || cy > 0 {
+				ac = append(ac, f)
+				scale = math.Max(scale, math.Abs(f))
+			} else {
+				dc = f
+			}
+		}
+	}
+	if scale > 0 {
+		for i := range ac {
+			ac[i] = 0.5 + 0.5/scale*ac[i]
+		}
+	}
+	return dc, ac, scale
+}
+
+// processS3ObjectImagePlaceholder computes the placeholders of the image and records them in the metadata.
+func processS3ObjectImagePlaceholder(imageMetadata *ImageMetadata, imageSource image.Image) {
+	imageMetadata.Placeholder = getImagePlaceholder(imageSource)
+	log.Printf("ImagePlaceholder: AspectRatio=%v AverageColor=%s BlurHash=%s ThumbHash=%s",
+		imageMetadata.Placeholder.AspectRatio,
+		imageMetadata.Placeholder.AverageColor,
+		imageMetadata.Placeholder.BlurHash,
+		imageMetadata.Placeholder.ThumbHash)
+}
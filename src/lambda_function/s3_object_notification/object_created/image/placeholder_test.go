@@ -0,0 +1,57 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"testing"
+)
+
+func TestGetBlurHash(t *testing.T) {
+	imageSource := image.NewRGBA(image.Rect(0, 0, 400, 300))
+	draw.Draw(imageSource, imageSource.Bounds(), image.NewUniform(color.RGBA{0xFF, 0, 0, 0xFF}), image.Point{}, draw.Src)
+
+	// Landscape images use 4x3 components and the DC component encodes the average colour.
+	if blurHash := getBlurHash(imageSource); len(blurHash) != 28 || blurHash[0] != 'L' || blurHash[2:6] != "TI:j" {
+		t.Fatalf("GetBlurHash: %s", blurHash)
+	}
+
+	// Portrait images use more vertical components.
+	imageSource = newTestHashImage(1, 300, 400)
+	if blurHash := getBlurHash(imageSource); len(blurHash) != 28 || blurHash[0] != 'T' {
+		t.Fatalf("GetBlurHash: %s", blurHash)
+	}
+}
+
+func TestGetThumbHash(t *testing.T) {
+	imageSource := image.NewRGBA(image.Rect(0, 0, 400, 300))
+	draw.Draw(imageSource, imageSource.Bounds(), image.NewUniform(color.RGBA{0x80, 0x80, 0x80, 0xFF}), image.Point{}, draw.Src)
+	thumbHash := getThumbHash(imageSource)
+	if len(thumbHash) != 21 {
+		t.Fatalf("GetThumbHash: length %d", len(thumbHash))
+	}
+	header24 := int(thumbHash[0]) | int(thumbHash[1])<<8 | int(thumbHash[2])<<16
+	header16 := int(thumbHash[3]) | int(thumbHash[4])<<8
+	if lDC, pDC, qDC, hasAlpha := header24&63, header24>>6&63, header24>>12&63, header24>>23&1; lDC != 32 || pDC != 32 || qDC != 32 || hasAlpha != 0 {
+		t.Fatalf("GetThumbHash: header24=%06x", header24)
+	}
+	if luminanceY, isLandscape := header16&7, header16>>15; luminanceY != 5 || isLandscape != 1 {
+		t.Fatalf("GetThumbHash: header16=%04x", header16)
+	}
+
+	// Transparent images carry an extra alpha byte and use fewer luminance components.
+	imageSource = image.NewRGBA(image.Rect(0, 0, 100, 100))
+	draw.Draw(imageSource, image.Rect(0, 0, 50, 100), image.NewUniform(color.RGBA{0x80, 0, 0, 0x80}), image.Point{}, draw.Src)
+	if thumbHash := getThumbHash(imageSource); thumbHash[2]>>7 != 1 {
+		t.Fatalf("GetThumbHash: %x", thumbHash)
+	}
+}
+
+func TestGetImagePlaceholder(t *testing.T) {
+	imageSource := image.NewRGBA(image.Rect(0, 0, 300, 200))
+	draw.Draw(imageSource, imageSource.Bounds(), image.NewUniform(color.RGBA{0x12, 0x34, 0x56, 0xFF}), image.Point{}, draw.Src)
+	imagePlaceholder := getImagePlaceholder(imageSource)
+	if imagePlaceholder.AspectRatio != 1.5 || imagePlaceholder.AverageColor != "#123456" {
+		t.Fatalf("GetImagePlaceholder: %+v", imagePlaceholder)
+	}
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"image"
+	"log"
+	"math"
+)
+
+// Contains functions for scoring the technical quality of an image so that bad frames can be culled.
+//
+// The image is analysed at a reduced size, which keeps the analysis fast and makes sharpness comparable between
+// images of different resolutions. Sharpness is the variance of the Laplacian, which is high when an image contains
+// crisp edges. It is measured for a grid of regions as well as the whole image, since a portrait with a sharp subject
+// and a blurred background is in focus. Noise is estimated with Immerkær's method and exposure from the share of
+// clipped highlights and shadows.
+
+// Constants used by the quality analyser.
+const (
+	qualityAnalysisSize       = 1024
+	qualityClippingHighlight  = 250
+	qualityClippingMax        = 20
+	qualityClippingShadow     = 5
+	qualityFlagBlurry         = "Blurry"
+	qualityFlagNoisy          = "Noisy"
+	qualityFlagOverexposed    = "Overexposed"
+	qualityFlagUnderexposed   = "Underexposed"
+	qualityHistogramBins      = 256
+	qualityNoiseMax           = 12
+	qualityNoiseMin           = 2
+	qualityRegions            = 3
+	qualitySharpnessMax       = 1000
+	qualitySharpnessMin       = 10
+	qualityThresholdClipping  = 5
+	qualityThresholdNoise     = 8
+	qualityThresholdSharpness = 50
+)
+
+// ImageQuality describes the technical quality of an image. Score combines sharpness, noise and exposure into a
+// value between 0 and 100, and Flags lists the problems found, such as Blurry or Overexposed.
+type ImageQuality struct {
+	Clipping  *ImageClipping  `json:"Clipping"`
+	Flags     []string        `json:"Flags"`
+	Histogram *ImageHistogram `json:"Histogram"`
+	Noise     float64         `json:"Noise"`
+	Score     float64         `json:"Score"`
+	Sharpness *ImageSharpness `json:"Sharpness"`
+}
+
+// ImageClipping contains the percentage of pixels whose luminance is clipped to white or black.
+type ImageClipping struct {
+	Highlights float64 `json:"Highlights"`
+	Shadows    float64 `json:"Shadows"`
+}
+
+// ImageHistogram contains the number of pixels of the analysed image at each 8-bit level.
+type ImageHistogram struct {
+	Blue      []int `json:"Blue"`
+	Green     []int `json:"Green"`
+	Luminance []int `json:"Luminance"`
+	Red       []int `json:"Red"`
+}
+
+// ImageSharpness contains the variance of the Laplacian for the whole image and for each region of a 3x3 grid,
+// listed row by row. Max is the sharpness of the sharpest region.
+type ImageSharpness struct {
+	Global  float64   `json:"Global"`
+	Max     float64   `json:"Max"`
+	Regions []float64 `json:"Regions"`
+}
+
+// getImageQuality analyses the technical quality of the image.
+func getImageQuality(imageSource image.Image) *ImageQuality {
+	thumbnail := getPlaceholderThumbnail(imageSource, qualityAnalysisSize)
+	width, height := thumbnail.Rect.Dx(), thumbnail.Rect.Dy()
+
+	// Compute the luminance and the histograms.
+	histogram := ImageHistogram{
+		Blue:      make([]int, qualityHistogramBins),
+		Green:     make([]int, qualityHistogramBins),
+		Luminance: make([]int, qualityHistogramBins),
+		Red:       make([]int, qualityHistogramBins)}
+	var clipping ImageClipping
+	luminance := make([]float64, width*height)
+	for i := range luminance {
+		pixel := thumbnail.Pix[i*4 : i*4+3]
+		luminance[i] = 0.299*float64(pixel[0]) + 0.587*float64(pixel[1]) + 0.114*float64(pixel[2])
+		level := int(math.Round(luminance[i]))
+		histogram.Red[pixel[0]]++
+		histogram.Green[pixel[1]]++
+		histogram.Blue[pixel[2]]++
+		histogram.Luminance[level]++
+		if level >= qualityClippingHighlight {
+			clipping.Highlights++
+		} else if level <= qualityClippingShadow {
+			clipping.Shadows++
+		}
+	}
+	clipping.Highlights = roundQuality(clipping.Highlights * 100 / float64(len(luminance)))
+	clipping.Shadows = roundQuality(clipping.Shadows * 100 / float64(len(luminance)))
+
+	imageQuality := ImageQuality{
+		Clipping:  &clipping,
+		Histogram: &histogram,
+		Noise:     roundQuality(getNoiseLevel(luminance, width, height)),
+		Sharpness: getSharpness(luminance, width, height)}
+
+	// Score each aspect between 0 and 1. Sharpness is scored logarithmically as the variance of the Laplacian
+	// spans several orders of magnitude.
+	sharpnessScore := clampUnit((math.Log10(math.Max(imageQuality.Sharpness.Max, 1)) - math.Log10(qualitySharpnessMin)) / (math.Log10(qualitySharpnessMax) - math.Log10(qualitySharpnessMin)))
+	noiseScore := clampUnit(1 - (imageQuality.Noise-qualityNoiseMin)/(qualityNoiseMax-qualityNoiseMin))
+	exposureScore := clampUnit(1 - (clipping.Highlights+clipping.Shadows)/qualityClippingMax)
+	imageQuality.Score = math.Round(100 * (0.5*sharpnessScore + 0.25*noiseScore + 0.25*exposureScore))
+
+	if imageQuality.Sharpness.Max < qualityThresholdSharpness {
+		imageQuality.Flags = append(imageQuality.Flags, qualityFlagBlurry)
+	}
+	if imageQuality.Noise > qualityThresholdNoise {
+		imageQuality.Flags = append(imageQuality.Flags, qualityFlagNoisy)
+	}
+	if clipping.Highlights > qualityThresholdClipping {
+		imageQuality.Flags = append(imageQuality.Flags, qualityFlagOverexposed)
+	}
+	if clipping.Shadows > qualityThresholdClipping {
+		imageQuality.Flags = append(imageQuality.Flags, qualityFlagUnderexposed)
+	}
+	return &imageQuality
+}
+
+// getSharpness computes the variance of the 4-neighbour Laplacian of the luminance over the whole image and over
+// each region.
+func getSharpness(luminance []float64, width int, height int) *ImageSharpness {
+	var sum, sumSquares [qualityRegions * qualityRegions]float64
+	var counts [qualityRegions * qualityRegions]int
+	var globalSum, globalSumSquares float64
+	globalCount := 0
+	for y := 1; y < height-1; y++ {
+		for x := 1; x < width-1; x++ {
+			i := y*width + x
+			laplacian := luminance[i-width] + luminance[i+width] + luminance[i-1] + luminance[i+1] - 4*luminance[i]
+			region := y*qualityRegions/height*qualityRegions + x*qualityRegions/width
+			sum[region] += laplacian
+			sumSquares[region] += laplacian * laplacian
+			counts[region]++
+			globalSum += laplacian
+			globalSumSquares += laplacian * laplacian
+			globalCount++
+		}
+	}
+	variance := func(sum float64, sumSquares float64, count int) float64 {
+		if count == 0 {
+			return 0
+		}
+		mean := sum / float64(count)
+		return roundQuality(sumSquares/float64(count) - mean*mean)
+	}
+	imageSharpness := ImageSharpness{
+		Global:  variance(globalSum, globalSumSquares, globalCount),
+		Regions: make([]float64, len(counts))}
+	for region := range counts {
+		imageSharpness.Regions[region] = variance(sum[region], sumSquares[region], counts[region])
+		imageSharpness.Max = math.Max(imageSharpness.Max, imageSharpness.Regions[region])
+	}
+	return &imageSharpness
+}
+
+// getNoiseLevel estimates the standard deviation of Gaussian noise in the luminance using Immerkær's method, which
+// convolves the image with a mask that cancels out image structure and leaves the noise.
+func getNoiseLevel(luminance []float64, width int, height int) float64 {
+	if width < 3 || height < 3 {
+		return 0
+	}
+	sum := 0.0
+	for y := 1; y < height-1; y++ {
+		for x := 1; x < width-1; x++ {
+			i := y*width + x
+			value := luminance[i-width-1] - 2*luminance[i-width] + luminance[i-width+1] -
+				2*luminance[i-1] + 4*luminance[i] - 2*luminance[i+1] +
+				luminance[i+width-1] - 2*luminance[i+width] + luminance[i+width+1]
+			sum += math.Abs(value)
+		}
+	}
+	return sum * math.Sqrt(math.Pi/2) / (6 * float64(width-2) * float64(height-2))
+}
+
+// clampUnit clamps the value to the range [0, 1].
+func clampUnit(value float64) float64 {
+	return math.Max(0, math.Min(1, value))
+}
+
+// roundQuality rounds a quality measurement to two decimal places.
+func roundQuality(value float64) float64 {
+	return math.Round(value*100) / 100
+}
+
+// processS3ObjectImageQuality scores the technical quality of the image and records it in the metadata.
+func processS3ObjectImageQuality(imageMetadata *ImageMetadata, imageSource image.Image) {
+	imageMetadata.Quality = getImageQuality(imageSource)
+	log.Printf("ImageQuality: Score=%v Sharpness=%v Noise=%v Clipping.Highlights=%v Clipping.Shadows=%v Flags=%v",
+		imageMetadata.Quality.Score,
+		imageMetadata.Quality.Sharpness.Max,
+		imageMetadata.Quality.Noise,
+		imageMetadata.Quality.Clipping.Highlights,
+		imageMetadata.Quality.Clipping.Shadows,
+		imageMetadata.Quality.Flags)
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"math/rand"
+	"slices"
+	"testing"
+
+	"golang.org/x/image/draw"
+)
+
+// newTestCheckerboardImage creates a black and white checkerboard with squares of the given size.
+func newTestCheckerboardImage(width int, height int, size int) *image.RGBA {
+	imageSource := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			value := uint8(64)
+			if (x/size+y/size)%2 == 0 {
+				value = 192
+			}
+			imageSource.Set(x, y, color.Gray{Y: value})
+		}
+	}
+	return imageSource
+}
+
+func TestGetImageQualitySharpness(t *testing.T) {
+	sharp := getImageQuality(newTestCheckerboardImage(800, 600, 8))
+	blurredImage := image.NewRGBA(image.Rect(0, 0, 800, 600))
+	draw.BiLinear.Scale(blurredImage, blurredImage.Bounds(), thumbnailImage(newTestCheckerboardImage(800, 600, 8), 40, 30), image.Rect(0, 0, 40, 30), draw.Src, nil)
+	blurred := getImageQuality(blurredImage)
+	if sharp.Sharpness.Max <= blurred.Sharpness.Max || sharp.Score <= blurred.Score {
+		t.Fatalf("GetImageQuality: sharp=%+v blurred=%+v", sharp.Sharpness, blurred.Sharpness)
+	}
+	if slices.Contains(sharp.Flags, qualityFlagBlurry) || !slices.Contains(blurred.Flags, qualityFlagBlurry) {
+		t.Fatalf("GetImageQuality: sharp=%v blurred=%v", sharp.Flags, blurred.Flags)
+	}
+
+	// Only the region containing detail is sharp.
+	imageSource := image.NewRGBA(image.Rect(0, 0, 900, 900))
+	draw.Draw(imageSource, imageSource.Bounds(), image.NewUniform(color.Gray{Y: 128}), image.Point{}, draw.Src)
+	draw.Draw(imageSource, image.Rect(300, 300, 600, 600), newTestCheckerboardImage(300, 300, 4), image.Point{}, draw.Src)
+	imageSharpness := getImageQuality(imageSource).Sharpness
+	if imageSharpness.Regions[4] != imageSharpness.Max || imageSharpness.Regions[0] != 0 {
+		t.Fatalf("GetImageQuality: %+v", imageSharpness)
+	}
+}
+
+func TestGetNoiseLevel(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+	width, height := 500, 500
+	luminance := make([]float64, width*height)
+	for i := range luminance {
+		luminance[i] = 128 + random.NormFloat64()*10
+	}
+	if noise := getNoiseLevel(luminance, width, height); math.Abs(noise-10) > 0.5 {
+		t.Fatalf("GetNoiseLevel: %v", noise)
+	}
+}
+
+func TestGetImageQualityClipping(t *testing.T) {
+	imageSource := image.NewRGBA(image.Rect(0, 0, 400, 400))
+	draw.Draw(imageSource, imageSource.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	draw.Draw(imageSource, image.Rect(0, 0, 400, 100), image.NewUniform(color.Gray{Y: 128}), image.Point{}, draw.Src)
+	imageQuality := getImageQuality(imageSource)
+	if imageQuality.Clipping.Highlights != 75 || imageQuality.Clipping.Shadows != 0 || imageQuality.Histogram.Luminance[255] != 120000 {
+		t.Fatalf("GetImageQuality: %+v", imageQuality.Clipping)
+	}
+	if !slices.Contains(imageQuality.Flags, qualityFlagOverexposed) {
+		t.Fatalf("GetImageQuality: %v", imageQuality.Flags)
+	}
+}
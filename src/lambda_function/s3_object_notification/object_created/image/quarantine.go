@@ -0,0 +1,91 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"path"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// Contains functions for moving rejected uploads to the quarantine folder.
+
+// QuarantineRecord is stored as JSON next to a quarantined file and explains why it was rejected.
+type QuarantineRecord struct {
+	Detail        string    `json:"Detail"`
+	Key           string    `json:"Key"`
+	QuarantineKey string    `json:"QuarantineKey"`
+	QuarantinedAt time.Time `json:"QuarantinedAt"`
+	Reason        string    `json:"Reason"`
+}
+
+// getImageValidationError classifies an error returned while decoding an image. Decoding errors mean the file is
+// truncated or corrupt, unlike errors reading the file, which are returned unchanged.
+func getImageValidationError(err error) error {
+	if err == nil {
+		return nil
+	}
+	var imageValidationError *ImageValidationError
+	if errors.As(err, &imageValidationError) {
+		return err
+	}
+	var pathError *fs.PathError
+	if errors.As(err, &pathError) {
+		return err
+	}
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return newImageValidationError(validationReasonTruncated, "%s", err)
+	}
+	return newImageValidationError(validationReasonCorrupt, "%s", err)
+}
+
+// processS3ObjectQuarantine moves the uploaded object to the quarantine folder and stores a QuarantineRecord
+// describing why it was rejected.
+func processS3ObjectQuarantine(s3Client *s3.S3, s3BucketName string, s3ObjectKey string, imageValidationError *ImageValidationError) {
+	quarantinedAt := time.Now().UTC()
+	quarantineRecord := QuarantineRecord{
+		Detail:        imageValidationError.Detail,
+		Key:           s3ObjectKey,
+		QuarantineKey: createS3ObjectKey(s3BucketFolderQuarantine, path.Base(s3ObjectKey), quarantinedAt),
+		QuarantinedAt: quarantinedAt,
+		Reason:        imageValidationError.Reason}
+	log.Printf("Quarantine: Bucket=%s Key=%s QuarantineKey=%s Reason=%s Detail=%s",
+		s3BucketName,
+		s3ObjectKey,
+		quarantineRecord.QuarantineKey,
+		quarantineRecord.Reason,
+		quarantineRecord.Detail)
+
+	// Copy the object before deleting it, so that a failure never loses the upload.
+	if _, err := copyS3Object(s3Client, s3BucketName, s3ObjectKey, quarantineRecord.QuarantineKey); err != nil {
+		log.Fatalf("Quarantine: Error=%s", err)
+	}
+	s3PutObjectOutput, err := putS3ObjectJSON(s3Client, s3BucketName, fmt.Sprintf("%s.JSON", quarantineRecord.QuarantineKey), &quarantineRecord)
+	if err != nil {
+		log.Fatalf("Quarantine: Error=%s", err)
+	}
+	processS3PutObjectOutput(s3PutObjectOutput)
+	if _, err := deleteS3Object(s3Client, s3BucketName, s3ObjectKey); err != nil {
+		log.Fatalf("Quarantine: Error=%s", err)
+	}
+}
+
+// processS3ObjectValidation validates the downloaded file and quarantines it if it is rejected.
+// It returns false if the file was quarantined and should not be processed any further.
+func processS3ObjectValidation(s3Client *s3.S3, s3BucketName string, s3ObjectKey string, fileName string) bool {
+	err := openImageValidation(fileName)
+	var imageValidationError *ImageValidationError
+	if errors.As(err, &imageValidationError) {
+		processS3ObjectQuarantine(s3Client, s3BucketName, s3ObjectKey, imageValidationError)
+		return false
+	}
+	if err != nil {
+		log.Fatalf("ImageValidation: Error=%s", err)
+	}
+	log.Printf("ImageValidation: Key=%s Valid=true", s3ObjectKey)
+	return true
+}
@@ -0,0 +1,370 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"log"
+	"math"
+	"regexp"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/rekognition"
+)
+
+// Contains functions for redacting faces and text in renditions so that bystanders who have not consented, number
+// plates and contact details are not published.
+//
+// Faces are located with the DetectFaces output stored by the image_compressed Lambda when its status record shows it
+// was produced for the current compressed image, and otherwise with a direct DetectFaces call, because a new image has
+// not been analysed yet when its renditions are produced. Text is always located with a direct DetectText call without
+// filters, because the output stored by the image_compressed Lambda leaves out the words removed by its configured
+// word filter and regions of interest, and a filtered out phone number must still be redacted. Faces that match the
+// consent collection stay unredacted; every other face is blurred or pixelated. Redaction fails closed: a face is only
+// left visible when Rekognition positively matches it. Text is redacted when a detected line or word matches one of
+// the rendition's patterns, using the detection's polygon so that rotated text is covered.
+
+// Constants used by the redaction stage.
+const (
+	redactionDefaultPadding    = 0.2
+	redactionDefaultResolution = 8
+	redactionFaceSearchPadding = 0.5
+	redactionMethodBlur        = "Blur"
+	redactionMethodPixelate    = "Pixelate"
+	redactionTextEmail         = "Email"
+	redactionTextLicensePlate  = "LicensePlate"
+	redactionTextPadding       = 0.1
+	redactionTextPhoneNumber   = "PhoneNumber"
+)
+
+// redactionTextPatterns are the built-in text patterns. Number plate formats vary between countries, so the pattern
+// matches short mixes of letters and digits in the common layouts rather than any one country's format.
+var redactionTextPatterns = map[string]*regexp.Regexp{
+	redactionTextEmail:        regexp.MustCompile(`(?i)[A-Z0-9._%+-]+@[A-Z0-9.-]+\.[A-Z]{2,}`),
+	redactionTextLicensePlate: regexp.MustCompile(`^(?:[A-Z]{1,3}[ -]?[0-9]{1,4}[ -]?[A-Z]{0,3}|[0-9]{1,3}[ -]?[A-Z]{1,3}[ -]?[0-9]{1,4}|[A-Z]{2}[0-9]{2}[ -]?[A-Z]{3}|[A-Z]{1,3}[ -][A-Z]{1,2}[ -]?[0-9]{1,4})$`),
+	redactionTextPhoneNumber:  regexp.MustCompile(`\+?[0-9(][0-9 ().-]{6,}[0-9]`)}
+
+// Redaction describes how faces are obscured in a rendition. Method is either Blur or Pixelate. Padding enlarges the
+// face bounding box by a fraction of its size on every side, so that hair and ears are covered, and Resolution is the
+// number of pixelation blocks or blur widths across a face, where lower values obscure more. Text lists the patterns
+// of text to redact, either the names of built-in patterns, Email, LicensePlate and PhoneNumber, or regular
+// expressions.
+type Redaction struct {
+	Method       string   `json:"Method"`
+	Padding      float64  `json:"Padding"`
+	Resolution   int      `json:"Resolution"`
+	Text         []string `json:"Text"`
+	textPatterns []*regexp.Regexp
+}
+
+// RedactionTargets holds the faces and text detected in an image that redacted renditions may obscure.
+type RedactionTargets struct {
+	Faces []*rekognition.BoundingBox
+	Text  []*rekognition.TextDetection
+}
+
+// validateRedaction checks that the redaction is usable, filling in the default padding and resolution and compiling
+// the text patterns.
+func validateRedaction(redaction *Redaction) error {
+	if redaction.Method != redactionMethodBlur && redaction.Method != redactionMethodPixelate {
+		return fmt.Errorf("unknown redaction method %q", redaction.Method)
+	}
+	if redaction.Padding == 0 {
+		redaction.Padding = redactionDefaultPadding
+	}
+	if redaction.Padding < 0 || redaction.Padding > 1 {
+		return fmt.Errorf("redaction padding %v outside 0-1", redaction.Padding)
+	}
+	if redaction.Resolution == 0 {
+		redaction.Resolution = redactionDefaultResolution
+	}
+	if redaction.Resolution < 1 || redaction.Resolution > 64 {
+		return fmt.Errorf("redaction resolution %d outside 1-64", redaction.Resolution)
+	}
+	redaction.textPatterns = nil
+	for _, text := range redaction.Text {
+		textPattern, ok := redactionTextPatterns[text]
+		if !ok {
+			var err error
+			if textPattern, err = regexp.Compile(text); err != nil {
+				return fmt.Errorf("invalid redaction text pattern %q: %w", text, err)
+			}
+		}
+		redaction.textPatterns = append(redaction.textPatterns, textPattern)
+	}
+	return nil
+}
+
+// getFaceMatchThreshold parses and validates the similarity a face needs to match the consent collection.
+func getFaceMatchThreshold(threshold string) (float64, error) {
+	value, err := strconv.ParseFloat(threshold, 64)
+	if err != nil {
+		return 0, err
+	}
+	if value < 0 || value > 100 {
+		return 0, fmt.Errorf("face match threshold %v outside 0-100", value)
+	}
+	return value, nil
+}
+
+// getRedactionRectangle maps a face bounding box, relative to the whole source image, to the pixels of a rendition
+// that was cropped from the source and resized to the destination rectangle. The box is enlarged by the padding.
+func getRedactionRectangle(boundingBox *rekognition.BoundingBox, imageSourceRectangle image.Rectangle, cropRectangle *CropRectangle, imageDestinationRectangle image.Rectangle, padding float64) image.Rectangle {
+	left, top := aws.Float64Value(boundingBox.Left), aws.Float64Value(boundingBox.Top)
+	width, height := aws.Float64Value(boundingBox.Width), aws.Float64Value(boundingBox.Height)
+	left, top, width, height = left-width*padding, top-height*padding, width*(1+2*padding), height*(1+2*padding)
+
+	// Convert to source pixels, then to the region of the source shown in the rendition.
+	region := imageSourceRectangle
+	if cropRectangle != nil {
+		region = image.Rect(cropRectangle.X, cropRectangle.Y, cropRectangle.X+cropRectangle.Width, cropRectangle.Y+cropRectangle.Height)
+	}
+	scaleX := float64(imageDestinationRectangle.Dx()) / float64(region.Dx())
+	scaleY := float64(imageDestinationRectangle.Dy()) / float64(region.Dy())
+	x0 := (float64(imageSourceRectangle.Min.X) + left*float64(imageSourceRectangle.Dx()) - float64(region.Min.X)) * scaleX
+	y0 := (float64(imageSourceRectangle.Min.Y) + top*float64(imageSourceRectangle.Dy()) - float64(region.Min.Y)) * scaleY
+	x1 := x0 + width*float64(imageSourceRectangle.Dx())*scaleX
+	y1 := y0 + height*float64(imageSourceRectangle.Dy())*scaleY
+	rectangle := image.Rect(int(math.Floor(x0)), int(math.Floor(y0)), int(math.Ceil(x1)), int(math.Ceil(y1)))
+	return rectangle.Add(imageDestinationRectangle.Min).Intersect(imageDestinationRectangle)
+}
+
+// applyRedaction obscures each rectangle of the image in place and returns the number of rectangles redacted.
+func applyRedaction(imageDestination *image.RGBA, rectangles []image.Rectangle, redaction *Redaction) int {
+	count := 0
+	for _, rectangle := range rectangles {
+		if rectangle.Empty() {
+			continue
+		}
+		size := float64(max(rectangle.Dx(), rectangle.Dy())) / float64(redaction.Resolution)
+		switch redaction.Method {
+		case redactionMethodBlur:
+			blurRectangle(imageDestination, rectangle, math.Max(1, size))
+		case redactionMethodPixelate:
+			pixelateRectangle(imageDestination, rectangle, max(1, int(math.Ceil(size))))
+		}
+		count++
+	}
+	return count
+}
+
+// blurRectangle applies a Gaussian blur to a rectangle of the image in place. Pixels outside the rectangle are read
+// but not changed, so that the blurred area blends with its surroundings.
+func blurRectangle(imageDestination *image.RGBA, rectangle image.Rectangle, sigma float64) {
+	kernel := getGaussianKernel(sigma)
+	radius := len(kernel) / 2
+	bounds := imageDestination.Rect
+	at := func(x, y, channel int) float64 {
+		x = min(bounds.Max.X-1, max(bounds.Min.X, x))
+		y = min(bounds.Max.Y-1, max(bounds.Min.Y, y))
+		return float64(imageDestination.Pix[imageDestination.PixOffset(x, y)+channel])
+	}
+
+	// Blur horizontally over the rows the vertical pass reads, then vertically within the rectangle.
+	rows := rectangle.Inset(-radius).Intersect(bounds)
+	width := rectangle.Dx()
+	horizontal := make([]float64, width*rows.Dy()*4)
+	for y := rows.Min.Y; y < rows.Max.Y; y++ {
+		for x := rectangle.Min.X; x < rectangle.Max.X; x++ {
+			for channel := 0; channel < 4; channel++ {
+				sum := 0.0
+				for k, weight := range kernel {
+					sum += weight * at(x+k-radius, y, channel)
+				}
+				horizontal[((y-rows.Min.Y)*width+x-rectangle.Min.X)*4+channel] = sum
+			}
+		}
+	}
+	for y := rectangle.Min.Y; y < rectangle.Max.Y; y++ {
+		for x := rectangle.Min.X; x < rectangle.Max.X; x++ {
+			for channel := 0; channel < 4; channel++ {
+				sum := 0.0
+				for k, weight := range kernel {
+					sy := min(rows.Max.Y-1, max(rows.Min.Y, y+k-radius))
+					sum += weight * horizontal[((sy-rows.Min.Y)*width+x-rectangle.Min.X)*4+channel]
+				}
+				imageDestination.Pix[imageDestination.PixOffset(x, y)+channel] = uint8(math.Round(math.Max(0, math.Min(0xFF, sum))))
+			}
+		}
+	}
+}
+
+// pixelateRectangle replaces each block of a rectangle of the image with the block's mean colour.
+func pixelateRectangle(imageDestination *image.RGBA, rectangle image.Rectangle, blockSize int) {
+	for blockY := rectangle.Min.Y; blockY < rectangle.Max.Y; blockY += blockSize {
+		for blockX := rectangle.Min.X; blockX < rectangle.Max.X; blockX += blockSize {
+			block := image.Rect(blockX, blockY, blockX+blockSize, blockY+blockSize).Intersect(rectangle)
+			var sums [4]int
+			for y := block.Min.Y; y < block.Max.Y; y++ {
+				for x := block.Min.X; x < block.Max.X; x++ {
+					offset := imageDestination.PixOffset(x, y)
+					for channel := range sums {
+						sums[channel] += int(imageDestination.Pix[offset+channel])
+					}
+				}
+			}
+			count := block.Dx() * block.Dy()
+			for y := block.Min.Y; y < block.Max.Y; y++ {
+				for x := block.Min.X; x < block.Max.X; x++ {
+					offset := imageDestination.PixOffset(x, y)
+					for channel := range sums {
+						imageDestination.Pix[offset+channel] = uint8((sums[channel] + count/2) / count)
+					}
+				}
+			}
+		}
+	}
+}
+
+// hasRedactedRenditions reports whether any of the renditions redacts faces.
+func hasRedactedRenditions(renditions []Rendition) bool {
+	for i := range renditions {
+		if renditions[i].Redact != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// hasTextRedactedRenditions reports whether any of the renditions redacts text.
+func hasTextRedactedRenditions(renditions []Rendition) bool {
+	for i := range renditions {
+		if renditions[i].Redact != nil && len(renditions[i].Redact.textPatterns) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// getTextDetectionBoundingBox returns the bounding box of a text detection. The box is computed from the polygon when
+// there is one, since the bounding box of rotated text does not always cover it.
+func getTextDetectionBoundingBox(textDetection *rekognition.TextDetection) *rekognition.BoundingBox {
+	if textDetection.Geometry == nil {
+		return nil
+	}
+	if len(textDetection.Geometry.Polygon) == 0 {
+		return textDetection.Geometry.BoundingBox
+	}
+	left, top, right, bottom := math.Inf(1), math.Inf(1), math.Inf(-1), math.Inf(-1)
+	for _, point := range textDetection.Geometry.Polygon {
+		x, y := aws.Float64Value(point.X), aws.Float64Value(point.Y)
+		left, top, right, bottom = math.Min(left, x), math.Min(top, y), math.Max(right, x), math.Max(bottom, y)
+	}
+	return &rekognition.BoundingBox{
+		Height: aws.Float64(bottom - top),
+		Left:   aws.Float64(left),
+		Top:    aws.Float64(top),
+		Width:  aws.Float64(right - left)}
+}
+
+// getTextRedactionBoundingBoxes returns the bounding boxes of the text detections that match any of the patterns.
+// Lines and words are both matched, so that a pattern finds an email address within a line as well as a number
+// plate split across words.
+func getTextRedactionBoundingBoxes(textDetections []*rekognition.TextDetection, textPatterns []*regexp.Regexp) []*rekognition.BoundingBox {
+	var boundingBoxes []*rekognition.BoundingBox
+	for _, textDetection := range textDetections {
+		boundingBox := getTextDetectionBoundingBox(textDetection)
+		if boundingBox == nil {
+			continue
+		}
+		for _, textPattern := range textPatterns {
+			if textPattern.MatchString(aws.StringValue(textDetection.DetectedText)) {
+				boundingBoxes = append(boundingBoxes, boundingBox)
+				break
+			}
+		}
+	}
+	return boundingBoxes
+}
+
+// detectRekognitionFaces detects the faces of an image stored in S3.
+func detectRekognitionFaces(rekognitionClient *rekognition.Rekognition, s3BucketName string, s3ObjectKey string) (*rekognition.DetectFacesOutput, error) {
+	rekognitionDetectFacesInput := rekognition.DetectFacesInput{
+		Image: &rekognition.Image{
+			S3Object: &rekognition.S3Object{
+				Bucket: &s3BucketName,
+				Name:   &s3ObjectKey}}}
+	return rekognitionClient.DetectFaces(&rekognitionDetectFacesInput)
+}
+
+// detectRekognitionText detects all of the text of an image stored in S3, without word filter or regions of interest.
+func detectRekognitionText(rekognitionClient *rekognition.Rekognition, s3BucketName string, s3ObjectKey string) (*rekognition.DetectTextOutput, error) {
+	rekognitionDetectTextInput := rekognition.DetectTextInput{
+		Image: &rekognition.Image{
+			S3Object: &rekognition.S3Object{
+				Bucket: &s3BucketName,
+				Name:   &s3ObjectKey}}}
+	return rekognitionClient.DetectText(&rekognitionDetectTextInput)
+}
+
+// searchRekognitionFace searches the collection for the face in the bounding box of the image. Only the face and its
+// surroundings are sent, since Rekognition searches for the largest face in the image it is given. It returns the
+// matching face, or nil if the face is not in the collection.
+func searchRekognitionFace(rekognitionClient *rekognition.Rekognition, collectionID string, faceMatchThreshold float64, imageSource image.Image, boundingBox *rekognition.BoundingBox) (*rekognition.Face, error) {
+	rectangle := getRedactionRectangle(boundingBox, imageSource.Bounds(), nil, imageSource.Bounds(), redactionFaceSearchPadding)
+	var buffer bytes.Buffer
+	if err := jpeg.Encode(&buffer, cropImage(imageSource, &CropRectangle{Height: rectangle.Dy(), Width: rectangle.Dx(), X: rectangle.Min.X, Y: rectangle.Min.Y}), nil); err != nil {
+		return nil, err
+	}
+	rekognitionSearchFacesByImageInput := rekognition.SearchFacesByImageInput{
+		CollectionId:       &collectionID,
+		FaceMatchThreshold: &faceMatchThreshold,
+		Image:              &rekognition.Image{Bytes: buffer.Bytes()},
+		MaxFaces:           aws.Int64(1)}
+	rekognitionSearchFacesByImageOutput, err := rekognitionClient.SearchFacesByImage(&rekognitionSearchFacesByImageInput)
+	var awsError awserr.Error
+	if errors.As(err, &awsError) && awsError.Code() == rekognition.ErrCodeInvalidParameterException {
+		// Rekognition found no face in the region, so there is nothing to match.
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, faceMatch := range rekognitionSearchFacesByImageOutput.FaceMatches {
+		if faceMatch.Face != nil {
+			return faceMatch.Face, nil
+		}
+	}
+	return nil, nil
+}
+
+// processS3ObjectImageRedactionFaces returns the bounding boxes of the faces that redacted renditions obscure,
+// leaving out the faces that match the consent collection.
+func processS3ObjectImageRedactionFaces(rekognitionClient *rekognition.Rekognition, imageSource image.Image, faceBoundingBoxes []*rekognition.BoundingBox) []*rekognition.BoundingBox {
+	if imageRedactionCollectionID == "" {
+		log.Printf("Redaction: Faces=%d Redacted=%d", len(faceBoundingBoxes), len(faceBoundingBoxes))
+		return faceBoundingBoxes
+	}
+
+	var redactionBoundingBoxes []*rekognition.BoundingBox
+	for _, boundingBox := range faceBoundingBoxes {
+		face, err := searchRekognitionFace(rekognitionClient, imageRedactionCollectionID, imageRedactionFaceMatchThreshold, imageSource, boundingBox)
+		if err != nil {
+			// A face that cannot be matched is treated as a bystander.
+			log.Printf("Redaction: SearchFacesByImage CollectionId=%s Error=%s", imageRedactionCollectionID, err)
+		}
+		if face != nil {
+			log.Printf("Redaction: Allowlisted FaceId=%s ExternalImageId=%s", aws.StringValue(face.FaceId), aws.StringValue(face.ExternalImageId))
+			continue
+		}
+		redactionBoundingBoxes = append(redactionBoundingBoxes, boundingBox)
+	}
+	log.Printf("Redaction: Faces=%d Redacted=%d", len(faceBoundingBoxes), len(redactionBoundingBoxes))
+	return redactionBoundingBoxes
+}
+
+// processS3ObjectImageRedactionText returns the text detections that text redacted renditions match against, detected
+// in the compressed image without filters.
+func processS3ObjectImageRedactionText(rekognitionClient *rekognition.Rekognition, s3BucketName string, s3ObjectKey string) []*rekognition.TextDetection {
+	rekognitionDetectTextOutput, err := detectRekognitionText(rekognitionClient, s3BucketName, s3ObjectKey)
+	if err != nil {
+		// Renditions must not be published without their text redacted.
+		log.Fatalf("Redaction: DetectText Bucket=%s Key=%s Error=%s", s3BucketName, s3ObjectKey, err)
+	}
+	log.Printf("Redaction: TextDetections=%d", len(rekognitionDetectTextOutput.TextDetections))
+	return rekognitionDetectTextOutput.TextDetections
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/rekognition"
+)
+
+func TestValidateRedaction(t *testing.T) {
+	redaction := Redaction{Method: redactionMethodBlur}
+	if err := validateRedaction(&redaction); err != nil || redaction.Padding != redactionDefaultPadding || redaction.Resolution != redactionDefaultResolution {
+		t.Fatalf("ValidateRedaction: %+v %v", redaction, err)
+	}
+	for _, redaction := range []Redaction{
+		{Method: "Mosaic"},
+		{Method: redactionMethodPixelate, Padding: 2},
+		{Method: redactionMethodPixelate, Resolution: 100},
+	} {
+		if err := validateRedaction(&redaction); err == nil {
+			t.Errorf("ValidateRedaction: %+v accepted", redaction)
+		}
+	}
+	if _, err := getRenditions(`[{"Name": "Public", "Redact": {"Method": "Mosaic"}}]`, nil); err == nil {
+		t.Errorf("GetRenditions: unknown redaction method accepted")
+	}
+}
+
+func TestGetRedactionRectangle(t *testing.T) {
+	boundingBox := &rekognition.BoundingBox{Left: aws.Float64(0.5), Top: aws.Float64(0.25), Width: aws.Float64(0.1), Height: aws.Float64(0.2)}
+	imageSourceRectangle := image.Rect(0, 0, 1000, 800)
+
+	// Resized to half the size without cropping.
+	rectangle := getRedactionRectangle(boundingBox, imageSourceRectangle, nil, image.Rect(0, 0, 500, 400), 0)
+	if rectangle != image.Rect(250, 100, 300, 180) {
+		t.Errorf("GetRedactionRectangle: resized %v", rectangle)
+	}
+
+	// Cropped to the right half and padded by half the face size on every side.
+	cropRectangle := &CropRectangle{Height: 800, Width: 500, X: 500, Y: 0}
+	rectangle = getRedactionRectangle(boundingBox, imageSourceRectangle, cropRectangle, image.Rect(0, 0, 500, 800), 0.5)
+	if rectangle != image.Rect(0, 120, 150, 440) {
+		t.Errorf("GetRedactionRectangle: cropped %v", rectangle)
+	}
+}
+
+func TestApplyRedaction(t *testing.T) {
+	newStripedImage := func() *image.RGBA {
+		imageDestination := image.NewRGBA(image.Rect(0, 0, 64, 64))
+		for y := 0; y < 64; y++ {
+			for x := 0; x < 64; x++ {
+				value := uint8(0)
+				if x%2 == 0 {
+					value = 0xFF
+				}
+				imageDestination.SetRGBA(x, y, color.RGBA{value, value, value, 0xFF})
+			}
+		}
+		return imageDestination
+	}
+	rectangle := image.Rect(16, 16, 48, 48)
+	for _, method := range []string{redactionMethodBlur, redactionMethodPixelate} {
+		imageDestination := newStripedImage()
+		redaction := Redaction{Method: method}
+		if err := validateRedaction(&redaction); err != nil {
+			t.Fatal(err)
+		}
+		if count := applyRedaction(imageDestination, []image.Rectangle{rectangle, {}}, &redaction); count != 1 {
+			t.Errorf("ApplyRedaction: %s redacted %d", method, count)
+		}
+
+		// The stripes are averaged away inside the rectangle and untouched outside it.
+		if value := imageDestination.RGBAAt(32, 32).R; value < 96 || value > 160 {
+			t.Errorf("ApplyRedaction: %s inside %d", method, value)
+		}
+		if imageDestination.RGBAAt(14, 32).R != 0xFF || imageDestination.RGBAAt(49, 32).R != 0 {
+			t.Errorf("ApplyRedaction: %s changed pixels outside the rectangle", method)
+		}
+	}
+}
+
+func TestGetTextRedactionBoundingBoxes(t *testing.T) {
+	newTextDetection := func(text string) *rekognition.TextDetection {
+		return &rekognition.TextDetection{
+			DetectedText: aws.String(text),
+			Geometry: &rekognition.Geometry{
+				BoundingBox: &rekognition.BoundingBox{Left: aws.Float64(0), Top: aws.Float64(0), Width: aws.Float64(0.1), Height: aws.Float64(0.1)}}}
+	}
+	textDetections := []*rekognition.TextDetection{
+		newTextDetection("AB12 CDE"),
+		newTextDetection("7ABC123"),
+		newTextDetection("Call +44 20 7946 0958 today"),
+		newTextDetection("hello@example.com"),
+		newTextDetection("OPEN"),
+		newTextDetection("TICKET 0042"),
+		{DetectedText: aws.String("AB12 CDE")},
+	}
+	for _, test := range []struct {
+		text  []string
+		count int
+	}{
+		{[]string{redactionTextLicensePlate}, 2},
+		{[]string{redactionTextPhoneNumber}, 1},
+		{[]string{redactionTextEmail}, 1},
+		{[]string{redactionTextEmail, redactionTextPhoneNumber, `^TICKET`}, 3},
+		{nil, 0},
+	} {
+		redaction := Redaction{Method: redactionMethodBlur, Text: test.text}
+		if err := validateRedaction(&redaction); err != nil {
+			t.Fatal(err)
+		}
+		if boundingBoxes := getTextRedactionBoundingBoxes(textDetections, redaction.textPatterns); len(boundingBoxes) != test.count {
+			t.Errorf("GetTextRedactionBoundingBoxes: %v matched %d want %d", test.text, len(boundingBoxes), test.count)
+		}
+	}
+	if err := validateRedaction(&Redaction{Method: redactionMethodBlur, Text: []string{"("}}); err == nil {
+		t.Errorf("ValidateRedaction: invalid text pattern accepted")
+	}
+}
+
+func TestGetTextDetectionBoundingBox(t *testing.T) {
+	// Rotated text whose polygon extends beyond its bounding box.
+	textDetection := &rekognition.TextDetection{
+		Geometry: &rekognition.Geometry{
+			BoundingBox: &rekognition.BoundingBox{Left: aws.Float64(0.2), Top: aws.Float64(0.2), Width: aws.Float64(0.1), Height: aws.Float64(0.1)},
+			Polygon: []*rekognition.Point{
+				{X: aws.Float64(0.1), Y: aws.Float64(0.3)},
+				{X: aws.Float64(0.4), Y: aws.Float64(0.1)},
+				{X: aws.Float64(0.45), Y: aws.Float64(0.2)},
+				{X: aws.Float64(0.15), Y: aws.Float64(0.4)}}}}
+	boundingBox := getTextDetectionBoundingBox(textDetection)
+	if rectangle := getRedactionRectangle(boundingBox, image.Rect(0, 0, 100, 100), nil, image.Rect(0, 0, 100, 100), 0); rectangle != image.Rect(10, 10, 45, 40) {
+		t.Errorf("GetTextDetectionBoundingBox: %v", rectangle)
+	}
+}
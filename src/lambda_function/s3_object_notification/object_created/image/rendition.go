@@ -0,0 +1,326 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"log"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/rekognition"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+)
+
+// Contains functions for producing the renditions configured for each uploaded image.
+
+// Rendition describes an image derived from an uploaded image and published alongside the compressed image.
+// MaxWidth and MaxHeight bound the rendition's dimensions, where zero leaves the dimension unbounded.
+// Crop names the crop preset that the image is smart cropped to before it is resized.
+// Kernel names the resampling kernel, defaulting to ApproxBiLinear, and LinearLight resamples in linear light rather
+// than on the sRGB encoded values. Enhance automatically corrects the white balance, levels and local contrast, and
+// Sharpen applies an unsharp mask after resizing.
+// Redact obscures the faces of people who are not in the consent collection, and any text matching its patterns,
+// making the rendition safe to publish.
+// Public renditions are intended for publication and are watermarked when a watermark is configured.
+type Rendition struct {
+	Crop        string       `json:"Crop"`
+	Enhance     *Enhancement `json:"Enhance"`
+	Kernel      string       `json:"Kernel"`
+	LinearLight bool         `json:"LinearLight"`
+	MaxHeight   int          `json:"MaxHeight"`
+	MaxWidth    int          `json:"MaxWidth"`
+	Name        string       `json:"Name"`
+	Public      bool         `json:"Public"`
+	Quality     int          `json:"Quality"`
+	Redact      *Redaction   `json:"Redact"`
+	Sharpen     *UnsharpMask `json:"Sharpen"`
+}
+
+// RenditionManifest lists the renditions produced for an uploaded image. Height and Width are the dimensions of the
+// decoded image that the renditions were produced from, in whose pixel coordinates their crop rectangles are recorded.
+// They are smaller than the uploaded image's dimensions when it was downscaled on decoding.
+type RenditionManifest struct {
+	Height     int                      `json:"Height"`
+	Name       string                   `json:"Name"`
+	Renditions []RenditionManifestEntry `json:"Renditions"`
+	Width      int                      `json:"Width"`
+}
+
+// RekognitionStatus is the part of the status record stored by the image_compressed Lambda that lists the analyzers
+// that succeeded for the ETag of the compressed image.
+type RekognitionStatus struct {
+	ETag      string   `json:"ETag"`
+	Succeeded []string `json:"Succeeded"`
+}
+
+// RenditionManifestEntry describes a single rendition stored in S3.
+type RenditionManifestEntry struct {
+	Crop          *CropRectangle         `json:"Crop"`
+	Enhancement   *EnhancementParameters `json:"Enhancement"`
+	Height        int                    `json:"Height"`
+	Kernel        string                 `json:"Kernel"`
+	Key           string                 `json:"Key"`
+	LinearLight   bool                   `json:"LinearLight"`
+	Name          string                 `json:"Name"`
+	Public        bool                   `json:"Public"`
+	Redacted      bool                   `json:"Redacted"`
+	RedactedFaces int                    `json:"RedactedFaces"`
+	RedactedText  int                    `json:"RedactedText"`
+	Sharpened     bool                   `json:"Sharpened"`
+	Watermarked   bool                   `json:"Watermarked"`
+	Width         int                    `json:"Width"`
+}
+
+// getRenditions parses the JSON rendition configuration and checks that every rendition is usable.
+// Renditions may only reference the given crop presets.
+func getRenditions(configuration string, cropPresets map[string]*CropPreset) ([]Rendition, error) {
+	var renditions []Rendition
+	if err := json.Unmarshal([]byte(configuration), &renditions); err != nil {
+		return nil, err
+	}
+	names := make(map[string]bool)
+	for i := range renditions {
+		rendition := &renditions[i]
+		if rendition.Name == "" || strings.ContainsAny(rendition.Name, "/.") {
+			return nil, fmt.Errorf("invalid rendition name %q", rendition.Name)
+		}
+		if names[rendition.Name] {
+			return nil, fmt.Errorf("duplicate rendition name %q", rendition.Name)
+		}
+		if rendition.MaxHeight < 0 || rendition.MaxWidth < 0 {
+			return nil, fmt.Errorf("rendition %q has negative dimensions", rendition.Name)
+		}
+		if rendition.Quality < 0 || rendition.Quality > 100 {
+			return nil, fmt.Errorf("rendition %q has quality %d outside 0-100 (0 for the default)", rendition.Name, rendition.Quality)
+		}
+		if _, ok := cropPresets[rendition.Crop]; rendition.Crop != "" && !ok {
+			return nil, fmt.Errorf("rendition %q has unknown crop preset %q", rendition.Name, rendition.Crop)
+		}
+		if rendition.Kernel == "" {
+			rendition.Kernel = resamplingKernelApproxBiLinear
+		}
+		if _, ok := resamplingKernels[rendition.Kernel]; !ok {
+			return nil, fmt.Errorf("rendition %q has unknown kernel %q", rendition.Name, rendition.Kernel)
+		}
+		if rendition.Sharpen != nil {
+			if err := validateUnsharpMask(rendition.Sharpen); err != nil {
+				return nil, fmt.Errorf("rendition %q: %w", rendition.Name, err)
+			}
+		}
+		if rendition.Enhance != nil {
+			if err := validateEnhancement(rendition.Enhance); err != nil {
+				return nil, fmt.Errorf("rendition %q: %w", rendition.Name, err)
+			}
+		}
+		if rendition.Redact != nil {
+			if err := validateRedaction(rendition.Redact); err != nil {
+				return nil, fmt.Errorf("rendition %q: %w", rendition.Name, err)
+			}
+		}
+		names[rendition.Name] = true
+	}
+	return renditions, nil
+}
+
+// getRenditionBounds returns the dimensions of the rendition when fitted within its maximum width and height.
+// The aspect ratio is preserved and images are never enlarged.
+func getRenditionBounds(rendition *Rendition, imageSourceRectangle image.Rectangle) image.Rectangle {
+	width, height := imageSourceRectangle.Dx(), imageSourceRectangle.Dy()
+	scale := 1.0
+	if rendition.MaxWidth > 0 && width > rendition.MaxWidth {
+		scale = float64(rendition.MaxWidth) / float64(width)
+	}
+	if rendition.MaxHeight > 0 && float64(height)*scale > float64(rendition.MaxHeight) {
+		scale = float64(rendition.MaxHeight) / float64(height)
+	}
+	return image.Rect(0, 0, max(1, int(float64(width)*scale+0.5)), max(1, int(float64(height)*scale+0.5)))
+}
+
+// getRenditionImage crops and resizes the source image for the rendition, redacts the faces and text of the redaction
+// targets and applies the watermark to public renditions. It returns the rendition image and its manifest entry,
+// without the S3 object key.
+func getRenditionImage(rendition *Rendition, imageSource image.Image, watermark image.Image, faceBoundingBoxes []*rekognition.BoundingBox, redactionTargets *RedactionTargets) (image.Image, RenditionManifestEntry) {
+	imageSourceRectangle := imageSource.Bounds()
+	renditionManifestEntry := RenditionManifestEntry{
+		Kernel:      rendition.Kernel,
+		LinearLight: rendition.LinearLight,
+		Name:        rendition.Name,
+		Public:      rendition.Public}
+	if rendition.Crop != "" {
+		renditionManifestEntry.Crop = getSmartCrop(imageSource, imageCropPresets[rendition.Crop], faceBoundingBoxes)
+		imageSource = cropImage(imageSource, renditionManifestEntry.Crop)
+	}
+	imageDestinationRectangle := getRenditionBounds(rendition, imageSource.Bounds())
+	imageDestination := image.NewRGBA(imageDestinationRectangle)
+	resampleImage(imageDestination, imageSource, rendition.Kernel, rendition.LinearLight)
+	if rendition.Enhance != nil {
+		renditionManifestEntry.Enhancement = applyEnhancement(imageDestination, rendition.Enhance)
+	}
+	if rendition.Sharpen != nil {
+		applyUnsharpMask(imageDestination, rendition.Sharpen)
+		renditionManifestEntry.Sharpened = true
+	}
+	if rendition.Redact != nil {
+		var rectangles []image.Rectangle
+		for _, boundingBox := range redactionTargets.Faces {
+			rectangles = append(rectangles, getRedactionRectangle(boundingBox, imageSourceRectangle, renditionManifestEntry.Crop, imageDestinationRectangle, rendition.Redact.Padding))
+		}
+		renditionManifestEntry.RedactedFaces = applyRedaction(imageDestination, rectangles, rendition.Redact)
+		rectangles = nil
+		for _, boundingBox := range getTextRedactionBoundingBoxes(redactionTargets.Text, rendition.Redact.textPatterns) {
+			rectangles = append(rectangles, getRedactionRectangle(boundingBox, imageSourceRectangle, renditionManifestEntry.Crop, imageDestinationRectangle, redactionTextPadding))
+		}
+		renditionManifestEntry.RedactedText = applyRedaction(imageDestination, rectangles, rendition.Redact)
+		renditionManifestEntry.Redacted = true
+	}
+	if rendition.Public && watermark != nil {
+		applyWatermark(imageDestination, watermark, imageWatermark)
+		renditionManifestEntry.Watermarked = true
+	}
+	renditionManifestEntry.Height = imageDestinationRectangle.Dy()
+	renditionManifestEntry.Width = imageDestinationRectangle.Dx()
+	return imageDestination, renditionManifestEntry
+}
+
+// processS3ObjectImageRenditions produces and uploads the configured renditions of the image,
+// followed by a manifest listing them.
+func processS3ObjectImageRenditions(session *session.Session, s3Client *s3.S3, s3BucketName string, fileName string, fileTime time.Time, eTag string, imageSource image.Image) {
+	if len(imageRenditions) == 0 {
+		return
+	}
+	log.Printf("Renditions: BucketName=%s FileName=%s Renditions=%d", s3BucketName, fileName, len(imageRenditions))
+
+	// Never publish renditions of an image that moderation has quarantined.
+	if isS3ObjectModerationQuarantined(s3Client, s3BucketName, fileName, fileTime) {
+		log.Printf("Renditions: Skipping quarantined FileName=%s", fileName)
+		return
+	}
+
+	// Load the watermark once for all public renditions.
+	watermark, err := getWatermarkImage(s3Client, s3BucketName)
+	if err != nil {
+		log.Fatalf("Watermark: Error=%s", err)
+	}
+
+	// Find the faces that guide smart cropping and that redacted renditions obscure, and the text they obscure, in
+	// the compressed image.
+	var faceBoundingBoxes []*rekognition.BoundingBox
+	var redactionTargets RedactionTargets
+	if hasCroppedRenditions(imageRenditions) || hasRedactedRenditions(imageRenditions) {
+		rekognitionClient := rekognition.New(session)
+		s3ObjectKey := createS3ObjectKey(s3BucketFolderImagesCompressed, path.Base(fileName), fileTime)
+		faceBoundingBoxes = processS3ObjectImageFaces(rekognitionClient, s3Client, s3BucketName, s3ObjectKey, eTag)
+		if hasRedactedRenditions(imageRenditions) {
+			redactionTargets.Faces = processS3ObjectImageRedactionFaces(rekognitionClient, imageSource, faceBoundingBoxes)
+		}
+		if hasTextRedactedRenditions(imageRenditions) {
+			redactionTargets.Text = processS3ObjectImageRedactionText(rekognitionClient, s3BucketName, s3ObjectKey)
+		}
+	}
+
+	renditionManifest := RenditionManifest{
+		Height: imageSource.Bounds().Dy(),
+		Name:   path.Base(fileName),
+		Width:  imageSource.Bounds().Dx()}
+	s3UploadManager := s3manager.NewUploader(session)
+	for i := range imageRenditions {
+		rendition := &imageRenditions[i]
+		renditionImage, renditionManifestEntry := getRenditionImage(rendition, imageSource, watermark, faceBoundingBoxes, &redactionTargets)
+
+		// Create the S3 object key for the rendition.
+		s3ObjectKey := createS3ObjectKey(fmt.Sprintf("%s/%s", s3BucketFolderImagesRenditions, rendition.Name), path.Base(fileName), fileTime)
+		log.Printf("Rendition: Name=%s Bucket=%s Key=%s Public=%v Watermarked=%v RedactedFaces=%d RedactedText=%d", rendition.Name, s3BucketName, s3ObjectKey, rendition.Public, renditionManifestEntry.Watermarked, renditionManifestEntry.RedactedFaces, renditionManifestEntry.RedactedText)
+		if renditionManifestEntry.Crop != nil {
+			log.Printf("Rendition: Name=%s Crop.Preset=%s Crop.X=%d Crop.Y=%d Crop.Width=%d Crop.Height=%d Crop.FaceCount=%d",
+				rendition.Name,
+				renditionManifestEntry.Crop.Preset,
+				renditionManifestEntry.Crop.X,
+				renditionManifestEntry.Crop.Y,
+				renditionManifestEntry.Crop.Width,
+				renditionManifestEntry.Crop.Height,
+				renditionManifestEntry.Crop.FaceCount)
+		}
+
+		// Upload the rendition to S3.
+		s3ManagerUploadOutput, err := putS3ObjectImageJpgQuality(s3UploadManager, s3BucketName, s3ObjectKey, renditionImage, rendition.Quality)
+		if err != nil {
+			log.Fatalf("Rendition: Name=%s Error=%s", rendition.Name, err)
+		}
+		processS3ManagerUploadOutput(s3ManagerUploadOutput)
+
+		renditionManifestEntry.Key = s3ObjectKey
+		renditionManifest.Renditions = append(renditionManifest.Renditions, renditionManifestEntry)
+	}
+
+	// Create the S3 object key for the manifest and upload it.
+	s3ObjectKey := createS3ObjectKey(s3BucketFolderImagesRenditions, fmt.Sprintf("%s.JSON", strings.Split(path.Base(fileName), ".")[0]), fileTime)
+	log.Printf("RenditionManifest: Bucket=%s Key=%s", s3BucketName, s3ObjectKey)
+	s3PutObjectOutput, err := putS3ObjectJSON(s3Client, s3BucketName, s3ObjectKey, &renditionManifest)
+	if err != nil {
+		log.Fatalf("RenditionManifest: Error=%s", err)
+	}
+	processS3PutObjectOutput(s3PutObjectOutput)
+}
+
+// hasCroppedRenditions reports whether any of the renditions is smart cropped.
+func hasCroppedRenditions(renditions []Rendition) bool {
+	for _, rendition := range renditions {
+		if rendition.Crop != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// processS3ObjectImageFaces returns the faces of the compressed image. The DetectFaces output stored by the
+// image_compressed Lambda is reused when it was produced for this version of the compressed image, so an image whose
+// renditions are produced again is not analysed twice. A new image has not been analysed yet, so its faces are
+// detected directly. Faces only guide smart cropping unless renditions redact them, so the Lambda fails when they
+// cannot be found only if they are to be redacted.
+func processS3ObjectImageFaces(rekognitionClient *rekognition.Rekognition, s3Client *s3.S3, s3BucketName string, s3ObjectKey string, eTag string) []*rekognition.BoundingBox {
+	rekognitionDetectFacesOutput, err := getS3ObjectRekognitionDetectFacesOutputStored(s3Client, s3BucketName, s3ObjectKey, eTag)
+	if err == nil && rekognitionDetectFacesOutput == nil {
+		rekognitionDetectFacesOutput, err = detectRekognitionFaces(rekognitionClient, s3BucketName, s3ObjectKey)
+	}
+	if err != nil && hasRedactedRenditions(imageRenditions) {
+		// Renditions must not be published without their faces redacted.
+		log.Fatalf("Redaction: DetectFaces Bucket=%s Key=%s Error=%s", s3BucketName, s3ObjectKey, err)
+	}
+	if err != nil {
+		log.Printf("RekognitionDetectFacesOutput: Bucket=%s Key=%s Error=%s", s3BucketName, s3ObjectKey, err)
+		return nil
+	}
+	log.Printf("RekognitionDetectFacesOutput: Bucket=%s Key=%s FaceDetails=%d", s3BucketName, s3ObjectKey, len(rekognitionDetectFacesOutput.FaceDetails))
+	return getFaceBoundingBoxes(rekognitionDetectFacesOutput)
+}
+
+// getS3ObjectRekognitionDetectFacesOutputStored loads the DetectFaces output stored by the image_compressed Lambda for
+// the compressed image with the ETag. It returns nil if the image has not been analysed yet, or if the output was
+// produced for another version of the image.
+func getS3ObjectRekognitionDetectFacesOutputStored(s3Client *s3.S3, s3BucketName string, s3ObjectKey string, eTag string) (*rekognition.DetectFacesOutput, error) {
+	name := strings.Split(path.Base(s3ObjectKey), ".")[0]
+	b, err := getS3ObjectBytes(s3Client, s3BucketName, fmt.Sprintf("%s/%s.JSON", s3BucketFolderRekognitionStatus, name))
+	var awsErr awserr.Error
+	if errors.As(err, &awsErr) && awsErr.Code() == s3.ErrCodeNoSuchKey {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var rekognitionStatus RekognitionStatus
+	if err := json.Unmarshal(b, &rekognitionStatus); err != nil {
+		return nil, err
+	}
+	if eTag == "" || rekognitionStatus.ETag != eTag || !slices.Contains(rekognitionStatus.Succeeded, "DetectFaces") {
+		return nil, nil
+	}
+	log.Printf("RekognitionStatus: Name=%s ETag=%s Succeeded=%v", name, eTag, rekognitionStatus.Succeeded)
+	return getS3ObjectRekognitionDetectFacesOutput(s3Client, s3BucketName, fmt.Sprintf("%s/%s.JSON", s3BucketFolderRekognitionDetectFaces, name))
+}
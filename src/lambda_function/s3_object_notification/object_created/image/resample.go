@@ -0,0 +1,186 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+
+	"golang.org/x/image/draw"
+)
+
+// Contains functions for resampling renditions and sharpening them afterwards.
+
+// Resampling kernels that renditions can select.
+const (
+	resamplingKernelApproxBiLinear = "ApproxBiLinear"
+	resamplingKernelBiLinear       = "BiLinear"
+	resamplingKernelCatmullRom     = "CatmullRom"
+	resamplingKernelLanczos3       = "Lanczos3"
+	resamplingKernelNearest        = "Nearest"
+)
+
+// lanczos3 is the Lanczos kernel with three lobes, which keeps more detail than Catmull-Rom at the cost of slight
+// ringing around hard edges.
+var lanczos3 = &draw.Kernel{Support: 3, At: func(t float64) float64 {
+	if t == 0 {
+		return 1
+	}
+	if t >= 3 {
+		return 0
+	}
+	return 3 * math.Sin(math.Pi*t) * math.Sin(math.Pi*t/3) / (math.Pi * math.Pi * t * t)
+}}
+
+// resamplingKernels maps the kernel names to their implementations.
+var resamplingKernels = map[string]draw.Interpolator{
+	resamplingKernelApproxBiLinear: draw.ApproxBiLinear,
+	resamplingKernelBiLinear:       draw.BiLinear,
+	resamplingKernelCatmullRom:     draw.CatmullRom,
+	resamplingKernelLanczos3:       lanczos3,
+	resamplingKernelNearest:        draw.NearestNeighbor}
+
+// UnsharpMask sharpens an image by adding back the difference between the image and a Gaussian blur of it.
+// Radius is the standard deviation of the blur in pixels, Amount the strength of the sharpening, where 1 adds the
+// full difference, and Threshold the minimum difference in 8-bit levels that is sharpened, which avoids amplifying
+// noise in flat areas.
+type UnsharpMask struct {
+	Amount    float64 `json:"Amount"`
+	Radius    float64 `json:"Radius"`
+	Threshold int     `json:"Threshold"`
+}
+
+// validateUnsharpMask checks that the unsharp mask parameters are usable.
+func validateUnsharpMask(unsharpMask *UnsharpMask) error {
+	if unsharpMask.Amount <= 0 || unsharpMask.Amount > 5 {
+		return fmt.Errorf("unsharp mask amount %v outside (0, 5]", unsharpMask.Amount)
+	}
+	if unsharpMask.Radius <= 0 || unsharpMask.Radius > 10 {
+		return fmt.Errorf("unsharp mask radius %v outside (0, 10]", unsharpMask.Radius)
+	}
+	if unsharpMask.Threshold < 0 || unsharpMask.Threshold > 255 {
+		return errors.New("unsharp mask threshold outside 0-255")
+	}
+	return nil
+}
+
+// linearLightImage presents an 8-bit sRGB image as 16-bit linear light, converting pixels as they are read so that
+// resampling in linear light does not need a linear copy of the source.
+type linearLightImage struct {
+	image.Image
+	table *[256]uint16
+}
+
+// ColorModel implements the image.Image interface.
+func (i *linearLightImage) ColorModel() color.Model {
+	return color.RGBA64Model
+}
+
+// At implements the image.Image interface.
+func (i *linearLightImage) At(x, y int) color.Color {
+	r, g, b, a := i.Image.At(x, y).RGBA()
+	if a == 0 {
+		return color.RGBA64{}
+	}
+	if a == 0xFFFF {
+		return color.RGBA64{i.table[r>>8], i.table[g>>8], i.table[b>>8], 0xFFFF}
+	}
+	// Convert the unpremultiplied colour and premultiply the linear value.
+	linear := func(c uint32) uint16 {
+		return uint16(uint32(i.table[c*0xFF/a]) * a / 0xFFFF)
+	}
+	return color.RGBA64{linear(r), linear(g), linear(b), uint16(a)}
+}
+
+// getLinearLightTable returns the conversion from 8-bit sRGB to 16-bit linear light.
+func getLinearLightTable() *[256]uint16 {
+	var table [256]uint16
+	for i := range table {
+		table[i] = uint16(math.Round(srgbLinear(float64(i)/0xFF) * 0xFFFF))
+	}
+	return &table
+}
+
+// resampleImage scales the source image into the destination with the named kernel. In linear light the source is
+// resampled as linear values, which keeps the brightness of fine detail, and the result is encoded back to sRGB.
+func resampleImage(imageDestination *image.RGBA, imageSource image.Image, kernel string, linearLight bool) {
+	interpolator, ok := resamplingKernels[kernel]
+	if !ok {
+		interpolator = draw.ApproxBiLinear
+	}
+	if !linearLight {
+		interpolator.Scale(imageDestination, imageDestination.Bounds(), imageSource, imageSource.Bounds(), draw.Src, nil)
+		return
+	}
+	imageLinear := image.NewRGBA64(imageDestination.Bounds())
+	interpolator.Scale(imageLinear, imageLinear.Bounds(), &linearLightImage{Image: imageSource, table: getLinearLightTable()}, imageSource.Bounds(), draw.Src, nil)
+	for i := 0; i < len(imageLinear.Pix)/8; i++ {
+		pixel := imageLinear.Pix[i*8 : i*8+8]
+		a := float64(uint16(pixel[6])<<8 | uint16(pixel[7]))
+		for channel := 0; channel < 3; channel++ {
+			value := 0.0
+			if a > 0 {
+				value = srgbEncode(float64(uint16(pixel[channel*2])<<8|uint16(pixel[channel*2+1]))/a) * a / 0xFFFF
+			}
+			imageDestination.Pix[i*4+channel] = uint8(math.Round(math.Max(0, math.Min(1, value)) * 0xFF))
+		}
+		imageDestination.Pix[i*4+3] = uint8(a / 0x101)
+	}
+}
+
+// getGaussianKernel returns a normalised one dimensional Gaussian kernel with the standard deviation.
+func getGaussianKernel(sigma float64) []float64 {
+	radius := int(math.Ceil(3 * sigma))
+	kernel := make([]float64, 2*radius+1)
+	sum := 0.0
+	for i := range kernel {
+		x := float64(i - radius)
+		kernel[i] = math.Exp(-x * x / (2 * sigma * sigma))
+		sum += kernel[i]
+	}
+	for i := range kernel {
+		kernel[i] /= sum
+	}
+	return kernel
+}
+
+// applyUnsharpMask sharpens the colour channels of the image in place.
+func applyUnsharpMask(imageDestination *image.RGBA, unsharpMask *UnsharpMask) {
+	width, height := imageDestination.Rect.Dx(), imageDestination.Rect.Dy()
+	kernel := getGaussianKernel(unsharpMask.Radius)
+	radius := len(kernel) / 2
+
+	// Blur horizontally then vertically, clamping at the edges.
+	horizontal := make([]float64, width*height*3)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			for channel := 0; channel < 3; channel++ {
+				sum := 0.0
+				for k, weight := range kernel {
+					sx := min(width-1, max(0, x+k-radius))
+					sum += weight * float64(imageDestination.Pix[y*imageDestination.Stride+sx*4+channel])
+				}
+				horizontal[(y*width+x)*3+channel] = sum
+			}
+		}
+	}
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			for channel := 0; channel < 3; channel++ {
+				blurred := 0.0
+				for k, weight := range kernel {
+					sy := min(height-1, max(0, y+k-radius))
+					blurred += weight * horizontal[(sy*width+x)*3+channel]
+				}
+				offset := y*imageDestination.Stride + x*4 + channel
+				original := float64(imageDestination.Pix[offset])
+				difference := original - blurred
+				if math.Abs(difference) < float64(unsharpMask.Threshold) {
+					continue
+				}
+				imageDestination.Pix[offset] = uint8(math.Round(math.Max(0, math.Min(0xFF, original+unsharpMask.Amount*difference))))
+			}
+		}
+	}
+}
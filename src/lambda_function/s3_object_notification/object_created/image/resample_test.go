@@ -0,0 +1,84 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"testing"
+
+	"golang.org/x/image/draw"
+)
+
+func TestGetRenditionsKernel(t *testing.T) {
+	renditions, err := getRenditions(`[{"Name": "Web", "MaxWidth": 100}]`, nil)
+	if err != nil || renditions[0].Kernel != resamplingKernelApproxBiLinear {
+		t.Fatalf("GetRenditions: %+v %v", renditions, err)
+	}
+	for _, configuration := range []string{
+		`[{"Name": "Web", "Kernel": "Bicubic"}]`,
+		`[{"Name": "Web", "Sharpen": {"Amount": 0, "Radius": 1}}]`,
+		`[{"Name": "Web", "Sharpen": {"Amount": 1, "Radius": 1, "Threshold": 300}}]`,
+	} {
+		if _, err := getRenditions(configuration, nil); err == nil {
+			t.Errorf("GetRenditions: %s accepted", configuration)
+		}
+	}
+}
+
+func TestResampleImageLinearLight(t *testing.T) {
+	// A one pixel black and white checkerboard averages to half the light of white.
+	imageSource := image.NewRGBA(image.Rect(0, 0, 64, 64))
+	for y := 0; y < 64; y++ {
+		for x := 0; x < 64; x++ {
+			if (x+y)%2 == 0 {
+				imageSource.SetRGBA(x, y, color.RGBA{0xFF, 0xFF, 0xFF, 0xFF})
+			} else {
+				imageSource.SetRGBA(x, y, color.RGBA{0, 0, 0, 0xFF})
+			}
+		}
+	}
+	for _, test := range []struct {
+		linearLight bool
+		value       float64
+	}{
+		{false, 128},
+		{true, math.Round(srgbEncode(0.5) * 0xFF)},
+	} {
+		imageDestination := image.NewRGBA(image.Rect(0, 0, 8, 8))
+		resampleImage(imageDestination, imageSource, resamplingKernelLanczos3, test.linearLight)
+		if value := float64(imageDestination.RGBAAt(4, 4).G); math.Abs(value-test.value) > 3 {
+			t.Errorf("ResampleImage: LinearLight=%v got %v want %v", test.linearLight, value, test.value)
+		}
+	}
+}
+
+func TestLanczos3(t *testing.T) {
+	if lanczos3.At(0) != 1 || math.Abs(lanczos3.At(1)) > 1e-9 || math.Abs(lanczos3.At(2)) > 1e-9 {
+		t.Fatalf("Lanczos3: %v %v %v", lanczos3.At(0), lanczos3.At(1), lanczos3.At(2))
+	}
+}
+
+func TestApplyUnsharpMask(t *testing.T) {
+	// A vertical edge between two grey levels.
+	newEdgeImage := func() *image.RGBA {
+		imageSource := image.NewRGBA(image.Rect(0, 0, 32, 8))
+		draw.Draw(imageSource, image.Rect(0, 0, 16, 8), image.NewUniform(color.RGBA{64, 64, 64, 0xFF}), image.Point{}, draw.Src)
+		draw.Draw(imageSource, image.Rect(16, 0, 32, 8), image.NewUniform(color.RGBA{192, 192, 192, 0xFF}), image.Point{}, draw.Src)
+		return imageSource
+	}
+	imageDestination := newEdgeImage()
+	applyUnsharpMask(imageDestination, &UnsharpMask{Amount: 1, Radius: 1})
+	if imageDestination.RGBAAt(15, 4).R >= 64 || imageDestination.RGBAAt(16, 4).R <= 192 {
+		t.Errorf("ApplyUnsharpMask: edge %v %v", imageDestination.RGBAAt(15, 4), imageDestination.RGBAAt(16, 4))
+	}
+	if imageDestination.RGBAAt(2, 4).R != 64 || imageDestination.RGBAAt(30, 4).R != 192 {
+		t.Errorf("ApplyUnsharpMask: flat %v %v", imageDestination.RGBAAt(2, 4), imageDestination.RGBAAt(30, 4))
+	}
+
+	// The threshold leaves differences smaller than it untouched.
+	imageDestination = newEdgeImage()
+	applyUnsharpMask(imageDestination, &UnsharpMask{Amount: 1, Radius: 1, Threshold: 255})
+	if imageDestination.RGBAAt(15, 4).R != 64 || imageDestination.RGBAAt(16, 4).R != 192 {
+		t.Errorf("ApplyUnsharpMask: threshold %v %v", imageDestination.RGBAAt(15, 4), imageDestination.RGBAAt(16, 4))
+	}
+}
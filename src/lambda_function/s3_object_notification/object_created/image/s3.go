@@ -3,11 +3,15 @@ package main
 import (
 	"bytes"
 	"encoding/json"
+	"fmt"
 	"image"
 	"image/jpeg"
+	"io"
+	"net/url"
 	"os"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/rekognition"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/aws/aws-sdk-go/service/s3/s3manager"
 )
@@ -30,11 +34,11 @@ func getS3Object(s3DownloadManager *s3manager.Downloader, s3BucketName string, s
 	return nil
 }
 
-// putS3ObjectExifMetadata uploads Exif metadata to the specified S3 bucket.
-// It serializes the ExifMetadata to JSON and stores it in the S3 object.
+// putS3ObjectImageMetadata uploads image metadata to the specified S3 bucket.
+// It serializes the ImageMetadata, including its Exif metadata, to JSON and stores it in the S3 object.
 // Returns the S3 PutObjectOutput and any error encountered.
-func putS3ObjectExifMetadata(s3Client *s3.S3, s3BucketName string, s3ObjectKey string, exifMetadata *ExifMetadata) (*s3.PutObjectOutput, error) {
-	b, err := json.Marshal(exifMetadata)
+func putS3ObjectImageMetadata(s3Client *s3.S3, s3BucketName string, s3ObjectKey string, imageMetadata *ImageMetadata) (*s3.PutObjectOutput, error) {
+	b, err := json.Marshal(imageMetadata)
 	if err != nil {
 		return nil, err
 	}
@@ -51,11 +55,64 @@ func putS3ObjectExifMetadata(s3Client *s3.S3, s3BucketName string, s3ObjectKey s
 	return s3PutObjectOutput, err
 }
 
+// getS3ObjectBytes downloads an object from the provided S3 bucket and returns its contents.
+func getS3ObjectBytes(s3Client *s3.S3, s3BucketName string, s3ObjectKey string) ([]byte, error) {
+	getObjectInput := s3.GetObjectInput{
+		Bucket: &s3BucketName,
+		Key:    &s3ObjectKey}
+	getObjectOutput, err := s3Client.GetObject(&getObjectInput)
+	if err != nil {
+		return nil, err
+	}
+	defer getObjectOutput.Body.Close()
+	return io.ReadAll(getObjectOutput.Body)
+}
+
+// getS3ObjectRekognitionDetectFacesOutput downloads and parses a DetectFaces output stored as JSON in S3.
+func getS3ObjectRekognitionDetectFacesOutput(s3Client *s3.S3, s3BucketName string, s3ObjectKey string) (*rekognition.DetectFacesOutput, error) {
+	b, err := getS3ObjectBytes(s3Client, s3BucketName, s3ObjectKey)
+	if err != nil {
+		return nil, err
+	}
+	var rekognitionDetectFacesOutput rekognition.DetectFacesOutput
+	if err := json.Unmarshal(b, &rekognitionDetectFacesOutput); err != nil {
+		return nil, err
+	}
+	return &rekognitionDetectFacesOutput, nil
+}
+
+// putS3ObjectJSON serializes the s3ObjectBody to JSON and uploads it to the specified S3 bucket.
+// Returns the S3 PutObjectOutput and any error encountered.
+func putS3ObjectJSON(s3Client *s3.S3, s3BucketName string, s3ObjectKey string, s3ObjectBody interface{}) (*s3.PutObjectOutput, error) {
+	b, err := json.Marshal(s3ObjectBody)
+	if err != nil {
+		return nil, err
+	}
+	s3PutObjectInput := s3.PutObjectInput{
+		Bucket:        &s3BucketName,
+		Body:          aws.ReadSeekCloser(bytes.NewReader(b)),
+		ContentLength: aws.Int64(int64(len(b))),
+		ContentType:   aws.String("application/json"),
+		Key:           &s3ObjectKey}
+	return s3Client.PutObject(&s3PutObjectInput)
+}
+
 // putS3ObjectImageJpg uploads an image in JPEG format to the specified S3 bucket.
 // Returns the S3 PutObjectOutput and any error encountered.
 func putS3ObjectImageJpg(s3UploadManager *s3manager.Uploader, s3BucketName string, s3ObjectKey string, sourceImage image.Image) (*s3manager.UploadOutput, error) {
+	return putS3ObjectImageJpgQuality(s3UploadManager, s3BucketName, s3ObjectKey, sourceImage, 0)
+}
+
+// putS3ObjectImageJpgQuality uploads an image in JPEG format with the given quality to the specified S3 bucket.
+// A quality of zero uses the default JPEG quality.
+// Returns the S3 PutObjectOutput and any error encountered.
+func putS3ObjectImageJpgQuality(s3UploadManager *s3manager.Uploader, s3BucketName string, s3ObjectKey string, sourceImage image.Image, quality int) (*s3manager.UploadOutput, error) {
+	var options *jpeg.Options
+	if quality > 0 {
+		options = &jpeg.Options{Quality: quality}
+	}
 	var buffer bytes.Buffer
-	err := jpeg.Encode(&buffer, sourceImage, nil)
+	err := jpeg.Encode(&buffer, sourceImage, options)
 	if err != nil {
 		return nil, err
 	}
@@ -70,3 +127,32 @@ func putS3ObjectImageJpg(s3UploadManager *s3manager.Uploader, s3BucketName strin
 	}
 	return s3ManagerUploadOutput, err
 }
+
+// copyS3Object copies an object within the specified S3 bucket.
+func copyS3Object(s3Client *s3.S3, s3BucketName string, s3ObjectKeySource string, s3ObjectKeyDestination string) (*s3.CopyObjectOutput, error) {
+	s3CopyObjectInput := s3.CopyObjectInput{
+		Bucket:     &s3BucketName,
+		CopySource: aws.String(url.PathEscape(fmt.Sprintf("%s/%s", s3BucketName, s3ObjectKeySource))),
+		Key:        &s3ObjectKeyDestination}
+	return s3Client.CopyObject(&s3CopyObjectInput)
+}
+
+// deleteS3Object deletes an object from the specified S3 bucket.
+func deleteS3Object(s3Client *s3.S3, s3BucketName string, s3ObjectKey string) (*s3.DeleteObjectOutput, error) {
+	s3DeleteObjectInput := s3.DeleteObjectInput{
+		Bucket: &s3BucketName,
+		Key:    &s3ObjectKey}
+	return s3Client.DeleteObject(&s3DeleteObjectInput)
+}
+
+// getS3ObjectTagging returns the tags of an object in the specified S3 bucket.
+func getS3ObjectTagging(s3Client *s3.S3, s3BucketName string, s3ObjectKey string) ([]*s3.Tag, error) {
+	s3GetObjectTaggingInput := s3.GetObjectTaggingInput{
+		Bucket: &s3BucketName,
+		Key:    &s3ObjectKey}
+	s3GetObjectTaggingOutput, err := s3Client.GetObjectTagging(&s3GetObjectTaggingInput)
+	if err != nil {
+		return nil, err
+	}
+	return s3GetObjectTaggingOutput.TagSet, nil
+}
@@ -0,0 +1,320 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"os"
+	"strconv"
+)
+
+// Contains functions for validating uploads before they are decoded.
+//
+// Uploads are only trusted as far as their content allows: the format is sniffed from the file's magic bytes rather
+// than its extension, the dimensions are read from the header and checked before any pixels are allocated, the
+// structure of JPEG files is walked to detect truncation, and the Exif IFDs are walked with loop and size limits
+// before they are handed to the Exif parser. Files that fail validation are quarantined rather than processed.
+
+// Reasons that an upload is rejected.
+const (
+	validationReasonCorrupt            = "Corrupt"
+	validationReasonDimensionsTooLarge = "DimensionsTooLarge"
+	validationReasonExifInvalid        = "ExifInvalid"
+	validationReasonExifMissing        = "ExifMissing"
+	validationReasonTooManyPixels      = "TooManyPixels"
+	validationReasonTruncated          = "Truncated"
+	validationReasonUnsupportedFormat  = "UnsupportedFormat"
+)
+
+// Limits applied to the Exif IFDs.
+const (
+	validationExifMaxEntries = 512
+	validationExifMaxIFDs    = 16
+)
+
+// Exif tags that point to sub-IFDs.
+const (
+	exifTagExifIFDPointer             = 0x8769
+	exifTagGPSInfoIFDPointer          = 0x8825
+	exifTagInteroperabilityIFDPointer = 0xA005
+)
+
+// ImageValidationError describes why an upload was rejected. Reason is one of the validation reasons and Detail
+// describes the specific problem.
+type ImageValidationError struct {
+	Detail string `json:"Detail"`
+	Reason string `json:"Reason"`
+}
+
+// Error implements the error interface.
+func (e *ImageValidationError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Reason, e.Detail)
+}
+
+// newImageValidationError returns an ImageValidationError with a formatted detail.
+func newImageValidationError(reason string, format string, a ...any) *ImageValidationError {
+	return &ImageValidationError{Detail: fmt.Sprintf(format, a...), Reason: reason}
+}
+
+// imageSignature identifies a file format by the bytes at the start of the file.
+type imageSignature struct {
+	format    string
+	offset    int
+	signature string
+	supported bool
+}
+
+// imageSignatures lists the formats recognised when sniffing uploads. Only formats with a registered decoder are
+// supported, the others are recognised so that the rejection names the format.
+var imageSignatures = []imageSignature{
+	{format: "jpeg", signature: "\xFF\xD8\xFF", supported: true},
+	{format: "png", signature: "\x89PNG\r\n\x1A\n", supported: true},
+	{format: "gif", signature: "GIF8"},
+	{format: "heic", offset: 4, signature: "ftypheic"},
+	{format: "heic", offset: 4, signature: "ftypmif1"},
+	{format: "pdf", signature: "%PDF"},
+	{format: "tiff", signature: "II*\x00"},
+	{format: "tiff", signature: "MM\x00*"},
+	{format: "webp", offset: 8, signature: "WEBP"},
+	{format: "zip", signature: "PK\x03\x04"}}
+
+// getImageMaxDimension parses and validates the maximum width or height of an upload.
+func getImageMaxDimension(maxDimension string) (int, error) {
+	value, err := strconv.Atoi(maxDimension)
+	if err != nil {
+		return 0, err
+	}
+	if value <= 0 || value > 65535 {
+		return 0, fmt.Errorf("maximum dimension %d outside 1-65535", value)
+	}
+	return value, nil
+}
+
+// sniffImageFormat returns the format identified by the header and whether it is supported.
+func sniffImageFormat(header []byte) (string, bool) {
+	for _, imageSignature := range imageSignatures {
+		if len(header) >= imageSignature.offset+len(imageSignature.signature) &&
+			string(header[imageSignature.offset:imageSignature.offset+len(imageSignature.signature)]) == imageSignature.signature {
+			return imageSignature.format, imageSignature.supported
+		}
+	}
+	return "", false
+}
+
+// openImageValidation validates the file specified by the filename. It returns an ImageValidationError if the file
+// should be rejected, or another error if the file could not be read.
+func openImageValidation(fileName string) error {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	// Identify the format from the content rather than the extension.
+	header := make([]byte, 16)
+	n, err := io.ReadFull(file, header)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return err
+	}
+	format, supported := sniffImageFormat(header[:n])
+	if format == "" {
+		return newImageValidationError(validationReasonUnsupportedFormat, "unrecognised file signature %x", header[:n])
+	}
+	if !supported {
+		return newImageValidationError(validationReasonUnsupportedFormat, "%s images are not supported", format)
+	}
+
+	// Check the dimensions before any pixels are allocated.
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	imageConfig, _, err := image.DecodeConfig(file)
+	if err != nil {
+		return newImageValidationError(validationReasonCorrupt, "invalid %s header: %s", format, err)
+	}
+	if err := validateImageConfig(imageConfig, imageMaxDimension, imageMaxUploadPixels); err != nil {
+		return err
+	}
+
+	if format != "jpeg" {
+		return nil
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	return validateJPEG(bufio.NewReader(file))
+}
+
+// validateImageConfig checks the dimensions of an image against the limits.
+func validateImageConfig(imageConfig image.Config, maxDimension int, maxPixels int) error {
+	if imageConfig.Width <= 0 || imageConfig.Height <= 0 {
+		return newImageValidationError(validationReasonCorrupt, "invalid dimensions %dx%d", imageConfig.Width, imageConfig.Height)
+	}
+	if imageConfig.Width > maxDimension || imageConfig.Height > maxDimension {
+		return newImageValidationError(validationReasonDimensionsTooLarge, "dimensions %dx%d exceed the maximum of %d", imageConfig.Width, imageConfig.Height, maxDimension)
+	}
+	if imageConfig.Width*imageConfig.Height > maxPixels {
+		return newImageValidationError(validationReasonTooManyPixels, "%d pixels exceed the maximum of %d", imageConfig.Width*imageConfig.Height, maxPixels)
+	}
+	return nil
+}
+
+// validateJPEG walks the markers of a JPEG file, checking that every segment is complete, that the Exif metadata is
+// well formed and that the image ends with an EOI marker. Entropy coded data is skipped; corrupt Huffman data is
+// detected when the image is decoded.
+func validateJPEG(reader *bufio.Reader) error {
+	truncated := func(err error) error {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return newImageValidationError(validationReasonTruncated, "file ends before the EOI marker")
+		}
+		return err
+	}
+	if _, err := reader.Discard(2); err != nil {
+		return truncated(err)
+	}
+	for {
+		b, err := reader.ReadByte()
+		if err != nil {
+			return truncated(err)
+		}
+		if b != 0xFF {
+			return newImageValidationError(validationReasonCorrupt, "expected marker, found %#02x", b)
+		}
+		marker, err := reader.ReadByte()
+		for err == nil && marker == 0xFF {
+			marker, err = reader.ReadByte()
+		}
+		if err != nil {
+			return truncated(err)
+		}
+		switch {
+		case marker == jpegMarkerEOI:
+			return nil
+		case marker >= jpegMarkerRST0 && marker <= jpegMarkerRST7 || marker == 0x01:
+			// Standalone markers have no segment.
+			continue
+		}
+		var length [2]byte
+		if _, err := io.ReadFull(reader, length[:]); err != nil {
+			return truncated(err)
+		}
+		size := int(binary.BigEndian.Uint16(length[:]))
+		if size < 2 {
+			return newImageValidationError(validationReasonCorrupt, "invalid length %d for marker %#02x", size, marker)
+		}
+		segment := make([]byte, size-2)
+		if _, err := io.ReadFull(reader, segment); err != nil {
+			return truncated(err)
+		}
+		if marker == 0xE1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			if err := validateExif(segment[6:]); err != nil {
+				return err
+			}
+		}
+		if marker == jpegMarkerSOS {
+			if err := skipJPEGEntropyCodedData(reader); err != nil {
+				return truncated(err)
+			}
+		}
+	}
+}
+
+// skipJPEGEntropyCodedData advances the reader past the entropy coded data of a scan, including stuffed bytes and
+// restart markers, leaving it at the marker that follows.
+func skipJPEGEntropyCodedData(reader *bufio.Reader) error {
+	for {
+		next, err := reader.Peek(2)
+		if err != nil {
+			return err
+		}
+		switch {
+		case next[0] != 0xFF || next[1] == 0xFF:
+			_, err = reader.Discard(1)
+		case next[1] == 0 || next[1] >= jpegMarkerRST0 && next[1] <= jpegMarkerRST7:
+			_, err = reader.Discard(2)
+		default:
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// validateExif walks the IFDs of the TIFF structure in an Exif segment, rejecting loops, excessive numbers of IFDs
+// or entries and values that lie outside the segment.
+func validateExif(tiff []byte) error {
+	if len(tiff) < 8 {
+		return newImageValidationError(validationReasonExifInvalid, "TIFF header truncated")
+	}
+	var byteOrder binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		byteOrder = binary.LittleEndian
+	case "MM":
+		byteOrder = binary.BigEndian
+	default:
+		return newImageValidationError(validationReasonExifInvalid, "invalid TIFF byte order %q", tiff[:2])
+	}
+	if byteOrder.Uint16(tiff[2:4]) != 42 {
+		return newImageValidationError(validationReasonExifInvalid, "invalid TIFF magic number")
+	}
+
+	visited := make(map[uint32]bool)
+	pending := []uint32{byteOrder.Uint32(tiff[4:8])}
+	for len(pending) > 0 {
+		offset := pending[0]
+		pending = pending[1:]
+		if offset == 0 {
+			continue
+		}
+		if visited[offset] {
+			return newImageValidationError(validationReasonExifInvalid, "IFD loop at offset %d", offset)
+		}
+		visited[offset] = true
+		if len(visited) > validationExifMaxIFDs {
+			return newImageValidationError(validationReasonExifInvalid, "more than %d IFDs", validationExifMaxIFDs)
+		}
+		if uint64(offset)+2 > uint64(len(tiff)) {
+			return newImageValidationError(validationReasonExifInvalid, "IFD offset %d outside segment", offset)
+		}
+		entries := int(byteOrder.Uint16(tiff[offset:]))
+		if entries > validationExifMaxEntries {
+			return newImageValidationError(validationReasonExifInvalid, "IFD at offset %d has %d entries", offset, entries)
+		}
+		end := uint64(offset) + 2 + uint64(entries)*12
+		if end+4 > uint64(len(tiff)) {
+			return newImageValidationError(validationReasonExifInvalid, "IFD at offset %d extends outside segment", offset)
+		}
+		for i := 0; i < entries; i++ {
+			entry := tiff[uint64(offset)+2+uint64(i)*12:]
+			tag, dataType, count := byteOrder.Uint16(entry[0:2]), byteOrder.Uint16(entry[2:4]), byteOrder.Uint32(entry[4:8])
+			size := uint64(count) * uint64(getExifTypeSize(dataType))
+			if size > 4 && uint64(byteOrder.Uint32(entry[8:12]))+size > uint64(len(tiff)) {
+				return newImageValidationError(validationReasonExifInvalid, "tag %#04x value outside segment", tag)
+			}
+			if tag == exifTagExifIFDPointer || tag == exifTagGPSInfoIFDPointer || tag == exifTagInteroperabilityIFDPointer {
+				pending = append(pending, byteOrder.Uint32(entry[8:12]))
+			}
+		}
+		pending = append(pending, byteOrder.Uint32(tiff[end:]))
+	}
+	return nil
+}
+
+// getExifTypeSize returns the size in bytes of a value of the TIFF data type, or 1 for unknown types.
+func getExifTypeSize(dataType uint16) int {
+	switch dataType {
+	case 3, 8:
+		return 2
+	case 4, 9, 11:
+		return 4
+	case 5, 10, 12:
+		return 8
+	}
+	return 1
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/gif"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// getValidationReason returns the reason of an ImageValidationError, or an empty string for other errors.
+func getValidationReason(err error) string {
+	var imageValidationError *ImageValidationError
+	if errors.As(err, &imageValidationError) {
+		return imageValidationError.Reason
+	}
+	return ""
+}
+
+// newTestExif creates a little endian TIFF structure whose IFD0 has a single entry and links to the next IFD.
+func newTestExif(entries uint16, next uint32) []byte {
+	tiff := []byte("II*\x00\x08\x00\x00\x00")
+	tiff = binary.LittleEndian.AppendUint16(tiff, entries)
+	for i := uint16(0); i < entries; i++ {
+		// An ImageWidth SHORT entry with its value stored inline.
+		tiff = append(tiff, 0x00, 0x01, 0x03, 0x00, 0x01, 0x00, 0x00, 0x00, 0x40, 0x00, 0x00, 0x00)
+	}
+	return binary.LittleEndian.AppendUint32(tiff, next)
+}
+
+func TestSniffImageFormat(t *testing.T) {
+	for _, test := range []struct {
+		header    string
+		format    string
+		supported bool
+	}{
+		{"\xFF\xD8\xFF\xE0", "jpeg", true},
+		{"\x89PNG\r\n\x1A\n", "png", true},
+		{"GIF89a", "gif", false},
+		{"RIFF\x00\x00\x00\x00WEBP", "webp", false},
+		{"\x00\x00\x00\x18ftypheic", "heic", false},
+		{"hello world", "", false},
+	} {
+		if format, supported := sniffImageFormat([]byte(test.header)); format != test.format || supported != test.supported {
+			t.Errorf("SniffImageFormat: %q got %s %v", test.header, format, supported)
+		}
+	}
+}
+
+func TestValidateImageConfig(t *testing.T) {
+	if err := validateImageConfig(image.Config{Width: 6000, Height: 4000}, 30000, 200000000); err != nil {
+		t.Errorf("ValidateImageConfig: %s", err)
+	}
+	if reason := getValidationReason(validateImageConfig(image.Config{Width: 40000, Height: 10}, 30000, 200000000)); reason != validationReasonDimensionsTooLarge {
+		t.Errorf("ValidateImageConfig: %s", reason)
+	}
+	if reason := getValidationReason(validateImageConfig(image.Config{Width: 20000, Height: 20000}, 30000, 200000000)); reason != validationReasonTooManyPixels {
+		t.Errorf("ValidateImageConfig: %s", reason)
+	}
+}
+
+func TestValidateJPEG(t *testing.T) {
+	b := newTestJPEG(t, newTestColorImage(64, 64))
+	if err := validateJPEG(bufio.NewReader(bytes.NewReader(b))); err != nil {
+		t.Fatalf("ValidateJPEG: %s", err)
+	}
+	if reason := getValidationReason(validateJPEG(bufio.NewReader(bytes.NewReader(b[:len(b)-100])))); reason != validationReasonTruncated {
+		t.Fatalf("ValidateJPEG: truncated %s", reason)
+	}
+
+	// Insert an Exif segment whose IFD links back to itself.
+	exif := append([]byte("Exif\x00\x00"), newTestExif(1, 8)...)
+	segment := append([]byte{0xFF, 0xE1, 0, 0}, exif...)
+	binary.BigEndian.PutUint16(segment[2:], uint16(len(exif)+2))
+	b = append(append(append([]byte{}, b[:2]...), segment...), b[2:]...)
+	if reason := getValidationReason(validateJPEG(bufio.NewReader(bytes.NewReader(b)))); reason != validationReasonExifInvalid {
+		t.Fatalf("ValidateJPEG: Exif loop %s", reason)
+	}
+}
+
+func TestValidateExif(t *testing.T) {
+	if err := validateExif(newTestExif(1, 0)); err != nil {
+		t.Errorf("ValidateExif: %s", err)
+	}
+	if err := validateExif(newTestExif(1, 8)); getValidationReason(err) != validationReasonExifInvalid {
+		t.Errorf("ValidateExif: loop %v", err)
+	}
+	if err := validateExif(newTestExif(validationExifMaxEntries+1, 0)); getValidationReason(err) != validationReasonExifInvalid {
+		t.Errorf("ValidateExif: entries %v", err)
+	}
+	if err := validateExif(newTestExif(1, 0)[:20]); getValidationReason(err) != validationReasonExifInvalid {
+		t.Errorf("ValidateExif: truncated %v", err)
+	}
+}
+
+func TestOpenImageValidation(t *testing.T) {
+	imageMaxDimension, imageMaxUploadPixels = 30000, 200000000
+	directory := t.TempDir()
+
+	// A PNG with a JPEG extension is accepted on its content.
+	var b bytes.Buffer
+	if err := png.Encode(&b, newTestColorImage(32, 32)); err != nil {
+		t.Fatal(err)
+	}
+	fileName := filepath.Join(directory, "png.JPG")
+	if err := os.WriteFile(fileName, b.Bytes(), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := openImageValidation(fileName); err != nil {
+		t.Errorf("OpenImageValidation: %s", err)
+	}
+
+	b.Reset()
+	if err := gif.Encode(&b, newTestColorImage(32, 32), nil); err != nil {
+		t.Fatal(err)
+	}
+	fileName = filepath.Join(directory, "gif.JPG")
+	if err := os.WriteFile(fileName, b.Bytes(), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if reason := getValidationReason(openImageValidation(fileName)); reason != validationReasonUnsupportedFormat {
+		t.Errorf("OpenImageValidation: %s", reason)
+	}
+}
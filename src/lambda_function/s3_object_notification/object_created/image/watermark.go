@@ -0,0 +1,215 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/service/s3"
+	"golang.org/x/image/draw"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+)
+
+// Contains functions for applying a visible watermark to public renditions.
+
+// Watermark positions relative to the rendition.
+const (
+	watermarkPositionBottom      = "Bottom"
+	watermarkPositionBottomLeft  = "BottomLeft"
+	watermarkPositionBottomRight = "BottomRight"
+	watermarkPositionCenter      = "Center"
+	watermarkPositionLeft        = "Left"
+	watermarkPositionRight       = "Right"
+	watermarkPositionTop         = "Top"
+	watermarkPositionTopLeft     = "TopLeft"
+	watermarkPositionTopRight    = "TopRight"
+	watermarkTextSize            = 128
+)
+
+// WatermarkConfiguration describes the watermark applied to public renditions. The watermark is either a PNG logo
+// stored in the bucket under Image or the rendered Text. Scale is the watermark's width as a fraction of the
+// rendition's width, Margin is the distance from the edges as a fraction of the rendition's shortest side and
+// TileSpacing is the gap between tiled watermarks as a fraction of the watermark's size.
+type WatermarkConfiguration struct {
+	Color       string  `json:"Color"`
+	Image       string  `json:"Image"`
+	Margin      float64 `json:"Margin"`
+	Opacity     float64 `json:"Opacity"`
+	Position    string  `json:"Position"`
+	Scale       float64 `json:"Scale"`
+	Text        string  `json:"Text"`
+	Tile        bool    `json:"Tile"`
+	TileSpacing float64 `json:"TileSpacing"`
+}
+
+// watermarkImage caches the unscaled watermark between invocations of a warm Lambda.
+var watermarkImage image.Image
+
+// getWatermarkConfiguration parses the JSON watermark configuration, returning nil if it is empty.
+func getWatermarkConfiguration(configuration string) (*WatermarkConfiguration, error) {
+	if configuration == "" {
+		return nil, nil
+	}
+	watermarkConfiguration := WatermarkConfiguration{
+		Color:    "#FFFFFF",
+		Margin:   0.02,
+		Opacity:  0.5,
+		Position: watermarkPositionBottomRight,
+		Scale:    0.2}
+	if err := json.Unmarshal([]byte(configuration), &watermarkConfiguration); err != nil {
+		return nil, err
+	}
+	if (watermarkConfiguration.Image == "") == (strings.TrimSpace(watermarkConfiguration.Text) == "") {
+		return nil, errors.New("watermark requires exactly one of Image or non-blank Text")
+	}
+	if watermarkConfiguration.Opacity <= 0 || watermarkConfiguration.Opacity > 1 {
+		return nil, fmt.Errorf("watermark opacity %v outside (0, 1]", watermarkConfiguration.Opacity)
+	}
+	if watermarkConfiguration.Scale <= 0 || watermarkConfiguration.Scale > 1 {
+		return nil, fmt.Errorf("watermark scale %v outside (0, 1]", watermarkConfiguration.Scale)
+	}
+	if watermarkConfiguration.Margin < 0 || watermarkConfiguration.TileSpacing < 0 {
+		return nil, errors.New("watermark margin and tile spacing cannot be negative")
+	}
+	if _, err := getWatermarkPosition(&watermarkConfiguration, image.Rect(0, 0, 1, 1), image.Rect(0, 0, 1, 1)); err != nil {
+		return nil, err
+	}
+	if _, err := parseHexColor(watermarkConfiguration.Color); err != nil {
+		return nil, err
+	}
+	return &watermarkConfiguration, nil
+}
+
+// getWatermarkImage returns the unscaled watermark, loading the logo from S3 or rendering the text on first use.
+// It returns nil if no watermark is configured.
+func getWatermarkImage(s3Client *s3.S3, s3BucketName string) (image.Image, error) {
+	if imageWatermark == nil || watermarkImage != nil {
+		return watermarkImage, nil
+	}
+	var err error
+	if imageWatermark.Image != "" {
+		var b []byte
+		b, err = getS3ObjectBytes(s3Client, s3BucketName, imageWatermark.Image)
+		if err != nil {
+			return nil, err
+		}
+		watermarkImage, err = png.Decode(bytes.NewReader(b))
+	} else {
+		watermarkImage, err = renderWatermarkText(imageWatermark.Text, imageWatermark.Color)
+	}
+	if err == nil && watermarkImage.Bounds().Empty() {
+		// A logo without pixels or text without visible glyphs cannot be scaled to the rendition.
+		err = errors.New("watermark is empty")
+	}
+	if err != nil {
+		watermarkImage = nil
+	}
+	return watermarkImage, err
+}
+
+// renderWatermarkText renders the text onto a transparent image using the embedded Go Regular font.
+func renderWatermarkText(text string, hexColor string) (image.Image, error) {
+	textColor, err := parseHexColor(hexColor)
+	if err != nil {
+		return nil, err
+	}
+	textFont, err := opentype.Parse(goregular.TTF)
+	if err != nil {
+		return nil, err
+	}
+	textFace, err := opentype.NewFace(textFont, &opentype.FaceOptions{Size: watermarkTextSize, DPI: 72, Hinting: font.HintingFull})
+	if err != nil {
+		return nil, err
+	}
+	defer textFace.Close()
+	textBounds, _ := font.BoundString(textFace, text)
+	imageDestination := image.NewRGBA(image.Rect(0, 0, (textBounds.Max.X - textBounds.Min.X).Ceil(), (textBounds.Max.Y - textBounds.Min.Y).Ceil()))
+	fontDrawer := font.Drawer{
+		Dst:  imageDestination,
+		Src:  image.NewUniform(textColor),
+		Face: textFace,
+		Dot:  fixed.Point26_6{X: -textBounds.Min.X, Y: -textBounds.Min.Y}}
+	fontDrawer.DrawString(text)
+	return imageDestination, nil
+}
+
+// applyWatermark draws the watermark onto the rendition, scaled relative to the rendition's width.
+func applyWatermark(imageDestination draw.Image, watermark image.Image, watermarkConfiguration *WatermarkConfiguration) {
+	if watermark.Bounds().Empty() {
+		return
+	}
+	imageDestinationRectangle := imageDestination.Bounds()
+	watermarkWidth := max(1, int(math.Round(float64(imageDestinationRectangle.Dx())*watermarkConfiguration.Scale)))
+	watermarkHeight := max(1, int(math.Round(float64(watermarkWidth)*float64(watermark.Bounds().Dy())/float64(watermark.Bounds().Dx()))))
+	watermarkScaled := image.NewRGBA(image.Rect(0, 0, watermarkWidth, watermarkHeight))
+	draw.CatmullRom.Scale(watermarkScaled, watermarkScaled.Bounds(), watermark, watermark.Bounds(), draw.Src, nil)
+	mask := image.NewUniform(color.Alpha{A: uint8(math.Round(watermarkConfiguration.Opacity * 0xFF))})
+
+	if !watermarkConfiguration.Tile {
+		// The position is validated when the configuration is parsed.
+		watermarkRectangle, _ := getWatermarkPosition(watermarkConfiguration, imageDestinationRectangle, watermarkScaled.Bounds())
+		draw.DrawMask(imageDestination, watermarkRectangle, watermarkScaled, image.Point{}, mask, image.Point{}, draw.Over)
+		return
+	}
+
+	// Tile the watermark across the whole rendition, starting from the top left margin.
+	margin := getWatermarkMargin(watermarkConfiguration, imageDestinationRectangle)
+	stepX := watermarkWidth + int(math.Round(float64(watermarkWidth)*watermarkConfiguration.TileSpacing))
+	stepY := watermarkHeight + int(math.Round(float64(watermarkHeight)*watermarkConfiguration.TileSpacing))
+	for y := imageDestinationRectangle.Min.Y + margin; y < imageDestinationRectangle.Max.Y; y += stepY {
+		for x := imageDestinationRectangle.Min.X + margin; x < imageDestinationRectangle.Max.X; x += stepX {
+			watermarkRectangle := watermarkScaled.Bounds().Add(image.Pt(x, y))
+			draw.DrawMask(imageDestination, watermarkRectangle, watermarkScaled, image.Point{}, mask, image.Point{}, draw.Over)
+		}
+	}
+}
+
+// getWatermarkMargin returns the margin in pixels for the rendition.
+func getWatermarkMargin(watermarkConfiguration *WatermarkConfiguration, imageDestinationRectangle image.Rectangle) int {
+	return int(math.Round(float64(min(imageDestinationRectangle.Dx(), imageDestinationRectangle.Dy())) * watermarkConfiguration.Margin))
+}
+
+// getWatermarkPosition returns the rectangle that the watermark occupies within the rendition.
+func getWatermarkPosition(watermarkConfiguration *WatermarkConfiguration, imageDestinationRectangle image.Rectangle, watermarkRectangle image.Rectangle) (image.Rectangle, error) {
+	margin := getWatermarkMargin(watermarkConfiguration, imageDestinationRectangle)
+	left := imageDestinationRectangle.Min.X + margin
+	right := imageDestinationRectangle.Max.X - margin - watermarkRectangle.Dx()
+	centerX := imageDestinationRectangle.Min.X + (imageDestinationRectangle.Dx()-watermarkRectangle.Dx())/2
+	top := imageDestinationRectangle.Min.Y + margin
+	bottom := imageDestinationRectangle.Max.Y - margin - watermarkRectangle.Dy()
+	centerY := imageDestinationRectangle.Min.Y + (imageDestinationRectangle.Dy()-watermarkRectangle.Dy())/2
+	positions := map[string]image.Point{
+		watermarkPositionBottom:      {centerX, bottom},
+		watermarkPositionBottomLeft:  {left, bottom},
+		watermarkPositionBottomRight: {right, bottom},
+		watermarkPositionCenter:      {centerX, centerY},
+		watermarkPositionLeft:        {left, centerY},
+		watermarkPositionRight:       {right, centerY},
+		watermarkPositionTop:         {centerX, top},
+		watermarkPositionTopLeft:     {left, top},
+		watermarkPositionTopRight:    {right, top}}
+	position, ok := positions[watermarkConfiguration.Position]
+	if !ok {
+		return image.Rectangle{}, fmt.Errorf("unknown watermark position %q", watermarkConfiguration.Position)
+	}
+	return watermarkRectangle.Sub(watermarkRectangle.Min).Add(position), nil
+}
+
+// parseHexColor parses a colour in the #RRGGBB format.
+func parseHexColor(hexColor string) (color.RGBA, error) {
+	value, err := strconv.ParseUint(strings.TrimPrefix(hexColor, "#"), 16, 32)
+	if err != nil || len(strings.TrimPrefix(hexColor, "#")) != 6 {
+		return color.RGBA{}, fmt.Errorf("invalid colour %q", hexColor)
+	}
+	return color.RGBA{R: uint8(value >> 16), G: uint8(value >> 8), B: uint8(value), A: 0xFF}, nil
+}
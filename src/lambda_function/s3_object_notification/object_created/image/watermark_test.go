@@ -0,0 +1,87 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestGetWatermarkConfiguration(t *testing.T) {
+	for _, configuration := range []string{
+		`{"Text":"Studio","Image":"watermark.png"}`,
+		`{}`,
+		`{"Text":"  "}`,
+		`{"Text":"Studio","Opacity":2}`,
+		`{"Text":"Studio","Position":"Middle"}`,
+		`{"Text":"Studio","Color":"white"}`,
+	} {
+		if _, err := getWatermarkConfiguration(configuration); err == nil {
+			t.Errorf("GetWatermarkConfiguration: expected an error for %s", configuration)
+		}
+	}
+	watermarkConfiguration, err := getWatermarkConfiguration(`{"Text":"Studio"}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if watermarkConfiguration.Position != watermarkPositionBottomRight || watermarkConfiguration.Opacity != 0.5 {
+		t.Fatalf("GetWatermarkConfiguration: Position=%s Opacity=%v", watermarkConfiguration.Position, watermarkConfiguration.Opacity)
+	}
+}
+
+func TestApplyWatermark(t *testing.T) {
+	watermarkConfiguration, err := getWatermarkConfiguration(`{"Text":"Studio","Opacity":1,"Scale":0.5,"Margin":0}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	watermark, err := renderWatermarkText(watermarkConfiguration.Text, watermarkConfiguration.Color)
+	if err != nil {
+		t.Fatal(err)
+	}
+	imageDestination := image.NewRGBA(image.Rect(0, 0, 200, 100))
+	applyWatermark(imageDestination, watermark, watermarkConfiguration)
+
+	// The watermark is confined to the bottom right quarter of the image.
+	for y := 0; y < 100; y++ {
+		for x := 0; x < 200; x++ {
+			if imageDestination.RGBAAt(x, y) != (color.RGBA{}) && (x < 100 || y < 50) {
+				t.Fatalf("ApplyWatermark: unexpected pixel at %d,%d", x, y)
+			}
+		}
+	}
+	if isTransparent(imageDestination.SubImage(image.Rect(100, 50, 200, 100))) {
+		t.Fatal("ApplyWatermark: watermark was not drawn")
+	}
+
+	// An empty watermark is ignored rather than scaled.
+	applyWatermark(imageDestination, image.NewRGBA(image.Rectangle{}), watermarkConfiguration)
+}
+
+func TestGetRenditionBounds(t *testing.T) {
+	imageSourceRectangle := image.Rect(0, 0, 6000, 4000)
+	for _, test := range []struct {
+		rendition Rendition
+		expected  image.Rectangle
+	}{
+		{Rendition{MaxWidth: 1200}, image.Rect(0, 0, 1200, 800)},
+		{Rendition{MaxHeight: 1000}, image.Rect(0, 0, 1500, 1000)},
+		{Rendition{MaxWidth: 1200, MaxHeight: 400}, image.Rect(0, 0, 600, 400)},
+		{Rendition{MaxWidth: 8000}, imageSourceRectangle},
+	} {
+		if bounds := getRenditionBounds(&test.rendition, imageSourceRectangle); !bounds.Eq(test.expected) {
+			t.Errorf("GetRenditionBounds: %+v returned %v, expected %v", test.rendition, bounds, test.expected)
+		}
+	}
+}
+
+// isTransparent reports whether every pixel of the image is fully transparent.
+func isTransparent(imageSource image.Image) bool {
+	bounds := imageSource.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			if _, _, _, a := imageSource.At(x, y).RGBA(); a != 0 {
+				return false
+			}
+		}
+	}
+	return true
+}
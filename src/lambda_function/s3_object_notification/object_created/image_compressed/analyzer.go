@@ -0,0 +1,97 @@
+package main
+
+import (
+	"sort"
+
+	"github.com/aws/aws-sdk-go/service/rekognition"
+	"github.com/aws/aws-sdk-go/service/rekognition/rekognitioniface"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+)
+
+// Contains the analyzers run on every compressed image.
+//
+// An analyzer runs one kind of analysis on the prepared image, stores its output and returns its result. Analyzers
+// are created from the registry, keyed by the name that identifies them in the status record, with the clients they
+// share, so that tests can run every analyzer against fake Rekognition and S3 clients.
+
+// analyzerNameDetectModerationLabels names the analyzer whose verdict decides whether an image is quarantined.
+const analyzerNameDetectModerationLabels = "DetectModerationLabels"
+
+// Analyzer analyses a compressed image, storing and returning its result.
+type Analyzer interface {
+	Name() string
+	Run(analyzerImage *AnalyzerImage) (interface{}, error)
+}
+
+// AnalyzerClients are the clients and the S3 bucket shared by the analyzers.
+type AnalyzerClients struct {
+	Rekognition  rekognitioniface.RekognitionAPI
+	S3           s3iface.S3API
+	S3BucketName string
+}
+
+// AnalyzerImage is a compressed image to analyse, identified by its S3 object key, and prepared for Rekognition.
+type AnalyzerImage struct {
+	Image *rekognition.Image
+	Key   string
+}
+
+// analyzerFunc runs an analysis with the shared clients.
+type analyzerFunc func(analyzerClients *AnalyzerClients, analyzerImage *AnalyzerImage) (interface{}, error)
+
+// registeredAnalyzer is an Analyzer created from the registry.
+type registeredAnalyzer struct {
+	analyzerClients *AnalyzerClients
+	name            string
+	run             analyzerFunc
+}
+
+// Name returns the name of the analyzer in the registry.
+func (a *registeredAnalyzer) Name() string {
+	return a.name
+}
+
+// Run runs the analysis on the image.
+func (a *registeredAnalyzer) Run(analyzerImage *AnalyzerImage) (interface{}, error) {
+	return a.run(a.analyzerClients, analyzerImage)
+}
+
+// analyzerRegistry maps the name of each analyzer to the analysis it runs. The DetectModerationLabels result is the
+// moderation verdict of the image.
+var analyzerRegistry = map[string]analyzerFunc{
+	"DetectCustomLabels": func(analyzerClients *AnalyzerClients, analyzerImage *AnalyzerImage) (interface{}, error) {
+		return processRekognitionDetectCustomLabels(analyzerClients.Rekognition, analyzerClients.S3, analyzerImage.Image, analyzerClients.S3BucketName, analyzerImage.Key)
+	},
+	"DetectFaces": func(analyzerClients *AnalyzerClients, analyzerImage *AnalyzerImage) (interface{}, error) {
+		return processRekognitionDetectFaces(analyzerClients.Rekognition, analyzerClients.S3, analyzerImage.Image, analyzerClients.S3BucketName, analyzerImage.Key)
+	},
+	"DetectLabels": func(analyzerClients *AnalyzerClients, analyzerImage *AnalyzerImage) (interface{}, error) {
+		return processRekognitionDetectLabels(analyzerClients.Rekognition, analyzerClients.S3, analyzerImage.Image, analyzerClients.S3BucketName, analyzerImage.Key)
+	},
+	analyzerNameDetectModerationLabels: func(analyzerClients *AnalyzerClients, analyzerImage *AnalyzerImage) (interface{}, error) {
+		return processRekognitionDetectModerationLabels(analyzerClients.Rekognition, analyzerClients.S3, analyzerImage.Image, analyzerClients.S3BucketName, analyzerImage.Key)
+	},
+	"DetectText": func(analyzerClients *AnalyzerClients, analyzerImage *AnalyzerImage) (interface{}, error) {
+		return processRekognitionDetectText(analyzerClients.Rekognition, analyzerClients.S3, analyzerImage.Image, analyzerClients.S3BucketName, analyzerImage.Key)
+	},
+	"People": func(analyzerClients *AnalyzerClients, analyzerImage *AnalyzerImage) (interface{}, error) {
+		return processRekognitionPeople(analyzerClients.Rekognition, analyzerClients.S3, analyzerImage.Image, analyzerClients.S3BucketName, analyzerImage.Key)
+	},
+	"RecognizeCelebrities": func(analyzerClients *AnalyzerClients, analyzerImage *AnalyzerImage) (interface{}, error) {
+		return processRekognitionRecognizeCelebrities(analyzerClients.Rekognition, analyzerClients.S3, analyzerImage.Image, analyzerClients.S3BucketName, analyzerImage.Key)
+	},
+}
+
+// newAnalyzers creates every registered analyzer with the clients, ordered by name.
+func newAnalyzers(analyzerClients *AnalyzerClients) []Analyzer {
+	names := make([]string, 0, len(analyzerRegistry))
+	for name := range analyzerRegistry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	analyzers := make([]Analyzer, len(names))
+	for i, name := range names {
+		analyzers[i] = &registeredAnalyzer{analyzerClients: analyzerClients, name: name, run: analyzerRegistry[name]}
+	}
+	return analyzers
+}
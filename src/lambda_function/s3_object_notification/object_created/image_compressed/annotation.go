@@ -0,0 +1,430 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"path"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/rekognition"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+)
+
+// Contains functions for merging the analysis of a photo into its annotation document.
+//
+// The annotation combines a summary of the Exif metadata written by the image Lambda with the stored outputs of the
+// DetectFaces, DetectLabels, DetectText and DetectModerationLabels analyzers, in a schema that is independent of the
+// Rekognition SDK structs. It is rebuilt from the stored documents on every invocation, so sections whose analyzer
+// failed are left out until the retry stores them. Outputs are stored by name and survive a new version of the image,
+// so a Rekognition section is read only when the status record lists its analyzer as succeeded for the ETag of the
+// image, and the ETag each section was derived from is recorded in the annotation. The Exif metadata is written after
+// the compressed image, so an annotation built before it exists is stored without it and built again when the Exif
+// metadata is created. The annotation is stored with conditional writes, so that when both builds run at once the
+// one that read the older documents reads them again rather than overwriting the other.
+
+const (
+	// annotationMaxAttempts limits the attempts to store an annotation while another build changes it.
+	annotationMaxAttempts = 5
+
+	// annotationVersion is the version of the annotation schema. It is incremented when a field is removed or
+	// changes meaning, and not when fields are added.
+	annotationVersion = 1
+)
+
+// AnnotationBoundingBox is the position of a face or a line of text as ratios of the image size.
+type AnnotationBoundingBox struct {
+	Height float64 `json:"Height"`
+	Left   float64 `json:"Left"`
+	Top    float64 `json:"Top"`
+	Width  float64 `json:"Width"`
+}
+
+// AnnotationExif summarises the Exif metadata of a photo. Latitude and Longitude are in decimal degrees, negative
+// south and west.
+type AnnotationExif struct {
+	DateTime              *time.Time `json:"DateTime"`
+	ExposureTime          *string    `json:"ExposureTime"`
+	FNumber               *string    `json:"FNumber"`
+	FocalLength           *string    `json:"FocalLength"`
+	FocalLengthIn35mmFilm *int       `json:"FocalLengthIn35mmFilm"`
+	Height                *int       `json:"Height"`
+	ISOSpeedRatings       *int       `json:"ISOSpeedRatings"`
+	Latitude              *float64   `json:"Latitude"`
+	LensModel             *string    `json:"LensModel"`
+	Longitude             *float64   `json:"Longitude"`
+	Make                  *string    `json:"Make"`
+	Model                 *string    `json:"Model"`
+	Orientation           *int       `json:"Orientation"`
+	Width                 *int       `json:"Width"`
+}
+
+// AnnotationFace is a face found in a photo. Attributes lists the facial attributes detected as present, such as
+// Smile or Eyeglasses, and Emotion is the most confident emotion.
+type AnnotationFace struct {
+	AgeHigh     *int64                 `json:"AgeHigh"`
+	AgeLow      *int64                 `json:"AgeLow"`
+	Attributes  []string               `json:"Attributes"`
+	BoundingBox *AnnotationBoundingBox `json:"BoundingBox"`
+	Confidence  float64                `json:"Confidence"`
+	Emotion     string                 `json:"Emotion"`
+	Gender      string                 `json:"Gender"`
+}
+
+// AnnotationFaces summarises the faces found in a photo. Attributes counts the faces with each attribute.
+type AnnotationFaces struct {
+	Attributes map[string]int    `json:"Attributes"`
+	Count      int               `json:"Count"`
+	Faces      []*AnnotationFace `json:"Faces"`
+}
+
+// AnnotationLabel is a label detected in a photo, with all of its ancestors in the label hierarchy and its categories.
+type AnnotationLabel struct {
+	Categories []string `json:"Categories"`
+	Confidence float64  `json:"Confidence"`
+	Instances  int      `json:"Instances"`
+	Name       string   `json:"Name"`
+	Parents    []string `json:"Parents"`
+}
+
+// AnnotationTextLine is a line of text detected in a photo.
+type AnnotationTextLine struct {
+	BoundingBox *AnnotationBoundingBox `json:"BoundingBox"`
+	Confidence  float64                `json:"Confidence"`
+	Text        string                 `json:"Text"`
+}
+
+// Annotation is the merged analysis of a photo. Sections that have not been produced are null. Sources maps each
+// section produced to the ETag it was derived from, that of the compressed image for the Rekognition sections and
+// that of the Exif metadata document for Exif.
+type Annotation struct {
+	ETag       string                `json:"ETag"`
+	Exif       *AnnotationExif       `json:"Exif"`
+	Faces      *AnnotationFaces      `json:"Faces"`
+	ID         string                `json:"ID"`
+	Key        string                `json:"Key"`
+	Labels     []*AnnotationLabel    `json:"Labels"`
+	Moderation *ModerationVerdict    `json:"Moderation"`
+	Name       string                `json:"Name"`
+	Sources    map[string]string     `json:"Sources"`
+	Text       []*AnnotationTextLine `json:"Text"`
+	UpdatedAt  time.Time             `json:"UpdatedAt"`
+	Version    int                   `json:"Version"`
+}
+
+// AnnotationExifMetadata is the part of the Exif metadata document read for the annotation.
+type AnnotationExifMetadata struct {
+	DateTime              *time.Time `json:"DateTime"`
+	DateTimeOriginal      *time.Time `json:"DateTimeOriginal"`
+	ExposureTime          *string    `json:"ExposureTime"`
+	FNumber               *string    `json:"FNumber"`
+	FocalLength           *string    `json:"FocalLength"`
+	FocalLengthIn35mmFilm *int       `json:"FocalLengthIn35mmFilm"`
+	GPSLatitudeDegrees    *int       `json:"GPSLatitudeDegrees"`
+	GPSLatitudeMinutes    *int       `json:"GPSLatitudeMinutes"`
+	GPSLatitudeRef        *string    `json:"GPSLatitudeRef"`
+	GPSLatitudeSeconds    *int       `json:"GPSLatitudeSeconds"`
+	GPSLongitudeDegrees   *int       `json:"GPSLongitudeDegrees"`
+	GPSLongitudeMinutes   *int       `json:"GPSLongitudeMinutes"`
+	GPSLongitudeRef       *string    `json:"GPSLongitudeRef"`
+	GPSLongitudeSeconds   *int       `json:"GPSLongitudeSeconds"`
+	ISOSpeedRatings       *int       `json:"ISOSpeedRatings"`
+	LensModel             *string    `json:"LensModel"`
+	Make                  *string    `json:"Make"`
+	Model                 *string    `json:"Model"`
+	Orientation           *int       `json:"Orientation"`
+	PixelXDimension       *int       `json:"PixelXDimension"`
+	PixelYDimension       *int       `json:"PixelYDimension"`
+}
+
+// getAnnotationKey returns the S3 object key of the annotation of the photo with the ID, which is stored beneath the
+// same date folders as its Exif metadata.
+func getAnnotationKey(imageID string) string {
+	return fmt.Sprintf("%s/%s.JSON", s3BucketFolderImagesAnnotations, imageID)
+}
+
+// getImageExifKey returns the S3 object key of the Exif metadata of a compressed image, which is stored beneath the
+// same date folders.
+func getImageExifKey(s3ObjectKey string) string {
+	return fmt.Sprintf("%s/%s.JSON", s3BucketFolderImagesExif, getImageID(s3ObjectKey))
+}
+
+// getAnnotationCoordinate converts an Exif GPS coordinate to decimal degrees, or returns nil if it is not set.
+func getAnnotationCoordinate(degrees *int, minutes *int, seconds *int, ref *string) *float64 {
+	if degrees == nil {
+		return nil
+	}
+	coordinate := float64(*degrees)
+	if minutes != nil {
+		coordinate += float64(*minutes) / 60
+	}
+	if seconds != nil {
+		coordinate += float64(*seconds) / 3600
+	}
+	if ref := strings.ToUpper(aws.StringValue(ref)); ref == "S" || ref == "W" {
+		coordinate = -coordinate
+	}
+	return &coordinate
+}
+
+// getAnnotationBoundingBox converts a Rekognition bounding box, or returns nil if it is not set.
+func getAnnotationBoundingBox(boundingBox *rekognition.BoundingBox) *AnnotationBoundingBox {
+	if boundingBox == nil {
+		return nil
+	}
+	return &AnnotationBoundingBox{
+		Height: aws.Float64Value(boundingBox.Height),
+		Left:   aws.Float64Value(boundingBox.Left),
+		Top:    aws.Float64Value(boundingBox.Top),
+		Width:  aws.Float64Value(boundingBox.Width)}
+}
+
+// getAnnotationExif summarises the Exif metadata. The photo is dated by DateTimeOriginal when it is set.
+func getAnnotationExif(exifMetadata *AnnotationExifMetadata) *AnnotationExif {
+	annotationExif := AnnotationExif{
+		DateTime:              exifMetadata.DateTimeOriginal,
+		ExposureTime:          exifMetadata.ExposureTime,
+		FNumber:               exifMetadata.FNumber,
+		FocalLength:           exifMetadata.FocalLength,
+		FocalLengthIn35mmFilm: exifMetadata.FocalLengthIn35mmFilm,
+		Height:                exifMetadata.PixelYDimension,
+		ISOSpeedRatings:       exifMetadata.ISOSpeedRatings,
+		Latitude:              getAnnotationCoordinate(exifMetadata.GPSLatitudeDegrees, exifMetadata.GPSLatitudeMinutes, exifMetadata.GPSLatitudeSeconds, exifMetadata.GPSLatitudeRef),
+		LensModel:             exifMetadata.LensModel,
+		Longitude:             getAnnotationCoordinate(exifMetadata.GPSLongitudeDegrees, exifMetadata.GPSLongitudeMinutes, exifMetadata.GPSLongitudeSeconds, exifMetadata.GPSLongitudeRef),
+		Make:                  exifMetadata.Make,
+		Model:                 exifMetadata.Model,
+		Orientation:           exifMetadata.Orientation,
+		Width:                 exifMetadata.PixelXDimension}
+	if annotationExif.DateTime == nil {
+		annotationExif.DateTime = exifMetadata.DateTime
+	}
+	return &annotationExif
+}
+
+// getAnnotationFaces summarises the faces detected by DetectFaces.
+func getAnnotationFaces(faceDetails []*rekognition.FaceDetail) *AnnotationFaces {
+	annotationFaces := AnnotationFaces{Attributes: make(map[string]int), Count: len(faceDetails)}
+	for _, faceDetail := range faceDetails {
+		annotationFace := AnnotationFace{
+			BoundingBox: getAnnotationBoundingBox(faceDetail.BoundingBox),
+			Confidence:  aws.Float64Value(faceDetail.Confidence)}
+		if faceDetail.AgeRange != nil {
+			annotationFace.AgeHigh = faceDetail.AgeRange.High
+			annotationFace.AgeLow = faceDetail.AgeRange.Low
+		}
+		if faceDetail.Gender != nil {
+			annotationFace.Gender = aws.StringValue(faceDetail.Gender.Value)
+		}
+		emotionConfidence := 0.0
+		for _, emotion := range faceDetail.Emotions {
+			if confidence := aws.Float64Value(emotion.Confidence); confidence > emotionConfidence {
+				annotationFace.Emotion = aws.StringValue(emotion.Type)
+				emotionConfidence = confidence
+			}
+		}
+
+		// Boolean attributes, ordered by name.
+		for _, attribute := range []struct {
+			name    string
+			present bool
+		}{
+			{"Beard", faceDetail.Beard != nil && aws.BoolValue(faceDetail.Beard.Value)},
+			{"Eyeglasses", faceDetail.Eyeglasses != nil && aws.BoolValue(faceDetail.Eyeglasses.Value)},
+			{"EyesOpen", faceDetail.EyesOpen != nil && aws.BoolValue(faceDetail.EyesOpen.Value)},
+			{"MouthOpen", faceDetail.MouthOpen != nil && aws.BoolValue(faceDetail.MouthOpen.Value)},
+			{"Mustache", faceDetail.Mustache != nil && aws.BoolValue(faceDetail.Mustache.Value)},
+			{"Smile", faceDetail.Smile != nil && aws.BoolValue(faceDetail.Smile.Value)},
+			{"Sunglasses", faceDetail.Sunglasses != nil && aws.BoolValue(faceDetail.Sunglasses.Value)},
+		} {
+			if attribute.present {
+				annotationFace.Attributes = append(annotationFace.Attributes, attribute.name)
+				annotationFaces.Attributes[attribute.name]++
+			}
+		}
+		annotationFaces.Faces = append(annotationFaces.Faces, &annotationFace)
+	}
+	return &annotationFaces
+}
+
+// getAnnotationLabels converts the labels detected by DetectLabels, ordered by decreasing confidence and then by name.
+func getAnnotationLabels(labels []*rekognition.Label) []*AnnotationLabel {
+	annotationLabels := make([]*AnnotationLabel, 0, len(labels))
+	for _, label := range labels {
+		annotationLabel := AnnotationLabel{
+			Categories: []string{},
+			Confidence: aws.Float64Value(label.Confidence),
+			Instances:  len(label.Instances),
+			Name:       aws.StringValue(label.Name),
+			Parents:    []string{}}
+		for _, category := range label.Categories {
+			annotationLabel.Categories = append(annotationLabel.Categories, aws.StringValue(category.Name))
+		}
+		for _, parent := range label.Parents {
+			annotationLabel.Parents = append(annotationLabel.Parents, aws.StringValue(parent.Name))
+		}
+		annotationLabels = append(annotationLabels, &annotationLabel)
+	}
+	sort.SliceStable(annotationLabels, func(i, j int) bool {
+		if annotationLabels[i].Confidence != annotationLabels[j].Confidence {
+			return annotationLabels[i].Confidence > annotationLabels[j].Confidence
+		}
+		return annotationLabels[i].Name < annotationLabels[j].Name
+	})
+	return annotationLabels
+}
+
+// getAnnotationText returns the lines of text detected by DetectText, in the order Rekognition detected them. Words
+// are left out, as every word is part of a line.
+func getAnnotationText(textDetections []*rekognition.TextDetection) []*AnnotationTextLine {
+	var lines []*rekognition.TextDetection
+	for _, textDetection := range textDetections {
+		if aws.StringValue(textDetection.Type) == rekognition.TextTypesLine {
+			lines = append(lines, textDetection)
+		}
+	}
+	sort.SliceStable(lines, func(i, j int) bool {
+		return aws.Int64Value(lines[i].Id) < aws.Int64Value(lines[j].Id)
+	})
+	annotationText := make([]*AnnotationTextLine, 0, len(lines))
+	for _, line := range lines {
+		annotationTextLine := AnnotationTextLine{
+			Confidence: aws.Float64Value(line.Confidence),
+			Text:       aws.StringValue(line.DetectedText)}
+		if line.Geometry != nil {
+			annotationTextLine.BoundingBox = getAnnotationBoundingBox(line.Geometry.BoundingBox)
+		}
+		annotationText = append(annotationText, &annotationTextLine)
+	}
+	return annotationText
+}
+
+// getAnnotation merges the Exif metadata and the outputs of the analyzers that succeeded for the ETag in the status
+// record of a compressed image into its annotation.
+func getAnnotation(s3Client s3iface.S3API, s3BucketName string, s3ObjectKey string, rekognitionStatus *RekognitionStatus) (*Annotation, error) {
+	imageID := getImageID(s3ObjectKey)
+	name := path.Base(imageID)
+	annotation := Annotation{
+		ETag:      rekognitionStatus.ETag,
+		ID:        imageID,
+		Key:       s3ObjectKey,
+		Name:      name,
+		Sources:   make(map[string]string),
+		UpdatedAt: time.Now().UTC(),
+		Version:   annotationVersion}
+
+	// Read the Exif metadata and each current output, leaving out those that do not exist.
+	var exifMetadata AnnotationExifMetadata
+	exifETag, err := getS3ObjectJSONETag(s3Client, s3BucketName, getImageExifKey(s3ObjectKey), &exifMetadata)
+	if err != nil {
+		return nil, fmt.Errorf("exif: %w", err)
+	}
+	if exifETag != "" {
+		annotation.Exif = getAnnotationExif(&exifMetadata)
+		annotation.Sources["Exif"] = exifETag
+	}
+	for _, section := range []struct {
+		analyzerName string
+		name         string
+		read         func() (bool, error)
+	}{
+		{"DetectFaces", "Faces", func() (bool, error) {
+			var rekognitionDetectFacesOutput rekognition.DetectFacesOutput
+			ok, err := getS3ObjectJSON(s3Client, s3BucketName, fmt.Sprintf("%s/%s.JSON", s3BucketFolderRekognitionDetectFaces, name), &rekognitionDetectFacesOutput)
+			if ok {
+				annotation.Faces = getAnnotationFaces(rekognitionDetectFacesOutput.FaceDetails)
+			}
+			return ok, err
+		}},
+		{"DetectLabels", "Labels", func() (bool, error) {
+			var rekognitionDetectLabelsOutput rekognition.DetectLabelsOutput
+			ok, err := getS3ObjectJSON(s3Client, s3BucketName, fmt.Sprintf("%s/%s.JSON", s3BucketFolderRekognitionDetectLabels, name), &rekognitionDetectLabelsOutput)
+			if ok {
+				annotation.Labels = getAnnotationLabels(rekognitionDetectLabelsOutput.Labels)
+			}
+			return ok, err
+		}},
+		{"DetectText", "Text", func() (bool, error) {
+			var rekognitionDetectTextOutput rekognition.DetectTextOutput
+			ok, err := getS3ObjectJSON(s3Client, s3BucketName, fmt.Sprintf("%s/%s.JSON", s3BucketFolderRekognitionDetectText, name), &rekognitionDetectTextOutput)
+			if ok {
+				annotation.Text = getAnnotationText(rekognitionDetectTextOutput.TextDetections)
+			}
+			return ok, err
+		}},
+		{analyzerNameDetectModerationLabels, "Moderation", func() (bool, error) {
+			moderationVerdict, err := getS3ObjectModerationVerdict(s3Client, s3BucketName, name)
+			annotation.Moderation = moderationVerdict
+			return moderationVerdict != nil, err
+		}},
+	} {
+		// An output left by another version of the image is skipped until its analyzer succeeds for this one.
+		if !slices.Contains(rekognitionStatus.Succeeded, section.analyzerName) {
+			continue
+		}
+		ok, err := section.read()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", strings.ToLower(section.name), err)
+		}
+		if ok {
+			annotation.Sources[section.name] = rekognitionStatus.ETag
+		}
+	}
+
+	return &annotation, nil
+}
+
+// processAnnotation builds the annotation of a compressed image and stores it only if no other build changed it in
+// the meantime, building it again from the latest documents otherwise.
+func processAnnotation(s3Client s3iface.S3API, s3BucketName string, s3ObjectKey string, rekognitionStatus *RekognitionStatus) error {
+	s3ObjectKeyAnnotation := getAnnotationKey(getImageID(s3ObjectKey))
+	for attempt := 1; ; attempt++ {
+		var storedAnnotation Annotation
+		eTag, err := getS3ObjectJSONETag(s3Client, s3BucketName, s3ObjectKeyAnnotation, &storedAnnotation)
+		if err != nil {
+			return err
+		}
+		annotation, err := getAnnotation(s3Client, s3BucketName, s3ObjectKey, rekognitionStatus)
+		if err != nil {
+			return err
+		}
+		log.Printf("Annotation: ID=%s Version=%d Exif=%v Faces=%v Labels=%d Text=%d Moderation=%v",
+			annotation.ID,
+			annotation.Version,
+			annotation.Exif != nil,
+			annotation.Faces != nil,
+			len(annotation.Labels),
+			len(annotation.Text),
+			annotation.Moderation != nil)
+		err = processS3ObjectJSONConditional(s3Client, s3BucketName, s3ObjectKeyAnnotation, annotation, eTag)
+		if err == nil {
+			return nil
+		}
+		if !isS3PreconditionFailed(err) || attempt == annotationMaxAttempts {
+			return err
+		}
+		log.Printf("Annotation: ID=%s Attempt=%d Error=%s", annotation.ID, attempt, err)
+	}
+}
+
+// processAnnotationExif builds the annotation of a compressed image again once its Exif metadata is created, from the
+// status record of the image. An image whose status record has not been stored yet is skipped, as its analysis
+// builds the annotation after storing the record and so reads the Exif metadata.
+func processAnnotationExif(s3Client s3iface.S3API, s3BucketName string, s3ObjectKeyExif string) error {
+	imageID := strings.TrimSuffix(strings.TrimPrefix(s3ObjectKeyExif, s3BucketFolderImagesExif+"/"), ".JSON")
+	var rekognitionStatus RekognitionStatus
+	ok, err := getS3ObjectJSON(s3Client, s3BucketName, getRekognitionStatusKey(path.Base(imageID)), &rekognitionStatus)
+	if err != nil {
+		return fmt.Errorf("status: %w", err)
+	}
+
+	// The status record is keyed by name, so it may belong to a photo with the same name taken on another day.
+	if !ok || rekognitionStatus.Key == "" || getImageID(rekognitionStatus.Key) != imageID {
+		log.Printf("Annotation: ID=%s Pending=true", imageID)
+		return nil
+	}
+	return processAnnotation(s3Client, s3BucketName, rekognitionStatus.Key, &rekognitionStatus)
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// getTestAnnotation decodes the annotation of the photo with the ID.
+func getTestAnnotation(t *testing.T, s3Client *fakeS3, imageID string) *Annotation {
+	b, ok := s3Client.getObject(getAnnotationKey(imageID))
+	if !ok {
+		t.Fatalf("Annotation: %s not stored", imageID)
+	}
+	var annotation Annotation
+	if err := json.Unmarshal(b, &annotation); err != nil {
+		t.Fatalf("Annotation: %s", err)
+	}
+	return &annotation
+}
+
+func TestGetAnnotationCoordinate(t *testing.T) {
+	if coordinate := getAnnotationCoordinate(aws.Int(51), aws.Int(30), aws.Int(36), aws.String("N")); coordinate == nil || math.Abs(*coordinate-51.51) > 1e-9 {
+		t.Errorf("GetAnnotationCoordinate: %v", coordinate)
+	}
+	if coordinate := getAnnotationCoordinate(aws.Int(33), aws.Int(52), nil, aws.String("S")); coordinate == nil || math.Abs(*coordinate+(33+52.0/60)) > 1e-9 {
+		t.Errorf("GetAnnotationCoordinate: %v south", coordinate)
+	}
+	if coordinate := getAnnotationCoordinate(nil, aws.Int(30), aws.Int(0), aws.String("W")); coordinate != nil {
+		t.Errorf("GetAnnotationCoordinate: %v without degrees", *coordinate)
+	}
+}
+
+func TestGetAnnotationSections(t *testing.T) {
+	rekognitionClient := newFakeRekognition()
+	rekognitionDetectFacesOutput, err := rekognitionClient.DetectFaces(nil)
+	if err != nil {
+		t.Fatalf("DetectFaces: %s", err)
+	}
+	annotationFaces := getAnnotationFaces(rekognitionDetectFacesOutput.FaceDetails)
+	if annotationFaces.Count != 1 || annotationFaces.Attributes["Smile"] != 1 || annotationFaces.Faces[0].Emotion != "HAPPY" || aws.Int64Value(annotationFaces.Faces[0].AgeLow) != 30 {
+		t.Errorf("GetAnnotationFaces: %+v %+v", annotationFaces, annotationFaces.Faces[0])
+	}
+	if !reflect.DeepEqual(annotationFaces.Faces[0].Attributes, []string{"Smile"}) {
+		t.Errorf("GetAnnotationFaces: attributes %v", annotationFaces.Faces[0].Attributes)
+	}
+
+	rekognitionDetectLabelsOutput, err := rekognitionClient.DetectLabels(nil)
+	if err != nil {
+		t.Fatalf("DetectLabels: %s", err)
+	}
+	annotationLabels := getAnnotationLabels(rekognitionDetectLabelsOutput.Labels)
+	if len(annotationLabels) != 3 || annotationLabels[0].Name != "Animal" || annotationLabels[1].Name != "Dog" || annotationLabels[2].Name != "Grass" {
+		t.Fatalf("GetAnnotationLabels: %+v", annotationLabels)
+	}
+	if !reflect.DeepEqual(annotationLabels[1].Parents, []string{"Animal", "Mammal", "Pet"}) || !reflect.DeepEqual(annotationLabels[1].Categories, []string{"Animals and Pets"}) {
+		t.Errorf("GetAnnotationLabels: %+v", annotationLabels[1])
+	}
+
+	rekognitionDetectTextOutput, err := rekognitionClient.DetectText(nil)
+	if err != nil {
+		t.Fatalf("DetectText: %s", err)
+	}
+	annotationText := getAnnotationText(rekognitionDetectTextOutput.TextDetections)
+	if len(annotationText) != 1 || annotationText[0].Text != "GOOD BOY" || annotationText[0].BoundingBox.Top != 0.8 {
+		t.Errorf("GetAnnotationText: %+v", annotationText)
+	}
+}
+
+func TestProcessAnnotation(t *testing.T) {
+	setTestConfiguration(t)
+	s3ObjectKey := "images/compressed//2024/05/01/IMG_0001.JPG"
+	analyzerClients, rekognitionClient, s3Client := newTestAnalyzerClients(t, s3ObjectKey)
+
+	if err := processRekognition(analyzerClients, s3ObjectKey, "etag"); err != nil {
+		t.Fatalf("ProcessRekognition: %s", err)
+	}
+	annotation := getTestAnnotation(t, s3Client, "2024/05/01/IMG_0001")
+	if annotation.Version != annotationVersion || annotation.ETag != "etag" || annotation.ID != "2024/05/01/IMG_0001" || annotation.Key != s3ObjectKey || annotation.Name != "IMG_0001" {
+		t.Errorf("ProcessAnnotation: %+v", annotation)
+	}
+	if annotation.Exif == nil || aws.StringValue(annotation.Exif.Model) != "EOS R5" || annotation.Exif.DateTime == nil || annotation.Exif.Longitude == nil || *annotation.Exif.Longitude != -0.125 {
+		t.Errorf("ProcessAnnotation: exif %+v", annotation.Exif)
+	}
+	if annotation.Faces == nil || annotation.Faces.Count != 1 || len(annotation.Labels) != 3 || len(annotation.Text) != 1 {
+		t.Errorf("ProcessAnnotation: faces %+v labels %d text %d", annotation.Faces, len(annotation.Labels), len(annotation.Text))
+	}
+	if annotation.Moderation == nil || annotation.Moderation.Safe || annotation.Moderation.Categories[0].Name != "Violence" {
+		t.Errorf("ProcessAnnotation: moderation %+v", annotation.Moderation)
+	}
+	exifMetadata, _ := s3Client.getObject(getImageExifKey(s3ObjectKey))
+	if want := map[string]string{"Exif": getFakeETag(exifMetadata), "Faces": "etag", "Labels": "etag", "Moderation": "etag", "Text": "etag"}; !reflect.DeepEqual(annotation.Sources, want) {
+		t.Errorf("ProcessAnnotation: sources %v", annotation.Sources)
+	}
+
+	// The outputs of the previous version are left out of a new version whose analyzers failed.
+	rekognitionClient.setError("DetectLabels", errors.New("throttled"))
+	if err := processRekognition(analyzerClients, s3ObjectKey, "etag2"); err == nil {
+		t.Fatalf("ProcessRekognition: no error for a failed analyzer")
+	}
+	annotation = getTestAnnotation(t, s3Client, "2024/05/01/IMG_0001")
+	if _, ok := annotation.Sources["Labels"]; ok || annotation.Labels != nil || annotation.ETag != "etag2" || annotation.Sources["Faces"] != "etag2" {
+		t.Errorf("ProcessAnnotation: stale labels %+v sources %v", annotation.Labels, annotation.Sources)
+	}
+	rekognitionClient.setError("DetectLabels", nil)
+
+	// An annotation built before the Exif metadata is stored without it, and built again when the Exif metadata is
+	// created.
+	s3ObjectKeyExif := getImageExifKey(s3ObjectKey)
+	s3Client.DeleteObject(&s3.DeleteObjectInput{Key: aws.String(s3ObjectKeyExif)})
+	if err := processRekognition(analyzerClients, s3ObjectKey, "etag2"); err != nil {
+		t.Errorf("ProcessRekognition: without Exif metadata %s", err)
+	}
+	if annotation := getTestAnnotation(t, s3Client, "2024/05/01/IMG_0001"); annotation.Exif != nil || len(annotation.Labels) != 3 {
+		t.Errorf("ProcessAnnotation: without Exif metadata %+v", annotation)
+	}
+	s3Client.putObject(s3ObjectKeyExif, exifMetadata)
+	if err := processAnnotationExif(s3Client, "photos", s3ObjectKeyExif); err != nil {
+		t.Fatalf("ProcessAnnotationExif: %s", err)
+	}
+	if annotation := getTestAnnotation(t, s3Client, "2024/05/01/IMG_0001"); annotation.Exif == nil || annotation.ETag != "etag2" || len(annotation.Labels) != 3 {
+		t.Errorf("ProcessAnnotationExif: %+v", annotation)
+	}
+}
+
+func TestProcessAnnotationExif(t *testing.T) {
+	setTestConfiguration(t)
+	s3ObjectKey := "images/compressed//2024/05/01/IMG_0001.JPG"
+	analyzerClients, _, s3Client := newTestAnalyzerClients(t, s3ObjectKey)
+
+	// Exif metadata created before the image is analysed is read by the analysis.
+	if err := processAnnotationExif(s3Client, "photos", getImageExifKey(s3ObjectKey)); err != nil {
+		t.Fatalf("ProcessAnnotationExif: %s", err)
+	}
+	if _, ok := s3Client.getObject(getAnnotationKey("2024/05/01/IMG_0001")); ok {
+		t.Errorf("ProcessAnnotationExif: annotation stored before the analysis")
+	}
+
+	// Exif metadata of a photo with the same name taken on another day leaves the annotation alone.
+	if err := processRekognition(analyzerClients, s3ObjectKey, "etag"); err != nil {
+		t.Fatalf("ProcessRekognition: %s", err)
+	}
+	s3ObjectKeyExifOther := getImageExifKey("images/compressed//2024/06/01/IMG_0001.JPG")
+	s3Client.putObject(s3ObjectKeyExifOther, []byte(`{"Make": "Nikon"}`))
+	if err := processAnnotationExif(s3Client, "photos", s3ObjectKeyExifOther); err != nil {
+		t.Fatalf("ProcessAnnotationExif: other day %s", err)
+	}
+	if _, ok := s3Client.getObject(getAnnotationKey("2024/06/01/IMG_0001")); ok {
+		t.Errorf("ProcessAnnotationExif: annotation stored for another day")
+	}
+	if annotation := getTestAnnotation(t, s3Client, "2024/05/01/IMG_0001"); aws.StringValue(annotation.Exif.Make) != "Canon" {
+		t.Errorf("ProcessAnnotationExif: other day %+v", annotation.Exif)
+	}
+}
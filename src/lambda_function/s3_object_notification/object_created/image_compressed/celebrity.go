@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"path"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/rekognition"
+	"github.com/aws/aws-sdk-go/service/rekognition/rekognitioniface"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+)
+
+// Contains functions for recognising celebrities and adding them to the keyword set of a photo.
+
+// getCelebrityKeywords returns a keyword for each celebrity recognised in a photo. A celebrity recognised in several
+// faces is listed once, with the highest match confidence. URLs are returned by Rekognition without a scheme, such
+// as www.imdb.com/name/nm0000123, and are stored with https.
+func getCelebrityKeywords(celebrities []*rekognition.Celebrity) []*Keyword {
+	var keywords []*Keyword
+	keywordMap := make(map[string]*Keyword)
+	for _, celebrity := range celebrities {
+		name := aws.StringValue(celebrity.Name)
+		if name == "" {
+			continue
+		}
+		confidence := aws.Float64Value(celebrity.MatchConfidence)
+		if keyword, ok := keywordMap[name]; ok {
+			keyword.Confidence = max(keyword.Confidence, confidence)
+			continue
+		}
+		keyword := Keyword{Confidence: confidence, Name: name}
+		for _, url := range aws.StringValueSlice(celebrity.Urls) {
+			if url == "" {
+				continue
+			}
+			if !strings.Contains(url, "://") {
+				url = "https://" + url
+			}
+			keyword.URLs = append(keyword.URLs, url)
+		}
+		keywordMap[name] = &keyword
+		keywords = append(keywords, &keyword)
+	}
+	return keywords
+}
+
+// processRekognitionRecognizeCelebrities processes an S3 object image with AWS Rekognition Recognize Celebrities.
+func processRekognitionRecognizeCelebrities(rekognitionClient rekognitioniface.RekognitionAPI, s3Client s3iface.S3API, rekognitionImage *rekognition.Image, s3BucketName string, s3ObjectKey string) (*rekognition.RecognizeCelebritiesOutput, error) {
+	// Create a Rekognition RecognizeCelebritiesInput.
+	rekognitionRecognizeCelebritiesInput := rekognition.RecognizeCelebritiesInput{
+		Image: rekognitionImage,
+	}
+
+	// Perform celebrity recognition using Rekognition.
+	rekognitionRecognizeCelebritiesOutput, err := rekognitionClient.RecognizeCelebrities(&rekognitionRecognizeCelebritiesInput)
+	if err != nil {
+		return nil, err
+	}
+
+	// Process the output and store it in S3.
+	if err := processRekognitionRecognizeCelebritiesOutput(s3Client, rekognitionRecognizeCelebritiesOutput, s3BucketName, s3ObjectKey); err != nil {
+		return nil, err
+	}
+	return rekognitionRecognizeCelebritiesOutput, nil
+}
+
+// processRekognitionRecognizeCelebritiesOutput processes a rekognition.RecognizeCelebritiesOutput, storing it in S3
+// and adding the recognised celebrities to the keyword set of the photo.
+func processRekognitionRecognizeCelebritiesOutput(s3Client s3iface.S3API, rekognitionRecognizeCelebritiesOutput *rekognition.RecognizeCelebritiesOutput, s3BucketName string, s3ObjectKey string) error {
+	log.Printf("RekognitionRecognizeCelebritiesOutput: CelebrityFaces=%d UnrecognizedFaces=%d",
+		len(rekognitionRecognizeCelebritiesOutput.CelebrityFaces),
+		len(rekognitionRecognizeCelebritiesOutput.UnrecognizedFaces))
+
+	// Modify the S3 object key for storage.
+	name := strings.Split(path.Base(s3ObjectKey), ".")[0]
+	s3ObjectKey = fmt.Sprintf("%s/%s.JSON", s3BucketFolderRekognitionRecognizeCelebrities, name)
+
+	// Store the output in S3.
+	if err := processS3ObjectJSON(s3Client, s3BucketName, s3ObjectKey, rekognitionRecognizeCelebritiesOutput); err != nil {
+		return err
+	}
+
+	// Add the recognised celebrities to the keyword set of the photo.
+	return processKeywordSet(s3Client, s3BucketName, name, keywordSourceCelebrity, getCelebrityKeywords(rekognitionRecognizeCelebritiesOutput.CelebrityFaces))
+}
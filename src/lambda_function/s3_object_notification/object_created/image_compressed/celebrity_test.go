@@ -0,0 +1,40 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/rekognition"
+)
+
+func TestGetCelebrityKeywords(t *testing.T) {
+	keywords := getCelebrityKeywords([]*rekognition.Celebrity{
+		{MatchConfidence: aws.Float64(98), Name: aws.String("Jane Doe"), Urls: aws.StringSlice([]string{"www.imdb.com/name/nm0000001", "www.wikidata.org/wiki/Q1"})},
+		{MatchConfidence: aws.Float64(99.5), Name: aws.String("Jane Doe"), Urls: aws.StringSlice([]string{"www.imdb.com/name/nm0000001"})},
+		{MatchConfidence: aws.Float64(90), Name: aws.String("John Roe"), Urls: aws.StringSlice([]string{"", "https://www.wikidata.org/wiki/Q2"})},
+		{MatchConfidence: aws.Float64(90)},
+	})
+	want := []*Keyword{
+		{Confidence: 99.5, Name: "Jane Doe", URLs: []string{"https://www.imdb.com/name/nm0000001", "https://www.wikidata.org/wiki/Q1"}},
+		{Confidence: 90, Name: "John Roe", URLs: []string{"https://www.wikidata.org/wiki/Q2"}},
+	}
+	if !reflect.DeepEqual(keywords, want) {
+		t.Errorf("GetCelebrityKeywords: %+v %+v", keywords[0], keywords[1])
+	}
+}
+
+func TestMergeKeywordSet(t *testing.T) {
+	keywordSet := KeywordSet{Keywords: []*Keyword{
+		{Name: "Old Celebrity", Source: keywordSourceCelebrity},
+		{Name: "Beach", Source: "Label"},
+	}}
+	mergeKeywordSet(&keywordSet, keywordSourceCelebrity, []*Keyword{{Confidence: 99, Name: "Jane Doe"}})
+	var names []string
+	for _, keyword := range keywordSet.Keywords {
+		names = append(names, keyword.Name+"/"+keyword.Source)
+	}
+	if want := []string{"Beach/Label", "Jane Doe/Celebrity"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("MergeKeywordSet: %v", names)
+	}
+}
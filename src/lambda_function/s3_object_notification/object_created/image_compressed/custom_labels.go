@@ -0,0 +1,124 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"path"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/arn"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/rekognition"
+	"github.com/aws/aws-sdk-go/service/rekognition/rekognitioniface"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+)
+
+// Contains functions for detecting labels with a Rekognition Custom Labels model.
+//
+// A Custom Labels model only answers while its project version is running, which is started and stopped outside the
+// pipeline because a running model is billed by the hour. Photos processed while the model is stopped are logged and
+// skipped, leaving any earlier output and keywords in place, rather than failing the Lambda. A skipped photo is not
+// recorded as analysed, so it is analysed the next time it is processed.
+
+// getProjectVersionARN validates the ARN of a Custom Labels project version. An empty ARN disables the detector.
+func getProjectVersionARN(projectVersionARN string) (string, error) {
+	if projectVersionARN == "" {
+		return "", nil
+	}
+	parsedARN, err := arn.Parse(projectVersionARN)
+	if err != nil {
+		return "", err
+	}
+	if parsedARN.Service != "rekognition" || !strings.HasPrefix(parsedARN.Resource, "project/") || !strings.Contains(parsedARN.Resource, "/version/") {
+		return "", fmt.Errorf("%q is not a Rekognition project version ARN", projectVersionARN)
+	}
+	return projectVersionARN, nil
+}
+
+// getCustomLabelKeywords returns a keyword for each custom label detected in a photo. A label detected several
+// times, such as a product appearing twice, is listed once with the highest confidence.
+func getCustomLabelKeywords(customLabels []*rekognition.CustomLabel) []*Keyword {
+	var keywords []*Keyword
+	keywordMap := make(map[string]*Keyword)
+	for _, customLabel := range customLabels {
+		name := aws.StringValue(customLabel.Name)
+		if name == "" {
+			continue
+		}
+		confidence := aws.Float64Value(customLabel.Confidence)
+		if keyword, ok := keywordMap[name]; ok {
+			keyword.Confidence = max(keyword.Confidence, confidence)
+			continue
+		}
+		keyword := Keyword{Confidence: confidence, Name: name}
+		keywordMap[name] = &keyword
+		keywords = append(keywords, &keyword)
+	}
+	return keywords
+}
+
+// isRekognitionResourceNotReady reports whether the error is returned because the Custom Labels model is not running.
+func isRekognitionResourceNotReady(err error) bool {
+	var awsErr awserr.Error
+	return errors.As(err, &awsErr) && awsErr.Code() == rekognition.ErrCodeResourceNotReadyException
+}
+
+// processRekognitionDetectCustomLabels processes an S3 object image with AWS Rekognition Detect Custom Labels. It
+// returns errAnalyzerSkipped when no project version is configured or the model is not running.
+func processRekognitionDetectCustomLabels(rekognitionClient rekognitioniface.RekognitionAPI, s3Client s3iface.S3API, rekognitionImage *rekognition.Image, s3BucketName string, s3ObjectKey string) (*rekognition.DetectCustomLabelsOutput, error) {
+	if rekognitionDetectCustomLabelsProjectVersionARN == "" {
+		return nil, errAnalyzerSkipped
+	}
+
+	// Create a Rekognition DetectCustomLabelsInput and process it.
+	rekognitionDetectCustomLabelsInput := rekognition.DetectCustomLabelsInput{
+		Image:             rekognitionImage,
+		MinConfidence:     aws.Float64(rekognitionDetectCustomLabelsMinConfidence),
+		ProjectVersionArn: aws.String(rekognitionDetectCustomLabelsProjectVersionARN),
+	}
+	processRekognitionDetectCustomLabelsInput(&rekognitionDetectCustomLabelsInput)
+
+	// Perform custom label detection using Rekognition, skipping the photo while the model is not running.
+	rekognitionDetectCustomLabelsOutput, err := rekognitionClient.DetectCustomLabels(&rekognitionDetectCustomLabelsInput)
+	if isRekognitionResourceNotReady(err) {
+		log.Printf("RekognitionDetectCustomLabelsOutput: Key=%s ProjectVersionArn=%s Skipped=true Error=%s", s3ObjectKey, rekognitionDetectCustomLabelsProjectVersionARN, err)
+		return nil, errAnalyzerSkipped
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Process the output and store it in S3.
+	if err := processRekognitionDetectCustomLabelsOutput(s3Client, rekognitionDetectCustomLabelsOutput, s3BucketName, s3ObjectKey); err != nil {
+		return nil, err
+	}
+	return rekognitionDetectCustomLabelsOutput, nil
+}
+
+// processRekognitionDetectCustomLabelsInput processes a rekognition.DetectCustomLabelsInput.
+func processRekognitionDetectCustomLabelsInput(rekognitionDetectCustomLabelsInput *rekognition.DetectCustomLabelsInput) {
+	log.Printf("RekognitionDetectCustomLabelsInput: MinConfidence=%v ProjectVersionArn=%s",
+		aws.Float64Value(rekognitionDetectCustomLabelsInput.MinConfidence),
+		aws.StringValue(rekognitionDetectCustomLabelsInput.ProjectVersionArn))
+}
+
+// processRekognitionDetectCustomLabelsOutput processes a rekognition.DetectCustomLabelsOutput, storing it in S3 and
+// adding the detected labels to the keyword set of the photo.
+func processRekognitionDetectCustomLabelsOutput(s3Client s3iface.S3API, rekognitionDetectCustomLabelsOutput *rekognition.DetectCustomLabelsOutput, s3BucketName string, s3ObjectKey string) error {
+	log.Printf("RekognitionDetectCustomLabelsOutput: CustomLabels=%d",
+		len(rekognitionDetectCustomLabelsOutput.CustomLabels))
+
+	// Modify the S3 object key for storage.
+	name := strings.Split(path.Base(s3ObjectKey), ".")[0]
+	s3ObjectKey = fmt.Sprintf("%s/%s.JSON", s3BucketFolderRekognitionDetectCustomLabels, name)
+
+	// Store the output in S3.
+	if err := processS3ObjectJSON(s3Client, s3BucketName, s3ObjectKey, rekognitionDetectCustomLabelsOutput); err != nil {
+		return err
+	}
+
+	// Add the detected labels to the keyword set of the photo.
+	return processKeywordSet(s3Client, s3BucketName, name, keywordSourceCustomLabel, getCustomLabelKeywords(rekognitionDetectCustomLabelsOutput.CustomLabels))
+}
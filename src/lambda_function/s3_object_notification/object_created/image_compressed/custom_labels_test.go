@@ -0,0 +1,53 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/rekognition"
+)
+
+func TestGetProjectVersionARN(t *testing.T) {
+	for _, projectVersionARN := range []string{
+		"",
+		"arn:aws:rekognition:eu-west-1:123456789012:project/catalogue/version/catalogue.2024-01-01T00.00.00/1700000000000",
+	} {
+		if value, err := getProjectVersionARN(projectVersionARN); err != nil || value != projectVersionARN {
+			t.Errorf("GetProjectVersionARN: %q %v", projectVersionARN, err)
+		}
+	}
+	for _, projectVersionARN := range []string{
+		"catalogue",
+		"arn:aws:s3:::bucket/project/catalogue/version/1",
+		"arn:aws:rekognition:eu-west-1:123456789012:project/catalogue/1700000000000",
+		"arn:aws:rekognition:eu-west-1:123456789012:collection/people",
+	} {
+		if _, err := getProjectVersionARN(projectVersionARN); err == nil {
+			t.Errorf("GetProjectVersionARN: %q accepted", projectVersionARN)
+		}
+	}
+}
+
+func TestGetCustomLabelKeywords(t *testing.T) {
+	keywords := getCustomLabelKeywords([]*rekognition.CustomLabel{
+		{Confidence: aws.Float64(80), Name: aws.String("Mug")},
+		{Confidence: aws.Float64(95), Name: aws.String("Mug")},
+		{Confidence: aws.Float64(70), Name: aws.String("Tote Bag")},
+		{Confidence: aws.Float64(90)},
+	})
+	want := []*Keyword{{Confidence: 95, Name: "Mug"}, {Confidence: 70, Name: "Tote Bag"}}
+	if !reflect.DeepEqual(keywords, want) {
+		t.Errorf("GetCustomLabelKeywords: %+v", keywords)
+	}
+}
+
+func TestIsRekognitionResourceNotReady(t *testing.T) {
+	if !isRekognitionResourceNotReady(awserr.New(rekognition.ErrCodeResourceNotReadyException, "not running", nil)) {
+		t.Errorf("IsRekognitionResourceNotReady: not ready not detected")
+	}
+	if isRekognitionResourceNotReady(awserr.New(rekognition.ErrCodeAccessDeniedException, "denied", nil)) || isRekognitionResourceNotReady(nil) {
+		t.Errorf("IsRekognitionResourceNotReady: other error detected")
+	}
+}
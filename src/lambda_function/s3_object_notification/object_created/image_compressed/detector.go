@@ -0,0 +1,230 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/rekognition"
+)
+
+// Contains the configuration of the DetectFaces, DetectLabels and DetectText detectors.
+//
+// The configuration is a JSON object with an optional member per detector, and is validated against the limits of
+// Rekognition when the Lambda starts, so that a mistake fails the deployment rather than every photo. Members left
+// out keep the inputs the detectors have always used.
+
+// Limits of the detector parameters accepted by Rekognition.
+const (
+	detectLabelsMaxFilters = 100
+	detectTextMaxRegions   = 10
+)
+
+// DetectFacesConfiguration configures DetectFaces. Attributes defaults to ALL.
+type DetectFacesConfiguration struct {
+	Attributes []string `json:"Attributes"`
+}
+
+// DetectLabelsConfiguration configures DetectLabels. Features defaults to GENERAL_LABELS and IMAGE_PROPERTIES, and
+// the label and category filters require GENERAL_LABELS.
+type DetectLabelsConfiguration struct {
+	ExcludedCategories []string `json:"ExcludedCategories"`
+	ExcludedLabels     []string `json:"ExcludedLabels"`
+	Features           []string `json:"Features"`
+	IncludedCategories []string `json:"IncludedCategories"`
+	IncludedLabels     []string `json:"IncludedLabels"`
+	MaxLabels          *int64   `json:"MaxLabels"`
+	MinConfidence      *float64 `json:"MinConfidence"`
+}
+
+// DetectTextConfiguration configures DetectText. Words smaller than the minimum bounding box, relative to the image
+// size, or below the minimum confidence are filtered out, and regions of interest limit detection to parts of the
+// image. The filters only shape the stored output and the annotation: the image Lambda redacts text from its own
+// DetectText call without filters, so that text filtered out here is still redacted.
+type DetectTextConfiguration struct {
+	MinBoundingBoxHeight *float64                   `json:"MinBoundingBoxHeight"`
+	MinBoundingBoxWidth  *float64                   `json:"MinBoundingBoxWidth"`
+	MinConfidence        *float64                   `json:"MinConfidence"`
+	RegionsOfInterest    []*rekognition.BoundingBox `json:"RegionsOfInterest"`
+}
+
+// DetectorConfiguration configures the detectors run on every compressed image.
+type DetectorConfiguration struct {
+	DetectFaces  DetectFacesConfiguration  `json:"DetectFaces"`
+	DetectLabels DetectLabelsConfiguration `json:"DetectLabels"`
+	DetectText   DetectTextConfiguration   `json:"DetectText"`
+}
+
+// validateDetectorValues checks that every value is one of the allowed values and that none is repeated.
+func validateDetectorValues(name string, values []string, allowedValues []string) error {
+	for i, value := range values {
+		if !slices.Contains(allowedValues, value) {
+			return fmt.Errorf("%s has unknown value %q", name, value)
+		}
+		if slices.Contains(values[:i], value) {
+			return fmt.Errorf("%s has duplicate value %q", name, value)
+		}
+	}
+	return nil
+}
+
+// validateDetectorFilter checks that a label or category filter is within the Rekognition limit and has no empty
+// or duplicate names.
+func validateDetectorFilter(name string, filter []string) error {
+	if len(filter) > detectLabelsMaxFilters {
+		return fmt.Errorf("%s has %d entries, more than %d", name, len(filter), detectLabelsMaxFilters)
+	}
+	for i, value := range filter {
+		if value == "" {
+			return fmt.Errorf("%s has an empty entry", name)
+		}
+		if slices.Contains(filter[:i], value) {
+			return fmt.Errorf("%s has duplicate entry %q", name, value)
+		}
+	}
+	return nil
+}
+
+// validateDetectorConfidence checks that an optional minimum confidence is between 0 and 100.
+func validateDetectorConfidence(name string, value *float64) error {
+	if value != nil && (*value < 0 || *value > 100) {
+		return fmt.Errorf("%s %v outside 0-100", name, *value)
+	}
+	return nil
+}
+
+// validateDetectorRatio checks that an optional value is a ratio of the image size between 0 and 1.
+func validateDetectorRatio(name string, value *float64) error {
+	if value != nil && (*value < 0 || *value > 1) {
+		return fmt.Errorf("%s %v outside 0-1", name, *value)
+	}
+	return nil
+}
+
+// getDetectorConfiguration parses and validates the JSON detector configuration, filling in the defaults. Unknown
+// members are rejected, so that a misspelt parameter is not silently ignored.
+func getDetectorConfiguration(configuration string) (*DetectorConfiguration, error) {
+	var detectorConfiguration DetectorConfiguration
+	decoder := json.NewDecoder(strings.NewReader(configuration))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&detectorConfiguration); err != nil {
+		return nil, err
+	}
+
+	// DetectFaces.
+	detectFaces := &detectorConfiguration.DetectFaces
+	if len(detectFaces.Attributes) == 0 {
+		detectFaces.Attributes = []string{rekognition.AttributeAll}
+	}
+	if err := validateDetectorValues("DetectFaces.Attributes", detectFaces.Attributes, rekognition.Attribute_Values()); err != nil {
+		return nil, err
+	}
+
+	// DetectLabels.
+	detectLabels := &detectorConfiguration.DetectLabels
+	if len(detectLabels.Features) == 0 {
+		detectLabels.Features = []string{rekognition.DetectLabelsFeatureNameGeneralLabels, rekognition.DetectLabelsFeatureNameImageProperties}
+	}
+	if err := validateDetectorValues("DetectLabels.Features", detectLabels.Features, rekognition.DetectLabelsFeatureName_Values()); err != nil {
+		return nil, err
+	}
+	filters := map[string][]string{
+		"DetectLabels.ExcludedCategories": detectLabels.ExcludedCategories,
+		"DetectLabels.ExcludedLabels":     detectLabels.ExcludedLabels,
+		"DetectLabels.IncludedCategories": detectLabels.IncludedCategories,
+		"DetectLabels.IncludedLabels":     detectLabels.IncludedLabels,
+	}
+	hasFilters := false
+	for name, filter := range filters {
+		if err := validateDetectorFilter(name, filter); err != nil {
+			return nil, err
+		}
+		hasFilters = hasFilters || len(filter) > 0
+	}
+	if hasFilters && !slices.Contains(detectLabels.Features, rekognition.DetectLabelsFeatureNameGeneralLabels) {
+		return nil, fmt.Errorf("DetectLabels filters require the %s feature", rekognition.DetectLabelsFeatureNameGeneralLabels)
+	}
+	for _, label := range detectLabels.IncludedLabels {
+		if slices.Contains(detectLabels.ExcludedLabels, label) {
+			return nil, fmt.Errorf("DetectLabels label %q is both included and excluded", label)
+		}
+	}
+	for _, category := range detectLabels.IncludedCategories {
+		if slices.Contains(detectLabels.ExcludedCategories, category) {
+			return nil, fmt.Errorf("DetectLabels category %q is both included and excluded", category)
+		}
+	}
+	if detectLabels.MaxLabels != nil && *detectLabels.MaxLabels < 0 {
+		return nil, fmt.Errorf("DetectLabels.MaxLabels %d is negative", *detectLabels.MaxLabels)
+	}
+	if err := validateDetectorConfidence("DetectLabels.MinConfidence", detectLabels.MinConfidence); err != nil {
+		return nil, err
+	}
+
+	// DetectText.
+	detectText := &detectorConfiguration.DetectText
+	if err := validateDetectorConfidence("DetectText.MinConfidence", detectText.MinConfidence); err != nil {
+		return nil, err
+	}
+	if err := validateDetectorRatio("DetectText.MinBoundingBoxHeight", detectText.MinBoundingBoxHeight); err != nil {
+		return nil, err
+	}
+	if err := validateDetectorRatio("DetectText.MinBoundingBoxWidth", detectText.MinBoundingBoxWidth); err != nil {
+		return nil, err
+	}
+	if len(detectText.RegionsOfInterest) > detectTextMaxRegions {
+		return nil, fmt.Errorf("DetectText.RegionsOfInterest has %d regions, more than %d", len(detectText.RegionsOfInterest), detectTextMaxRegions)
+	}
+	for i, boundingBox := range detectText.RegionsOfInterest {
+		if boundingBox == nil || boundingBox.Height == nil || boundingBox.Left == nil || boundingBox.Top == nil || boundingBox.Width == nil {
+			return nil, fmt.Errorf("DetectText.RegionsOfInterest[%d] needs Height, Left, Top and Width", i)
+		}
+		height, left, top, width := *boundingBox.Height, *boundingBox.Left, *boundingBox.Top, *boundingBox.Width
+		if left < 0 || top < 0 || width <= 0 || height <= 0 || left+width > 1 || top+height > 1 {
+			return nil, fmt.Errorf("DetectText.RegionsOfInterest[%d] is not within the image", i)
+		}
+	}
+	return &detectorConfiguration, nil
+}
+
+// getDetectLabelsSettings returns the DetectLabels settings for the configured filters, or nil if there are none.
+func getDetectLabelsSettings(detectLabels *DetectLabelsConfiguration) *rekognition.DetectLabelsSettings {
+	if len(detectLabels.ExcludedCategories)+len(detectLabels.ExcludedLabels)+len(detectLabels.IncludedCategories)+len(detectLabels.IncludedLabels) == 0 {
+		return nil
+	}
+	generalLabelsSettings := rekognition.GeneralLabelsSettings{}
+	if len(detectLabels.ExcludedCategories) > 0 {
+		generalLabelsSettings.LabelCategoryExclusionFilters = aws.StringSlice(detectLabels.ExcludedCategories)
+	}
+	if len(detectLabels.ExcludedLabels) > 0 {
+		generalLabelsSettings.LabelExclusionFilters = aws.StringSlice(detectLabels.ExcludedLabels)
+	}
+	if len(detectLabels.IncludedCategories) > 0 {
+		generalLabelsSettings.LabelCategoryInclusionFilters = aws.StringSlice(detectLabels.IncludedCategories)
+	}
+	if len(detectLabels.IncludedLabels) > 0 {
+		generalLabelsSettings.LabelInclusionFilters = aws.StringSlice(detectLabels.IncludedLabels)
+	}
+	return &rekognition.DetectLabelsSettings{GeneralLabels: &generalLabelsSettings}
+}
+
+// getDetectTextFilters returns the DetectText filters for the configured word filter and regions of interest, or nil
+// if there are none.
+func getDetectTextFilters(detectText *DetectTextConfiguration) *rekognition.DetectTextFilters {
+	var detectTextFilters rekognition.DetectTextFilters
+	if detectText.MinBoundingBoxHeight != nil || detectText.MinBoundingBoxWidth != nil || detectText.MinConfidence != nil {
+		detectTextFilters.WordFilter = &rekognition.DetectionFilter{
+			MinBoundingBoxHeight: detectText.MinBoundingBoxHeight,
+			MinBoundingBoxWidth:  detectText.MinBoundingBoxWidth,
+			MinConfidence:        detectText.MinConfidence}
+	}
+	for _, boundingBox := range detectText.RegionsOfInterest {
+		detectTextFilters.RegionsOfInterest = append(detectTextFilters.RegionsOfInterest, &rekognition.RegionOfInterest{BoundingBox: boundingBox})
+	}
+	if detectTextFilters.WordFilter == nil && detectTextFilters.RegionsOfInterest == nil {
+		return nil
+	}
+	return &detectTextFilters
+}
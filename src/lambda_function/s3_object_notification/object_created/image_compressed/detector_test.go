@@ -0,0 +1,62 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+func TestGetDetectorConfiguration(t *testing.T) {
+	detectorConfiguration, err := getDetectorConfiguration("{}")
+	if err != nil {
+		t.Fatalf("GetDetectorConfiguration: %s", err)
+	}
+	if !reflect.DeepEqual(detectorConfiguration.DetectFaces.Attributes, []string{"ALL"}) || !reflect.DeepEqual(detectorConfiguration.DetectLabels.Features, []string{"GENERAL_LABELS", "IMAGE_PROPERTIES"}) {
+		t.Errorf("GetDetectorConfiguration: defaults %+v", detectorConfiguration)
+	}
+	if getDetectLabelsSettings(&detectorConfiguration.DetectLabels) != nil || getDetectTextFilters(&detectorConfiguration.DetectText) != nil {
+		t.Errorf("GetDetectorConfiguration: default filters set")
+	}
+
+	detectorConfiguration, err = getDetectorConfiguration(`{
+		"DetectFaces": {"Attributes": ["DEFAULT", "EMOTIONS"]},
+		"DetectLabels": {"Features": ["GENERAL_LABELS"], "ExcludedLabels": ["Person"], "IncludedCategories": ["Animals and Pets"], "MaxLabels": 20, "MinConfidence": 75},
+		"DetectText": {"MinConfidence": 90, "MinBoundingBoxHeight": 0.05, "RegionsOfInterest": [{"Height": 0.2, "Left": 0, "Top": 0.8, "Width": 1}]}}`)
+	if err != nil {
+		t.Fatalf("GetDetectorConfiguration: %s", err)
+	}
+	if aws.Int64Value(detectorConfiguration.DetectLabels.MaxLabels) != 20 || aws.Float64Value(detectorConfiguration.DetectLabels.MinConfidence) != 75 {
+		t.Errorf("GetDetectorConfiguration: %+v", detectorConfiguration.DetectLabels)
+	}
+	detectLabelsSettings := getDetectLabelsSettings(&detectorConfiguration.DetectLabels)
+	if detectLabelsSettings == nil || !reflect.DeepEqual(aws.StringValueSlice(detectLabelsSettings.GeneralLabels.LabelExclusionFilters), []string{"Person"}) ||
+		!reflect.DeepEqual(aws.StringValueSlice(detectLabelsSettings.GeneralLabels.LabelCategoryInclusionFilters), []string{"Animals and Pets"}) ||
+		detectLabelsSettings.GeneralLabels.LabelInclusionFilters != nil {
+		t.Errorf("GetDetectLabelsSettings: %v", detectLabelsSettings)
+	}
+	detectTextFilters := getDetectTextFilters(&detectorConfiguration.DetectText)
+	if detectTextFilters == nil || aws.Float64Value(detectTextFilters.WordFilter.MinConfidence) != 90 || detectTextFilters.WordFilter.MinBoundingBoxWidth != nil ||
+		len(detectTextFilters.RegionsOfInterest) != 1 || aws.Float64Value(detectTextFilters.RegionsOfInterest[0].BoundingBox.Top) != 0.8 {
+		t.Errorf("GetDetectTextFilters: %v", detectTextFilters)
+	}
+
+	for _, configuration := range []string{
+		`[]`,
+		`{"DetectLabel": {}}`,
+		`{"DetectFaces": {"Attributes": ["HAIR"]}}`,
+		`{"DetectFaces": {"Attributes": ["ALL", "ALL"]}}`,
+		`{"DetectLabels": {"Features": ["IMAGE_PROPERTIES"], "IncludedLabels": ["Dog"]}}`,
+		`{"DetectLabels": {"IncludedLabels": ["Dog"], "ExcludedLabels": ["Dog"]}}`,
+		`{"DetectLabels": {"ExcludedCategories": [""]}}`,
+		`{"DetectLabels": {"MaxLabels": -1}}`,
+		`{"DetectLabels": {"MinConfidence": 101}}`,
+		`{"DetectText": {"MinBoundingBoxWidth": 2}}`,
+		`{"DetectText": {"RegionsOfInterest": [{"Height": 0.5, "Left": 0.6, "Top": 0, "Width": 0.5}]}}`,
+		`{"DetectText": {"RegionsOfInterest": [{"Height": 0.5, "Left": 0}]}}`,
+	} {
+		if _, err := getDetectorConfiguration(configuration); err == nil {
+			t.Errorf("GetDetectorConfiguration: %s accepted", configuration)
+		}
+	}
+}
@@ -1,10 +1,14 @@
 package main
 
 import (
+	"errors"
+	"fmt"
 	"log"
+	"strings"
 
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/rekognition"
 	"github.com/aws/aws-sdk-go/service/s3"
 )
 
@@ -16,37 +20,42 @@ func processS3Bucket(session *session.Session, s3Bucket *events.S3Bucket) {
 		s3Bucket.OwnerIdentity.PrincipalID)
 }
 
-// processS3Event processes an AWS S3 event.
-func processS3Event(session *session.Session, s3Event *events.S3Event) {
+// processS3Event processes an AWS S3 event. Every record is processed, and the errors of the records that failed are
+// returned together.
+func processS3Event(session *session.Session, s3Event *events.S3Event) error {
 	log.Printf("S3Event: Records=%d", len(s3Event.Records))
+	var errs []error
 	for index, s3EventRecord := range s3Event.Records {
 		log.Printf("S3EventRecord: Index=%d", index)
-		processS3EventRecord(session, &s3EventRecord)
+		if err := processS3EventRecord(session, &s3EventRecord); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", s3EventRecord.S3.Object.Key, err))
+		}
 	}
+	return errors.Join(errs...)
 }
 
 // processS3EventRecord processes an AWS S3 event record.
-func processS3EventRecord(session *session.Session, s3EventRecord *events.S3EventRecord) {
+func processS3EventRecord(session *session.Session, s3EventRecord *events.S3EventRecord) error {
 	log.Printf("S3EventRecord: AWSRegion=%s EventTime=%s EventName=%s EventSource=%s EventVersion=%s",
 		s3EventRecord.AWSRegion,
 		s3EventRecord.EventTime,
 		s3EventRecord.EventName,
 		s3EventRecord.EventSource,
 		s3EventRecord.EventVersion)
-	processS3Entity(session, &s3EventRecord.S3)
+	return processS3Entity(session, &s3EventRecord.S3)
 }
 
 // processS3Entity processes an AWS S3 entity.
-func processS3Entity(session *session.Session, s3Entity *events.S3Entity) {
+func processS3Entity(session *session.Session, s3Entity *events.S3Entity) error {
 	log.Printf("S3Entity: ConfigurationID=%s SchemaVersion=%s",
 		s3Entity.ConfigurationID,
 		s3Entity.SchemaVersion)
 	processS3Bucket(session, &s3Entity.Bucket)
-	processS3Object(session, s3Entity.Bucket.Name, &s3Entity.Object)
+	return processS3Object(session, s3Entity.Bucket.Name, &s3Entity.Object)
 }
 
 // processS3Object processes an AWS S3 object event.
-func processS3Object(session *session.Session, s3BucketName string, s3Object *events.S3Object) {
+func processS3Object(session *session.Session, s3BucketName string, s3Object *events.S3Object) error {
 	log.Printf("S3Object: Bucket=%s ETag=%s Key=%s Sequencer=%s Size=%d URLDecodeKey=%s VersionID=%s",
 		s3BucketName,
 		s3Object.ETag,
@@ -55,7 +64,16 @@ func processS3Object(session *session.Session, s3BucketName string, s3Object *ev
 		s3Object.Size,
 		s3Object.URLDecodedKey,
 		s3Object.VersionID)
-	processRekognition(session, s3BucketName, s3Object.Key)
+
+	// Exif metadata created after its compressed image was analysed is merged into the annotation.
+	if strings.HasPrefix(s3Object.Key, s3BucketFolderImagesExif+"/") {
+		return processAnnotationExif(s3.New(session), s3BucketName, s3Object.Key)
+	}
+	analyzerClients := AnalyzerClients{
+		Rekognition:  rekognition.New(session),
+		S3:           s3.New(session),
+		S3BucketName: s3BucketName}
+	return processRekognition(&analyzerClients, s3Object.Key, s3Object.ETag)
 }
 
 // processS3PutObjectOutput processes the output of an S3 PutObject operation.
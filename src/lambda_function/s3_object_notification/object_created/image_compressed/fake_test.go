@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/rekognition"
+	"github.com/aws/aws-sdk-go/service/rekognition/rekognitioniface"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+)
+
+// Contains fake Rekognition and S3 clients for running the analyzers offline.
+
+// fakeRekognition is a Rekognition client that returns the output stored for each operation in
+// testdata/rekognition/<Operation>.json, or the error set for the operation. Operations it does not implement panic.
+type fakeRekognition struct {
+	rekognitioniface.RekognitionAPI
+	calls  map[string]int
+	errors map[string]error
+	mutex  sync.Mutex
+}
+
+// newFakeRekognition creates a fake Rekognition client that returns the fixtures.
+func newFakeRekognition() *fakeRekognition {
+	return &fakeRekognition{calls: make(map[string]int), errors: make(map[string]error)}
+}
+
+// setError makes the operation return the error, or the fixture again when the error is nil.
+func (f *fakeRekognition) setError(operation string, err error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.errors[operation] = err
+}
+
+// getCalls returns the number of times the operation was called.
+func (f *fakeRekognition) getCalls(operation string) int {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.calls[operation]
+}
+
+// getOutput counts the call of the operation and decodes its fixture into the output.
+func (f *fakeRekognition) getOutput(operation string, output interface{}) error {
+	f.mutex.Lock()
+	f.calls[operation]++
+	err := f.errors[operation]
+	f.mutex.Unlock()
+	if err != nil {
+		return err
+	}
+	b, err := os.ReadFile(filepath.Join("testdata", "rekognition", operation+".json"))
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, output)
+}
+
+func (f *fakeRekognition) DetectCustomLabels(input *rekognition.DetectCustomLabelsInput) (*rekognition.DetectCustomLabelsOutput, error) {
+	var output rekognition.DetectCustomLabelsOutput
+	return &output, f.getOutput("DetectCustomLabels", &output)
+}
+
+func (f *fakeRekognition) DetectFaces(input *rekognition.DetectFacesInput) (*rekognition.DetectFacesOutput, error) {
+	var output rekognition.DetectFacesOutput
+	return &output, f.getOutput("DetectFaces", &output)
+}
+
+func (f *fakeRekognition) DetectLabels(input *rekognition.DetectLabelsInput) (*rekognition.DetectLabelsOutput, error) {
+	var output rekognition.DetectLabelsOutput
+	return &output, f.getOutput("DetectLabels", &output)
+}
+
+func (f *fakeRekognition) DetectModerationLabels(input *rekognition.DetectModerationLabelsInput) (*rekognition.DetectModerationLabelsOutput, error) {
+	var output rekognition.DetectModerationLabelsOutput
+	return &output, f.getOutput("DetectModerationLabels", &output)
+}
+
+func (f *fakeRekognition) DetectText(input *rekognition.DetectTextInput) (*rekognition.DetectTextOutput, error) {
+	var output rekognition.DetectTextOutput
+	return &output, f.getOutput("DetectText", &output)
+}
+
+func (f *fakeRekognition) IndexFaces(input *rekognition.IndexFacesInput) (*rekognition.IndexFacesOutput, error) {
+	var output rekognition.IndexFacesOutput
+	return &output, f.getOutput("IndexFaces", &output)
+}
+
+func (f *fakeRekognition) RecognizeCelebrities(input *rekognition.RecognizeCelebritiesInput) (*rekognition.RecognizeCelebritiesOutput, error) {
+	var output rekognition.RecognizeCelebritiesOutput
+	return &output, f.getOutput("RecognizeCelebrities", &output)
+}
+
+func (f *fakeRekognition) SearchFaces(input *rekognition.SearchFacesInput) (*rekognition.SearchFacesOutput, error) {
+	var output rekognition.SearchFacesOutput
+	return &output, f.getOutput("SearchFaces", &output)
+}
+
+// fakeS3 is an S3 client that keeps the objects of a single bucket in memory, with the MD5 of their contents as their
+// ETags. Operations it does not implement panic.
+type fakeS3 struct {
+	s3iface.S3API
+	mutex   sync.Mutex
+	objects map[string][]byte
+}
+
+// newFakeS3 creates a fake S3 client with an empty bucket.
+func newFakeS3() *fakeS3 {
+	return &fakeS3{objects: make(map[string][]byte)}
+}
+
+// getObject returns the object stored at the key and whether it exists.
+func (f *fakeS3) getObject(key string) ([]byte, bool) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	b, ok := f.objects[key]
+	return b, ok
+}
+
+// putObject stores the object at the key.
+func (f *fakeS3) putObject(key string, b []byte) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.objects[key] = b
+}
+
+func (f *fakeS3) CopyObject(input *s3.CopyObjectInput) (*s3.CopyObjectOutput, error) {
+	copySource, err := url.PathUnescape(aws.StringValue(input.CopySource))
+	if err != nil {
+		return nil, err
+	}
+	key := strings.TrimPrefix(copySource, aws.StringValue(input.Bucket)+"/")
+	b, ok := f.getObject(key)
+	if !ok {
+		return nil, awserr.New(s3.ErrCodeNoSuchKey, fmt.Sprintf("%s not found", key), nil)
+	}
+	f.putObject(aws.StringValue(input.Key), b)
+	return &s3.CopyObjectOutput{}, nil
+}
+
+func (f *fakeS3) DeleteObject(input *s3.DeleteObjectInput) (*s3.DeleteObjectOutput, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	delete(f.objects, aws.StringValue(input.Key))
+	return &s3.DeleteObjectOutput{}, nil
+}
+
+func (f *fakeS3) GetObject(input *s3.GetObjectInput) (*s3.GetObjectOutput, error) {
+	b, ok := f.getObject(aws.StringValue(input.Key))
+	if !ok {
+		return nil, awserr.New(s3.ErrCodeNoSuchKey, fmt.Sprintf("%s not found", aws.StringValue(input.Key)), nil)
+	}
+	return &s3.GetObjectOutput{Body: io.NopCloser(bytes.NewReader(b)), ContentLength: aws.Int64(int64(len(b))), ETag: aws.String(getFakeETag(b))}, nil
+}
+
+func (f *fakeS3) PutObject(input *s3.PutObjectInput) (*s3.PutObjectOutput, error) {
+	return f.PutObjectWithContext(aws.BackgroundContext(), input)
+}
+
+// PutObjectWithContext honours the If-Match and If-None-Match headers set by the options.
+func (f *fakeS3) PutObjectWithContext(ctx aws.Context, input *s3.PutObjectInput, options ...request.Option) (*s3.PutObjectOutput, error) {
+	b, err := io.ReadAll(input.Body)
+	if err != nil {
+		return nil, err
+	}
+	r := request.Request{HTTPRequest: &http.Request{Header: make(http.Header)}}
+	for _, option := range options {
+		option(&r)
+	}
+	key := aws.StringValue(input.Key)
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	current, ok := f.objects[key]
+	ifMatch := r.HTTPRequest.Header.Get("If-Match")
+	ifNoneMatch := r.HTTPRequest.Header.Get("If-None-Match")
+	if (ifMatch != "" && (!ok || getFakeETag(current) != ifMatch)) || (ifNoneMatch == "*" && ok) {
+		return nil, awserr.NewRequestFailure(awserr.New("PreconditionFailed", fmt.Sprintf("%s changed", key), nil), http.StatusPreconditionFailed, "")
+	}
+	f.objects[key] = b
+	return &s3.PutObjectOutput{ETag: aws.String(getFakeETag(b))}, nil
+}
+
+// getFakeETag returns the ETag of an object with the contents.
+func getFakeETag(b []byte) string {
+	return fmt.Sprintf(`"%x"`, md5.Sum(b))
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+)
+
+// Contains functions for maintaining the keyword set of each photo.
+//
+// The keyword set collects the keywords found in a photo by the detectors, each recording the detector that found it.
+// A detector replaces only its own keywords, so detectors can update the set independently.
+
+// Keyword sources, naming the detector that found a keyword.
+const (
+	keywordSourceCelebrity   = "Celebrity"
+	keywordSourceCustomLabel = "CustomLabel"
+	keywordSourcePerson      = "Person"
+)
+
+// keywordSetMaxAttempts limits the attempts to update a keyword set while other writers change it.
+const keywordSetMaxAttempts = 5
+
+// keywordSetMutex serialises the updates of keyword sets by detectors running concurrently.
+var keywordSetMutex sync.Mutex
+
+// Keyword is a keyword found in a photo. URLs link to more information about the keyword, such as the IMDb and
+// Wikidata pages of a celebrity.
+type Keyword struct {
+	Confidence float64  `json:"Confidence"`
+	Name       string   `json:"Name"`
+	Source     string   `json:"Source"`
+	URLs       []string `json:"URLs"`
+}
+
+// KeywordSet is the set of keywords found in a photo.
+type KeywordSet struct {
+	Keywords []*Keyword `json:"Keywords"`
+	Name     string     `json:"Name"`
+}
+
+// getKeywordSetKey returns the S3 object key of the keyword set of the named photo.
+func getKeywordSetKey(name string) string {
+	return fmt.Sprintf("%s/%s.JSON", s3BucketFolderImagesKeywords, name)
+}
+
+// mergeKeywordSet replaces the keywords of the source in the keyword set. Keywords are sorted by name and source so
+// that the stored set is stable.
+func mergeKeywordSet(keywordSet *KeywordSet, source string, keywords []*Keyword) {
+	var merged []*Keyword
+	for _, keyword := range keywordSet.Keywords {
+		if keyword.Source != source {
+			merged = append(merged, keyword)
+		}
+	}
+	for _, keyword := range keywords {
+		keyword.Source = source
+		merged = append(merged, keyword)
+	}
+	sort.SliceStable(merged, func(i, j int) bool {
+		if merged[i].Name != merged[j].Name {
+			return merged[i].Name < merged[j].Name
+		}
+		return merged[i].Source < merged[j].Source
+	})
+	keywordSet.Keywords = merged
+}
+
+// processKeywordSet replaces the keywords of the source in the keyword set of the named photo stored in S3. The set
+// is updated with a read-modify-write, which is serialised within the Lambda because detectors run concurrently, and
+// stored only if no other writer, such as the people Lambda, changed it in the meantime.
+func processKeywordSet(s3Client s3iface.S3API, s3BucketName string, name string, source string, keywords []*Keyword) error {
+	keywordSetMutex.Lock()
+	defer keywordSetMutex.Unlock()
+
+	s3ObjectKey := getKeywordSetKey(name)
+	for attempt := 1; ; attempt++ {
+		keywordSet := KeywordSet{Name: name}
+		eTag, err := getS3ObjectJSONETag(s3Client, s3BucketName, s3ObjectKey, &keywordSet)
+		if err != nil {
+			return fmt.Errorf("keyword set %s: %w", s3ObjectKey, err)
+		}
+		mergeKeywordSet(&keywordSet, source, keywords)
+		log.Printf("KeywordSet: Key=%s Source=%s Keywords=%d", s3ObjectKey, source, len(keywords))
+		err = processS3ObjectJSONConditional(s3Client, s3BucketName, s3ObjectKey, &keywordSet, eTag)
+		if err == nil || !isS3PreconditionFailed(err) || attempt == keywordSetMaxAttempts {
+			return err
+		}
+		log.Printf("KeywordSet: Key=%s Attempt=%d Error=%s", s3ObjectKey, attempt, err)
+	}
+}
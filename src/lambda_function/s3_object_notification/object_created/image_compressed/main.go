@@ -13,10 +13,34 @@ import (
 
 // Global variables to store S3 bucket folder names.
 var (
-	s3BucketFolderImagesCompressed        string
-	s3BucketFolderRekognitionDetectFaces  string
-	s3BucketFolderRekognitionDetectLabels string
-	s3BucketFolderRekognitionDetectText   string
+	s3BucketFolderImagesAnnotations                 string
+	s3BucketFolderImagesCompressed                  string
+	s3BucketFolderImagesExif                        string
+	s3BucketFolderImagesHashes                      string
+	s3BucketFolderImagesKeywords                    string
+	s3BucketFolderImagesPeople                      string
+	s3BucketFolderImagesRenditions                  string
+	s3BucketFolderPeople                            string
+	s3BucketFolderQuarantine                        string
+	s3BucketFolderRekognitionDetectCustomLabels     string
+	s3BucketFolderRekognitionDetectFaces            string
+	s3BucketFolderRekognitionDetectLabels           string
+	s3BucketFolderRekognitionDetectModerationLabels string
+	s3BucketFolderRekognitionDetectText             string
+	s3BucketFolderRekognitionRecognizeCelebrities   string
+	s3BucketFolderRekognitionStatus                 string
+)
+
+// Global variables to store the Rekognition configuration.
+var (
+	detectorConfiguration                          *DetectorConfiguration
+	moderationQuarantineThresholds                 map[string]float64
+	rekognitionDetectCustomLabelsMinConfidence     float64
+	rekognitionDetectCustomLabelsProjectVersionARN string
+	rekognitionDetectModerationLabelsMinConfidence float64
+	rekognitionPeopleCollectionID                  string
+	rekognitionDetectorConcurrency                 int
+	rekognitionPeopleFaceMatchThreshold            float64
 )
 
 // createAWSSession creates and returns a new AWS session.
@@ -38,13 +62,35 @@ func getEnvironmentVariable(key string) string {
 	return environmentValue
 }
 
+// getEnvironmentVariableOrDefault retrieves an environment variable by its key, returning the default value when
+// the variable is not set or empty.
+func getEnvironmentVariableOrDefault(key string, defaultValue string) string {
+	environmentValue := os.Getenv(key)
+	if len(environmentValue) == 0 {
+		return defaultValue
+	}
+	return environmentValue
+}
+
 // validateS3Folders checks that S3 bucket folder names are unique and not empty.
 func validateS3Folders() {
 	folders := []string{
+		s3BucketFolderImagesAnnotations,
 		s3BucketFolderImagesCompressed,
+		s3BucketFolderImagesExif,
+		s3BucketFolderImagesHashes,
+		s3BucketFolderImagesKeywords,
+		s3BucketFolderImagesPeople,
+		s3BucketFolderImagesRenditions,
+		s3BucketFolderPeople,
+		s3BucketFolderQuarantine,
+		s3BucketFolderRekognitionDetectCustomLabels,
 		s3BucketFolderRekognitionDetectFaces,
 		s3BucketFolderRekognitionDetectLabels,
+		s3BucketFolderRekognitionDetectModerationLabels,
 		s3BucketFolderRekognitionDetectText,
+		s3BucketFolderRekognitionRecognizeCelebrities,
+		s3BucketFolderRekognitionStatus,
 	}
 
 	// Create a map to store folder names and check for duplicates.
@@ -61,26 +107,83 @@ func validateS3Folders() {
 	}
 }
 
-// handler is the AWS Lambda function that processes S3 events.
-func handler(context context.Context, s3Event *events.S3Event) {
-	log.Printf("S3_BUCKET_FOLDER_IMAGES_COMPRESSED=%s S3_BUCKET_FOLDER_REKOGNITION_DETECT_FACES=%s S3_BUCKET_FOLDER_REKOGNITION_DETECT_LABELS=%s S3_BUCKET_FOLDER_REKOGNITION_DETECT_TEXT=%s",
+// handler is the AWS Lambda function that processes S3 events for compressed images and their Exif metadata. It
+// returns the error of the analyzers that failed, so that the invocation is retried.
+func handler(context context.Context, s3Event *events.S3Event) error {
+	log.Printf("S3_BUCKET_FOLDER_IMAGES_ANNOTATIONS=%s S3_BUCKET_FOLDER_IMAGES_COMPRESSED=%s S3_BUCKET_FOLDER_IMAGES_EXIF=%s S3_BUCKET_FOLDER_IMAGES_HASHES=%s S3_BUCKET_FOLDER_IMAGES_KEYWORDS=%s S3_BUCKET_FOLDER_IMAGES_PEOPLE=%s S3_BUCKET_FOLDER_IMAGES_RENDITIONS=%s S3_BUCKET_FOLDER_PEOPLE=%s S3_BUCKET_FOLDER_QUARANTINE=%s S3_BUCKET_FOLDER_REKOGNITION_DETECT_CUSTOM_LABELS=%s S3_BUCKET_FOLDER_REKOGNITION_DETECT_FACES=%s S3_BUCKET_FOLDER_REKOGNITION_DETECT_LABELS=%s S3_BUCKET_FOLDER_REKOGNITION_DETECT_MODERATION_LABELS=%s S3_BUCKET_FOLDER_REKOGNITION_DETECT_TEXT=%s S3_BUCKET_FOLDER_REKOGNITION_RECOGNIZE_CELEBRITIES=%s S3_BUCKET_FOLDER_REKOGNITION_STATUS=%s",
+		s3BucketFolderImagesAnnotations,
 		s3BucketFolderImagesCompressed,
+		s3BucketFolderImagesExif,
+		s3BucketFolderImagesHashes,
+		s3BucketFolderImagesKeywords,
+		s3BucketFolderImagesPeople,
+		s3BucketFolderImagesRenditions,
+		s3BucketFolderPeople,
+		s3BucketFolderQuarantine,
+		s3BucketFolderRekognitionDetectCustomLabels,
 		s3BucketFolderRekognitionDetectFaces,
 		s3BucketFolderRekognitionDetectLabels,
-		s3BucketFolderRekognitionDetectText)
+		s3BucketFolderRekognitionDetectModerationLabels,
+		s3BucketFolderRekognitionDetectText,
+		s3BucketFolderRekognitionRecognizeCelebrities,
+		s3BucketFolderRekognitionStatus)
 
 	// Create an AWS session and process the S3 event.
 	awsSession := createAWSSession()
-	processS3Event(awsSession, s3Event)
+	return processS3Event(awsSession, s3Event)
 }
 
 // main function is the entry point of the application.
 func main() {
 	// Initialize S3 bucket folder variables from environment variables.
+	s3BucketFolderImagesAnnotations = getEnvironmentVariable("S3_BUCKET_FOLDER_IMAGES_ANNOTATIONS")
 	s3BucketFolderImagesCompressed = getEnvironmentVariable("S3_BUCKET_FOLDER_IMAGES_COMPRESSED")
+	s3BucketFolderImagesExif = getEnvironmentVariable("S3_BUCKET_FOLDER_IMAGES_EXIF")
+	s3BucketFolderImagesHashes = getEnvironmentVariable("S3_BUCKET_FOLDER_IMAGES_HASHES")
+	s3BucketFolderImagesKeywords = getEnvironmentVariable("S3_BUCKET_FOLDER_IMAGES_KEYWORDS")
+	s3BucketFolderImagesPeople = getEnvironmentVariable("S3_BUCKET_FOLDER_IMAGES_PEOPLE")
+	s3BucketFolderImagesRenditions = getEnvironmentVariable("S3_BUCKET_FOLDER_IMAGES_RENDITIONS")
+	s3BucketFolderPeople = getEnvironmentVariable("S3_BUCKET_FOLDER_PEOPLE")
+	s3BucketFolderQuarantine = getEnvironmentVariable("S3_BUCKET_FOLDER_QUARANTINE")
+	s3BucketFolderRekognitionDetectCustomLabels = getEnvironmentVariable("S3_BUCKET_FOLDER_REKOGNITION_DETECT_CUSTOM_LABELS")
 	s3BucketFolderRekognitionDetectFaces = getEnvironmentVariable("S3_BUCKET_FOLDER_REKOGNITION_DETECT_FACES")
 	s3BucketFolderRekognitionDetectLabels = getEnvironmentVariable("S3_BUCKET_FOLDER_REKOGNITION_DETECT_LABELS")
+	s3BucketFolderRekognitionDetectModerationLabels = getEnvironmentVariable("S3_BUCKET_FOLDER_REKOGNITION_DETECT_MODERATION_LABELS")
 	s3BucketFolderRekognitionDetectText = getEnvironmentVariable("S3_BUCKET_FOLDER_REKOGNITION_DETECT_TEXT")
+	s3BucketFolderRekognitionRecognizeCelebrities = getEnvironmentVariable("S3_BUCKET_FOLDER_REKOGNITION_RECOGNIZE_CELEBRITIES")
+	s3BucketFolderRekognitionStatus = getEnvironmentVariable("S3_BUCKET_FOLDER_REKOGNITION_STATUS")
+
+	// Initialize the Rekognition configuration from environment variables.
+	var err error
+	detectorConfiguration, err = getDetectorConfiguration(getEnvironmentVariableOrDefault("REKOGNITION_DETECTORS", "{}"))
+	if err != nil {
+		log.Fatalf("REKOGNITION_DETECTORS: Error=%s", err)
+	}
+	moderationQuarantineThresholds, err = getModerationQuarantineThresholds(getEnvironmentVariableOrDefault("MODERATION_QUARANTINE_THRESHOLDS", "{}"))
+	if err != nil {
+		log.Fatalf("MODERATION_QUARANTINE_THRESHOLDS: Error=%s", err)
+	}
+	rekognitionDetectCustomLabelsMinConfidence, err = getMinConfidence(getEnvironmentVariableOrDefault("REKOGNITION_DETECT_CUSTOM_LABELS_MIN_CONFIDENCE", "50"))
+	if err != nil {
+		log.Fatalf("REKOGNITION_DETECT_CUSTOM_LABELS_MIN_CONFIDENCE: Error=%s", err)
+	}
+	rekognitionDetectCustomLabelsProjectVersionARN, err = getProjectVersionARN(getEnvironmentVariableOrDefault("REKOGNITION_DETECT_CUSTOM_LABELS_PROJECT_VERSION_ARN", ""))
+	if err != nil {
+		log.Fatalf("REKOGNITION_DETECT_CUSTOM_LABELS_PROJECT_VERSION_ARN: Error=%s", err)
+	}
+	rekognitionDetectModerationLabelsMinConfidence, err = getMinConfidence(getEnvironmentVariableOrDefault("REKOGNITION_DETECT_MODERATION_LABELS_MIN_CONFIDENCE", "50"))
+	if err != nil {
+		log.Fatalf("REKOGNITION_DETECT_MODERATION_LABELS_MIN_CONFIDENCE: Error=%s", err)
+	}
+	rekognitionDetectorConcurrency, err = getDetectorConcurrency(getEnvironmentVariableOrDefault("REKOGNITION_DETECTOR_CONCURRENCY", "4"))
+	if err != nil {
+		log.Fatalf("REKOGNITION_DETECTOR_CONCURRENCY: Error=%s", err)
+	}
+	rekognitionPeopleCollectionID = getEnvironmentVariableOrDefault("REKOGNITION_PEOPLE_COLLECTION_ID", "")
+	rekognitionPeopleFaceMatchThreshold, err = getMinConfidence(getEnvironmentVariableOrDefault("REKOGNITION_PEOPLE_FACE_MATCH_THRESHOLD", "90"))
+	if err != nil {
+		log.Fatalf("REKOGNITION_PEOPLE_FACE_MATCH_THRESHOLD: Error=%s", err)
+	}
 
 	// Validate S3 folder names.
 	validateS3Folders()
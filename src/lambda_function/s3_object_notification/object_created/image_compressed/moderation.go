@@ -0,0 +1,148 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/rekognition"
+	"github.com/aws/aws-sdk-go/service/rekognition/rekognitioniface"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+)
+
+// Contains functions for detecting moderation labels and summarising them as a safe or unsafe verdict.
+
+// ModerationCategory is a top-level moderation category, such as Explicit or Violence, found in an image. Confidence
+// is the highest confidence of the category and of the labels beneath it.
+type ModerationCategory struct {
+	Confidence float64  `json:"Confidence"`
+	Labels     []string `json:"Labels"`
+	Name       string   `json:"Name"`
+}
+
+// ModerationVerdict summarises the moderation labels of an image. An image is safe when no label reaches the
+// minimum confidence.
+type ModerationVerdict struct {
+	Categories    []*ModerationCategory `json:"Categories"`
+	MinConfidence float64               `json:"MinConfidence"`
+	Safe          bool                  `json:"Safe"`
+}
+
+// RekognitionDetectModerationLabelsResult is the DetectModerationLabels output stored in S3, together with its
+// verdict. It parses as a rekognition.DetectModerationLabelsOutput.
+type RekognitionDetectModerationLabelsResult struct {
+	*rekognition.DetectModerationLabelsOutput
+	Verdict *ModerationVerdict `json:"Verdict"`
+}
+
+// getMinConfidence parses a Rekognition minimum confidence, which must be between 0 and 100.
+func getMinConfidence(value string) (float64, error) {
+	minConfidence, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, err
+	}
+	if minConfidence < 0 || minConfidence > 100 {
+		return 0, fmt.Errorf("minimum confidence %v outside 0-100", minConfidence)
+	}
+	return minConfidence, nil
+}
+
+// getModerationVerdict summarises moderation labels by their top-level category. Labels are attributed to the
+// category at the root of their parent chain, following the parents returned alongside them, or to their last named
+// parent when the chain leaves the returned labels.
+func getModerationVerdict(moderationLabels []*rekognition.ModerationLabel, minConfidence float64) *ModerationVerdict {
+	parentNames := make(map[string]string)
+	for _, moderationLabel := range moderationLabels {
+		parentNames[aws.StringValue(moderationLabel.Name)] = aws.StringValue(moderationLabel.ParentName)
+	}
+	categoryMap := make(map[string]*ModerationCategory)
+	for _, moderationLabel := range moderationLabels {
+		confidence := aws.Float64Value(moderationLabel.Confidence)
+		if confidence < minConfidence {
+			continue
+		}
+		name := aws.StringValue(moderationLabel.Name)
+		categoryName := name
+		parentName := aws.StringValue(moderationLabel.ParentName)
+		for depth := 0; parentName != "" && depth < len(moderationLabels); depth++ {
+			categoryName, parentName = parentName, parentNames[parentName]
+		}
+		category, ok := categoryMap[categoryName]
+		if !ok {
+			category = &ModerationCategory{Name: categoryName}
+			categoryMap[categoryName] = category
+		}
+		category.Confidence = max(category.Confidence, confidence)
+		if name != categoryName {
+			category.Labels = append(category.Labels, name)
+		}
+	}
+	moderationVerdict := ModerationVerdict{
+		MinConfidence: minConfidence,
+		Safe:          len(categoryMap) == 0}
+	for _, category := range categoryMap {
+		sort.Strings(category.Labels)
+		moderationVerdict.Categories = append(moderationVerdict.Categories, category)
+	}
+	sort.Slice(moderationVerdict.Categories, func(i, j int) bool {
+		return moderationVerdict.Categories[i].Name < moderationVerdict.Categories[j].Name
+	})
+	return &moderationVerdict
+}
+
+// processRekognitionDetectModerationLabels processes an S3 object image with AWS Rekognition Detect Moderation Labels.
+// It returns the moderation verdict of the image.
+func processRekognitionDetectModerationLabels(rekognitionClient rekognitioniface.RekognitionAPI, s3Client s3iface.S3API, rekognitionImage *rekognition.Image, s3BucketName string, s3ObjectKey string) (*ModerationVerdict, error) {
+	// Create a Rekognition DetectModerationLabelsInput and process it.
+	rekognitionDetectModerationLabelsInput := rekognition.DetectModerationLabelsInput{
+		Image:         rekognitionImage,
+		MinConfidence: aws.Float64(rekognitionDetectModerationLabelsMinConfidence),
+	}
+	processRekognitionDetectModerationLabelsInput(&rekognitionDetectModerationLabelsInput)
+
+	// Perform moderation label detection using Rekognition.
+	rekognitionDetectModerationLabelsOutput, err := rekognitionClient.DetectModerationLabels(&rekognitionDetectModerationLabelsInput)
+	if err != nil {
+		return nil, err
+	}
+
+	// Process the output and store it in S3.
+	return processRekognitionDetectModerationLabelsOutput(s3Client, rekognitionDetectModerationLabelsOutput, s3BucketName, s3ObjectKey)
+}
+
+// processRekognitionDetectModerationLabelsInput processes a rekognition.DetectModerationLabelsInput.
+func processRekognitionDetectModerationLabelsInput(rekognitionDetectModerationLabelsInput *rekognition.DetectModerationLabelsInput) {
+	log.Printf("RekognitionDetectModerationLabelsInput: MinConfidence=%v",
+		aws.Float64Value(rekognitionDetectModerationLabelsInput.MinConfidence))
+}
+
+// processRekognitionDetectModerationLabelsOutput processes a rekognition.DetectModerationLabelsOutput, storing it in
+// S3 together with its verdict, which it returns.
+func processRekognitionDetectModerationLabelsOutput(s3Client s3iface.S3API, rekognitionDetectModerationLabelsOutput *rekognition.DetectModerationLabelsOutput, s3BucketName string, s3ObjectKey string) (*ModerationVerdict, error) {
+	moderationVerdict := getModerationVerdict(rekognitionDetectModerationLabelsOutput.ModerationLabels, rekognitionDetectModerationLabelsMinConfidence)
+	categoryNames := make([]string, len(moderationVerdict.Categories))
+	for i, category := range moderationVerdict.Categories {
+		categoryNames[i] = category.Name
+	}
+	log.Printf("RekognitionDetectModerationLabelsOutput: ModerationModelVersion=%v ModerationLabels=%d Safe=%v Categories=%s",
+		aws.StringValue(rekognitionDetectModerationLabelsOutput.ModerationModelVersion),
+		len(rekognitionDetectModerationLabelsOutput.ModerationLabels),
+		moderationVerdict.Safe,
+		strings.Join(categoryNames, ","))
+
+	// Modify the S3 object key for storage.
+	s3ObjectKey = fmt.Sprintf("%s/%s.JSON", s3BucketFolderRekognitionDetectModerationLabels, strings.Split(path.Base(s3ObjectKey), ".")[0])
+
+	// Store the output in S3.
+	rekognitionDetectModerationLabelsResult := RekognitionDetectModerationLabelsResult{
+		DetectModerationLabelsOutput: rekognitionDetectModerationLabelsOutput,
+		Verdict:                      moderationVerdict}
+	if err := processS3ObjectJSON(s3Client, s3BucketName, s3ObjectKey, &rekognitionDetectModerationLabelsResult); err != nil {
+		return nil, err
+	}
+	return moderationVerdict, nil
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/rekognition"
+)
+
+// newTestModerationLabel returns a moderation label with the parent and confidence.
+func newTestModerationLabel(name string, parentName string, confidence float64) *rekognition.ModerationLabel {
+	return &rekognition.ModerationLabel{
+		Confidence: aws.Float64(confidence),
+		Name:       aws.String(name),
+		ParentName: aws.String(parentName)}
+}
+
+func TestGetMinConfidence(t *testing.T) {
+	if minConfidence, err := getMinConfidence("75.5"); err != nil || minConfidence != 75.5 {
+		t.Fatalf("GetMinConfidence: %v %v", minConfidence, err)
+	}
+	for _, value := range []string{"", "high", "-1", "101"} {
+		if _, err := getMinConfidence(value); err == nil {
+			t.Errorf("GetMinConfidence: %q accepted", value)
+		}
+	}
+}
+
+func TestGetModerationVerdict(t *testing.T) {
+	if moderationVerdict := getModerationVerdict(nil, 50); !moderationVerdict.Safe || len(moderationVerdict.Categories) != 0 {
+		t.Fatalf("GetModerationVerdict: %+v", moderationVerdict)
+	}
+
+	moderationVerdict := getModerationVerdict([]*rekognition.ModerationLabel{
+		newTestModerationLabel("Violence", "", 80),
+		newTestModerationLabel("Weapons", "Violence", 85),
+		newTestModerationLabel("Weapon Violence", "Weapons", 90),
+		newTestModerationLabel("Smoking", "Drugs & Tobacco", 70),
+		newTestModerationLabel("Rude Gestures", "", 40),
+	}, 50)
+	want := []*ModerationCategory{
+		{Confidence: 70, Labels: []string{"Smoking"}, Name: "Drugs & Tobacco"},
+		{Confidence: 90, Labels: []string{"Weapon Violence", "Weapons"}, Name: "Violence"},
+	}
+	if moderationVerdict.Safe || moderationVerdict.MinConfidence != 50 || !reflect.DeepEqual(moderationVerdict.Categories, want) {
+		b, _ := json.Marshal(moderationVerdict)
+		t.Errorf("GetModerationVerdict: %s", b)
+	}
+}
+
+func TestRekognitionDetectModerationLabelsResult(t *testing.T) {
+	// The stored result still parses as a DetectModerationLabelsOutput.
+	b, err := json.Marshal(&RekognitionDetectModerationLabelsResult{
+		DetectModerationLabelsOutput: &rekognition.DetectModerationLabelsOutput{
+			ModerationLabels:       []*rekognition.ModerationLabel{newTestModerationLabel("Violence", "", 80)},
+			ModerationModelVersion: aws.String("7.0")},
+		Verdict: &ModerationVerdict{MinConfidence: 50}})
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	var rekognitionDetectModerationLabelsOutput rekognition.DetectModerationLabelsOutput
+	if err := json.Unmarshal(b, &rekognitionDetectModerationLabelsOutput); err != nil || len(rekognitionDetectModerationLabelsOutput.ModerationLabels) != 1 || aws.StringValue(rekognitionDetectModerationLabelsOutput.ModerationModelVersion) != "7.0" {
+		t.Errorf("Unmarshal: %s %v", b, err)
+	}
+}
@@ -0,0 +1,323 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/rekognition"
+	"github.com/aws/aws-sdk-go/service/rekognition/rekognitioniface"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+)
+
+// Contains functions for recognising people with a Rekognition face collection.
+//
+// Every face found in a photo is indexed into the collection, with the photo's ID as its external image ID, and
+// searched for among the faces already indexed. The faces indexed from each photo are stored before they are added to
+// the registry, so a retried invocation reuses them instead of indexing the photo again. The people registry, stored as JSON in S3, groups the indexed faces
+// into people, so a face that matches a face of a known person is added to that person and any other face starts a
+// new, unnamed person. The people of each photo are derived from the registry, and the people Lambda names, merges
+// and splits people and rewrites the people of the photos affected. The registry is stored with conditional writes,
+// so a photo processed while another writer changes the registry reads it again and adds its faces to the latest
+// registry rather than overwriting it.
+
+const (
+	// peopleMaxFaceMatches limits the matches returned when searching for each face.
+	peopleMaxFaceMatches = 10
+
+	// peopleRegistryMaxAttempts limits the attempts to update the people registry while other writers change it.
+	peopleRegistryMaxAttempts = 5
+)
+
+// PersonFace is a face of a person, indexed into the face collection from the photo identified by its external image
+// ID.
+type PersonFace struct {
+	BoundingBox     *rekognition.BoundingBox `json:"BoundingBox"`
+	Confidence      float64                  `json:"Confidence"`
+	ExternalImageID string                   `json:"ExternalImageId"`
+	FaceID          string                   `json:"FaceId"`
+}
+
+// Person groups the faces of one person. ID is the face ID of the person's first face, and Name is empty until the
+// person is named.
+type Person struct {
+	Faces []*PersonFace `json:"Faces"`
+	ID    string        `json:"ID"`
+	Name  string        `json:"Name"`
+}
+
+// PeopleRegistry is the registry of the people known to the face collection.
+type PeopleRegistry struct {
+	People []*Person `json:"People"`
+}
+
+// PhotoPerson is a person found in a photo.
+type PhotoPerson struct {
+	BoundingBox *rekognition.BoundingBox `json:"BoundingBox"`
+	Confidence  float64                  `json:"Confidence"`
+	FaceID      string                   `json:"FaceId"`
+	Name        string                   `json:"Name"`
+	PersonID    string                   `json:"PersonID"`
+}
+
+// PhotoPeople lists the people found in a photo.
+type PhotoPeople struct {
+	ID     string         `json:"ID"`
+	Name   string         `json:"Name"`
+	People []*PhotoPerson `json:"People"`
+}
+
+// getPeopleRegistryKey returns the S3 object key of the people registry.
+func getPeopleRegistryKey() string {
+	return fmt.Sprintf("%s/registry.JSON", s3BucketFolderPeople)
+}
+
+// getIndexedFacesKey returns the S3 object key of the faces indexed from the photo with the ID.
+func getIndexedFacesKey(imageID string) string {
+	return fmt.Sprintf("%s/faces/%s.JSON", s3BucketFolderPeople, imageID)
+}
+
+// getPhotoPeopleKey returns the S3 object key of the people of the photo with the ID.
+func getPhotoPeopleKey(imageID string) string {
+	return fmt.Sprintf("%s/%s.JSON", s3BucketFolderImagesPeople, imageID)
+}
+
+// getExternalImageID returns the external image ID of the faces of the photo with the ID in the face collection.
+// External image IDs may not contain slashes, so the slashes between the date folders and the name become colons.
+func getExternalImageID(imageID string) string {
+	return strings.ReplaceAll(imageID, "/", ":")
+}
+
+// getPersonByFaceID returns the person with the face, or nil if the face is not in the registry.
+func (r *PeopleRegistry) getPersonByFaceID(faceID string) *Person {
+	for _, person := range r.People {
+		for _, face := range person.Faces {
+			if face.FaceID == faceID {
+				return person
+			}
+		}
+	}
+	return nil
+}
+
+// hasExternalImageID reports whether faces of the photo have already been indexed.
+func (r *PeopleRegistry) hasExternalImageID(externalImageID string) bool {
+	for _, person := range r.People {
+		for _, face := range person.Faces {
+			if face.ExternalImageID == externalImageID {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// addFace adds the face to the person of the first matching face in the registry, or to a new person if none of the
+// matching faces is known. It returns the person the face was added to.
+func (r *PeopleRegistry) addFace(personFace *PersonFace, matchingFaceIDs []string) *Person {
+	for _, faceID := range matchingFaceIDs {
+		if person := r.getPersonByFaceID(faceID); person != nil {
+			person.Faces = append(person.Faces, personFace)
+			return person
+		}
+	}
+	person := Person{Faces: []*PersonFace{personFace}, ID: personFace.FaceID}
+	r.People = append(r.People, &person)
+	return &person
+}
+
+// getPhotoPeople returns the people of the registry found in the photo with the ID, ordered from left to right.
+func getPhotoPeople(peopleRegistry *PeopleRegistry, imageID string) *PhotoPeople {
+	photoPeople := PhotoPeople{ID: imageID, Name: path.Base(imageID)}
+	externalImageID := getExternalImageID(imageID)
+	for _, person := range peopleRegistry.People {
+		for _, face := range person.Faces {
+			if face.ExternalImageID == externalImageID {
+				photoPeople.People = append(photoPeople.People, &PhotoPerson{
+					BoundingBox: face.BoundingBox,
+					Confidence:  face.Confidence,
+					FaceID:      face.FaceID,
+					Name:        person.Name,
+					PersonID:    person.ID})
+			}
+		}
+	}
+	left := func(photoPerson *PhotoPerson) float64 {
+		if photoPerson.BoundingBox == nil {
+			return 0
+		}
+		return aws.Float64Value(photoPerson.BoundingBox.Left)
+	}
+	sort.SliceStable(photoPeople.People, func(i, j int) bool {
+		return left(photoPeople.People[i]) < left(photoPeople.People[j])
+	})
+	return &photoPeople
+}
+
+// getPeopleKeywords returns a keyword for each named person in the photo.
+func getPeopleKeywords(photoPeople *PhotoPeople) []*Keyword {
+	var keywords []*Keyword
+	keywordMap := make(map[string]*Keyword)
+	for _, photoPerson := range photoPeople.People {
+		if photoPerson.Name == "" {
+			continue
+		}
+		if keyword, ok := keywordMap[photoPerson.Name]; ok {
+			keyword.Confidence = max(keyword.Confidence, photoPerson.Confidence)
+			continue
+		}
+		keyword := Keyword{Confidence: photoPerson.Confidence, Name: photoPerson.Name}
+		keywordMap[photoPerson.Name] = &keyword
+		keywords = append(keywords, &keyword)
+	}
+	return keywords
+}
+
+// getS3ObjectPeopleRegistry downloads the people registry and returns it with its ETag. A registry that does not exist
+// yet is empty, with an empty ETag.
+func getS3ObjectPeopleRegistry(s3Client s3iface.S3API, s3BucketName string) (*PeopleRegistry, string, error) {
+	var peopleRegistry PeopleRegistry
+	eTag, err := getS3ObjectJSONETag(s3Client, s3BucketName, getPeopleRegistryKey(), &peopleRegistry)
+	if err != nil {
+		return nil, "", err
+	}
+	return &peopleRegistry, eTag, nil
+}
+
+// updatePeopleRegistry applies the update to the latest people registry and stores it only if no other writer changed
+// the registry in the meantime, reading and updating it again otherwise. It returns the registry stored.
+func updatePeopleRegistry(s3Client s3iface.S3API, s3BucketName string, update func(*PeopleRegistry)) (*PeopleRegistry, error) {
+	for attempt := 1; ; attempt++ {
+		peopleRegistry, eTag, err := getS3ObjectPeopleRegistry(s3Client, s3BucketName)
+		if err != nil {
+			return nil, err
+		}
+		update(peopleRegistry)
+		err = processS3ObjectJSONConditional(s3Client, s3BucketName, getPeopleRegistryKey(), peopleRegistry, eTag)
+		if err == nil {
+			return peopleRegistry, nil
+		}
+		if !isS3PreconditionFailed(err) || attempt == peopleRegistryMaxAttempts {
+			return nil, err
+		}
+		log.Printf("PeopleRegistry: Attempt=%d Error=%s", attempt, err)
+	}
+}
+
+// processRekognitionIndexFaces indexes the faces of the photo with the ID into the face collection and stores the
+// output, or returns the output stored by an earlier invocation whose registry update failed.
+func processRekognitionIndexFaces(rekognitionClient rekognitioniface.RekognitionAPI, s3Client s3iface.S3API, rekognitionImage *rekognition.Image, s3BucketName string, imageID string) (*rekognition.IndexFacesOutput, error) {
+	b, err := getS3ObjectBytes(s3Client, s3BucketName, getIndexedFacesKey(imageID))
+	var awsErr awserr.Error
+	if err != nil && !(errors.As(err, &awsErr) && awsErr.Code() == s3.ErrCodeNoSuchKey) {
+		return nil, err
+	}
+	if err == nil {
+		var rekognitionIndexFacesOutput rekognition.IndexFacesOutput
+		if err := json.Unmarshal(b, &rekognitionIndexFacesOutput); err != nil {
+			return nil, err
+		}
+		log.Printf("People: ID=%s FaceRecords=%d Stored=true", imageID, len(rekognitionIndexFacesOutput.FaceRecords))
+		return &rekognitionIndexFacesOutput, nil
+	}
+
+	rekognitionIndexFacesInput := rekognition.IndexFacesInput{
+		CollectionId:    aws.String(rekognitionPeopleCollectionID),
+		ExternalImageId: aws.String(getExternalImageID(imageID)),
+		Image:           rekognitionImage,
+		QualityFilter:   aws.String(rekognition.QualityFilterAuto)}
+	rekognitionIndexFacesOutput, err := rekognitionClient.IndexFaces(&rekognitionIndexFacesInput)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("RekognitionIndexFacesOutput: FaceRecords=%d UnindexedFaces=%d",
+		len(rekognitionIndexFacesOutput.FaceRecords),
+		len(rekognitionIndexFacesOutput.UnindexedFaces))
+
+	// Store the faces before searching for them, so that a failure from here on does not index them again.
+	if err := processS3ObjectJSON(s3Client, s3BucketName, getIndexedFacesKey(imageID), rekognitionIndexFacesOutput); err != nil {
+		return nil, err
+	}
+	return rekognitionIndexFacesOutput, nil
+}
+
+// processRekognitionPeople indexes the faces of an S3 object image into the face collection, matches them to known
+// people and stores the people of the photo, which it returns. It does nothing when no collection is configured.
+func processRekognitionPeople(rekognitionClient rekognitioniface.RekognitionAPI, s3Client s3iface.S3API, rekognitionImage *rekognition.Image, s3BucketName string, s3ObjectKey string) (*PhotoPeople, error) {
+	if rekognitionPeopleCollectionID == "" {
+		return nil, nil
+	}
+	imageID := getImageID(s3ObjectKey)
+	name := path.Base(imageID)
+	externalImageID := getExternalImageID(imageID)
+	peopleRegistry, _, err := getS3ObjectPeopleRegistry(s3Client, s3BucketName)
+	if err != nil {
+		return nil, err
+	}
+
+	// Index the faces of a photo only once, so that reprocessing it does not duplicate its faces in the collection.
+	if peopleRegistry.hasExternalImageID(externalImageID) {
+		log.Printf("People: ID=%s Indexed=true", imageID)
+	} else {
+		rekognitionIndexFacesOutput, err := processRekognitionIndexFaces(rekognitionClient, s3Client, rekognitionImage, s3BucketName, imageID)
+		if err != nil {
+			return nil, err
+		}
+
+		// Search for each face among the faces of other photos before updating the registry, so that a retried update
+		// does not search again.
+		personFaces := make([]*PersonFace, len(rekognitionIndexFacesOutput.FaceRecords))
+		matchingFaceIDs := make([][]string, len(rekognitionIndexFacesOutput.FaceRecords))
+		for i, faceRecord := range rekognitionIndexFacesOutput.FaceRecords {
+			personFaces[i] = &PersonFace{
+				BoundingBox:     faceRecord.Face.BoundingBox,
+				Confidence:      aws.Float64Value(faceRecord.Face.Confidence),
+				ExternalImageID: externalImageID,
+				FaceID:          aws.StringValue(faceRecord.Face.FaceId)}
+			rekognitionSearchFacesInput := rekognition.SearchFacesInput{
+				CollectionId:       aws.String(rekognitionPeopleCollectionID),
+				FaceId:             aws.String(personFaces[i].FaceID),
+				FaceMatchThreshold: aws.Float64(rekognitionPeopleFaceMatchThreshold),
+				MaxFaces:           aws.Int64(peopleMaxFaceMatches)}
+			rekognitionSearchFacesOutput, err := rekognitionClient.SearchFaces(&rekognitionSearchFacesInput)
+			if err != nil {
+				return nil, fmt.Errorf("face %s: %w", personFaces[i].FaceID, err)
+			}
+			for _, faceMatch := range rekognitionSearchFacesOutput.FaceMatches {
+				if aws.StringValue(faceMatch.Face.ExternalImageId) != externalImageID {
+					matchingFaceIDs[i] = append(matchingFaceIDs[i], aws.StringValue(faceMatch.Face.FaceId))
+				}
+			}
+		}
+		peopleRegistry, err = updatePeopleRegistry(s3Client, s3BucketName, func(peopleRegistry *PeopleRegistry) {
+			if peopleRegistry.hasExternalImageID(externalImageID) {
+				return
+			}
+			for i, personFace := range personFaces {
+				person := peopleRegistry.addFace(personFace, matchingFaceIDs[i])
+				log.Printf("People: FaceId=%s PersonID=%s PersonName=%s FaceMatches=%d", personFace.FaceID, person.ID, person.Name, len(matchingFaceIDs[i]))
+			}
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Store the people of the photo and add the named people to its keyword set.
+	photoPeople := getPhotoPeople(peopleRegistry, imageID)
+	log.Printf("People: ID=%s People=%d", imageID, len(photoPeople.People))
+	if err := processS3ObjectJSON(s3Client, s3BucketName, getPhotoPeopleKey(imageID), photoPeople); err != nil {
+		return nil, err
+	}
+	if err := processKeywordSet(s3Client, s3BucketName, name, keywordSourcePerson, getPeopleKeywords(photoPeople)); err != nil {
+		return nil, err
+	}
+	return photoPeople, nil
+}
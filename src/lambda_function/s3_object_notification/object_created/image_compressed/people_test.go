@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/rekognition"
+)
+
+func TestPeopleRegistryAddFace(t *testing.T) {
+	var peopleRegistry PeopleRegistry
+	alice := peopleRegistry.addFace(&PersonFace{ExternalImageID: "A", FaceID: "f1"}, nil)
+	alice.Name = "Alice"
+	if person := peopleRegistry.addFace(&PersonFace{ExternalImageID: "B", FaceID: "f2"}, []string{"unknown", "f1"}); person != alice || len(alice.Faces) != 2 {
+		t.Fatalf("AddFace: matched %+v", person)
+	}
+	if person := peopleRegistry.addFace(&PersonFace{ExternalImageID: "B", FaceID: "f3"}, []string{"unknown"}); person.ID != "f3" || len(peopleRegistry.People) != 2 {
+		t.Fatalf("AddFace: new %+v", person)
+	}
+	if !peopleRegistry.hasExternalImageID("B") || peopleRegistry.hasExternalImageID("C") {
+		t.Errorf("HasExternalImageID: wrong")
+	}
+}
+
+func TestGetPhotoPeople(t *testing.T) {
+	peopleRegistry := PeopleRegistry{People: []*Person{
+		{ID: "f1", Name: "Alice", Faces: []*PersonFace{
+			{BoundingBox: &rekognition.BoundingBox{Left: aws.Float64(0.6)}, Confidence: 99, ExternalImageID: "B", FaceID: "f1"},
+			{BoundingBox: &rekognition.BoundingBox{Left: aws.Float64(0.2)}, Confidence: 90, ExternalImageID: "B", FaceID: "f4"}}},
+		{ID: "f3", Faces: []*PersonFace{
+			{BoundingBox: &rekognition.BoundingBox{Left: aws.Float64(0.4)}, Confidence: 97, ExternalImageID: "B", FaceID: "f3"}}},
+	}}
+	photoPeople := getPhotoPeople(&peopleRegistry, "B")
+	if photoPeople.ID != "B" || photoPeople.Name != "B" || len(photoPeople.People) != 3 || photoPeople.People[0].FaceID != "f4" || photoPeople.People[1].FaceID != "f3" {
+		t.Fatalf("GetPhotoPeople: %+v", photoPeople.People)
+	}
+	keywords := getPeopleKeywords(photoPeople)
+	if len(keywords) != 1 || keywords[0].Name != "Alice" || keywords[0].Confidence != 99 {
+		t.Errorf("GetPeopleKeywords: %+v", keywords)
+	}
+}
+
+func TestGetExternalImageID(t *testing.T) {
+	s3BucketFolderImagesCompressed = "images/compressed/"
+	s3BucketFolderImagesPeople = "images/people/"
+
+	// Photos with the same name taken on different days have different external image IDs.
+	imageID := getImageID("images/compressed//2024/05/01/IMG_0001.JPG")
+	if externalImageID := getExternalImageID(imageID); externalImageID != "2024:05:01:IMG_0001" {
+		t.Errorf("GetExternalImageID: %q", externalImageID)
+	}
+	if externalImageID := getExternalImageID(getImageID("images/compressed//2024/06/01/IMG_0001.JPG")); externalImageID != "2024:06:01:IMG_0001" {
+		t.Errorf("GetExternalImageID: other day %q", externalImageID)
+	}
+	if s3ObjectKey := getPhotoPeopleKey(imageID); s3ObjectKey != "images/people//2024/05/01/IMG_0001.JSON" {
+		t.Errorf("GetPhotoPeopleKey: %q", s3ObjectKey)
+	}
+
+	peopleRegistry := PeopleRegistry{People: []*Person{{ID: "f1", Faces: []*PersonFace{
+		{ExternalImageID: "2024:05:01:IMG_0001", FaceID: "f1"},
+		{ExternalImageID: "2024:06:01:IMG_0001", FaceID: "f2"}}}}}
+	if photoPeople := getPhotoPeople(&peopleRegistry, imageID); photoPeople.ID != imageID || photoPeople.Name != "IMG_0001" || len(photoPeople.People) != 1 || photoPeople.People[0].FaceID != "f1" {
+		t.Errorf("GetPhotoPeople: %+v", photoPeople)
+	}
+}
+
+func TestUpdatePeopleRegistry(t *testing.T) {
+	setTestConfiguration(t)
+	s3Client := newFakeS3()
+
+	// Another photo adds its face after the registry was read, so the update is applied again to the latest registry.
+	attempts := 0
+	peopleRegistry, err := updatePeopleRegistry(s3Client, "photos", func(peopleRegistry *PeopleRegistry) {
+		attempts++
+		if attempts == 1 {
+			other := PeopleRegistry{People: []*Person{{Faces: []*PersonFace{{ExternalImageID: "A", FaceID: "f1"}}, ID: "f1"}}}
+			if err := processS3ObjectJSON(s3Client, "photos", getPeopleRegistryKey(), &other); err != nil {
+				t.Fatalf("ProcessS3ObjectJSON: %s", err)
+			}
+		}
+		peopleRegistry.addFace(&PersonFace{ExternalImageID: "B", FaceID: "f2"}, []string{"f1"})
+	})
+	if err != nil {
+		t.Fatalf("UpdatePeopleRegistry: %s", err)
+	}
+	if attempts != 2 || len(peopleRegistry.People) != 1 || len(peopleRegistry.People[0].Faces) != 2 {
+		t.Errorf("UpdatePeopleRegistry: attempts %d registry %+v", attempts, peopleRegistry.People)
+	}
+	stored, _, err := getS3ObjectPeopleRegistry(s3Client, "photos")
+	if err != nil || len(stored.People) != 1 || len(stored.People[0].Faces) != 2 {
+		t.Errorf("UpdatePeopleRegistry: stored %+v %v", stored, err)
+	}
+
+	// A registry that keeps changing gives up after the maximum attempts.
+	attempts = 0
+	_, err = updatePeopleRegistry(s3Client, "photos", func(peopleRegistry *PeopleRegistry) {
+		attempts++
+		s3Client.putObject(getPeopleRegistryKey(), []byte(fmt.Sprintf(`{"People": [], "Attempt": %d}`, attempts)))
+	})
+	if !isS3PreconditionFailed(err) || attempts != peopleRegistryMaxAttempts {
+		t.Errorf("UpdatePeopleRegistry: attempts %d error %v", attempts, err)
+	}
+}
+
+func TestProcessRekognitionIndexFaces(t *testing.T) {
+	setTestConfiguration(t)
+	rekognitionClient := newFakeRekognition()
+	s3Client := newFakeS3()
+
+	// A retried invocation reuses the stored faces rather than indexing the photo again.
+	for i := 0; i < 2; i++ {
+		rekognitionIndexFacesOutput, err := processRekognitionIndexFaces(rekognitionClient, s3Client, &rekognition.Image{}, "photos", "2024/05/01/IMG_0001")
+		if err != nil || len(rekognitionIndexFacesOutput.FaceRecords) == 0 {
+			t.Fatalf("ProcessRekognitionIndexFaces: %+v %v", rekognitionIndexFacesOutput, err)
+		}
+	}
+	if calls := rekognitionClient.getCalls("IndexFaces"); calls != 1 {
+		t.Errorf("ProcessRekognitionIndexFaces: IndexFaces called %d times", calls)
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"
+	"image/jpeg"
+	_ "image/png"
+	"log"
+	"net/http"
+
+	"github.com/aws/aws-sdk-go/service/rekognition"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	_ "golang.org/x/image/bmp"
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/tiff"
+	_ "golang.org/x/image/webp"
+)
+
+// Contains functions for preparing the image bytes sent to Rekognition.
+//
+// Every detector is sent the image bytes rather than an S3 object reference, which Rekognition rejects for objects
+// over 15 MB and for formats other than JPEG and PNG. Images that Rekognition accepts as they are, within the bytes
+// payload limit, are sent unchanged. Anything else is decoded, downsized until its JPEG encoding fits the limit and
+// sent as a JPEG. The whole image is always resampled, never cropped or padded, so the relative bounding boxes and
+// polygons returned by Rekognition apply unchanged to the original image. Images too small for Rekognition to analyse
+// are logged and skipped rather than failing the Lambda, as retrying them would fail the same way.
+
+// Limits of the image bytes accepted by Rekognition, and the JPEG quality of prepared images.
+const (
+	rekognitionImageMaxBytes     = 5 * 1024 * 1024
+	rekognitionImageMaxDimension = 4096
+	rekognitionImageMinDimension = 80
+	rekognitionImageQuality      = 90
+	rekognitionImageScaleStep    = 0.75
+)
+
+// errImageTooSmall is returned for images smaller than Rekognition analyses.
+var errImageTooSmall = errors.New("image too small")
+
+// PreparedImage describes the image bytes prepared for Rekognition.
+type PreparedImage struct {
+	Bytes          []byte
+	Format         string
+	Height         int
+	OriginalFormat string
+	OriginalHeight int
+	OriginalWidth  int
+	Width          int
+}
+
+// isRekognitionImageFormat reports whether Rekognition accepts the content type detected in the image bytes.
+func isRekognitionImageFormat(contentType string) bool {
+	return contentType == "image/jpeg" || contentType == "image/png"
+}
+
+// getPreparedImageSize returns the dimensions of an image scaled so that its longest side is no more than the maximum.
+func getPreparedImageSize(width int, height int, maxDimension int) (int, int) {
+	if width <= maxDimension && height <= maxDimension {
+		return width, height
+	}
+	if width >= height {
+		return maxDimension, max(1, height*maxDimension/width)
+	}
+	return max(1, width*maxDimension/height), maxDimension
+}
+
+// getPreparedImage returns the image bytes to send to Rekognition, converting and downsizing the image when
+// Rekognition would not accept it as it is.
+func getPreparedImage(b []byte) (*PreparedImage, error) {
+	imageConfig, format, err := image.DecodeConfig(bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	preparedImage := PreparedImage{
+		Bytes:          b,
+		Format:         format,
+		Height:         imageConfig.Height,
+		OriginalFormat: format,
+		OriginalHeight: imageConfig.Height,
+		OriginalWidth:  imageConfig.Width,
+		Width:          imageConfig.Width}
+	if min(imageConfig.Width, imageConfig.Height) < rekognitionImageMinDimension {
+		return nil, fmt.Errorf("%w: %dx%d is smaller than %d pixels", errImageTooSmall, imageConfig.Width, imageConfig.Height, rekognitionImageMinDimension)
+	}
+	if isRekognitionImageFormat(http.DetectContentType(b)) && len(b) <= rekognitionImageMaxBytes && max(imageConfig.Width, imageConfig.Height) <= rekognitionImageMaxDimension {
+		return &preparedImage, nil
+	}
+
+	// Downsize the decoded image until its JPEG encoding fits the payload limit.
+	imageSource, _, err := image.Decode(bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	maxDimension := rekognitionImageMaxDimension
+	for {
+		width, height := getPreparedImageSize(imageConfig.Width, imageConfig.Height, maxDimension)
+		if min(width, height) < rekognitionImageMinDimension {
+			return nil, fmt.Errorf("image %dx%d does not fit in %d bytes", imageConfig.Width, imageConfig.Height, rekognitionImageMaxBytes)
+		}
+		imagePrepared := image.NewRGBA(image.Rect(0, 0, width, height))
+		draw.CatmullRom.Scale(imagePrepared, imagePrepared.Bounds(), imageSource, imageSource.Bounds(), draw.Src, nil)
+		var buffer bytes.Buffer
+		if err := jpeg.Encode(&buffer, imagePrepared, &jpeg.Options{Quality: rekognitionImageQuality}); err != nil {
+			return nil, err
+		}
+		if buffer.Len() <= rekognitionImageMaxBytes {
+			preparedImage.Bytes = buffer.Bytes()
+			preparedImage.Format = "jpeg"
+			preparedImage.Height = height
+			preparedImage.Width = width
+			return &preparedImage, nil
+		}
+		maxDimension = int(float64(max(width, height)) * rekognitionImageScaleStep)
+	}
+}
+
+// getRekognitionImage downloads an S3 object image and prepares the Rekognition Image sent to every detector.
+func getRekognitionImage(s3Client s3iface.S3API, s3BucketName string, s3ObjectKey string) (*rekognition.Image, error) {
+	b, err := getS3ObjectBytes(s3Client, s3BucketName, s3ObjectKey)
+	if err != nil {
+		return nil, err
+	}
+	preparedImage, err := getPreparedImage(b)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("RekognitionImage: Key=%s Format=%s Width=%d Height=%d Bytes=%d OriginalFormat=%s OriginalWidth=%d OriginalHeight=%d OriginalBytes=%d",
+		s3ObjectKey,
+		preparedImage.Format,
+		preparedImage.Width,
+		preparedImage.Height,
+		len(preparedImage.Bytes),
+		preparedImage.OriginalFormat,
+		preparedImage.OriginalWidth,
+		preparedImage.OriginalHeight,
+		len(b))
+	return &rekognition.Image{Bytes: preparedImage.Bytes}, nil
+}
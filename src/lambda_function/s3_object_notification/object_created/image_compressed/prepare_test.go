@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/gif"
+	"image/png"
+	"net/http"
+	"testing"
+)
+
+// encodeTestImage encodes a uniform image of the given size with the encoder.
+func encodeTestImage(t *testing.T, width int, height int, encode func(*bytes.Buffer, image.Image) error) []byte {
+	imageSource := image.NewRGBA(image.Rect(0, 0, width, height))
+	for i := range imageSource.Pix {
+		imageSource.Pix[i] = 128
+	}
+	var buffer bytes.Buffer
+	if err := encode(&buffer, imageSource); err != nil {
+		t.Fatalf("Encode: %s", err)
+	}
+	return buffer.Bytes()
+}
+
+func TestGetPreparedImageSize(t *testing.T) {
+	for _, test := range []struct{ width, height, wantWidth, wantHeight int }{
+		{1000, 800, 1000, 800},
+		{8192, 4096, 4096, 2048},
+		{3000, 6000, 2048, 4096},
+	} {
+		if width, height := getPreparedImageSize(test.width, test.height, 4096); width != test.wantWidth || height != test.wantHeight {
+			t.Errorf("GetPreparedImageSize: %dx%d gives %dx%d", test.width, test.height, width, height)
+		}
+	}
+}
+
+func TestGetPreparedImage(t *testing.T) {
+	encodePNG := func(buffer *bytes.Buffer, imageSource image.Image) error { return png.Encode(buffer, imageSource) }
+	encodeGIF := func(buffer *bytes.Buffer, imageSource image.Image) error {
+		return gif.Encode(buffer, imageSource, nil)
+	}
+
+	// Accepted images are sent unchanged.
+	b := encodeTestImage(t, 200, 100, encodePNG)
+	preparedImage, err := getPreparedImage(b)
+	if err != nil || !bytes.Equal(preparedImage.Bytes, b) || preparedImage.Format != "png" {
+		t.Fatalf("GetPreparedImage: PNG %+v %v", preparedImage, err)
+	}
+
+	// Other formats are converted to JPEG.
+	preparedImage, err = getPreparedImage(encodeTestImage(t, 200, 100, encodeGIF))
+	if err != nil || preparedImage.Format != "jpeg" || preparedImage.OriginalFormat != "gif" || http.DetectContentType(preparedImage.Bytes) != "image/jpeg" {
+		t.Fatalf("GetPreparedImage: GIF %v %v", preparedImage.Format, err)
+	}
+	if preparedImage.Width != 200 || preparedImage.Height != 100 {
+		t.Errorf("GetPreparedImage: GIF %dx%d", preparedImage.Width, preparedImage.Height)
+	}
+
+	// Images over the maximum dimension are downsized, keeping their aspect ratio.
+	preparedImage, err = getPreparedImage(encodeTestImage(t, 8192, 400, encodePNG))
+	if err != nil || preparedImage.Width != 4096 || preparedImage.Height != 200 || preparedImage.OriginalWidth != 8192 {
+		t.Fatalf("GetPreparedImage: large %+v %v", preparedImage, err)
+	}
+	imageConfig, _, err := image.DecodeConfig(bytes.NewReader(preparedImage.Bytes))
+	if err != nil || imageConfig.Width != 4096 || imageConfig.Height != 200 {
+		t.Errorf("GetPreparedImage: large encoded as %+v %v", imageConfig, err)
+	}
+
+	// Images Rekognition cannot analyse are rejected.
+	if _, err := getPreparedImage(encodeTestImage(t, 200, 50, encodePNG)); !errors.Is(err, errImageTooSmall) {
+		t.Errorf("GetPreparedImage: small image accepted")
+	}
+	if _, err := getPreparedImage([]byte("not an image")); err == nil {
+		t.Errorf("GetPreparedImage: invalid image accepted")
+	}
+}
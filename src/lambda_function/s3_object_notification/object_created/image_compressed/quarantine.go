@@ -0,0 +1,314 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+)
+
+// Contains functions for quarantining images whose moderation labels exceed the configured thresholds.
+//
+// A quarantined image is described by a review record stored in the quarantine folder, and every object derived from
+// it is moved beneath the folder, keeping its original key so that approving the image can restore it. The record is
+// written before anything is moved, so the image Lambda, which checks for the record when it finishes, quarantines
+// whatever it produced after this Lambda looked for it. The image is also removed from the image Lambda's hash index,
+// so that later uploads are not flagged as duplicates of it.
+
+// Review statuses of a quarantined image.
+const (
+	moderationReviewStatusApproved = "Approved"
+	moderationReviewStatusPending  = "Pending"
+	moderationReviewStatusRejected = "Rejected"
+)
+
+// ModerationReviewRecord is stored in the quarantine folder for an image quarantined by moderation, and is updated
+// when the image is approved or rejected. The record and the quarantined objects are keyed by the ID of the image,
+// so that a photo sharing the name of another is reviewed on its own.
+type ModerationReviewRecord struct {
+	Categories    []*ModerationCategory `json:"Categories"`
+	ID            string                `json:"ID"`
+	Key           string                `json:"Key"`
+	Name          string                `json:"Name"`
+	QuarantineKey string                `json:"QuarantineKey"`
+	QuarantinedAt time.Time             `json:"QuarantinedAt"`
+	ReviewedAt    *time.Time            `json:"ReviewedAt"`
+	Status        string                `json:"Status"`
+}
+
+// imageHashIndexMaxAttempts limits the attempts to update a shard of the hash index while other writers change it.
+const imageHashIndexMaxAttempts = 5
+
+// ImageHashIndexEntry records the hashes of an uploaded image in the hash index of the image Lambda.
+type ImageHashIndexEntry struct {
+	DHash  string `json:"DHash"`
+	Key    string `json:"Key"`
+	PHash  string `json:"PHash"`
+	SHA256 string `json:"SHA256"`
+}
+
+// getModerationQuarantineThresholds parses the JSON object of minimum confidences, keyed by top-level moderation
+// category, at which images are quarantined. An empty object disables quarantine.
+func getModerationQuarantineThresholds(configuration string) (map[string]float64, error) {
+	var moderationQuarantineThresholds map[string]float64
+	if err := json.Unmarshal([]byte(configuration), &moderationQuarantineThresholds); err != nil {
+		return nil, err
+	}
+	for category, threshold := range moderationQuarantineThresholds {
+		if threshold < 0 || threshold > 100 {
+			return nil, fmt.Errorf("category %q threshold %v outside 0-100", category, threshold)
+		}
+	}
+	return moderationQuarantineThresholds, nil
+}
+
+// getModerationQuarantineCategories returns the categories of the verdict that reach their quarantine thresholds.
+func getModerationQuarantineCategories(moderationVerdict *ModerationVerdict, moderationQuarantineThresholds map[string]float64) []*ModerationCategory {
+	var categories []*ModerationCategory
+	for _, category := range moderationVerdict.Categories {
+		if threshold, ok := moderationQuarantineThresholds[category.Name]; ok && category.Confidence >= threshold {
+			categories = append(categories, category)
+		}
+	}
+	return categories
+}
+
+// getModerationReviewRecordKey returns the S3 object key of the review record of the image with the ID.
+func getModerationReviewRecordKey(imageID string) string {
+	return fmt.Sprintf("%s/moderation/%s.JSON", s3BucketFolderQuarantine, imageID)
+}
+
+// getModerationQuarantineKey returns the S3 object key that an object of the image with the ID is quarantined at.
+func getModerationQuarantineKey(imageID string, s3ObjectKey string) string {
+	return fmt.Sprintf("%s/moderation/%s/%s", s3BucketFolderQuarantine, imageID, s3ObjectKey)
+}
+
+// getS3ObjectModerationReviewRecord downloads the review record of the image with the ID. It returns nil if the
+// image has never been quarantined.
+func getS3ObjectModerationReviewRecord(s3Client s3iface.S3API, s3BucketName string, imageID string) (*ModerationReviewRecord, error) {
+	b, err := getS3ObjectBytes(s3Client, s3BucketName, getModerationReviewRecordKey(imageID))
+	var awsErr awserr.Error
+	if errors.As(err, &awsErr) && awsErr.Code() == s3.ErrCodeNoSuchKey {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var moderationReviewRecord ModerationReviewRecord
+	if err := json.Unmarshal(b, &moderationReviewRecord); err != nil {
+		return nil, err
+	}
+	return &moderationReviewRecord, nil
+}
+
+// getModerationQuarantineObjectKeys returns the keys of the objects derived from a compressed image: the image itself,
+// its Exif metadata, renditions, graded copies and original upload, as listed by the metadata and rendition
+// manifest when they exist yet, and its Rekognition outputs, annotation, keyword set and people.
+func getModerationQuarantineObjectKeys(s3Client s3iface.S3API, s3BucketName string, s3ObjectKey string) ([]string, error) {
+	name := strings.Split(path.Base(s3ObjectKey), ".")[0]
+	date := path.Dir(strings.TrimPrefix(s3ObjectKey, s3BucketFolderImagesCompressed+"/"))
+	s3ObjectKeyExif := getImageExifKey(s3ObjectKey)
+	s3ObjectKeyRenditionManifest := fmt.Sprintf("%s/%s/%s.JSON", s3BucketFolderImagesRenditions, date, name)
+	s3ObjectKeyAnnotation := getAnnotationKey(getImageID(s3ObjectKey))
+	s3ObjectKeyPeople := getPhotoPeopleKey(getImageID(s3ObjectKey))
+	s3ObjectKeyIndexedFaces := getIndexedFacesKey(getImageID(s3ObjectKey))
+	s3ObjectKeys := []string{s3ObjectKey, s3ObjectKeyExif, s3ObjectKeyRenditionManifest, s3ObjectKeyAnnotation, s3ObjectKeyPeople, s3ObjectKeyIndexedFaces}
+	for _, s3BucketFolder := range []string{
+		s3BucketFolderImagesKeywords,
+		s3BucketFolderRekognitionDetectCustomLabels,
+		s3BucketFolderRekognitionDetectFaces,
+		s3BucketFolderRekognitionDetectLabels,
+		s3BucketFolderRekognitionDetectModerationLabels,
+		s3BucketFolderRekognitionDetectText,
+		s3BucketFolderRekognitionRecognizeCelebrities,
+		s3BucketFolderRekognitionStatus,
+	} {
+		s3ObjectKeys = append(s3ObjectKeys, fmt.Sprintf("%s/%s.JSON", s3BucketFolder, name))
+	}
+
+	// Only the fields listing other objects are read from the metadata and the manifest.
+	var imageMetadata struct {
+		Grades []struct {
+			Key string `json:"Key"`
+		} `json:"Grades"`
+		UploadedKey string `json:"UploadedKey"`
+	}
+	var renditionManifest struct {
+		Renditions []struct {
+			Key string `json:"Key"`
+		} `json:"Renditions"`
+	}
+	for _, document := range []struct {
+		key   string
+		value interface{}
+	}{
+		{s3ObjectKeyExif, &imageMetadata},
+		{s3ObjectKeyRenditionManifest, &renditionManifest},
+	} {
+		b, err := getS3ObjectBytes(s3Client, s3BucketName, document.key)
+		var awsErr awserr.Error
+		if errors.As(err, &awsErr) && awsErr.Code() == s3.ErrCodeNoSuchKey {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(b, document.value); err != nil {
+			return nil, err
+		}
+	}
+	if imageMetadata.UploadedKey != "" {
+		s3ObjectKeys = append(s3ObjectKeys, imageMetadata.UploadedKey)
+	}
+	for _, grade := range imageMetadata.Grades {
+		s3ObjectKeys = append(s3ObjectKeys, grade.Key)
+	}
+	for _, rendition := range renditionManifest.Renditions {
+		s3ObjectKeys = append(s3ObjectKeys, rendition.Key)
+	}
+	return s3ObjectKeys, nil
+}
+
+// getImageHashIndexShardKeys returns the S3 object keys of the shards of the hash index that an image with the
+// perceptual hash is recorded in, matching the image Lambda.
+func getImageHashIndexShardKeys(perceptualHash uint64) []string {
+	s3ObjectKeys := make([]string, 8)
+	for i := range s3ObjectKeys {
+		s3ObjectKeys[i] = fmt.Sprintf("%s/%d/%02x.JSON", s3BucketFolderImagesHashes, i, byte(perceptualHash>>(56-8*i)))
+	}
+	return s3ObjectKeys
+}
+
+// deleteImageHashIndexEntry removes the uploaded image of the compressed image from the hash index. The hashes are
+// read from the image's metadata, which the image Lambda has not stored yet while it is still processing the image;
+// that Lambda removes the entry itself once it sees the quarantine.
+func deleteImageHashIndexEntry(s3Client s3iface.S3API, s3BucketName string, s3ObjectKey string) error {
+	var imageMetadata struct {
+		Hashes *struct {
+			PHash string `json:"PHash"`
+		} `json:"Hashes"`
+		UploadedKey string `json:"UploadedKey"`
+	}
+	ok, err := getS3ObjectJSON(s3Client, s3BucketName, getImageExifKey(s3ObjectKey), &imageMetadata)
+	if err != nil || !ok || imageMetadata.Hashes == nil || imageMetadata.UploadedKey == "" {
+		return err
+	}
+	perceptualHash, err := strconv.ParseUint(imageMetadata.Hashes.PHash, 16, 64)
+	if err != nil {
+		return err
+	}
+	for _, shardKey := range getImageHashIndexShardKeys(perceptualHash) {
+		if err := deleteImageHashIndexShardEntry(s3Client, s3BucketName, shardKey, imageMetadata.UploadedKey); err != nil {
+			return fmt.Errorf("%s: %w", shardKey, err)
+		}
+	}
+	return nil
+}
+
+// deleteImageHashIndexShardEntry removes the entry for the uploaded key from a shard of the hash index. The shard is
+// only stored if no other writer changed it in the meantime, and is read again otherwise.
+func deleteImageHashIndexShardEntry(s3Client s3iface.S3API, s3BucketName string, shardKey string, s3ObjectKeyUploaded string) error {
+	for attempt := 1; ; attempt++ {
+		var imageHashIndexEntries []ImageHashIndexEntry
+		eTag, err := getS3ObjectJSONETag(s3Client, s3BucketName, shardKey, &imageHashIndexEntries)
+		if err != nil {
+			return err
+		}
+		updatedEntries := []ImageHashIndexEntry{}
+		for _, imageHashIndexEntry := range imageHashIndexEntries {
+			if imageHashIndexEntry.Key != s3ObjectKeyUploaded {
+				updatedEntries = append(updatedEntries, imageHashIndexEntry)
+			}
+		}
+		if len(updatedEntries) == len(imageHashIndexEntries) {
+			return nil
+		}
+		log.Printf("ImageHashIndex: Key=%s Removed=%s", shardKey, s3ObjectKeyUploaded)
+		err = processS3ObjectJSONConditional(s3Client, s3BucketName, shardKey, updatedEntries, eTag)
+		if err == nil || !isS3PreconditionFailed(err) || attempt == imageHashIndexMaxAttempts {
+			return err
+		}
+		log.Printf("ImageHashIndex: Key=%s Attempt=%d Error=%s", shardKey, attempt, err)
+	}
+}
+
+// moveS3ObjectToQuarantine moves an object to its quarantine key. Objects that do not exist, because they were never
+// produced or have already been moved, are skipped.
+func moveS3ObjectToQuarantine(s3Client s3iface.S3API, s3BucketName string, s3ObjectKey string, s3ObjectKeyQuarantine string) error {
+	// Copy the object before deleting it, so that a failure never loses it.
+	_, err := copyS3Object(s3Client, s3BucketName, s3ObjectKey, s3ObjectKeyQuarantine)
+	var awsErr awserr.Error
+	if errors.As(err, &awsErr) && awsErr.Code() == s3.ErrCodeNoSuchKey {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	log.Printf("ModerationQuarantine: Key=%s QuarantineKey=%s", s3ObjectKey, s3ObjectKeyQuarantine)
+	_, err = deleteS3Object(s3Client, s3BucketName, s3ObjectKey)
+	return err
+}
+
+// processModerationQuarantine quarantines the compressed image and everything derived from it when its moderation
+// verdict reaches a quarantine threshold. Images that a reviewer has approved are left alone. It returns whether the
+// image was quarantined.
+func processModerationQuarantine(s3Client s3iface.S3API, s3BucketName string, s3ObjectKey string, moderationVerdict *ModerationVerdict) (bool, error) {
+	categories := getModerationQuarantineCategories(moderationVerdict, moderationQuarantineThresholds)
+	if len(categories) == 0 {
+		return false, nil
+	}
+	imageID := getImageID(s3ObjectKey)
+	name := strings.Split(path.Base(s3ObjectKey), ".")[0]
+	moderationReviewRecord, err := getS3ObjectModerationReviewRecord(s3Client, s3BucketName, imageID)
+	if err != nil {
+		return false, err
+	}
+	if moderationReviewRecord != nil && moderationReviewRecord.Status == moderationReviewStatusApproved {
+		log.Printf("ModerationQuarantine: Key=%s Status=%s", s3ObjectKey, moderationReviewRecord.Status)
+		return false, nil
+	}
+
+	// Record the quarantine before moving anything, so that the image Lambda sees it.
+	moderationReviewRecord = &ModerationReviewRecord{
+		Categories:    categories,
+		ID:            imageID,
+		Key:           s3ObjectKey,
+		Name:          name,
+		QuarantineKey: getModerationQuarantineKey(imageID, ""),
+		QuarantinedAt: time.Now().UTC(),
+		Status:        moderationReviewStatusPending}
+	log.Printf("ModerationQuarantine: Key=%s QuarantineKey=%s Categories=%d", s3ObjectKey, moderationReviewRecord.QuarantineKey, len(categories))
+	s3PutObjectInput, err := getS3PutObjectInput(s3BucketName, getModerationReviewRecordKey(imageID), moderationReviewRecord)
+	if err != nil {
+		return false, err
+	}
+	s3PutObjectOutput, err := putS3Object(s3Client, s3PutObjectInput)
+	if err != nil {
+		return false, err
+	}
+	processS3PutObjectOutput(s3PutObjectOutput)
+
+	// Remove the image from the hash index while its metadata, which holds its hashes, has not been moved yet.
+	if err := deleteImageHashIndexEntry(s3Client, s3BucketName, s3ObjectKey); err != nil {
+		return false, fmt.Errorf("hash index: %w", err)
+	}
+
+	s3ObjectKeys, err := getModerationQuarantineObjectKeys(s3Client, s3BucketName, s3ObjectKey)
+	if err != nil {
+		return false, err
+	}
+	for _, s3ObjectKeyQuarantined := range s3ObjectKeys {
+		if err := moveS3ObjectToQuarantine(s3Client, s3BucketName, s3ObjectKeyQuarantined, getModerationQuarantineKey(imageID, s3ObjectKeyQuarantined)); err != nil {
+			return false, fmt.Errorf("%s: %w", s3ObjectKeyQuarantined, err)
+		}
+	}
+	return true, nil
+}
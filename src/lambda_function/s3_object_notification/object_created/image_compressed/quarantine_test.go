@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestGetModerationQuarantineThresholds(t *testing.T) {
+	moderationQuarantineThresholds, err := getModerationQuarantineThresholds(`{"Explicit": 80, "Violence": 90}`)
+	if err != nil || len(moderationQuarantineThresholds) != 2 || moderationQuarantineThresholds["Violence"] != 90 {
+		t.Fatalf("GetModerationQuarantineThresholds: %v %v", moderationQuarantineThresholds, err)
+	}
+	for _, configuration := range []string{"", "[]", `{"Explicit": 120}`, `{"Explicit": -1}`} {
+		if _, err := getModerationQuarantineThresholds(configuration); err == nil {
+			t.Errorf("GetModerationQuarantineThresholds: %q accepted", configuration)
+		}
+	}
+}
+
+func TestGetModerationQuarantineCategories(t *testing.T) {
+	moderationVerdict := ModerationVerdict{Categories: []*ModerationCategory{
+		{Confidence: 85, Name: "Explicit"},
+		{Confidence: 85, Name: "Violence"},
+		{Confidence: 99, Name: "Rude Gestures"},
+	}}
+	categories := getModerationQuarantineCategories(&moderationVerdict, map[string]float64{"Explicit": 80, "Violence": 90})
+	if len(categories) != 1 || categories[0].Name != "Explicit" {
+		t.Errorf("GetModerationQuarantineCategories: %+v", categories)
+	}
+	if categories := getModerationQuarantineCategories(&moderationVerdict, map[string]float64{}); len(categories) != 0 {
+		t.Errorf("GetModerationQuarantineCategories: %+v with no thresholds", categories)
+	}
+}
+
+func TestGetModerationQuarantineKey(t *testing.T) {
+	s3BucketFolderImagesCompressed = "images/compressed/"
+	s3BucketFolderQuarantine = "quarantine/"
+	imageID := getImageID("images/compressed//2024/05/01/IMG_0001.JPG")
+	if imageID != "2024/05/01/IMG_0001" {
+		t.Errorf("GetImageID: %q", imageID)
+	}
+	if s3ObjectKey := getModerationQuarantineKey(imageID, "images/compressed//2024/05/01/IMG_0001.JPG"); s3ObjectKey != "quarantine//moderation/2024/05/01/IMG_0001/images/compressed//2024/05/01/IMG_0001.JPG" {
+		t.Errorf("GetModerationQuarantineKey: %q", s3ObjectKey)
+	}
+	if s3ObjectKey := getModerationReviewRecordKey(imageID); s3ObjectKey != "quarantine//moderation/2024/05/01/IMG_0001.JSON" {
+		t.Errorf("GetModerationReviewRecordKey: %q", s3ObjectKey)
+	}
+}
+
+func TestDeleteImageHashIndexEntry(t *testing.T) {
+	setTestConfiguration(t)
+	s3Client := newFakeS3()
+	s3ObjectKey := "images/compressed//2024/05/01/IMG_0001.JPG"
+	s3ObjectKeyUploaded := "images/uploaded//IMG_0001.JPG"
+	s3Client.putObject(getImageExifKey(s3ObjectKey), []byte(`{"Hashes": {"PHash": "0102030405060708"}, "UploadedKey": "images/uploaded//IMG_0001.JPG"}`))
+	shardKeys := getImageHashIndexShardKeys(0x0102030405060708)
+	if shardKeys[0] != "images/hashes//0/01.JSON" || shardKeys[7] != "images/hashes//7/08.JSON" {
+		t.Fatalf("GetImageHashIndexShardKeys: %v", shardKeys)
+	}
+	for _, shardKey := range shardKeys {
+		b, _ := json.Marshal([]ImageHashIndexEntry{{Key: s3ObjectKeyUploaded}, {Key: "images/uploaded//IMG_0002.JPG"}})
+		s3Client.putObject(shardKey, b)
+	}
+
+	if err := deleteImageHashIndexEntry(s3Client, "bucket", s3ObjectKey); err != nil {
+		t.Fatal(err)
+	}
+	for _, shardKey := range shardKeys {
+		b, _ := s3Client.getObject(shardKey)
+		var imageHashIndexEntries []ImageHashIndexEntry
+		if err := json.Unmarshal(b, &imageHashIndexEntries); err != nil || len(imageHashIndexEntries) != 1 || imageHashIndexEntries[0].Key != "images/uploaded//IMG_0002.JPG" {
+			t.Errorf("DeleteImageHashIndexEntry: %s %+v %v", shardKey, imageHashIndexEntries, err)
+		}
+	}
+
+	// An image whose metadata has not been stored yet is left to the image Lambda.
+	if err := deleteImageHashIndexEntry(s3Client, "bucket", "images/compressed//2024/05/01/IMG_0003.JPG"); err != nil {
+		t.Errorf("DeleteImageHashIndexEntry: missing metadata %v", err)
+	}
+}
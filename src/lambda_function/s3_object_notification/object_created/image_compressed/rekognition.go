@@ -1,175 +1,194 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"path"
 	"strings"
 
 	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/rekognition"
-	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/rekognition/rekognitioniface"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
 )
 
-// processRekognition processes an image object in S3 against various AWS Rekognition services.
-func processRekognition(session *session.Session, s3BucketName string, s3ObjectKey string) {
-	rekognitionClient := rekognition.New(session)
-	s3Client := s3.New(session)
-	processRekognitionDetectFaces(rekognitionClient, s3Client, s3BucketName, s3ObjectKey)
-	processRekognitionDetectLabels(rekognitionClient, s3Client, s3BucketName, s3ObjectKey)
-	processRekognitionDetectText(rekognitionClient, s3Client, s3BucketName, s3ObjectKey)
-}
+// processRekognition processes an image object in S3 against various AWS Rekognition services. The analyzers that
+// have not yet succeeded for the ETag of the image run concurrently, and the error of those that fail is returned.
+func processRekognition(analyzerClients *AnalyzerClients, s3ObjectKey string, eTag string) error {
+	s3Client := analyzerClients.S3
+	s3BucketName := analyzerClients.S3BucketName
+	name := strings.Split(path.Base(s3ObjectKey), ".")[0]
+	rekognitionStatus, err := getS3ObjectRekognitionStatus(s3Client, s3BucketName, name, eTag)
+	if err != nil {
+		return fmt.Errorf("status: %w", err)
+	}
+	rekognitionStatus.Key = s3ObjectKey
+	pendingAnalyzers := getPendingAnalyzers(newAnalyzers(analyzerClients), rekognitionStatus)
+	log.Printf("RekognitionStatus: Name=%s ETag=%s Succeeded=%v Pending=%d", name, eTag, rekognitionStatus.Succeeded, len(pendingAnalyzers))
+
+	// Every analyzer is sent the same prepared image.
+	var analyzerErr error
+	var moderationVerdict *ModerationVerdict
+	moderationPending := false
+	if len(pendingAnalyzers) > 0 {
+		rekognitionImage, err := getRekognitionImage(s3Client, s3BucketName, s3ObjectKey)
+		if errors.Is(err, errImageTooSmall) {
+			log.Printf("RekognitionImage: Key=%s Skipped=true Error=%s", s3ObjectKey, err)
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("image: %w", err)
+		}
+		analyzerResults, analyzerErrors := runAnalyzers(pendingAnalyzers, &AnalyzerImage{Image: rekognitionImage, Key: s3ObjectKey}, rekognitionDetectorConcurrency)
+		_, moderationPending = analyzerErrors[analyzerNameDetectModerationLabels]
+		moderationVerdict, _ = analyzerResults[analyzerNameDetectModerationLabels].(*ModerationVerdict)
+		analyzerErr = updateRekognitionStatus(rekognitionStatus, analyzerErrors)
+		if err := processS3ObjectJSON(s3Client, s3BucketName, getRekognitionStatusKey(name), rekognitionStatus); err != nil {
+			return errors.Join(analyzerErr, fmt.Errorf("status: %w", err))
+		}
+	}
 
-// processRekognitionDetectFaces processes an S3 object image with AWS Rekognition Detect Faces.
-func processRekognitionDetectFaces(rekognitionClient *rekognition.Rekognition, s3Client *s3.S3, s3BucketName string, s3ObjectKey string) {
-	// Create a Rekognition S3Object representation.
-	rekognitionS3Object := rekognition.S3Object{
-		Bucket: &s3BucketName,
-		Name:   &s3ObjectKey}
-	processRekognitionS3Object(&rekognitionS3Object)
+	// Use the verdict stored by an earlier run when moderation did not run again.
+	if !moderationPending {
+		moderationVerdict, err = getS3ObjectModerationVerdict(s3Client, s3BucketName, name)
+		if err != nil {
+			return errors.Join(analyzerErr, fmt.Errorf("moderation verdict: %w", err))
+		}
+	}
 
-	// Create a Rekognition Image representation.
-	rekognitionImage := rekognition.Image{
-		S3Object: &rekognitionS3Object,
+	// Merge the stored outputs into the annotation, which is quarantined with them.
+	if err := processAnnotation(s3Client, s3BucketName, s3ObjectKey, rekognitionStatus); err != nil {
+		analyzerErr = errors.Join(analyzerErr, fmt.Errorf("annotation: %w", err))
 	}
-	processRekognitionImage(&rekognitionImage)
 
+	// Quarantine unsafe images once every output has been stored, so that the outputs are quarantined with them. A
+	// quarantined image is not retried, as its failed analyzers run again when it is approved. A quarantine that fails
+	// part way is retried with the analyzers, and moves whatever was left behind.
+	if moderationVerdict == nil {
+		return analyzerErr
+	}
+	quarantined, err := processModerationQuarantine(s3Client, s3BucketName, s3ObjectKey, moderationVerdict)
+	if err != nil {
+		return errors.Join(analyzerErr, fmt.Errorf("quarantine: %w", err))
+	}
+	if quarantined {
+		if analyzerErr != nil {
+			log.Printf("RekognitionStatus: Name=%s Quarantined=true Error=%s", name, analyzerErr)
+		}
+		return nil
+	}
+	return analyzerErr
+}
+
+// processRekognitionDetectFaces processes an S3 object image with AWS Rekognition Detect Faces.
+func processRekognitionDetectFaces(rekognitionClient rekognitioniface.RekognitionAPI, s3Client s3iface.S3API, rekognitionImage *rekognition.Image, s3BucketName string, s3ObjectKey string) (*rekognition.DetectFacesOutput, error) {
 	// Create a Rekognition DetectFacesInput and process it.
 	rekognitionDetectFacesInput := rekognition.DetectFacesInput{
-		Attributes: aws.StringSlice([]string{"ALL"}),
-		Image:      &rekognitionImage,
+		Attributes: aws.StringSlice(detectorConfiguration.DetectFaces.Attributes),
+		Image:      rekognitionImage,
 	}
 	processRekognitionDetectFacesInput(&rekognitionDetectFacesInput)
 
 	// Perform face detection using Rekognition.
 	rekognitionDetectFacesOutput, err := rekognitionClient.DetectFaces(&rekognitionDetectFacesInput)
 	if err != nil {
-		log.Fatalf("RekognitionDetectFacesOutput: Error=%s", err)
+		return nil, err
 	}
 
 	// Process the output and store it in S3.
-	processRekognitionDetectFacesOutput(s3Client, rekognitionDetectFacesOutput, s3BucketName, s3ObjectKey)
+	if err := processRekognitionDetectFacesOutput(s3Client, rekognitionDetectFacesOutput, s3BucketName, s3ObjectKey); err != nil {
+		return nil, err
+	}
+	return rekognitionDetectFacesOutput, nil
 }
 
 // processRekognitionDetectFacesInput processes a rekognition.DetectFacesInput.
 func processRekognitionDetectFacesInput(rekognitionDetectFacesInput *rekognition.DetectFacesInput) {
 	log.Printf("RekognitionDetectFacesInput: Attributes=%v Bytes=%v",
 		rekognitionDetectFacesInput.Attributes,
-		rekognitionDetectFacesInput.Image.Bytes)
+		len(rekognitionDetectFacesInput.Image.Bytes))
 }
 
 // processRekognitionDetectFacesOutput processes a rekognition.DetectFacesOutput.
-func processRekognitionDetectFacesOutput(s3Client *s3.S3, rekognitionDetectFacesOutput *rekognition.DetectFacesOutput, s3BucketName string, s3ObjectKey string) {
+func processRekognitionDetectFacesOutput(s3Client s3iface.S3API, rekognitionDetectFacesOutput *rekognition.DetectFacesOutput, s3BucketName string, s3ObjectKey string) error {
 	log.Printf("RekognitionDetectFacesOutput: OrientationCorrection=%v",
 		rekognitionDetectFacesOutput.OrientationCorrection)
 
 	// Modify the S3 object key for storage.
 	s3ObjectKey = fmt.Sprintf("%s/%s.JSON", s3BucketFolderRekognitionDetectFaces, strings.Split(path.Base(s3ObjectKey), ".")[0])
 
-	// Prepare S3 PutObjectInput and perform the S3 object update.
-	s3PutObjectInput, err := getS3PutObjectInput(s3BucketName, s3ObjectKey, rekognitionDetectFacesOutput)
-	if err != nil {
-		log.Fatalf("S3PutObjectInput: Error=%s", err)
-	}
-
-	// Todo: Implement process S3PutObjectInput
-	s3PutObjectOutput, err := putS3Object(s3Client, s3PutObjectInput)
-	if err != nil {
-		log.Fatalf("S3PutObjectOutput: Error=%s", err)
-	}
-	processS3PutObjectOutput(s3PutObjectOutput)
+	// Store the output in S3.
+	return processS3ObjectJSON(s3Client, s3BucketName, s3ObjectKey, rekognitionDetectFacesOutput)
 }
 
 // processRekognitionDetectLabels processes an S3 object image with AWS Rekognition Detect Labels.
-func processRekognitionDetectLabels(rekognitionClient *rekognition.Rekognition, s3Client *s3.S3, s3BucketName string, s3ObjectKey string) {
-	// Create a Rekognition S3Object representation.
-	rekognitionS3Object := rekognition.S3Object{
-		Bucket: &s3BucketName,
-		Name:   &s3ObjectKey}
-	processRekognitionS3Object(&rekognitionS3Object)
-
-	// Create a Rekognition Image representation.
-	rekognitionImage := rekognition.Image{
-		S3Object: &rekognitionS3Object,
-	}
-	processRekognitionImage(&rekognitionImage)
-
+func processRekognitionDetectLabels(rekognitionClient rekognitioniface.RekognitionAPI, s3Client s3iface.S3API, rekognitionImage *rekognition.Image, s3BucketName string, s3ObjectKey string) (*rekognition.DetectLabelsOutput, error) {
 	// Create a Rekognition DetectLabelsInput and process it.
 	rekognitionDetectLabelsInput := rekognition.DetectLabelsInput{
-		Features: aws.StringSlice([]string{"GENERAL_LABELS", "IMAGE_PROPERTIES"}),
-		Image:    &rekognitionImage,
+		Features:      aws.StringSlice(detectorConfiguration.DetectLabels.Features),
+		Image:         rekognitionImage,
+		MaxLabels:     detectorConfiguration.DetectLabels.MaxLabels,
+		MinConfidence: detectorConfiguration.DetectLabels.MinConfidence,
+		Settings:      getDetectLabelsSettings(&detectorConfiguration.DetectLabels),
 	}
 	processRekognitionDetectLabelsInput(&rekognitionDetectLabelsInput)
 
 	// Perform label detection using Rekognition.
 	rekognitionDetectLabelsOutput, err := rekognitionClient.DetectLabels(&rekognitionDetectLabelsInput)
 	if err != nil {
-		log.Fatalf("RekognitionDetectLabelsOutput: Error=%s", err)
+		return nil, err
 	}
 
 	// Process the output and store it in S3.
-	processRekognitionDetectLabelsOutput(s3Client, rekognitionDetectLabelsOutput, s3BucketName, s3ObjectKey)
+	if err := processRekognitionDetectLabelsOutput(s3Client, rekognitionDetectLabelsOutput, s3BucketName, s3ObjectKey); err != nil {
+		return nil, err
+	}
+	return rekognitionDetectLabelsOutput, nil
 }
 
 // processRekognitionDetectLabelsInput processes a rekognition.DetectLabelsInput.
 func processRekognitionDetectLabelsInput(rekognitionDetectLabelsInput *rekognition.DetectLabelsInput) {
-	log.Printf("RekognitionDetectLabelsInput: Features=%v Bytes=%v MaxLabels=%v",
+	log.Printf("RekognitionDetectLabelsInput: Features=%v Bytes=%v MaxLabels=%v MinConfidence=%v Settings=%v",
 		rekognitionDetectLabelsInput.Features,
-		rekognitionDetectLabelsInput.Image.Bytes,
-		rekognitionDetectLabelsInput.MaxLabels)
+		len(rekognitionDetectLabelsInput.Image.Bytes),
+		rekognitionDetectLabelsInput.MaxLabels,
+		rekognitionDetectLabelsInput.MinConfidence,
+		rekognitionDetectLabelsInput.Settings)
 }
 
 // processRekognitionDetectLabelsOutput processes a rekognition.DetectLabelsOutput.
-func processRekognitionDetectLabelsOutput(s3Client *s3.S3, rekognitionDetectLabelsOutput *rekognition.DetectLabelsOutput, s3BucketName string, s3ObjectKey string) {
+func processRekognitionDetectLabelsOutput(s3Client s3iface.S3API, rekognitionDetectLabelsOutput *rekognition.DetectLabelsOutput, s3BucketName string, s3ObjectKey string) error {
 	log.Printf("RekognitionDetectLabelsOutput: OrientationCorrection=%v",
 		rekognitionDetectLabelsOutput.OrientationCorrection)
 
 	// Modify the S3 object key for storage.
 	s3ObjectKey = fmt.Sprintf("%s/%s.JSON", s3BucketFolderRekognitionDetectLabels, strings.Split(path.Base(s3ObjectKey), ".")[0])
 
-	// Prepare S3 PutObjectInput and perform the S3 object update.
-	s3PutObjectInput, err := getS3PutObjectInput(s3BucketName, s3ObjectKey, rekognitionDetectLabelsOutput)
-	if err != nil {
-		log.Fatalf("S3PutObjectInput: Error=%s", err)
-	}
-
-	// Perform S3 PutObject operation to store the processed data.
-	s3PutObjectOutput, err := putS3Object(s3Client, s3PutObjectInput)
-	if err != nil {
-		log.Fatalf("S3PutObjectOutput: Error=%s", err)
-	}
-	processS3PutObjectOutput(s3PutObjectOutput)
+	// Store the output in S3.
+	return processS3ObjectJSON(s3Client, s3BucketName, s3ObjectKey, rekognitionDetectLabelsOutput)
 }
 
-// processRekognitionDetectText processes an S3 object image with AWS Rekognition Detect Faces.
-func processRekognitionDetectText(rekognitionClient *rekognition.Rekognition, s3Client *s3.S3, s3BucketName string, s3ObjectKey string) {
-	// Create a Rekognition S3Object representation.
-	rekognitionS3Object := rekognition.S3Object{
-		Bucket: &s3BucketName,
-		Name:   &s3ObjectKey}
-	processRekognitionS3Object(&rekognitionS3Object)
-
-	// Create a Rekognition Image representation.
-	rekognitionImage := rekognition.Image{
-		S3Object: &rekognitionS3Object,
-	}
-	processRekognitionImage(&rekognitionImage)
-
+// processRekognitionDetectText processes an S3 object image with AWS Rekognition Detect Text.
+func processRekognitionDetectText(rekognitionClient rekognitioniface.RekognitionAPI, s3Client s3iface.S3API, rekognitionImage *rekognition.Image, s3BucketName string, s3ObjectKey string) (*rekognition.DetectTextOutput, error) {
 	// Create a Rekognition DetectTextInput and process it.
 	rekognitionDetectTextInput := rekognition.DetectTextInput{
-		Image: &rekognitionImage,
+		Filters: getDetectTextFilters(&detectorConfiguration.DetectText),
+		Image:   rekognitionImage,
 	}
 	processRekognitionDetectTextInput(&rekognitionDetectTextInput)
 
-	// Perform face detection using Rekognition.
+	// Perform text detection using Rekognition.
 	rekognitionDetectTextOutput, err := rekognitionClient.DetectText(&rekognitionDetectTextInput)
 	if err != nil {
-		log.Fatalf("RekognitionDetectTextOutput: Error=%s", err)
+		return nil, err
 	}
 
 	// Process the output and store it in S3.
-	processRekognitionDetectTextOutput(s3Client, rekognitionDetectTextOutput, s3BucketName, s3ObjectKey)
+	if err := processRekognitionDetectTextOutput(s3Client, rekognitionDetectTextOutput, s3BucketName, s3ObjectKey); err != nil {
+		return nil, err
+	}
+	return rekognitionDetectTextOutput, nil
 }
 
 // processRekognitionDetectTextInput processes a rekognition.DetectTextInput.
@@ -179,37 +198,13 @@ func processRekognitionDetectTextInput(rekognitionDetectTextInput *rekognition.D
 }
 
 // processRekognitionDetectTextOutput processes a rekognition.DetectTextOutput.
-func processRekognitionDetectTextOutput(s3Client *s3.S3, rekognitionDetectTextOutput *rekognition.DetectTextOutput, s3BucketName string, s3ObjectKey string) {
-	log.Printf("RekognitionDetectTextOutput: TextModelVersion =%v",
+func processRekognitionDetectTextOutput(s3Client s3iface.S3API, rekognitionDetectTextOutput *rekognition.DetectTextOutput, s3BucketName string, s3ObjectKey string) error {
+	log.Printf("RekognitionDetectTextOutput: TextModelVersion=%v",
 		rekognitionDetectTextOutput.TextModelVersion)
 
 	// Modify the S3 object key for storage.
 	s3ObjectKey = fmt.Sprintf("%s/%s.JSON", s3BucketFolderRekognitionDetectText, strings.Split(path.Base(s3ObjectKey), ".")[0])
 
-	// Prepare S3 PutObjectInput and perform the S3 object update.
-	s3PutObjectInput, err := getS3PutObjectInput(s3BucketName, s3ObjectKey, rekognitionDetectTextOutput)
-	if err != nil {
-		log.Fatalf("S3PutObjectInput: Error=%s", err)
-	}
-
-	// Todo: Implement process S3PutObjectInput
-	s3PutObjectOutput, err := putS3Object(s3Client, s3PutObjectInput)
-	if err != nil {
-		log.Fatalf("S3PutObjectOutput: Error=%s", err)
-	}
-	processS3PutObjectOutput(s3PutObjectOutput)
-}
-
-// processRekognitionS3Object processes a rekognition.S3Object.
-func processRekognitionS3Object(rekognitionS3Object *rekognition.S3Object) {
-	log.Printf("RekognitionS3Object: Bucket=%s Key=%s",
-		*rekognitionS3Object.Bucket,
-		*rekognitionS3Object.Name)
-}
-
-// processRekognitionImage processes a rekognition.Image.
-func processRekognitionImage(rekognitionImage *rekognition.Image) {
-	log.Printf("RekognitionImage: S3Object.Bucket=%s S3Object.Name=%s",
-		*rekognitionImage.S3Object.Bucket,
-		*rekognitionImage.S3Object.Name)
+	// Store the output in S3.
+	return processS3ObjectJSON(s3Client, s3BucketName, s3ObjectKey, rekognitionDetectTextOutput)
 }
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"image"
+	"image/jpeg"
+	"reflect"
+	"testing"
+)
+
+// setTestConfiguration sets the folders and the Rekognition configuration used by the analyzers.
+func setTestConfiguration(t *testing.T) {
+	s3BucketFolderImagesAnnotations = "images/annotations/"
+	s3BucketFolderImagesCompressed = "images/compressed/"
+	s3BucketFolderImagesExif = "images/exif/"
+	s3BucketFolderImagesHashes = "images/hashes/"
+	s3BucketFolderImagesKeywords = "images/keywords/"
+	s3BucketFolderImagesPeople = "images/people/"
+	s3BucketFolderImagesRenditions = "images/renditions/"
+	s3BucketFolderPeople = "people/"
+	s3BucketFolderQuarantine = "quarantine/"
+	s3BucketFolderRekognitionDetectCustomLabels = "rekognition/detect_custom_labels/"
+	s3BucketFolderRekognitionDetectFaces = "rekognition/detect_faces/"
+	s3BucketFolderRekognitionDetectLabels = "rekognition/detect_labels/"
+	s3BucketFolderRekognitionDetectModerationLabels = "rekognition/detect_moderation_labels/"
+	s3BucketFolderRekognitionDetectText = "rekognition/detect_text/"
+	s3BucketFolderRekognitionRecognizeCelebrities = "rekognition/recognize_celebrities/"
+	s3BucketFolderRekognitionStatus = "rekognition/status/"
+
+	var err error
+	detectorConfiguration, err = getDetectorConfiguration("{}")
+	if err != nil {
+		t.Fatalf("GetDetectorConfiguration: %s", err)
+	}
+	moderationQuarantineThresholds = map[string]float64{}
+	rekognitionDetectCustomLabelsMinConfidence = 50
+	rekognitionDetectCustomLabelsProjectVersionARN = "arn:aws:rekognition:eu-west-1:123456789012:project/pets/version/pets.2024-05-01/1714521600000"
+	rekognitionDetectModerationLabelsMinConfidence = 50
+	rekognitionDetectorConcurrency = 2
+	rekognitionPeopleCollectionID = "photos"
+	rekognitionPeopleFaceMatchThreshold = 90
+}
+
+// newTestAnalyzerClients returns clients backed by the fakes, with a compressed image stored at the key alongside its
+// Exif metadata.
+func newTestAnalyzerClients(t *testing.T, s3ObjectKey string) (*AnalyzerClients, *fakeRekognition, *fakeS3) {
+	var buffer bytes.Buffer
+	if err := jpeg.Encode(&buffer, image.NewRGBA(image.Rect(0, 0, 160, 120)), nil); err != nil {
+		t.Fatalf("Encode: %s", err)
+	}
+	rekognitionClient := newFakeRekognition()
+	s3Client := newFakeS3()
+	s3Client.putObject(s3ObjectKey, buffer.Bytes())
+	s3Client.putObject(getImageExifKey(s3ObjectKey), []byte(`{"DateTime": "2024-05-01T10:30:00Z", "GPSLatitudeDegrees": 51, "GPSLatitudeMinutes": 30, "GPSLatitudeRef": "N", "GPSLatitudeSeconds": 0, "GPSLongitudeDegrees": 0, "GPSLongitudeMinutes": 7, "GPSLongitudeRef": "W", "GPSLongitudeSeconds": 30, "Make": "Canon", "Model": "EOS R5", "PixelXDimension": 160, "PixelYDimension": 120}`))
+	return &AnalyzerClients{Rekognition: rekognitionClient, S3: s3Client, S3BucketName: "photos"}, rekognitionClient, s3Client
+}
+
+// getTestRekognitionStatus decodes the status record of the named photo.
+func getTestRekognitionStatus(t *testing.T, s3Client *fakeS3, name string) *RekognitionStatus {
+	b, ok := s3Client.getObject(getRekognitionStatusKey(name))
+	if !ok {
+		t.Fatalf("RekognitionStatus: %s not stored", name)
+	}
+	var rekognitionStatus RekognitionStatus
+	if err := json.Unmarshal(b, &rekognitionStatus); err != nil {
+		t.Fatalf("RekognitionStatus: %s", err)
+	}
+	return &rekognitionStatus
+}
+
+func TestNewAnalyzers(t *testing.T) {
+	var names []string
+	for _, analyzer := range newAnalyzers(&AnalyzerClients{}) {
+		names = append(names, analyzer.Name())
+	}
+	want := []string{"DetectCustomLabels", "DetectFaces", "DetectLabels", "DetectModerationLabels", "DetectText", "People", "RecognizeCelebrities"}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("NewAnalyzers: %v", names)
+	}
+}
+
+func TestProcessRekognition(t *testing.T) {
+	setTestConfiguration(t)
+	s3ObjectKey := "images/compressed//2024/05/01/IMG_0001.JPG"
+	analyzerClients, rekognitionClient, s3Client := newTestAnalyzerClients(t, s3ObjectKey)
+
+	// A failing analyzer does not stop the others, and the error is returned for a retry.
+	rekognitionClient.setError("DetectText", errors.New("throttled"))
+	if err := processRekognition(analyzerClients, s3ObjectKey, "etag"); err == nil || err.Error() != "DetectText: throttled" {
+		t.Fatalf("ProcessRekognition: error %v", err)
+	}
+	for _, s3ObjectKeyOutput := range []string{
+		"images/annotations//2024/05/01/IMG_0001.JSON",
+		"images/keywords//IMG_0001.JSON",
+		"images/people//2024/05/01/IMG_0001.JSON",
+		"people//registry.JSON",
+		"rekognition/detect_custom_labels//IMG_0001.JSON",
+		"rekognition/detect_faces//IMG_0001.JSON",
+		"rekognition/detect_labels//IMG_0001.JSON",
+		"rekognition/detect_moderation_labels//IMG_0001.JSON",
+		"rekognition/recognize_celebrities//IMG_0001.JSON",
+	} {
+		if _, ok := s3Client.getObject(s3ObjectKeyOutput); !ok {
+			t.Errorf("ProcessRekognition: %s not stored", s3ObjectKeyOutput)
+		}
+	}
+	if _, ok := s3Client.getObject("rekognition/detect_text//IMG_0001.JSON"); ok {
+		t.Errorf("ProcessRekognition: failed DetectText output stored")
+	}
+	rekognitionStatus := getTestRekognitionStatus(t, s3Client, "IMG_0001")
+	if len(rekognitionStatus.Succeeded) != 6 || rekognitionStatus.Failed["DetectText"] != "throttled" {
+		t.Errorf("ProcessRekognition: status %+v", rekognitionStatus)
+	}
+
+	// The retry runs only the analyzer that failed.
+	rekognitionClient.setError("DetectText", nil)
+	if err := processRekognition(analyzerClients, s3ObjectKey, "etag"); err != nil {
+		t.Fatalf("ProcessRekognition: retry %s", err)
+	}
+	if calls := rekognitionClient.getCalls("DetectText"); calls != 2 {
+		t.Errorf("ProcessRekognition: DetectText called %d times", calls)
+	}
+	if calls := rekognitionClient.getCalls("DetectLabels"); calls != 1 {
+		t.Errorf("ProcessRekognition: DetectLabels called %d times", calls)
+	}
+	if _, ok := s3Client.getObject("rekognition/detect_text//IMG_0001.JSON"); !ok {
+		t.Errorf("ProcessRekognition: retried DetectText output not stored")
+	}
+	if rekognitionStatus := getTestRekognitionStatus(t, s3Client, "IMG_0001"); len(rekognitionStatus.Succeeded) != 7 || len(rekognitionStatus.Failed) != 0 {
+		t.Errorf("ProcessRekognition: retry status %+v", rekognitionStatus)
+	}
+
+	// A new version of the image runs every analyzer again.
+	if err := processRekognition(analyzerClients, s3ObjectKey, "etag2"); err != nil {
+		t.Fatalf("ProcessRekognition: new version %s", err)
+	}
+	if calls := rekognitionClient.getCalls("DetectLabels"); calls != 2 {
+		t.Errorf("ProcessRekognition: DetectLabels called %d times for a new version", calls)
+	}
+}
+
+func TestProcessRekognitionQuarantine(t *testing.T) {
+	setTestConfiguration(t)
+	moderationQuarantineThresholds = map[string]float64{"Violence": 70}
+	s3ObjectKey := "images/compressed//2024/05/01/IMG_0001.JPG"
+	analyzerClients, _, s3Client := newTestAnalyzerClients(t, s3ObjectKey)
+
+	if err := processRekognition(analyzerClients, s3ObjectKey, "etag"); err != nil {
+		t.Fatalf("ProcessRekognition: %s", err)
+	}
+	for _, s3ObjectKeyMoved := range []string{s3ObjectKey, "images/annotations//2024/05/01/IMG_0001.JSON", "rekognition/detect_labels//IMG_0001.JSON", "rekognition/status//IMG_0001.JSON"} {
+		if _, ok := s3Client.getObject(s3ObjectKeyMoved); ok {
+			t.Errorf("ProcessRekognition: %s not quarantined", s3ObjectKeyMoved)
+		}
+		if _, ok := s3Client.getObject(getModerationQuarantineKey("2024/05/01/IMG_0001", s3ObjectKeyMoved)); !ok {
+			t.Errorf("ProcessRekognition: %s missing from quarantine", s3ObjectKeyMoved)
+		}
+	}
+	moderationReviewRecord, err := getS3ObjectModerationReviewRecord(s3Client, "photos", "2024/05/01/IMG_0001")
+	if err != nil || moderationReviewRecord == nil || moderationReviewRecord.Status != moderationReviewStatusPending || moderationReviewRecord.Categories[0].Name != "Violence" {
+		t.Errorf("ProcessRekognition: review record %+v %v", moderationReviewRecord, err)
+	}
+}
+
+func TestProcessRekognitionQuarantineError(t *testing.T) {
+	setTestConfiguration(t)
+	moderationQuarantineThresholds = map[string]float64{"Violence": 70}
+	s3ObjectKey := "images/compressed//2024/05/01/IMG_0001.JPG"
+	analyzerClients, _, s3Client := newTestAnalyzerClients(t, s3ObjectKey)
+
+	// A review record that cannot be read fails the invocation, so that the quarantine is retried.
+	s3Client.putObject(getModerationReviewRecordKey("2024/05/01/IMG_0001"), []byte("{"))
+	if err := processRekognition(analyzerClients, s3ObjectKey, "etag"); err == nil {
+		t.Fatal("ProcessRekognition: expected a quarantine error")
+	}
+	if _, ok := s3Client.getObject(s3ObjectKey); !ok {
+		t.Error("ProcessRekognition: image moved without a review record")
+	}
+}
+
+func TestProcessRekognitionQuarantineSameName(t *testing.T) {
+	setTestConfiguration(t)
+	moderationQuarantineThresholds = map[string]float64{"Violence": 70}
+	s3ObjectKey := "images/compressed//2024/05/01/IMG_0001.JPG"
+	analyzerClients, _, s3Client := newTestAnalyzerClients(t, s3ObjectKey)
+
+	// Approve the first photo, as the moderation review Lambda does.
+	if err := processRekognition(analyzerClients, s3ObjectKey, "etag"); err != nil {
+		t.Fatalf("ProcessRekognition: %s", err)
+	}
+	moderationReviewRecord, err := getS3ObjectModerationReviewRecord(s3Client, "photos", "2024/05/01/IMG_0001")
+	if err != nil || moderationReviewRecord == nil {
+		t.Fatalf("ProcessRekognition: review record %v", err)
+	}
+	moderationReviewRecord.Status = moderationReviewStatusApproved
+	if err := processS3ObjectJSON(s3Client, "photos", getModerationReviewRecordKey("2024/05/01/IMG_0001"), moderationReviewRecord); err != nil {
+		t.Fatalf("ProcessS3ObjectJSON: %s", err)
+	}
+
+	// An unsafe photo with the same name taken on another day is still quarantined.
+	s3ObjectKeyOther := "images/compressed//2024/06/01/IMG_0001.JPG"
+	b, _ := s3Client.getObject(getModerationQuarantineKey("2024/05/01/IMG_0001", s3ObjectKey))
+	s3Client.putObject(s3ObjectKeyOther, b)
+	if err := processRekognition(analyzerClients, s3ObjectKeyOther, "etag-other"); err != nil {
+		t.Fatalf("ProcessRekognition: %s", err)
+	}
+	if _, ok := s3Client.getObject(s3ObjectKeyOther); ok {
+		t.Errorf("ProcessRekognition: %s not quarantined", s3ObjectKeyOther)
+	}
+	moderationReviewRecordOther, err := getS3ObjectModerationReviewRecord(s3Client, "photos", "2024/06/01/IMG_0001")
+	if err != nil || moderationReviewRecordOther == nil || moderationReviewRecordOther.Status != moderationReviewStatusPending || moderationReviewRecordOther.Key != s3ObjectKeyOther {
+		t.Errorf("ProcessRekognition: review record %+v %v", moderationReviewRecordOther, err)
+	}
+	if moderationReviewRecord, err := getS3ObjectModerationReviewRecord(s3Client, "photos", "2024/05/01/IMG_0001"); err != nil || moderationReviewRecord.Status != moderationReviewStatusApproved {
+		t.Errorf("ProcessRekognition: first review record %+v %v", moderationReviewRecord, err)
+	}
+}
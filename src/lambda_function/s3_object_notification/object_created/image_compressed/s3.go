@@ -3,12 +3,30 @@ package main
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
 )
 
-// Contains utility functions for interacting with Amazon S3, specifically for putting objects.
+// Contains utility functions for interacting with Amazon S3.
+
+// getImageID returns the date folders and name that identify a compressed image, such as 2024/05/01/IMG_0001. Photos
+// taken on different days may share a name, but not an ID.
+func getImageID(s3ObjectKey string) string {
+	name := strings.Split(path.Base(s3ObjectKey), ".")[0]
+	date := path.Dir(strings.TrimPrefix(s3ObjectKey, s3BucketFolderImagesCompressed+"/"))
+	return fmt.Sprintf("%s/%s", date, name)
+}
 
 // getS3PutObjectInput creates an S3 PutObjectInput based on the provided parameters.
 // It serializes the s3ObjectBody to JSON and prepares the necessary input for object storage.
@@ -33,7 +51,7 @@ func getS3PutObjectInput(s3BucketName string, s3ObjectKey string, s3ObjectBody i
 
 // putS3Object puts an object into an Amazon S3 bucket using the provided S3 client and input.
 // It returns the result of the PutObject operation or an error if the operation fails.
-func putS3Object(s3Client *s3.S3, s3PutObjectInput *s3.PutObjectInput) (*s3.PutObjectOutput, error) {
+func putS3Object(s3Client s3iface.S3API, s3PutObjectInput *s3.PutObjectInput) (*s3.PutObjectOutput, error) {
 	// Perform the S3 PutObject operation.
 	s3PutObjectOutput, err := s3Client.PutObject(s3PutObjectInput)
 	if err != nil {
@@ -42,3 +60,114 @@ func putS3Object(s3Client *s3.S3, s3PutObjectInput *s3.PutObjectInput) (*s3.PutO
 
 	return s3PutObjectOutput, nil
 }
+
+// getS3ObjectBytes downloads an object from the provided S3 bucket and returns its contents.
+func getS3ObjectBytes(s3Client s3iface.S3API, s3BucketName string, s3ObjectKey string) ([]byte, error) {
+	getObjectInput := s3.GetObjectInput{
+		Bucket: &s3BucketName,
+		Key:    &s3ObjectKey}
+	getObjectOutput, err := s3Client.GetObject(&getObjectInput)
+	if err != nil {
+		return nil, err
+	}
+	defer getObjectOutput.Body.Close()
+	return io.ReadAll(getObjectOutput.Body)
+}
+
+// getS3ObjectJSON downloads a JSON document from the provided S3 bucket and decodes it into the value. It returns
+// false if the object does not exist.
+func getS3ObjectJSON(s3Client s3iface.S3API, s3BucketName string, s3ObjectKey string, value interface{}) (bool, error) {
+	b, err := getS3ObjectBytes(s3Client, s3BucketName, s3ObjectKey)
+	var awsErr awserr.Error
+	if errors.As(err, &awsErr) && awsErr.Code() == s3.ErrCodeNoSuchKey {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, json.Unmarshal(b, value)
+}
+
+// getS3ObjectJSONETag downloads a JSON document like getS3ObjectJSON and also returns its ETag, which is empty if the
+// object does not exist.
+func getS3ObjectJSONETag(s3Client s3iface.S3API, s3BucketName string, s3ObjectKey string, value interface{}) (string, error) {
+	getObjectInput := s3.GetObjectInput{
+		Bucket: &s3BucketName,
+		Key:    &s3ObjectKey}
+	getObjectOutput, err := s3Client.GetObject(&getObjectInput)
+	var awsErr awserr.Error
+	if errors.As(err, &awsErr) && awsErr.Code() == s3.ErrCodeNoSuchKey {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	defer getObjectOutput.Body.Close()
+	b, err := io.ReadAll(getObjectOutput.Body)
+	if err != nil {
+		return "", err
+	}
+	return aws.StringValue(getObjectOutput.ETag), json.Unmarshal(b, value)
+}
+
+// isS3PreconditionFailed reports whether a conditional write was rejected because the object changed, or because
+// another conditional write to it was in progress.
+func isS3PreconditionFailed(err error) bool {
+	var requestFailure awserr.RequestFailure
+	return errors.As(err, &requestFailure) &&
+		(requestFailure.StatusCode() == http.StatusPreconditionFailed || requestFailure.StatusCode() == http.StatusConflict)
+}
+
+// copyS3Object copies an object within the specified S3 bucket.
+func copyS3Object(s3Client s3iface.S3API, s3BucketName string, s3ObjectKeySource string, s3ObjectKeyDestination string) (*s3.CopyObjectOutput, error) {
+	s3CopyObjectInput := s3.CopyObjectInput{
+		Bucket:     &s3BucketName,
+		CopySource: aws.String(url.PathEscape(fmt.Sprintf("%s/%s", s3BucketName, s3ObjectKeySource))),
+		Key:        &s3ObjectKeyDestination}
+	return s3Client.CopyObject(&s3CopyObjectInput)
+}
+
+// deleteS3Object deletes an object from the specified S3 bucket.
+func deleteS3Object(s3Client s3iface.S3API, s3BucketName string, s3ObjectKey string) (*s3.DeleteObjectOutput, error) {
+	s3DeleteObjectInput := s3.DeleteObjectInput{
+		Bucket: &s3BucketName,
+		Key:    &s3ObjectKey}
+	return s3Client.DeleteObject(&s3DeleteObjectInput)
+}
+
+// processS3ObjectJSON stores the JSON document in S3.
+func processS3ObjectJSON(s3Client s3iface.S3API, s3BucketName string, s3ObjectKey string, s3ObjectBody interface{}) error {
+	s3PutObjectInput, err := getS3PutObjectInput(s3BucketName, s3ObjectKey, s3ObjectBody)
+	if err != nil {
+		return err
+	}
+	s3PutObjectOutput, err := putS3Object(s3Client, s3PutObjectInput)
+	if err != nil {
+		return err
+	}
+	processS3PutObjectOutput(s3PutObjectOutput)
+	return nil
+}
+
+// processS3ObjectJSONConditional stores the JSON document in S3 only if the object still has the ETag, or does not
+// exist yet when the ETag is empty. Use isS3PreconditionFailed to tell whether another writer changed it first.
+func processS3ObjectJSONConditional(s3Client s3iface.S3API, s3BucketName string, s3ObjectKey string, s3ObjectBody interface{}, eTag string) error {
+	s3PutObjectInput, err := getS3PutObjectInput(s3BucketName, s3ObjectKey, s3ObjectBody)
+	if err != nil {
+		return err
+	}
+
+	// The SDK has no field for the conditions of a PutObject, so set their headers on the request.
+	s3PutObjectOutput, err := s3Client.PutObjectWithContext(aws.BackgroundContext(), s3PutObjectInput, func(r *request.Request) {
+		if eTag == "" {
+			r.HTTPRequest.Header.Set("If-None-Match", "*")
+		} else {
+			r.HTTPRequest.Header.Set("If-Match", eTag)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	processS3PutObjectOutput(s3PutObjectOutput)
+	return nil
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+)
+
+// Contains functions for running the analyzers concurrently and recording which of them succeeded.
+//
+// Analyzers run with bounded parallelism and a failing analyzer does not stop the others, whose outputs are stored
+// regardless. The status record of each photo lists the analyzers that succeeded for the ETag of its compressed image,
+// so that when the Lambda returns the aggregated error and is retried, only the analyzers that failed run again. A
+// new version of the image has a different ETag and runs every analyzer. An analyzer that skips the image, such as a
+// Custom Labels model that is not running, is recorded as neither succeeded nor failed, so it runs again the next time
+// the photo is processed without failing the invocation.
+
+// RekognitionStatus records the outcome of the analyzers run on the compressed image stored at Key.
+type RekognitionStatus struct {
+	ETag      string            `json:"ETag"`
+	Failed    map[string]string `json:"Failed"`
+	Key       string            `json:"Key"`
+	Name      string            `json:"Name"`
+	Skipped   []string          `json:"Skipped"`
+	Succeeded []string          `json:"Succeeded"`
+	UpdatedAt time.Time         `json:"UpdatedAt"`
+}
+
+// errAnalyzerSkipped is returned by an analyzer that cannot analyse the image for now.
+var errAnalyzerSkipped = errors.New("analyzer skipped")
+
+// getDetectorConcurrency parses and validates the maximum number of analyzers run at once.
+func getDetectorConcurrency(concurrency string) (int, error) {
+	value, err := strconv.Atoi(concurrency)
+	if err != nil {
+		return 0, err
+	}
+	if value <= 0 {
+		return 0, fmt.Errorf("detector concurrency %d must be positive", value)
+	}
+	return value, nil
+}
+
+// getRekognitionStatusKey returns the S3 object key of the status record of the named photo.
+func getRekognitionStatusKey(name string) string {
+	return fmt.Sprintf("%s/%s.JSON", s3BucketFolderRekognitionStatus, name)
+}
+
+// getS3ObjectRekognitionStatus downloads the status record of the named photo. A record that does not exist yet, or
+// that was written for another version of the image, is replaced by an empty record for the ETag.
+func getS3ObjectRekognitionStatus(s3Client s3iface.S3API, s3BucketName string, name string, eTag string) (*RekognitionStatus, error) {
+	rekognitionStatus := RekognitionStatus{ETag: eTag, Name: name}
+	b, err := getS3ObjectBytes(s3Client, s3BucketName, getRekognitionStatusKey(name))
+	var awsErr awserr.Error
+	if errors.As(err, &awsErr) && awsErr.Code() == s3.ErrCodeNoSuchKey {
+		return &rekognitionStatus, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var storedStatus RekognitionStatus
+	if err := json.Unmarshal(b, &storedStatus); err != nil {
+		return nil, err
+	}
+	if eTag == "" || storedStatus.ETag != eTag {
+		return &rekognitionStatus, nil
+	}
+	return &storedStatus, nil
+}
+
+// getPendingAnalyzers returns the analyzers that have not yet succeeded for the image.
+func getPendingAnalyzers(analyzers []Analyzer, rekognitionStatus *RekognitionStatus) []Analyzer {
+	var pendingAnalyzers []Analyzer
+	for _, analyzer := range analyzers {
+		if !slices.Contains(rekognitionStatus.Succeeded, analyzer.Name()) {
+			pendingAnalyzers = append(pendingAnalyzers, analyzer)
+		}
+	}
+	return pendingAnalyzers
+}
+
+// runAnalyzers runs the analyzers on the image, at most concurrency at a time, and returns the result and the error
+// of each analyzer by name. Analyzers that succeed have a nil error.
+func runAnalyzers(analyzers []Analyzer, analyzerImage *AnalyzerImage, concurrency int) (map[string]interface{}, map[string]error) {
+	analyzerResults := make(map[string]interface{})
+	analyzerErrors := make(map[string]error)
+	var mutex sync.Mutex
+	var waitGroup sync.WaitGroup
+	semaphore := make(chan struct{}, concurrency)
+	for _, analyzer := range analyzers {
+		waitGroup.Add(1)
+		semaphore <- struct{}{}
+		go func(analyzer Analyzer) {
+			defer waitGroup.Done()
+			defer func() { <-semaphore }()
+			startedAt := time.Now()
+			result, err := analyzer.Run(analyzerImage)
+			log.Printf("Analyzer: Name=%s Duration=%s Error=%v", analyzer.Name(), time.Since(startedAt), err)
+			mutex.Lock()
+			analyzerResults[analyzer.Name()] = result
+			analyzerErrors[analyzer.Name()] = err
+			mutex.Unlock()
+		}(analyzer)
+	}
+	waitGroup.Wait()
+	return analyzerResults, analyzerErrors
+}
+
+// updateRekognitionStatus records the outcome of the analyzers in the status record, and returns the aggregated
+// error of the analyzers that failed, ordered by name. Analyzers that skipped the image are not part of the error.
+func updateRekognitionStatus(rekognitionStatus *RekognitionStatus, analyzerErrors map[string]error) error {
+	names := make([]string, 0, len(analyzerErrors))
+	for name := range analyzerErrors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var errs []error
+	rekognitionStatus.Failed = make(map[string]string)
+	rekognitionStatus.Skipped = nil
+	for _, name := range names {
+		if err := analyzerErrors[name]; errors.Is(err, errAnalyzerSkipped) {
+			rekognitionStatus.Skipped = append(rekognitionStatus.Skipped, name)
+		} else if err != nil {
+			rekognitionStatus.Failed[name] = err.Error()
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+		} else if !slices.Contains(rekognitionStatus.Succeeded, name) {
+			rekognitionStatus.Succeeded = append(rekognitionStatus.Succeeded, name)
+		}
+	}
+	sort.Strings(rekognitionStatus.Succeeded)
+	rekognitionStatus.UpdatedAt = time.Now().UTC()
+	return errors.Join(errs...)
+}
+
+// getS3ObjectModerationVerdict downloads the moderation verdict stored for the named photo by an earlier run. It
+// returns nil if no verdict has been stored.
+func getS3ObjectModerationVerdict(s3Client s3iface.S3API, s3BucketName string, name string) (*ModerationVerdict, error) {
+	b, err := getS3ObjectBytes(s3Client, s3BucketName, fmt.Sprintf("%s/%s.JSON", s3BucketFolderRekognitionDetectModerationLabels, name))
+	var awsErr awserr.Error
+	if errors.As(err, &awsErr) && awsErr.Code() == s3.ErrCodeNoSuchKey {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var rekognitionDetectModerationLabelsResult struct {
+		Verdict *ModerationVerdict `json:"Verdict"`
+	}
+	if err := json.Unmarshal(b, &rekognitionDetectModerationLabelsResult); err != nil {
+		return nil, err
+	}
+	return rekognitionDetectModerationLabelsResult.Verdict, nil
+}
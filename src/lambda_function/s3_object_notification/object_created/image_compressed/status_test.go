@@ -0,0 +1,110 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestGetDetectorConcurrency(t *testing.T) {
+	if concurrency, err := getDetectorConcurrency("4"); err != nil || concurrency != 4 {
+		t.Errorf("GetDetectorConcurrency: %d %v", concurrency, err)
+	}
+	for _, concurrency := range []string{"", "0", "-1", "two"} {
+		if _, err := getDetectorConcurrency(concurrency); err == nil {
+			t.Errorf("GetDetectorConcurrency: %q accepted", concurrency)
+		}
+	}
+}
+
+// testAnalyzer is an Analyzer that returns its result and error.
+type testAnalyzer struct {
+	err    error
+	name   string
+	result interface{}
+	run    func()
+}
+
+func (a *testAnalyzer) Name() string {
+	return a.name
+}
+
+func (a *testAnalyzer) Run(analyzerImage *AnalyzerImage) (interface{}, error) {
+	if a.run != nil {
+		a.run()
+	}
+	return a.result, a.err
+}
+
+func TestRunAnalyzers(t *testing.T) {
+	var running, maxRunning atomic.Int32
+	run := func() {
+		current := running.Add(1)
+		for {
+			observed := maxRunning.Load()
+			if current <= observed || maxRunning.CompareAndSwap(observed, current) {
+				break
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+		running.Add(-1)
+	}
+	failure := errors.New("throttled")
+	analyzerResults, analyzerErrors := runAnalyzers([]Analyzer{
+		&testAnalyzer{name: "A", result: "a", run: run},
+		&testAnalyzer{err: failure, name: "B", run: run},
+		&testAnalyzer{name: "C", run: run},
+		&testAnalyzer{name: "D", run: run},
+		&testAnalyzer{name: "E", run: run},
+	}, &AnalyzerImage{Key: "IMG_0001.jpg"}, 2)
+	if len(analyzerErrors) != 5 || analyzerErrors["A"] != nil || analyzerErrors["B"] != failure {
+		t.Errorf("RunAnalyzers: %v", analyzerErrors)
+	}
+	if analyzerResults["A"] != "a" {
+		t.Errorf("RunAnalyzers: results %v", analyzerResults)
+	}
+	if maxRunning.Load() > 2 {
+		t.Errorf("RunAnalyzers: %d analyzers ran at once", maxRunning.Load())
+	}
+}
+
+func TestUpdateRekognitionStatus(t *testing.T) {
+	rekognitionStatus := RekognitionStatus{ETag: "etag", Name: "IMG_0001", Succeeded: []string{"DetectText"}}
+	analyzers := []Analyzer{&testAnalyzer{name: "DetectFaces"}, &testAnalyzer{name: "DetectLabels"}, &testAnalyzer{name: "DetectModerationLabels"}, &testAnalyzer{name: "DetectText"}}
+	pendingAnalyzers := getPendingAnalyzers(analyzers, &rekognitionStatus)
+	if len(pendingAnalyzers) != 3 || pendingAnalyzers[0].Name() != "DetectFaces" {
+		t.Fatalf("GetPendingAnalyzers: %v", pendingAnalyzers)
+	}
+
+	err := updateRekognitionStatus(&rekognitionStatus, map[string]error{
+		"DetectFaces":            nil,
+		"DetectLabels":           errors.New("throttled"),
+		"DetectModerationLabels": errors.New("timeout"),
+	})
+	if err == nil || err.Error() != "DetectLabels: throttled\nDetectModerationLabels: timeout" {
+		t.Errorf("UpdateRekognitionStatus: error %v", err)
+	}
+	if want := []string{"DetectFaces", "DetectText"}; !reflect.DeepEqual(rekognitionStatus.Succeeded, want) {
+		t.Errorf("UpdateRekognitionStatus: succeeded %v", rekognitionStatus.Succeeded)
+	}
+	if len(rekognitionStatus.Failed) != 2 || !strings.Contains(rekognitionStatus.Failed["DetectLabels"], "throttled") {
+		t.Errorf("UpdateRekognitionStatus: failed %v", rekognitionStatus.Failed)
+	}
+
+	// A retry in which the failed analyzers succeed clears the failures.
+	pendingAnalyzers = getPendingAnalyzers(analyzers, &rekognitionStatus)
+	if len(pendingAnalyzers) != 2 {
+		t.Fatalf("GetPendingAnalyzers: %v", pendingAnalyzers)
+	}
+	if err := updateRekognitionStatus(&rekognitionStatus, map[string]error{"DetectLabels": nil, "DetectModerationLabels": nil}); err != nil || len(rekognitionStatus.Failed) != 0 || len(rekognitionStatus.Succeeded) != 4 {
+		t.Errorf("UpdateRekognitionStatus: retry %v %+v", err, rekognitionStatus)
+	}
+
+	// A skipped analyzer neither succeeds nor fails, so it runs again.
+	if err := updateRekognitionStatus(&rekognitionStatus, map[string]error{"DetectCustomLabels": errAnalyzerSkipped}); err != nil || len(rekognitionStatus.Failed) != 0 || len(rekognitionStatus.Succeeded) != 4 || !reflect.DeepEqual(rekognitionStatus.Skipped, []string{"DetectCustomLabels"}) {
+		t.Errorf("UpdateRekognitionStatus: skipped %v %+v", err, rekognitionStatus)
+	}
+}
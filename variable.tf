@@ -4,6 +4,181 @@ variable "application" {
   type      = string
 }
 
+variable "contact_sheet_captions" {
+  default   = true
+  sensitive = false
+  type      = bool
+}
+
+variable "contact_sheet_cell_size" {
+  default   = 256
+  sensitive = false
+  type      = number
+}
+
+variable "contact_sheet_columns" {
+  default   = 5
+  sensitive = false
+  type      = number
+}
+
+variable "contact_sheet_format" {
+  default   = "JPEG"
+  sensitive = false
+  type      = string
+}
+
+variable "contact_sheet_rows" {
+  default   = 6
+  sensitive = false
+  type      = number
+}
+
+variable "contact_sheet_schedule_expression" {
+  default   = "cron(0 1 * * ? *)"
+  sensitive = false
+  type      = string
+}
+
+variable "image_crop_presets" {
+  default = [
+    {
+      AspectRatio = "1:1"
+      Name        = "Square"
+    },
+    {
+      AspectRatio = "4:5"
+      Name        = "Portrait"
+    },
+    {
+      AspectRatio = "9:16"
+      Name        = "Story"
+    }
+  ]
+  sensitive = false
+  type      = any
+}
+
+variable "image_duplicate_action" {
+  default   = "process"
+  sensitive = false
+  type      = string
+}
+
+variable "image_duplicate_threshold" {
+  default   = 4
+  sensitive = false
+  type      = number
+}
+
+variable "image_grades" {
+  default   = []
+  sensitive = false
+  type      = any
+}
+
+variable "image_max_decode_pixels" {
+  default   = 40000000
+  sensitive = false
+  type      = number
+}
+
+variable "image_max_dimension" {
+  default   = 30000
+  sensitive = false
+  type      = number
+}
+
+variable "image_max_pixels" {
+  default   = 24000000
+  sensitive = false
+  type      = number
+}
+
+variable "image_max_upload_pixels" {
+  default   = 200000000
+  sensitive = false
+  type      = number
+}
+
+variable "image_palette_size" {
+  default   = 5
+  sensitive = false
+  type      = number
+}
+
+variable "image_redaction_collection_id" {
+  default   = ""
+  sensitive = false
+  type      = string
+}
+
+variable "image_redaction_face_match_threshold" {
+  default   = 90
+  sensitive = false
+  type      = number
+}
+
+variable "image_renditions" {
+  default   = []
+  sensitive = false
+  type      = any
+}
+
+variable "image_watermark" {
+  default   = null
+  sensitive = false
+  type      = any
+}
+
+variable "moderation_quarantine_thresholds" {
+  default   = {}
+  sensitive = false
+  type      = map(number)
+}
+
+variable "rekognition_detect_custom_labels_min_confidence" {
+  default   = 50
+  sensitive = false
+  type      = number
+}
+
+variable "rekognition_detect_custom_labels_project_version_arn" {
+  default   = ""
+  sensitive = false
+  type      = string
+}
+
+variable "rekognition_detect_moderation_labels_min_confidence" {
+  default   = 50
+  sensitive = false
+  type      = number
+}
+
+variable "rekognition_detector_concurrency" {
+  default   = 4
+  sensitive = false
+  type      = number
+}
+
+variable "rekognition_detectors" {
+  default   = {}
+  sensitive = false
+  type      = any
+}
+
+variable "rekognition_people_collection_id" {
+  default   = ""
+  sensitive = false
+  type      = string
+}
+
+variable "rekognition_people_face_match_threshold" {
+  default   = 90
+  sensitive = false
+  type      = number
+}
+
 variable "region" {
   default   = "us-east-1"
   sensitive = false